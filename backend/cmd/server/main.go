@@ -1,59 +1,160 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
 
 	"backend/internal/api"
+	"backend/internal/config"
 	"backend/internal/db"
+	"backend/internal/metrics"
+	"backend/internal/models"
 	"backend/internal/repository"
 	"backend/internal/service"
 )
 
 func main() {
-	if err := godotenv.Load("../../../.env"); err != nil {
-		log.Println("No .env file found, falling back to system env vars")
+	cfg, err := config.Load("server", os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(2)
 	}
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	if cfg.PrintOnly {
+		cfg.Print(os.Stdout)
+		return
+	}
+	log.Println("Effective configuration:")
+	cfg.Print(log.Writer())
 
-	storageQuotaMB := os.Getenv("USER_STORAGE_QUOTA_MB")
-	api_limit := os.Getenv("API_RATE_LIMIT")
-	quota, _ := strconv.ParseInt(storageQuotaMB, 10, 64)
-	limit,_ := strconv.ParseInt(api_limit , 10, 64)
-
-	conn, err := db.NewPostgresDB()
+	conn, err := db.NewPostgresDB(cfg.DB)
 	if err != nil {
 		log.Fatal("Failed to connect to DB:", err)
 	}
+	migrator, err := db.NewMigrator(conn)
+	if err != nil {
+		log.Fatal("Failed to load migrations:", err)
+	}
+	if cfg.DB.AutoMigrate {
+		applied, err := migrator.Up(0)
+		if err != nil {
+			log.Fatal("Failed to migrate DB:", err)
+		}
+		for _, m := range applied {
+			log.Printf("Applied migration %d_%s", m.Version, m.Name)
+		}
+	}
+
+	// Background workers run until shutdown, once the listeners have drained
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
 
 	//Auth setup
 	userRepo := repository.NewUserRepository(conn)
-	authService := service.NewAuthService(userRepo)
-	authHandler := api.NewAuthHandler(authService)
+	authService := service.NewAuthService(userRepo, cfg.Auth.JWTSecret)
+	authHandler := api.NewAuthHandler(authService, cfg.Cookie)
 
 	fileConfig := service.FileConfig{
-    UploadDir:    "uploads",         // Directory to store files
-    AllowedTypes: []string{},	     // Optional: restrict file types
+		MaxFileSize:            cfg.Limits.MaxFileSize,
+		UploadDir:              cfg.Storage.UploadDir,
+		AllowedTypes:           cfg.Storage.AllowedTypes,
+		MaxArchiveEntries:      cfg.Limits.MaxArchiveEntries,
+		MaxArchiveExpandedSize: cfg.Limits.MaxArchiveExpandedSize,
+		MaxCompressionRatio:    cfg.Limits.MaxCompressionRatio,
+		MaxZipDownloadSize:     cfg.Limits.MaxZipDownloadSize,
 	}
 
-
 	//File setup
 	userFileRepo := repository.NewUserFileRepository(conn)
 	fileRepo := repository.NewFileRepository(conn)
-	fileService := service.NewFileService(fileRepo, userFileRepo, userRepo, fileConfig,quota)
-	fileHandler := api.NewFileHandler(fileService)
-	rateLimiter := api.NewRateLimiter(int(limit))
+	txManager := repository.NewTxManager(conn)
+	blobDeleter := service.NewBlobDeleter(txManager, time.Minute)
+	workers.Go(func() { blobDeleter.Run(workerCtx) })
+	fileService := service.NewFileService(fileRepo, userFileRepo, userRepo, repository.NewFolderRepository(conn), txManager, blobDeleter, fileConfig, cfg.Limits.UserQuotaMB)
+	analyticsService := service.NewAnalyticsService(repository.NewDownloadEventRepository(conn), userFileRepo)
+	fileHandler := api.NewFileHandler(fileService, analyticsService)
+	analyticsHandler := api.NewAnalyticsHandler(analyticsService)
+	changesHandler := api.NewChangesHandler(service.NewChangeService(repository.NewChangeRepository(conn)))
+
+	//Preview setup
+	previewService := service.NewPreviewService(repository.NewPreviewRepository(conn), fileRepo, userFileRepo, filepath.Join(fileConfig.UploadDir, ".previews"))
+	fileService.OnNewBlob(previewService.Enqueue)
+	blobDeleter.OnBlobRemoved(previewService.Purge)
+	workers.Go(func() { previewService.Run(workerCtx, 2) })
+	previewHandler := api.NewPreviewHandler(previewService)
+
+	//Search setup
+	searchService := service.NewSearchService(repository.NewSearchRepository(conn), fileRepo)
+	fileService.OnNewBlob(searchService.Enqueue)
+	blobDeleter.OnBlobRemoved(searchService.Purge)
+	workers.Go(func() { searchService.Run(workerCtx, 2) })
+	searchHandler := api.NewSearchHandler(searchService)
+
+	//Tag setup
+	tagService := service.NewTagService(repository.NewTagRepository(conn), txManager)
+	tagHandler := api.NewTagHandler(tagService)
+	rateLimiter := api.NewRateLimiter(cfg.Limits.RateLimit)
+
+	//Event setup
+	eventDispatcher := service.NewEventDispatcher(txManager, 5*time.Second)
+	fileService.OnEventsQueued(eventDispatcher.Wake)
+	webhookService := service.NewWebhookService(repository.NewWebhookRepository(conn), userRepo, 5*time.Second)
+	eventDispatcher.Subscribe(webhookService.Fanout)
+	eventDispatcher.AfterDispatch(func([]models.OutboxEvent) { webhookService.Wake() })
+	var broker service.Broker = service.NewMemoryBroker()
+	if cfg.Events.Broker == "postgres" {
+		pgBroker := service.NewPostgresBroker(conn, cfg.DB.DSN())
+		workers.Go(func() { pgBroker.Run(workerCtx) })
+		broker = pgBroker
+	}
+	eventDispatcher.AfterDispatch(service.PublishDispatched(broker))
+	notificationService := service.NewNotificationService(broker, repository.NewOutboxRepository(conn))
+	eventsHandler := api.NewEventsHandler(notificationService)
+	workers.Go(func() { eventDispatcher.Run(workerCtx) })
+	workers.Go(func() { webhookService.Run(workerCtx) })
+	webhookHandler := api.NewWebhookHandler(webhookService)
+
+	//Audit setup
+	auditService := service.NewAuditService(repository.NewAuditRepository(conn))
+	workers.Go(func() { auditService.Run(workerCtx) })
+	auditHandler := api.NewAuditHandler(auditService)
+
+	//WebDAV setup
+	tokenService := service.NewTokenService(repository.NewTokenRepository(conn), userRepo)
+	tokenHandler := api.NewTokenHandler(tokenService)
+	webdavHandler := api.NewWebDAVHandler(fileService, tokenService)
+
+	//S3 setup
+	accessKeyService := service.NewAccessKeyService(repository.NewAccessKeyRepository(conn), userRepo)
+	accessKeyHandler := api.NewAccessKeyHandler(accessKeyService)
+	multipartService := service.NewMultipartService(repository.NewMultipartRepository(conn), fileService, 7*24*time.Hour)
+	workers.Go(func() { multipartService.Run(workerCtx) })
+	uploadHandler := api.NewUploadHandler(multipartService)
+	s3Handler := api.NewS3Handler(fileService, multipartService, accessKeyService, auditService)
+
+	//SFTP setup
+	sshKeyService := service.NewSSHKeyService(repository.NewSSHKeyRepository(conn), userRepo)
+	sshKeyHandler := api.NewSSHKeyHandler(sshKeyService)
 
 	r := gin.Default()
+	r.Use(api.Metrics("api"))
 
-	frontendOrigin := os.Getenv("FRONTEND_ORIGIN")
 	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{frontendOrigin}, 
+		AllowOrigins:     cfg.HTTP.AllowedOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
 		AllowHeaders:     []string{"Content-Type" ,"Authorization"},
 		AllowCredentials: true, 
@@ -61,41 +162,230 @@ func main() {
 
 	
 	apiRoutes := r.Group("/api")
+	apiRoutes.Use(api.Audit(auditService, authService))
 	{
 		// Public
 		apiRoutes.POST("/signup", authHandler.SignUp)
 		apiRoutes.POST("/login", authHandler.SignIn)
 		apiRoutes.POST("/logout", authHandler.SignOut)
-		apiRoutes.GET("/public/:token", fileHandler.DownloadPublic)
+		apiRoutes.GET("/public/:token", api.MeasureDownload("http"), fileHandler.DownloadPublic)
 
 		// Protected routes (require auth)
 		protected := apiRoutes.Group("/")
-		protected.Use(api.AuthMiddleware() , rateLimiter.RateMiddleware())
+		protected.Use(api.AuthMiddleware(authService, tokenService) , rateLimiter.RateMiddleware())
 		{
 			protected.GET("/me", authHandler.Me)
 
 			
-			protected.POST("/upload", fileHandler.Upload)
+			protected.POST("/upload", api.MeasureUpload("http"), fileHandler.Upload)
+			protected.POST("/upload/bulk", api.MeasureUpload("http"), fileHandler.BulkUpload)
+			protected.POST("/upload/archive", api.MeasureUpload("http"), fileHandler.UploadArchive)
+			protected.POST("/uploads", uploadHandler.Start)
+			protected.GET("/uploads/:id", uploadHandler.Get)
+			protected.PUT("/uploads/:id/parts/:number", api.MeasureUpload("http"), uploadHandler.PutPart)
+			protected.POST("/uploads/:id/complete", uploadHandler.Complete)
+			protected.DELETE("/uploads/:id", uploadHandler.Abort)
 			protected.GET("/files", fileHandler.ListFiles)
-			protected.GET("/files/:id/download", fileHandler.DownloadFile)
+			protected.GET("/files/:id/download", api.MeasureDownload("http"), fileHandler.DownloadFile)
+			protected.POST("/files/download-zip", api.MeasureDownload("http"), fileHandler.DownloadZip)
+			protected.GET("/files/:id/preview", previewHandler.GetPreview)
+			protected.GET("/files/:id/preview/:size", previewHandler.GetThumbnail)
 			protected.POST("/files/:id/delete", fileHandler.DeleteFile)
 			protected.PATCH("/files/:id/visibility", fileHandler.ChangeVisibility)
+			protected.PATCH("/files/:id", fileHandler.RenameFile)
+			protected.GET("/files/:id/downloads", analyticsHandler.GetDownloadStats)
 			protected.GET("/storage-stats", fileHandler.GetStorageStats)
+			protected.GET("/search", searchHandler.Search)
+			protected.GET("/events", eventsHandler.Stream)
+			protected.GET("/changes", changesHandler.List)
+
+			protected.GET("/tags", tagHandler.SuggestTags)
+			protected.POST("/files/tags/bulk", tagHandler.BulkEdit)
+			protected.GET("/files/:id/tags", tagHandler.GetTags)
+			protected.POST("/files/:id/tags", tagHandler.AddTags)
+			protected.DELETE("/files/:id/tags/:tag", tagHandler.RemoveTag)
+			protected.PUT("/files/:id/metadata", tagHandler.SetMetadata)
+			protected.DELETE("/files/:id/metadata/:key", tagHandler.RemoveMetadata)
+
+			protected.POST("/tokens", tokenHandler.Create)
+			protected.GET("/tokens", tokenHandler.List)
+			protected.DELETE("/tokens/:id", tokenHandler.Revoke)
+
+			protected.POST("/access-keys", accessKeyHandler.Create)
+			protected.GET("/access-keys", accessKeyHandler.List)
+			protected.DELETE("/access-keys/:id", accessKeyHandler.Delete)
+
+			protected.POST("/ssh-keys", sshKeyHandler.Add)
+			protected.GET("/ssh-keys", sshKeyHandler.List)
+			protected.DELETE("/ssh-keys/:id", sshKeyHandler.Delete)
+
+			protected.POST("/webhooks", webhookHandler.Register)
+			protected.GET("/webhooks", webhookHandler.List)
+			protected.DELETE("/webhooks/:id", webhookHandler.Delete)
+			protected.GET("/webhooks/:id/deliveries", webhookHandler.Deliveries)
+			protected.POST("/webhooks/:id/deliveries/:delivery/redeliver", webhookHandler.Redeliver)
+		}
+
+		// Admin only
+		admin := apiRoutes.Group("/admin")
+		admin.Use(api.AuthMiddleware(authService, tokenService), api.AdminMiddleware(authService))
+		{
+			admin.GET("/audit", auditHandler.Query)
+			admin.GET("/audit/export", auditHandler.Export)
+			admin.GET("/audit/verify", auditHandler.Verify)
 		}
 	}
 
-	
+	// WebDAV, authenticated with basic auth rather than the session cookie
+	webdavRoutes := r.Group(api.WebDAVPrefix)
+	webdavRoutes.Use(api.MeasureTransfers("webdav"), api.Audit(auditService, authService))
+	for _, method := range api.WebDAVMethods {
+		webdavRoutes.Handle(method, "", webdavHandler.Serve)
+		webdavRoutes.Handle(method, "/*path", webdavHandler.Serve)
+	}
+
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
 	})
 
+	// SIGTERM or SIGINT starts a graceful shutdown, as does a listener
+	// failing; a second signal kills the process
+	stopping, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	serveErr := make(chan error, 4)
+
 	srv := &http.Server{
-		Addr:    ":8080",
+		Addr:    cfg.HTTP.Addr,
 		Handler: r,
 	}
+	srv.RegisterOnShutdown(eventsHandler.Shutdown)
+	httpServers := []*http.Server{srv}
+	servers := []shutdowner{srv}
+
+	// S3-compatible API on its own listener, so bucket paths don't clash
+	// with the routes above
+	if cfg.S3.Enabled() {
+		s3Router := gin.New()
+		s3Router.Use(gin.Logger(), gin.Recovery(), api.Metrics("s3"), api.MeasureTransfers("s3"))
+		s3Router.Any("/*path", s3Handler.Serve)
+		s3Srv := &http.Server{Addr: cfg.S3.Addr, Handler: s3Router}
+		httpServers = append(httpServers, s3Srv)
+		servers = append(servers, s3Srv)
+		go func() {
+			log.Printf("S3 API running on %s", cfg.S3.Addr)
+			if err := s3Srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				serveErr <- fmt.Errorf("s3 listen: %w", err)
+			}
+		}()
+	}
+
+	// Prometheus metrics on their own listener, kept off the public ports
+	if pool, err := conn.DB(); err == nil {
+		metrics.RegisterDB(pool)
+	}
+	metrics.RegisterStorage(fileRepo.StorageTotals, 30*time.Second)
+	if cfg.Metrics.Enabled() {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		metricsSrv := &http.Server{Addr: cfg.Metrics.Addr, Handler: mux}
+		httpServers = append(httpServers, metricsSrv)
+		servers = append(servers, metricsSrv)
+		go func() {
+			log.Printf("Metrics served on %s/metrics", cfg.Metrics.Addr)
+			if err := metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				serveErr <- fmt.Errorf("metrics listen: %w", err)
+			}
+		}()
+	}
+
+	// SFTP on its own listener, for partners that only support SFTP drops
+	if cfg.SFTP.Enabled() {
+		hostKeyPath := cfg.SFTP.HostKey
+		if hostKeyPath == "" {
+			hostKeyPath = filepath.Join(fileConfig.UploadDir, ".sftp_host_key")
+		}
+		hostKey, err := api.LoadHostKey(hostKeyPath)
+		if err != nil {
+			log.Fatal("Failed to load SFTP host key:", err)
+		}
+		sftpServer := api.NewSFTPServer(fileService, tokenService, sshKeyService, auditService, hostKey)
+		servers = append(servers, sftpServer)
+		go func() {
+			log.Printf("SFTP running on %s", cfg.SFTP.Addr)
+			if err := sftpServer.ListenAndServe(cfg.SFTP.Addr); err != nil && err != api.ErrSFTPServerClosed {
+				serveErr <- fmt.Errorf("sftp listen: %w", err)
+			}
+		}()
+	}
+
+	go func() {
+		log.Printf("Server running on %s", cfg.HTTP.Addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			serveErr <- fmt.Errorf("listen: %w", err)
+		}
+	}()
+
+	failed := false
+	select {
+	case <-stopping.Done():
+		log.Printf("Shutting down; waiting up to %s for transfers in progress", cfg.Shutdown.DrainTimeout)
+	case err := <-serveErr:
+		log.Printf("%v; shutting down", err)
+		failed = true
+	}
+	stop()
+
+	// Stop accepting connections and uploads, and let transfers in progress
+	// finish. Any still running when the time is up are cut off, and the
+	// staging files they were writing removed.
+	drainCtx, cancel := context.WithTimeout(context.Background(), cfg.Shutdown.DrainTimeout)
+	err = drain(drainCtx, servers)
+	cancel()
+	if err != nil {
+		log.Printf("Drain did not finish: %v", err)
+		for _, s := range httpServers {
+			s.Close()
+		}
+		if n := fileService.AbortUploads(); n > 0 {
+			log.Printf("Aborted %d uploads in progress", n)
+		}
+	}
+
+	// Let the workers finish the job in hand, then flush what the last
+	// requests queued
+	stopWorkers()
+	workers.Wait()
+	if err := eventDispatcher.Drain(); err != nil {
+		log.Printf("event dispatcher: %v", err)
+	}
+	if _, err := blobDeleter.Drain(); err != nil {
+		log.Printf("blob deleter: %v", err)
+	}
+	auditService.Drain()
+
+	if pool, err := conn.DB(); err == nil {
+		pool.Close()
+	}
+	log.Println("Server stopped")
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// shutdowner is a listener that can stop gracefully, such as *http.Server
+// or *api.SFTPServer.
+type shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
 
-	log.Println("Server running on :8080")
-	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("listen: %s\n", err)
+// drain shuts the servers down together and returns their errors, such as
+// ctx ending with transfers still in progress.
+func drain(ctx context.Context, servers []shutdowner) error {
+	errs := make([]error, len(servers))
+	var wg sync.WaitGroup
+	for i, s := range servers {
+		wg.Go(func() { errs[i] = s.Shutdown(ctx) })
 	}
+	wg.Wait()
+	return errors.Join(errs...)
 }
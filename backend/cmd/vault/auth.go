@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+
+	"backend/pkg/vaultclient"
+)
+
+func runLogin(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("[--server URL] [--username NAME] [--password-stdin]")
+	server := fs.String("server", "", "server URL, such as https://vault.example.com")
+	username := fs.String("username", "", "username (prompted for if not given)")
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from standard input")
+	if err := fs.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if fs.NArg() > 0 {
+		return usagef("unexpected argument %q", fs.Arg(0))
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if *server != "" {
+		cfg.Server = strings.TrimSuffix(*server, "/")
+	}
+	if cfg.Server == "" {
+		return usagef("no server configured; pass --server")
+	}
+	if *username == "" {
+		*username = cfg.Username
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	if *username == "" {
+		if *passwordStdin {
+			return usagef("--password-stdin needs --username")
+		}
+		fmt.Fprint(a.stderr, "Username: ")
+		line, err := in.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		*username = strings.TrimSpace(line)
+	}
+	password, err := readPassword(a, in, *passwordStdin)
+	if err != nil {
+		return err
+	}
+
+	c, err := vaultclient.New(cfg.Server, vaultclient.WithUserAgent("vault-cli"))
+	if err != nil {
+		return usageError{err}
+	}
+	if _, err := c.Login(ctx, *username, password); err != nil {
+		return err
+	}
+
+	// Keep a revocable token rather than the password or the short-lived
+	// session
+	host, _ := os.Hostname()
+	secret, token, err := c.CreateToken(ctx, "vault CLI on "+host, 0)
+	if err != nil {
+		return fmt.Errorf("creating a token: %w", err)
+	}
+	c.Logout(ctx)
+
+	if cfg.TokenID != 0 && cfg.Token != "" {
+		// Revoke the token an earlier login stored
+		old, err := vaultclient.New(cfg.Server, vaultclient.WithToken(cfg.Token))
+		if err == nil {
+			old.RevokeToken(ctx, cfg.TokenID)
+		}
+	}
+	cfg.Username, cfg.Token, cfg.TokenID = *username, secret, token.ID
+	if err := saveConfig(cfg); err != nil {
+		return fmt.Errorf("saving credentials: %w", err)
+	}
+
+	a.print(map[string]any{"server": cfg.Server, "username": cfg.Username, "token_id": token.ID}, func(w io.Writer) {
+		fmt.Fprintf(w, "Logged in to %s as %s\n", cfg.Server, cfg.Username)
+	})
+	return nil
+}
+
+// readPassword prompts without echo on a terminal, or reads one line.
+func readPassword(a *app, in *bufio.Reader, fromStdin bool) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !fromStdin && term.IsTerminal(fd) {
+		fmt.Fprint(a.stderr, "Password: ")
+		b, err := term.ReadPassword(fd)
+		fmt.Fprintln(a.stderr)
+		return string(b), err
+	}
+	if !fromStdin {
+		return "", usagef("standard input is not a terminal; use --password-stdin")
+	}
+	line, err := in.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func runLogout(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("")
+	if err := fs.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if err := a.connect(); err != nil {
+		return err
+	}
+
+	revoked := false
+	if a.cfg.TokenID != 0 {
+		err := a.client.RevokeToken(ctx, a.cfg.TokenID)
+		if err != nil && !errors.Is(err, vaultclient.ErrNotFound) && !errors.Is(err, vaultclient.ErrUnauthorized) {
+			return fmt.Errorf("revoking the token: %w", err)
+		}
+		revoked = err == nil
+	}
+	if err := removeConfig(); err != nil {
+		return err
+	}
+	a.print(map[string]any{"revoked": revoked}, func(w io.Writer) {
+		fmt.Fprintln(w, "Logged out")
+	})
+	return nil
+}
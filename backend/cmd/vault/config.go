@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// config is what login stores. The password is never written; login
+// exchanges it for a personal token that logout revokes.
+type config struct {
+	Server   string `json:"server"`
+	Username string `json:"username"`
+	Token    string `json:"token"`
+	TokenID  uint   `json:"token_id"`
+}
+
+// configDir is $VAULT_CONFIG_DIR, or "vault" in the user's config
+// directory (~/.config/vault on Linux).
+func configDir() (string, error) {
+	if dir := os.Getenv("VAULT_CONFIG_DIR"); dir != "" {
+		return dir, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "vault"), nil
+}
+
+// readPrivate reads a file in the config directory, refusing files other
+// users can read or write, as ssh does for keys.
+func readPrivate(name string) ([]byte, error) {
+	dir, err := configDir()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, name)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Mode().Perm()&0o077 != 0 {
+		return nil, fmt.Errorf("%s is accessible by other users; run: chmod 600 %s", path, path)
+	}
+	return os.ReadFile(path)
+}
+
+// writePrivate replaces a file in the config directory atomically, with
+// permissions for the user only.
+func writePrivate(name string, data []byte) error {
+	dir, err := configDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, name+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// CreateTemp already uses 0600; be explicit in case that changes
+	if err := os.Chmod(tmp.Name(), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, name))
+}
+
+// loadConfig reads config.json. VAULT_SERVER and VAULT_TOKEN override it,
+// for CI jobs that keep credentials in their secret store instead.
+func loadConfig() (*config, error) {
+	cfg := &config{}
+	data, err := readPrivate("config.json")
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("reading config: %w", err)
+		}
+	}
+	if v := os.Getenv("VAULT_SERVER"); v != "" {
+		cfg.Server = v
+	}
+	if v := os.Getenv("VAULT_TOKEN"); v != "" {
+		cfg.Token, cfg.TokenID = v, 0
+	}
+	return cfg, nil
+}
+
+func saveConfig(cfg *config) error {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writePrivate("config.json", append(data, '\n'))
+}
+
+func removeConfig() error {
+	dir, err := configDir()
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filepath.Join(dir, "config.json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// resumeState remembers the IDs of resumable uploads in progress, so an
+// interrupted "vault put" picks up where it stopped. Entries are keyed by
+// the local file's path, size and modification time and the destination,
+// so a changed file starts over.
+type resumeState struct {
+	mu      sync.Mutex
+	Uploads map[string]string `json:"uploads"`
+}
+
+const resumeFile = "uploads.json"
+
+func resumeKey(server, path string, fi os.FileInfo, folder string) string {
+	return server + "\x00" + path + "\x00" + strconv.FormatInt(fi.Size(), 10) + "\x00" +
+		strconv.FormatInt(fi.ModTime().UnixNano(), 10) + "\x00" + folder
+}
+
+func loadResumeState() *resumeState {
+	st := &resumeState{Uploads: map[string]string{}}
+	if data, err := readPrivate(resumeFile); err == nil {
+		json.Unmarshal(data, st)
+	}
+	if st.Uploads == nil {
+		st.Uploads = map[string]string{}
+	}
+	return st
+}
+
+func (st *resumeState) get(key string) string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.Uploads[key]
+}
+
+// set records an upload ID for key, or forgets key when id is empty, and
+// saves the state right away so it survives a crash.
+func (st *resumeState) set(key, id string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if id == "" {
+		delete(st.Uploads, key)
+	} else {
+		st.Uploads[key] = id
+	}
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return writePrivate(resumeFile, data)
+}
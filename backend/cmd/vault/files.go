@@ -0,0 +1,475 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"backend/pkg/vaultclient"
+)
+
+var (
+	errNoSuchFile = errors.New("no such file")
+	errNotShared  = errors.New(`file is not shared; run "vault share"`)
+)
+
+// resolve finds a file by the ID "vault ls" shows or by its path. Where
+// several files share a path, the newest one is meant, as on the server.
+func (a *app) resolve(ctx context.Context, arg string) (*vaultclient.File, error) {
+	if id, err := strconv.ParseUint(arg, 10, 64); err == nil {
+		for f, err := range a.client.Files(ctx, nil) {
+			if err != nil {
+				return nil, err
+			}
+			if f.ID == uint(id) {
+				return &f, nil
+			}
+		}
+		return nil, fmt.Errorf("%s: %w", arg, errNoSuchFile)
+	}
+
+	p := strings.Trim(arg, "/")
+	var found *vaultclient.File
+	for f, err := range a.client.Files(ctx, &vaultclient.ListOptions{Filename: path.Base(p)}) {
+		if err != nil {
+			return nil, err
+		}
+		if f.Path() == p && (found == nil || f.ID > found.ID) {
+			found = &f
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%s: %w", arg, errNoSuchFile)
+	}
+	return found, nil
+}
+
+// stringList collects a flag that may be repeated or comma-separated.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*l = append(*l, s)
+		}
+	}
+	return nil
+}
+
+// sizeFlag is a byte count such as 1048576, 512K, 10M or 2G.
+type sizeFlag struct{ v *int64 }
+
+func (s sizeFlag) String() string {
+	if s.v == nil || *s.v == 0 {
+		return ""
+	}
+	return strconv.FormatInt(*s.v, 10)
+}
+
+func (s sizeFlag) Set(v string) error {
+	n, err := parseSize(v)
+	if err != nil {
+		return err
+	}
+	*s.v = n
+	return nil
+}
+
+func parseSize(v string) (int64, error) {
+	mult := int64(1)
+	switch strings.ToUpper(v[len(v)-min(len(v), 1):]) {
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	case "T":
+		mult = 1 << 40
+	}
+	if mult > 1 {
+		v = v[:len(v)-1]
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("must be a size such as 4096, 512K, 10M or 2G")
+	}
+	return n * mult, nil
+}
+
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func parseDate(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, v)
+}
+
+func runList(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("[flags] [FOLDER]")
+	var opts vaultclient.ListOptions
+	var minSize, maxSize int64
+	var tags, meta stringList
+	var since, until string
+	fs.StringVar(&opts.Filename, "name", "", "name contains this text")
+	fs.StringVar(&opts.MimeType, "type", "", `MIME type, such as "application/pdf" or "image/*"`)
+	fs.StringVar(&opts.Uploader, "uploader", "", "original uploader's username contains this text")
+	fs.Var(sizeFlag{&minSize}, "min-size", "at least this size (4096, 512K, 10M, 2G)")
+	fs.Var(sizeFlag{&maxSize}, "max-size", "at most this size")
+	fs.StringVar(&since, "since", "", "uploaded on or after this date (YYYY-MM-DD or RFC 3339)")
+	fs.StringVar(&until, "until", "", "uploaded on or before this date")
+	fs.Var(&tags, "tag", "has this tag; repeat or separate with commas for several")
+	fs.Var(&meta, "meta", `has metadata "key" or "key=value"; repeatable`)
+	public := fs.Bool("public", false, "only public files")
+	private := fs.Bool("private", false, "only private files")
+	fs.StringVar(&opts.Sort, "sort", "", "name, size, uploaded_at, downloads or mime_type")
+	fs.StringVar(&opts.Order, "order", "", "asc or desc")
+	limit := fs.Int("limit", 0, "show at most this many files")
+	if err := fs.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if fs.NArg() > 1 {
+		return usagef("ls takes at most one folder")
+	}
+	if *public && *private {
+		return usagef("--public and --private exclude each other")
+	}
+
+	if minSize > 0 {
+		opts.MinSize = &minSize
+	}
+	if maxSize > 0 {
+		opts.MaxSize = &maxSize
+	}
+	var err error
+	if since != "" {
+		if opts.StartDate, err = parseDate(since); err != nil {
+			return usagef("--since: %v", err)
+		}
+	}
+	if until != "" {
+		if opts.EndDate, err = parseDate(until); err != nil {
+			return usagef("--until: %v", err)
+		}
+		if len(until) == len(time.DateOnly) {
+			// The whole day, as the web app treats a date
+			opts.EndDate = opts.EndDate.AddDate(0, 0, 1).Add(-time.Microsecond)
+		}
+	}
+	opts.Tags, opts.Metadata = tags, meta
+	if *public || *private {
+		opts.Public = public
+	}
+	if *limit > 0 && *limit < 200 {
+		opts.Limit = *limit
+	}
+	folder := strings.Trim(fs.Arg(0), "/")
+
+	if err := a.connect(); err != nil {
+		return err
+	}
+	files := []vaultclient.File{}
+	for f, err := range a.client.Files(ctx, &opts) {
+		if err != nil {
+			return err
+		}
+		if folder != "" && f.Folder != folder && !strings.HasPrefix(f.Folder, folder+"/") {
+			continue
+		}
+		files = append(files, f)
+		if *limit > 0 && len(files) == *limit {
+			break
+		}
+	}
+
+	a.print(files, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tSIZE\tUPLOADED\tPUBLIC\tPATH")
+		for _, f := range files {
+			pub := "-"
+			if f.Public {
+				pub = "yes"
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", f.ID, humanSize(f.Size), f.UploadDate, pub, f.Path())
+		}
+		tw.Flush()
+	})
+	return nil
+}
+
+func runGet(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("[-o PATH] FILE...")
+	out := fs.String("o", "", `where to write: a file, a directory, or "-" for standard output`)
+	if err := fs.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if fs.NArg() == 0 {
+		return usagef("name at least one file")
+	}
+	toDir := fs.NArg() > 1
+	if fi, err := os.Stat(*out); err == nil && fi.IsDir() {
+		toDir = true
+	}
+	if *out == "-" && fs.NArg() > 1 {
+		return usagef(`"-o -" takes a single file`)
+	}
+	if err := a.connect(); err != nil {
+		return err
+	}
+
+	type result struct {
+		File  string `json:"file"`
+		Path  string `json:"path,omitempty"`
+		Bytes int64  `json:"bytes"`
+		Error string `json:"error,omitempty"`
+	}
+	var results []result
+	var lastErr error
+	for _, arg := range fs.Args() {
+		r := result{File: arg}
+		dest, n, err := a.getOne(ctx, arg, *out, toDir, fs.NArg() == 1)
+		r.Path, r.Bytes = dest, n
+		if err != nil {
+			r.Error, lastErr = err.Error(), err
+			a.warn(len(fs.Args()), err)
+		}
+		results = append(results, r)
+	}
+
+	if *out != "-" {
+		a.print(results, func(w io.Writer) {
+			for _, r := range results {
+				if r.Error == "" {
+					fmt.Fprintf(w, "%s -> %s (%s)\n", r.File, r.Path, humanSize(r.Bytes))
+				}
+			}
+		})
+	}
+	return batchError(lastErr, len(results), countFailed(results, func(r result) bool { return r.Error != "" }))
+}
+
+// getOne downloads one file to out, via a temporary file so a failed
+// download leaves nothing half-written behind.
+func (a *app) getOne(ctx context.Context, arg, out string, toDir, showProgress bool) (string, int64, error) {
+	f, err := a.resolve(ctx, arg)
+	if err != nil {
+		return "", 0, err
+	}
+	var progress vaultclient.ProgressFunc
+	if showProgress {
+		progress = a.progress(f.Filename)
+	}
+	if out == "-" {
+		n, err := a.client.Download(ctx, f.FileID, a.stdout, progress)
+		return "-", n, err
+	}
+
+	dest := out
+	switch {
+	case dest == "":
+		dest = f.Filename
+	case toDir:
+		dest = filepath.Join(dest, f.Filename)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".vault-get-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+	n, err := a.client.Download(ctx, f.FileID, tmp, progress)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", n, fmt.Errorf("%s: %w", arg, err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return "", n, err
+	}
+	return dest, n, os.Rename(tmp.Name(), dest)
+}
+
+func runRemove(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("FILE...")
+	if err := fs.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if fs.NArg() == 0 {
+		return usagef("name at least one file")
+	}
+	if err := a.connect(); err != nil {
+		return err
+	}
+
+	type result struct {
+		File  string `json:"file"`
+		ID    uint   `json:"id,omitempty"`
+		Error string `json:"error,omitempty"`
+	}
+	var results []result
+	var lastErr error
+	for _, arg := range fs.Args() {
+		r := result{File: arg}
+		f, err := a.resolve(ctx, arg)
+		if err == nil {
+			r.ID = f.ID
+			err = a.client.Delete(ctx, f.ID)
+		}
+		if err != nil {
+			r.Error, lastErr = err.Error(), err
+			a.warn(len(fs.Args()), err)
+		}
+		results = append(results, r)
+	}
+	a.print(results, func(w io.Writer) {
+		for _, r := range results {
+			if r.Error == "" {
+				fmt.Fprintf(w, "removed %s\n", r.File)
+			}
+		}
+	})
+	return batchError(lastErr, len(results), countFailed(results, func(r result) bool { return r.Error != "" }))
+}
+
+func runShare(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("[--revoke] FILE")
+	revoke := fs.Bool("revoke", false, "make the file private, so its link stops working")
+	if err := fs.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if fs.NArg() != 1 {
+		return usagef("name one file")
+	}
+	if err := a.connect(); err != nil {
+		return err
+	}
+	f, err := a.resolve(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	s, err := a.client.SetPublic(ctx, f.ID, !*revoke)
+	if err != nil {
+		return err
+	}
+
+	out := map[string]any{"id": f.ID, "path": f.Path(), "visibility": s.Visibility}
+	if s.Token != "" {
+		out["url"] = a.client.PublicURL(s.Token)
+	}
+	a.print(out, func(w io.Writer) {
+		if s.Token == "" {
+			fmt.Fprintf(w, "%s is private\n", f.Path())
+			return
+		}
+		fmt.Fprintln(w, a.client.PublicURL(s.Token))
+	})
+	return nil
+}
+
+func runPublicLink(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("FILE")
+	if err := fs.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if fs.NArg() != 1 {
+		return usagef("name one file")
+	}
+	if err := a.connect(); err != nil {
+		return err
+	}
+	f, err := a.resolve(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	token := f.PublicToken()
+	if token == "" {
+		return fmt.Errorf("%s: %w", fs.Arg(0), errNotShared)
+	}
+	url := a.client.PublicURL(token)
+	a.print(map[string]any{"id": f.ID, "path": f.Path(), "url": url}, func(w io.Writer) {
+		fmt.Fprintln(w, url)
+	})
+	return nil
+}
+
+func runStats(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("")
+	if err := fs.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if err := a.connect(); err != nil {
+		return err
+	}
+	s, err := a.client.StorageStats(ctx)
+	if err != nil {
+		return err
+	}
+	a.print(s, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "Stored:\t%s\n", humanSize(s.TotalStorage))
+		fmt.Fprintf(tw, "Uploaded:\t%s\n", humanSize(s.OriginalStorage))
+		saved := ""
+		if s.OriginalStorage > 0 {
+			saved = fmt.Sprintf(" (%.1f%%)", 100*float64(s.Savings)/float64(s.OriginalStorage))
+		}
+		fmt.Fprintf(tw, "Saved by deduplication:\t%s%s\n", humanSize(s.Savings), saved)
+		tw.Flush()
+	})
+	return nil
+}
+
+// warn reports the failure of one of n files. A lone file's error is left
+// for main to print.
+func (a *app) warn(n int, err error) {
+	if n > 1 && !a.json {
+		fmt.Fprintf(a.stderr, "vault %s: %v\n", a.name, err)
+	}
+}
+
+func countFailed[T any](results []T, failed func(T) bool) int {
+	n := 0
+	for _, r := range results {
+		if failed(r) {
+			n++
+		}
+	}
+	return n
+}
+
+// batchError is the error for a command run over several files: the
+// failure itself when there was only one file, errPartial when only some
+// failed. Commands report each file's error as they go, so this is a
+// summary.
+func batchError(last error, total, failed int) error {
+	switch {
+	case failed == 0:
+		return nil
+	case total == 1:
+		return last
+	case failed < total:
+		return fmt.Errorf("%d of %d files failed: %w", failed, total, errPartial)
+	}
+	return fmt.Errorf("all %d files failed; last error: %w", total, last)
+}
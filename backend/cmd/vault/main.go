@@ -0,0 +1,197 @@
+// Command vault is a command-line client for the file vault.
+//
+//	vault login --server https://vault.example.com
+//	vault put -r -j 4 ./photos --to backup
+//	vault ls --type 'image/*' --json
+//
+// Credentials and settings live in a config file readable only by the
+// user. Every command takes --json for machine-readable output, and exit
+// codes tell scripts what went wrong.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"backend/pkg/vaultclient"
+)
+
+// Exit codes.
+const (
+	exitOK       = 0
+	exitError    = 1 // anything not listed below
+	exitUsage    = 2
+	exitAuth     = 3 // not logged in, or credentials rejected
+	exitNotFound = 4
+	exitConflict = 5 // content already stored, or a name clash
+	exitQuota    = 6 // quota exceeded or file too large
+	exitPartial  = 7 // some of several files failed
+)
+
+const usage = `Usage: vault <command> [flags] [args]
+
+Commands:
+  login        sign in and store a personal token
+  logout       revoke the stored token and forget it
+  ls           list files, with the same filters as the web app
+  put          upload files or, with -r, directories
+  get          download files
+  rm           delete files
+  share        make a file public and print its link, or --revoke it
+  public-link  print the current public link of a file
+  stats        show storage usage and deduplication savings
+  sync         keep a local directory and a vault folder the same
+
+Files are named by the ID shown by "vault ls" or by their path.
+Run "vault <command> -h" for a command's flags.
+
+Exit codes: 0 ok, 1 error, 2 usage, 3 auth, 4 not found, 5 conflict,
+6 quota or size limit, 7 partial failure.
+`
+
+type command func(ctx context.Context, app *app, args []string) error
+
+var commands = map[string]command{
+	"login":       runLogin,
+	"logout":      runLogout,
+	"ls":          runList,
+	"put":         runPut,
+	"get":         runGet,
+	"rm":          runRemove,
+	"share":       runShare,
+	"public-link": runPublicLink,
+	"stats":       runStats,
+	"sync":        runSync,
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+// run executes the command named by args[0] and returns the exit code.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "vault: unknown command %q\n\n%s", args[0], usage)
+		return exitUsage
+	}
+
+	a := &app{name: args[0], stdout: stdout, stderr: stderr}
+	err := cmd(ctx, a, args[1:])
+	if err == nil {
+		return exitOK
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return exitUsage
+	}
+	code := exitCode(err)
+	if a.json {
+		json.NewEncoder(a.stderr).Encode(map[string]any{"error": err.Error(), "exit_code": code})
+	} else {
+		fmt.Fprintf(a.stderr, "vault %s: %v\n", a.name, err)
+	}
+	return code
+}
+
+// app is what commands share: output settings and, once loaded, the
+// config and client.
+type app struct {
+	name   string
+	json   bool
+	stdout io.Writer
+	stderr io.Writer
+
+	cfg    *config
+	client *vaultclient.Client
+}
+
+// flags returns a flag set for the command with the flags every command
+// has.
+func (a *app) flags(synopsis string) *flag.FlagSet {
+	fs := flag.NewFlagSet("vault "+a.name, flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	fs.BoolVar(&a.json, "json", false, "print JSON instead of text")
+	fs.Usage = func() {
+		fmt.Fprintf(a.stderr, "Usage: vault %s %s\n\nFlags:\n", a.name, synopsis)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// connect loads the config and builds a client signed in with the stored
+// token.
+func (a *app) connect() error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.Server == "" || cfg.Token == "" {
+		return errNotLoggedIn
+	}
+	c, err := vaultclient.New(cfg.Server, vaultclient.WithToken(cfg.Token), vaultclient.WithUserAgent("vault-cli"))
+	if err != nil {
+		return err
+	}
+	a.cfg, a.client = cfg, c
+	return nil
+}
+
+// print writes v as JSON, or calls human to write text.
+func (a *app) print(v any, human func(w io.Writer)) {
+	if a.json {
+		enc := json.NewEncoder(a.stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(v)
+		return
+	}
+	human(a.stdout)
+}
+
+var (
+	errNotLoggedIn = errors.New(`not logged in; run "vault login"`)
+	errPartial     = errors.New("some files failed")
+)
+
+// usageError marks errors in how the command was invoked.
+type usageError struct{ error }
+
+func (e usageError) Unwrap() error { return e.error }
+
+func usagef(format string, args ...any) error {
+	return usageError{fmt.Errorf(format, args...)}
+}
+
+func exitCode(err error) int {
+	var ue usageError
+	switch {
+	case errors.Is(err, errNotLoggedIn), errors.Is(err, vaultclient.ErrUnauthorized):
+		return exitAuth
+	case errors.As(err, &ue):
+		return exitUsage
+	case errors.Is(err, errPartial):
+		return exitPartial
+	case errors.Is(err, vaultclient.ErrNotFound), errors.Is(err, errNoSuchFile),
+		errors.Is(err, errNotShared), errors.Is(err, fs.ErrNotExist):
+		return exitNotFound
+	case errors.Is(err, vaultclient.ErrAlreadyUploaded), errors.Is(err, vaultclient.ErrConflict):
+		return exitConflict
+	case errors.Is(err, vaultclient.ErrQuotaExceeded), errors.Is(err, vaultclient.ErrFileTooLarge):
+		return exitQuota
+	}
+	return exitError
+}
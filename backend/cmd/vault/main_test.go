@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"backend/pkg/vaultclient"
+)
+
+func TestExitCode(t *testing.T) {
+	// The client maps statuses and codes onto its sentinels, so these stand
+	// in for its errors
+	apiErr := func(kind error) error { return fmt.Errorf("vaultclient: %w", kind) }
+	for _, tt := range []struct {
+		err  error
+		want int
+	}{
+		{errNotLoggedIn, exitAuth},
+		{fmt.Errorf("a.txt: %w", apiErr(vaultclient.ErrUnauthorized)), exitAuth},
+		{usagef("name at least one file"), exitUsage},
+		{batchError(errors.New("x"), 3, 1), exitPartial},
+		{apiErr(vaultclient.ErrNotFound), exitNotFound},
+		{fmt.Errorf("docs/a.txt: %w", errNoSuchFile), exitNotFound},
+		{errNotShared, exitNotFound},
+		{&fs.PathError{Op: "stat", Path: "missing.txt", Err: fs.ErrNotExist}, exitNotFound},
+		{apiErr(vaultclient.ErrAlreadyUploaded), exitConflict},
+		{apiErr(vaultclient.ErrConflict), exitConflict},
+		{apiErr(vaultclient.ErrQuotaExceeded), exitQuota},
+		{apiErr(vaultclient.ErrFileTooLarge), exitQuota},
+		{apiErr(vaultclient.ErrForbidden), exitError},
+		{apiErr(vaultclient.ErrServer), exitError},
+		{errors.New("connection refused"), exitError},
+	} {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestBatchError(t *testing.T) {
+	last := errors.New("b.txt: quota")
+	if err := batchError(last, 3, 0); err != nil {
+		t.Errorf("no failures: %v", err)
+	}
+	if err := batchError(last, 1, 1); err != last {
+		t.Errorf("lone failure: %v, want the file's own error", err)
+	}
+	if err := batchError(last, 3, 2); !errors.Is(err, errPartial) || errors.Is(err, last) {
+		t.Errorf("some failed: %v, want a partial failure", err)
+	}
+	if err := batchError(last, 3, 3); errors.Is(err, errPartial) || !errors.Is(err, last) {
+		t.Errorf("all failed: %v, want the last error", err)
+	}
+}
+
+// fakeServer imitates the parts of the vault API the commands use.
+type fakeServer struct {
+	mu       sync.Mutex
+	files    map[string][]byte // by path
+	parts    map[int][]byte    // of the one resumable upload
+	uploadID string
+	uploadTo [2]string // folder and filename of the resumable upload
+	partPuts []int
+	failPart int // answer 500 to this part number once
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func md5Hex(b []byte) string {
+	sum := md5.Sum(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func (srv *fakeServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/storage-stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"total_storage": 1 << 20, "original_storage": 4 << 20, "savings": 3 << 20})
+	})
+	mux.HandleFunc("POST /api/upload", func(w http.ResponseWriter, r *http.Request) {
+		f, hdr, err := r.FormFile("file")
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "no file provided"})
+			return
+		}
+		data, _ := io.ReadAll(f)
+		if strings.HasPrefix(string(data), "too much") {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "storage quota exceeded", "code": "quota_exceeded"})
+			return
+		}
+		folder := r.URL.Query().Get("folder")
+		srv.mu.Lock()
+		srv.files[path.Join(folder, hdr.Filename)] = data
+		id := len(srv.files)
+		srv.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]any{"id": id, "file_id": id, "filename": hdr.Filename, "folder": folder})
+	})
+
+	mux.HandleFunc("POST /api/uploads", func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Folder, Filename string }
+		json.NewDecoder(r.Body).Decode(&req)
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+		srv.uploadID = fmt.Sprintf("up%d", len(srv.partPuts)+1)
+		srv.uploadTo = [2]string{req.Folder, req.Filename}
+		srv.parts = map[int][]byte{}
+		writeJSON(w, http.StatusCreated, map[string]any{"upload_id": srv.uploadID, "folder": req.Folder, "filename": req.Filename})
+	})
+	upload := func(h func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			srv.mu.Lock()
+			known := r.PathValue("id") == srv.uploadID
+			srv.mu.Unlock()
+			if !known {
+				writeJSON(w, http.StatusNotFound, map[string]string{"error": "Upload not found"})
+				return
+			}
+			h(w, r)
+		}
+	}
+	mux.HandleFunc("GET /api/uploads/{id}", upload(func(w http.ResponseWriter, r *http.Request) {
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+		parts := []vaultclient.Part{}
+		for n, data := range srv.parts {
+			parts = append(parts, vaultclient.Part{PartNumber: n, Size: int64(len(data)), ETag: md5Hex(data)})
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"upload_id": srv.uploadID, "parts": parts})
+	}))
+	mux.HandleFunc("PUT /api/uploads/{id}/parts/{n}", upload(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.PathValue("n"))
+		data, _ := io.ReadAll(r.Body)
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+		srv.partPuts = append(srv.partPuts, n)
+		if n == srv.failPart {
+			srv.failPart = 0
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Upload failed"})
+			return
+		}
+		srv.parts[n] = data
+		writeJSON(w, http.StatusOK, map[string]any{"part_number": n, "etag": md5Hex(data)})
+	}))
+	mux.HandleFunc("POST /api/uploads/{id}/complete", upload(func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Parts []vaultclient.Part }
+		json.NewDecoder(r.Body).Decode(&req)
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+		var all []byte
+		for _, p := range req.Parts {
+			all = append(all, srv.parts[p.PartNumber]...)
+		}
+		folder, name := srv.uploadTo[0], srv.uploadTo[1]
+		srv.files[path.Join(folder, name)] = all
+		srv.uploadID = ""
+		id := len(srv.files)
+		writeJSON(w, http.StatusOK, map[string]any{"id": id, "file_id": id, "filename": name, "folder": folder})
+	}))
+	return mux
+}
+
+// setup logs the commands in to a fake server, with the config kept in a
+// temporary directory.
+func setup(t *testing.T) *fakeServer {
+	t.Helper()
+	t.Setenv("VAULT_CONFIG_DIR", t.TempDir())
+	t.Setenv("VAULT_SERVER", "")
+	t.Setenv("VAULT_TOKEN", "")
+	fake := &fakeServer{files: map[string][]byte{}, parts: map[int][]byte{}}
+	srv := httptest.NewServer(fake.routes())
+	t.Cleanup(srv.Close)
+	if err := saveConfig(&config{Server: srv.URL, Username: "alice", Token: "vt_test"}); err != nil {
+		t.Fatal(err)
+	}
+	return fake
+}
+
+func runVault(args ...string) (code int, stdout, stderr string) {
+	var out, errOut bytes.Buffer
+	code = run(context.Background(), args, &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+func writeFiles(t *testing.T, contents ...string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	paths := make([]string, len(contents))
+	for i, c := range contents {
+		paths[i] = filepath.Join(dir, fmt.Sprintf("%c.txt", 'a'+i))
+		if err := os.WriteFile(paths[i], []byte(c), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return paths
+}
+
+func TestRunUsageAndAuthErrors(t *testing.T) {
+	t.Setenv("VAULT_CONFIG_DIR", t.TempDir())
+	t.Setenv("VAULT_SERVER", "")
+	t.Setenv("VAULT_TOKEN", "")
+
+	for _, args := range [][]string{nil, {"help"}, {"frobnicate"}, {"put"}, {"ls", "--bogus"}, {"ls", "-h"}} {
+		if code, stdout, stderr := runVault(args...); code != exitUsage || stdout != "" || stderr == "" {
+			t.Errorf("vault %q: exit %d, stdout %q; want %d with the problem on stderr", args, code, stdout, exitUsage)
+		}
+	}
+
+	code, _, stderr := runVault("stats")
+	if code != exitAuth || stderr != "vault stats: "+errNotLoggedIn.Error()+"\n" {
+		t.Errorf("stats logged out: exit %d, stderr %q", code, stderr)
+	}
+
+	code, stdout, stderr := runVault("stats", "--json")
+	var report struct {
+		Error    string `json:"error"`
+		ExitCode int    `json:"exit_code"`
+	}
+	if err := json.Unmarshal([]byte(stderr), &report); err != nil || stdout != "" {
+		t.Fatalf("stats --json logged out: stdout %q, stderr %q is not a JSON error", stdout, stderr)
+	}
+	if code != exitAuth || report.ExitCode != exitAuth || report.Error != errNotLoggedIn.Error() {
+		t.Errorf("stats --json logged out: exit %d, report %+v", code, report)
+	}
+}
+
+func TestRunOutputModes(t *testing.T) {
+	setup(t)
+
+	code, stdout, stderr := runVault("stats")
+	if code != exitOK || stderr != "" {
+		t.Fatalf("stats: exit %d, stderr %q", code, stderr)
+	}
+	for _, want := range []string{"Stored:", "1.0 MiB", "Saved by deduplication:", "(75.0%)"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("stats text output lacks %q:\n%s", want, stdout)
+		}
+	}
+
+	code, stdout, _ = runVault("stats", "--json")
+	var stats vaultclient.StorageStats
+	if err := json.Unmarshal([]byte(stdout), &stats); err != nil || code != exitOK {
+		t.Fatalf("stats --json: exit %d, %v:\n%s", code, err, stdout)
+	}
+	if stats.Savings != 3<<20 {
+		t.Errorf("stats --json = %+v", stats)
+	}
+}
+
+func TestPutReportsEachFile(t *testing.T) {
+	setup(t)
+	paths := writeFiles(t, "hello", "too much data", "world")
+
+	code, stdout, stderr := runVault("put", "-j", "1", "--to", "docs", paths[0], paths[1], paths[2])
+	if code != exitPartial {
+		t.Errorf("put with one failure: exit %d, want %d", code, exitPartial)
+	}
+	want := fmt.Sprintf("%s -> docs/a.txt\n%s -> docs/c.txt\n", paths[0], paths[2])
+	if stdout != want {
+		t.Errorf("stdout:\n%s\nwant:\n%s", stdout, want)
+	}
+	if !strings.Contains(stderr, paths[1]) || !strings.Contains(stderr, "quota") ||
+		!strings.HasSuffix(stderr, "vault put: 1 of 3 files failed: some files failed\n") {
+		t.Errorf("stderr:\n%s", stderr)
+	}
+
+	code, stdout, stderr = runVault("put", "--json", "--to", "docs", paths[0], paths[1])
+	var results []putResult
+	if err := json.Unmarshal([]byte(stdout), &results); err != nil {
+		t.Fatalf("put --json stdout is not JSON: %v\n%s", err, stdout)
+	}
+	if code != exitPartial || len(results) != 2 ||
+		results[0].Status != "uploaded" || results[0].Path != "docs/a.txt" ||
+		results[1].Status != "failed" || !strings.Contains(results[1].Error, "quota") {
+		t.Errorf("put --json: exit %d, results %+v", code, results)
+	}
+	if !strings.Contains(stderr, `"exit_code":7`) || strings.Count(stderr, "\n") != 1 {
+		t.Errorf("put --json stderr %q, want only the JSON error", stderr)
+	}
+
+	// A lone file's failure carries its own exit code
+	if code, _, _ := runVault("put", paths[1]); code != exitQuota {
+		t.Errorf("put over quota: exit %d, want %d", code, exitQuota)
+	}
+	if code, _, _ := runVault("put", filepath.Join(t.TempDir(), "missing.txt")); code != exitNotFound {
+		t.Errorf("put of a missing file: exit %d, want %d", code, exitNotFound)
+	}
+}
+
+func TestPutResumesInterruptedUpload(t *testing.T) {
+	fake := setup(t)
+	content := "0123456789abcdef" // four parts of four bytes
+	path := writeFiles(t, content)[0]
+	fake.failPart = 3
+
+	if code, _, stderr := runVault("put", "--resumable", "--part-size", "4", path); code != exitError {
+		t.Fatalf("interrupted put: exit %d, %s", code, stderr)
+	}
+	state := loadResumeState()
+	if len(state.Uploads) != 1 {
+		t.Fatalf("resume state %v, want the unfinished upload", state.Uploads)
+	}
+
+	code, stdout, stderr := runVault("put", "--resumable", "--part-size", "4", path)
+	if code != exitOK || stdout != path+" -> a.txt\n" {
+		t.Fatalf("resumed put: exit %d, stdout %q, stderr %q", code, stdout, stderr)
+	}
+	if got := fmt.Sprint(fake.partPuts); got != "[1 2 3 3 4]" {
+		t.Errorf("parts sent %s, want only the failed part and later ones resent", got)
+	}
+	if string(fake.files["a.txt"]) != content {
+		t.Errorf("stored %q", fake.files["a.txt"])
+	}
+	if state := loadResumeState(); len(state.Uploads) != 0 {
+		t.Errorf("resume state %v kept after completing", state.Uploads)
+	}
+
+	// If the server discarded the upload in between, put starts over
+	fake.failPart = 2
+	fake.partPuts = nil
+	runVault("put", "--resumable", "--part-size", "4", path)
+	fake.uploadID = ""
+	if code, _, stderr := runVault("put", "--resumable", "--part-size", "4", path); code != exitOK {
+		t.Fatalf("put after the upload expired: exit %d, %s", code, stderr)
+	}
+	if got := fmt.Sprint(fake.partPuts); got != "[1 2 1 2 3 4]" {
+		t.Errorf("parts sent %s, want a fresh upload after the first attempt", got)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/term"
+
+	"backend/pkg/vaultclient"
+)
+
+type putTask struct {
+	local  string
+	folder string
+	info   os.FileInfo
+}
+
+type putResult struct {
+	Local  string `json:"local"`
+	Path   string `json:"path,omitempty"`
+	ID     uint   `json:"id,omitempty"`
+	Status string `json:"status"` // uploaded, skipped or failed
+	Error  string `json:"error,omitempty"`
+
+	err error
+}
+
+func runPut(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("[-r] [-j N] [--to FOLDER] [--resumable] PATH...")
+	recursive := fs.Bool("r", false, "upload directories and everything in them")
+	jobs := fs.Int("j", 4, "number of files to upload at once")
+	to := fs.String("to", "", "destination folder in the vault")
+	resumable := fs.Bool("resumable", false, "upload every file in resumable parts")
+	partSize := int64(vaultclient.DefaultPartSize)
+	fs.Var(sizeFlag{&partSize}, "part-size", "part size for resumable uploads")
+	resumeOver := int64(64 << 20)
+	fs.Var(sizeFlag{&resumeOver}, "resumable-over", "upload files at least this large in resumable parts")
+	if err := fs.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if fs.NArg() == 0 {
+		return usagef("name at least one file or directory")
+	}
+	if *jobs < 1 {
+		return usagef("-j must be at least 1")
+	}
+
+	tasks, err := collectPutTasks(fs.Args(), *to, *recursive)
+	if err != nil {
+		return err
+	}
+	if err := a.connect(); err != nil {
+		return err
+	}
+	state := loadResumeState()
+
+	results := make([]putResult, len(tasks))
+	var printMu sync.Mutex
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for range min(*jobs, len(tasks)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				t := tasks[i]
+				var progress vaultclient.ProgressFunc
+				if len(tasks) == 1 {
+					progress = a.progress(t.info.Name())
+				}
+				useParts := *resumable || t.info.Size() >= resumeOver
+				results[i] = a.putOne(ctx, state, t, useParts, partSize, progress)
+
+				printMu.Lock()
+				a.reportPut(results[i], len(tasks))
+				printMu.Unlock()
+			}
+		}()
+	}
+	for i := range tasks {
+		if ctx.Err() != nil {
+			break
+		}
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	var lastErr error
+	failed := 0
+	for i := range results {
+		r := &results[i]
+		if r.Status == "" {
+			// Never started because of an interrupt
+			r.Local, r.Status, r.Error, r.err = tasks[i].local, "failed", "interrupted", ctx.Err()
+		}
+		if r.Status == "failed" {
+			failed++
+			lastErr = r.err
+		}
+	}
+	if a.json {
+		a.print(results, nil)
+	}
+	return batchError(lastErr, len(results), failed)
+}
+
+// collectPutTasks expands the arguments into files to upload. A directory
+// is uploaded as a folder of the same name inside the destination, as cp -r
+// would copy it.
+func collectPutTasks(args []string, to string, recursive bool) ([]putTask, error) {
+	var tasks []putTask
+	for _, arg := range args {
+		fi, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if fi.Mode().IsRegular() {
+			tasks = append(tasks, putTask{local: arg, folder: to, info: fi})
+			continue
+		}
+		if !fi.IsDir() {
+			return nil, usagef("%s is not a regular file", arg)
+		}
+		if !recursive {
+			return nil, usagef("%s is a directory; use -r", arg)
+		}
+
+		root := filepath.Clean(arg)
+		base := filepath.Base(root)
+		if base == "." || base == string(filepath.Separator) {
+			base = ""
+		}
+		err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				// Symlinks and special files are skipped, like the
+				// server skips them in archives
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, filepath.Dir(p))
+			if err != nil {
+				return err
+			}
+			folder := path.Join(to, base, filepath.ToSlash(rel))
+			if folder == "." {
+				folder = ""
+			}
+			tasks = append(tasks, putTask{local: p, folder: folder, info: info})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tasks, nil
+}
+
+// putOne uploads one file. Content the user already stores counts as
+// skipped rather than failed, since the vault keeps one copy per user.
+func (a *app) putOne(ctx context.Context, state *resumeState, t putTask, useParts bool, partSize int64, progress vaultclient.ProgressFunc) putResult {
+	r := putResult{Local: t.local}
+	var ref *vaultclient.FileRef
+	var err error
+	if useParts {
+		ref, err = a.putResumable(ctx, state, t, partSize, progress)
+	} else {
+		ref, err = a.client.UploadFile(ctx, t.local, &vaultclient.UploadOptions{Folder: t.folder, Progress: progress})
+	}
+
+	switch {
+	case err == nil:
+		r.Status, r.ID = "uploaded", ref.ID
+		r.Path = path.Join(ref.Folder, ref.Filename)
+	case errors.Is(err, vaultclient.ErrAlreadyUploaded):
+		r.Status, r.Error = "skipped", "already stored"
+	default:
+		r.Status, r.Error, r.err = "failed", err.Error(), fmt.Errorf("%s: %w", t.local, err)
+	}
+	return r
+}
+
+// putResumable uploads in parts, recording the upload ID so that running
+// the same put again after an interruption resumes it.
+func (a *app) putResumable(ctx context.Context, state *resumeState, t putTask, partSize int64, progress vaultclient.ProgressFunc) (*vaultclient.FileRef, error) {
+	abs, err := filepath.Abs(t.local)
+	if err != nil {
+		return nil, err
+	}
+	key := resumeKey(a.cfg.Server, abs, t.info, t.folder)
+	opts := &vaultclient.ResumableOptions{
+		Folder:   t.folder,
+		PartSize: partSize,
+		Progress: progress,
+		UploadID: state.get(key),
+		OnStart:  func(id string) { state.set(key, id) },
+	}
+
+	ref, err := a.client.UploadFileResumable(ctx, t.local, opts)
+	if errors.Is(err, vaultclient.ErrNotFound) && opts.UploadID != "" {
+		// The server discarded the unfinished upload; start over
+		opts.UploadID = ""
+		ref, err = a.client.UploadFileResumable(ctx, t.local, opts)
+	}
+	if err == nil {
+		state.set(key, "")
+	}
+	return ref, err
+}
+
+func (a *app) reportPut(r putResult, n int) {
+	if a.json {
+		return
+	}
+	switch r.Status {
+	case "uploaded":
+		fmt.Fprintf(a.stdout, "%s -> %s\n", r.Local, r.Path)
+	case "skipped":
+		fmt.Fprintf(a.stdout, "%s: skipped, %s\n", r.Local, r.Error)
+	default:
+		a.warn(n, r.err)
+	}
+}
+
+// progress returns a callback that draws a percentage on the terminal, or
+// nil when standard error is not a terminal or output is JSON.
+func (a *app) progress(label string) vaultclient.ProgressFunc {
+	f, ok := a.stderr.(*os.File)
+	if a.json || !ok || !term.IsTerminal(int(f.Fd())) {
+		return nil
+	}
+	last := -1
+	return func(done, total int64) {
+		if total <= 0 {
+			return
+		}
+		pct := int(done * 100 / total)
+		if pct == last {
+			return
+		}
+		last = pct
+		fmt.Fprintf(f, "\r%s %3d%% of %s", label, pct, humanSize(total))
+		if done >= total {
+			fmt.Fprintln(f)
+		}
+	}
+}
@@ -0,0 +1,795 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"backend/pkg/vaultclient"
+)
+
+// Sync keeps a local directory and a vault folder the same, both ways. The
+// server's change feed says what changed in the vault since the last run;
+// comparing the local files with the hashes both sides held after the last
+// run says what changed here. Content is compared by SHA-256, the hash the
+// vault keys its deduplication on, so unchanged files are never sent, and
+// moves and copies of content already present are done without a
+// transfer.
+
+// syncState is what a sync pair remembers between runs.
+type syncState struct {
+	Server string `json:"server"`
+	Local  string `json:"local"`
+	Folder string `json:"folder"`
+
+	// Cursor is how far into the change feed Remote is up to date
+	Cursor int64                 `json:"cursor"`
+	Remote map[string]remoteFile `json:"remote"`
+	// Synced is what both sides held after the last run, by path relative
+	// to the pair's roots
+	Synced map[string]syncedFile `json:"synced"`
+}
+
+type remoteFile struct {
+	ID     uint   `json:"id"`
+	FileID uint   `json:"file_id"`
+	Hash   string `json:"hash"`
+	Size   int64  `json:"size"`
+}
+
+// syncedFile also keeps the local file's size and modification time, so an
+// untouched file need not be hashed again.
+type syncedFile struct {
+	Hash    string `json:"hash"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+}
+
+type localFile struct {
+	hash    string
+	size    int64
+	modTime int64
+}
+
+// syncStateFile names the state file of a pair in the config directory.
+func syncStateFile(server, local, folder string) string {
+	sum := sha256.Sum256([]byte(server + "\x00" + local + "\x00" + folder))
+	return "sync-" + hex.EncodeToString(sum[:8]) + ".json"
+}
+
+func loadSyncState(server, local, folder string) (*syncState, error) {
+	st := &syncState{Server: server, Local: local, Folder: folder}
+	data, err := readPrivate(syncStateFile(server, local, folder))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, st); err != nil {
+			return nil, fmt.Errorf("reading sync state: %w", err)
+		}
+	}
+	if st.Remote == nil {
+		st.Remote = map[string]remoteFile{}
+	}
+	if st.Synced == nil {
+		st.Synced = map[string]syncedFile{}
+	}
+	return st, nil
+}
+
+func (st *syncState) save() error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return writePrivate(syncStateFile(st.Server, st.Local, st.Folder), data)
+}
+
+// Sync actions, in the order they run. Moves and remote deletions go first
+// so that uploads of the same content find it gone, and local deletions go
+// last so that copies can still read from them.
+const (
+	actMoveRemote   = "move-remote"
+	actMoveLocal    = "move-local"
+	actDeleteRemote = "delete-remote"
+	actCopyLocal    = "copy-local"
+	actDownload     = "download"
+	actConflict     = "conflict"
+	actUpload       = "upload"
+	actDuplicate    = "duplicate"
+	actDeleteLocal  = "delete-local"
+	actSame         = "same"
+)
+
+var actionOrder = []string{
+	actMoveRemote, actMoveLocal, actDeleteRemote, actCopyLocal, actDownload,
+	actConflict, actUpload, actDuplicate, actDeleteLocal, actSame,
+}
+
+type syncAction struct {
+	kind string
+	path string
+	from string // source of moves and copies, the other copy for duplicates
+}
+
+// planSync decides what to do with every path from the hashes each side
+// holds now and held after the last run ("" for no file):
+//
+//   - a side that still matches the last run takes the other side's change;
+//   - a file deleted on one side but changed on the other is kept;
+//   - a file changed differently on both sides is a conflict.
+//
+// Uploads of content the vault already holds at a path deleted here become
+// remote moves, and downloads of content already here become local moves
+// or copies. Uploads of content the vault holds elsewhere are reported
+// rather than sent, since the vault keeps one copy of a content per user.
+func planSync(local, remote, synced map[string]string) []syncAction {
+	paths := map[string]bool{}
+	for _, m := range []map[string]string{local, remote, synced} {
+		for p := range m {
+			paths[p] = true
+		}
+	}
+
+	var acts []syncAction
+	var uploads, downloads []string
+	delRemote, delLocal := map[string]bool{}, map[string]bool{}
+	for _, p := range slices.Sorted(maps.Keys(paths)) {
+		b, l, r := synced[p], local[p], remote[p]
+		switch {
+		case l == r:
+			acts = append(acts, syncAction{kind: actSame, path: p})
+		case l == b && r == "":
+			delLocal[p] = true
+		case l == b:
+			downloads = append(downloads, p)
+		case r == b && l == "":
+			delRemote[p] = true
+		case r == b:
+			uploads = append(uploads, p)
+		case l == "":
+			// Deleted here but changed there: keep the change
+			downloads = append(downloads, p)
+		case r == "":
+			uploads = append(uploads, p)
+		default:
+			acts = append(acts, syncAction{kind: actConflict, path: p})
+		}
+	}
+
+	for _, p := range uploads {
+		h := local[p]
+		if remote[p] == "" {
+			if q, ok := findHash(delRemote, remote, h); ok {
+				delete(delRemote, q)
+				acts = append(acts, syncAction{kind: actMoveRemote, path: p, from: q})
+				continue
+			}
+		}
+		if q, ok := findHashExcept(remote, h, p, delRemote); ok {
+			acts = append(acts, syncAction{kind: actDuplicate, path: p, from: q})
+			continue
+		}
+		acts = append(acts, syncAction{kind: actUpload, path: p})
+	}
+	movedLocal := map[string]bool{}
+	for _, p := range downloads {
+		h := remote[p]
+		if local[p] == "" {
+			if q, ok := findHash(delLocal, local, h); ok {
+				delete(delLocal, q)
+				movedLocal[q] = true
+				acts = append(acts, syncAction{kind: actMoveLocal, path: p, from: q})
+				continue
+			}
+		}
+		if q, ok := findHashExcept(local, h, p, movedLocal); ok {
+			acts = append(acts, syncAction{kind: actCopyLocal, path: p, from: q})
+			continue
+		}
+		acts = append(acts, syncAction{kind: actDownload, path: p})
+	}
+	for p := range delRemote {
+		acts = append(acts, syncAction{kind: actDeleteRemote, path: p})
+	}
+	for p := range delLocal {
+		acts = append(acts, syncAction{kind: actDeleteLocal, path: p})
+	}
+
+	slices.SortStableFunc(acts, func(x, y syncAction) int {
+		if c := slices.Index(actionOrder, x.kind) - slices.Index(actionOrder, y.kind); c != 0 {
+			return c
+		}
+		return strings.Compare(x.path, y.path)
+	})
+	return acts
+}
+
+// findHash returns the first path in candidates whose hash in hashes is h.
+func findHash(candidates map[string]bool, hashes map[string]string, h string) (string, bool) {
+	for _, q := range slices.Sorted(maps.Keys(candidates)) {
+		if hashes[q] == h {
+			return q, true
+		}
+	}
+	return "", false
+}
+
+// findHashExcept returns a path other than p whose hash is h, skipping the
+// paths in skip.
+func findHashExcept(hashes map[string]string, h, p string, skip map[string]bool) (string, bool) {
+	for _, q := range slices.Sorted(maps.Keys(hashes)) {
+		if q != p && !skip[q] && hashes[q] == h {
+			return q, true
+		}
+	}
+	return "", false
+}
+
+type syncResult struct {
+	Action string `json:"action"`
+	Path   string `json:"path"`
+	From   string `json:"from,omitempty"`
+	Status string `json:"status"` // done, planned, skipped or failed
+	Detail string `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+
+	err error
+}
+
+// syncer runs one pass over a pair.
+type syncer struct {
+	a       *app
+	root    string
+	st      *syncState
+	local   map[string]localFile
+	uploads *resumeState
+	dryRun  bool
+}
+
+func runSync(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("[--dry-run] [--watch INTERVAL] LOCAL_DIR [FOLDER]")
+	dryRun := fs.Bool("dry-run", false, "print what would be done without doing it")
+	watch := fs.Duration("watch", 0, "keep syncing, waiting this long between passes")
+	if err := fs.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return usagef("name a local directory and, optionally, a vault folder")
+	}
+	if *watch < 0 || (*watch > 0 && *watch < time.Second) {
+		return usagef("--watch must be at least 1s")
+	}
+	root, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if fi, err := os.Stat(root); err != nil {
+		return err
+	} else if !fi.IsDir() {
+		return usagef("%s is not a directory", fs.Arg(0))
+	}
+	folder := strings.Trim(path.Clean("/"+fs.Arg(1)), "/")
+
+	if err := a.connect(); err != nil {
+		return err
+	}
+	for {
+		err := a.syncOnce(ctx, root, folder, *dryRun)
+		if *watch == 0 || ctx.Err() != nil {
+			return err
+		}
+		if errors.Is(err, vaultclient.ErrUnauthorized) {
+			return err
+		}
+		if err != nil && !a.json {
+			fmt.Fprintf(a.stderr, "vault sync: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(*watch):
+		}
+	}
+}
+
+func (a *app) syncOnce(ctx context.Context, root, folder string, dryRun bool) error {
+	st, err := loadSyncState(a.cfg.Server, root, folder)
+	if err != nil {
+		return err
+	}
+	s := &syncer{a: a, root: root, st: st, uploads: loadResumeState(), dryRun: dryRun}
+	if err := s.pull(ctx); err != nil {
+		return fmt.Errorf("reading changes: %w", err)
+	}
+	if err := s.scan(); err != nil {
+		return fmt.Errorf("scanning %s: %w", root, err)
+	}
+
+	localHashes := make(map[string]string, len(s.local))
+	for p, f := range s.local {
+		localHashes[p] = f.hash
+	}
+	remoteHashes := make(map[string]string, len(st.Remote))
+	for p, f := range st.Remote {
+		remoteHashes[p] = f.Hash
+	}
+	syncedHashes := make(map[string]string, len(st.Synced))
+	for p, f := range st.Synced {
+		syncedHashes[p] = f.Hash
+	}
+
+	var results []syncResult
+	for _, act := range planSync(localHashes, remoteHashes, syncedHashes) {
+		if act.kind == actSame {
+			s.markSame(act.path)
+			continue
+		}
+		res := syncResult{Action: act.kind, Path: act.path, From: act.from}
+		switch {
+		case ctx.Err() != nil:
+			res.Status, res.Error, res.err = "failed", "interrupted", ctx.Err()
+		case dryRun:
+			res.Status = "planned"
+		default:
+			res.Detail, res.err = s.apply(ctx, act)
+			switch {
+			case res.err == nil && act.kind == actDuplicate:
+				res.Status = "skipped"
+			case res.err == nil:
+				res.Status = "done"
+			case errors.Is(res.err, errSyncSkipped):
+				res.Status, res.Error, res.err = "skipped", res.Detail, nil
+			default:
+				res.Status, res.Error = "failed", res.err.Error()
+				res.err = fmt.Errorf("%s: %w", act.path, res.err)
+			}
+		}
+		results = append(results, res)
+		a.reportSync(res)
+	}
+
+	if !dryRun {
+		if err := st.save(); err != nil {
+			return fmt.Errorf("saving sync state: %w", err)
+		}
+	}
+	if a.json {
+		if results == nil {
+			results = []syncResult{}
+		}
+		a.print(results, nil)
+	} else if len(results) == 0 {
+		fmt.Fprintf(a.stdout, "%s is up to date\n", root)
+	}
+
+	var lastErr error
+	failed := countFailed(results, func(r syncResult) bool { return r.Status == "failed" })
+	for _, r := range results {
+		if r.err != nil {
+			lastErr = r.err
+		}
+	}
+	return batchError(lastErr, len(results), failed)
+}
+
+// pull brings the view of the vault folder up to date with the change feed.
+func (s *syncer) pull(ctx context.Context) error {
+	for {
+		page, err := s.a.client.Changes(ctx, s.st.Cursor, 0)
+		if err != nil {
+			return err
+		}
+		for _, ch := range page.Changes {
+			rel, ok := s.relPath(ch.Path())
+			if !ok {
+				continue
+			}
+			if ch.Op == vaultclient.ChangeDeleted {
+				delete(s.st.Remote, rel)
+			} else {
+				s.st.Remote[rel] = remoteFile{ID: ch.ID, FileID: ch.FileID, Hash: ch.Hash, Size: ch.Size}
+			}
+		}
+		s.st.Cursor = page.Cursor
+		if !page.HasMore {
+			return nil
+		}
+	}
+}
+
+// relPath maps a vault path to one relative to the pair's folder, or
+// reports false for paths outside it or that can't be stored locally.
+func (s *syncer) relPath(p string) (string, bool) {
+	if s.st.Folder != "" {
+		var ok bool
+		if p, ok = strings.CutPrefix(p, s.st.Folder+"/"); !ok {
+			return "", false
+		}
+	}
+	if !filepath.IsLocal(filepath.FromSlash(p)) || isSyncTemp(path.Base(p)) {
+		return "", false
+	}
+	return p, true
+}
+
+// isSyncTemp reports whether name is one of the temporary files downloads
+// write before renaming them into place.
+func isSyncTemp(name string) bool {
+	return strings.HasPrefix(name, ".vault-")
+}
+
+// scan hashes the local files, reusing the last run's hash for files whose
+// size and modification time are unchanged.
+func (s *syncer) scan() error {
+	s.local = map[string]localFile{}
+	return filepath.WalkDir(s.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() || isSyncTemp(d.Name()) {
+			// Symlinks and special files are skipped, as put skips them
+			return err
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		f := localFile{size: fi.Size(), modTime: fi.ModTime().UnixNano()}
+		if prev, ok := s.st.Synced[rel]; ok && prev.Size == f.size && prev.ModTime == f.modTime {
+			f.hash = prev.Hash
+		} else if f.hash, err = hashFile(p); err != nil {
+			return err
+		}
+		s.local[rel] = f
+		return nil
+	})
+}
+
+func hashFile(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (s *syncer) abs(rel string) string {
+	return filepath.Join(s.root, filepath.FromSlash(rel))
+}
+
+// remoteDest splits a relative path into the vault folder and name.
+func (s *syncer) remoteDest(rel string) (string, string) {
+	dir, name := path.Split(path.Join(s.st.Folder, rel))
+	return strings.TrimSuffix(dir, "/"), name
+}
+
+// errSyncSkipped marks actions left for a later run, such as a file that
+// changed while it was being synced.
+var errSyncSkipped = errors.New("skipped")
+
+// unchanged checks that a local file is still as scanned, so a file edited
+// during the run is never overwritten or deleted.
+func (s *syncer) unchanged(rel string) error {
+	want, ok := s.local[rel]
+	fi, err := os.Lstat(s.abs(rel))
+	switch {
+	case errors.Is(err, fs.ErrNotExist) && !ok:
+		return nil
+	case err != nil && !errors.Is(err, fs.ErrNotExist):
+		return err
+	case err == nil && ok && fi.Size() == want.size && fi.ModTime().UnixNano() == want.modTime:
+		return nil
+	}
+	return errSyncSkipped
+}
+
+// record notes that both sides hold the local file at rel.
+func (s *syncer) record(rel, hash string) {
+	fi, err := os.Stat(s.abs(rel))
+	if err != nil {
+		delete(s.st.Synced, rel)
+		return
+	}
+	s.st.Synced[rel] = syncedFile{Hash: hash, Size: fi.Size(), ModTime: fi.ModTime().UnixNano()}
+}
+
+func (s *syncer) markSame(rel string) {
+	if f, ok := s.local[rel]; ok {
+		s.st.Synced[rel] = syncedFile{Hash: f.hash, Size: f.size, ModTime: f.modTime}
+	} else {
+		delete(s.st.Synced, rel)
+	}
+}
+
+// apply carries out one action, returning a note for the report.
+func (s *syncer) apply(ctx context.Context, act syncAction) (string, error) {
+	p := act.path
+	switch act.kind {
+	case actUpload:
+		return s.upload(ctx, p)
+
+	case actDownload:
+		if err := s.unchanged(p); err != nil {
+			return "changed during sync", err
+		}
+		r := s.st.Remote[p]
+		if err := s.download(ctx, r, p); err != nil {
+			return "", err
+		}
+		s.record(p, r.Hash)
+		return "", nil
+
+	case actDeleteRemote:
+		err := s.a.client.Delete(ctx, s.st.Remote[p].ID)
+		if err != nil && !errors.Is(err, vaultclient.ErrNotFound) {
+			return "", err
+		}
+		delete(s.st.Remote, p)
+		delete(s.st.Synced, p)
+		return "", nil
+
+	case actDeleteLocal:
+		if err := s.unchanged(p); err != nil {
+			return "changed during sync", err
+		}
+		if err := os.Remove(s.abs(p)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		s.removeEmptyDirs(path.Dir(p))
+		delete(s.st.Synced, p)
+		return "", nil
+
+	case actMoveRemote:
+		r := s.st.Remote[act.from]
+		folder, name := s.remoteDest(p)
+		ref, err := s.a.client.Rename(ctx, r.ID, &folder, &name)
+		if err != nil {
+			return "", err
+		}
+		r.ID, r.FileID = ref.ID, ref.FileID
+		delete(s.st.Remote, act.from)
+		delete(s.st.Synced, act.from)
+		s.st.Remote[p] = r
+		s.record(p, r.Hash)
+		return "", nil
+
+	case actMoveLocal:
+		if err := s.unchanged(act.from); err != nil {
+			return "changed during sync", err
+		}
+		if err := s.unchanged(p); err != nil {
+			return "changed during sync", err
+		}
+		if err := os.MkdirAll(filepath.Dir(s.abs(p)), 0o755); err != nil {
+			return "", err
+		}
+		if err := os.Rename(s.abs(act.from), s.abs(p)); err != nil {
+			return "", err
+		}
+		s.removeEmptyDirs(path.Dir(act.from))
+		delete(s.st.Synced, act.from)
+		s.record(p, s.st.Remote[p].Hash)
+		return "", nil
+
+	case actCopyLocal:
+		if err := s.unchanged(p); err != nil {
+			return "changed during sync", err
+		}
+		h := s.st.Remote[p].Hash
+		if err := s.writeFile(p, h, func(w io.Writer) error {
+			src, err := os.Open(s.abs(act.from))
+			if err != nil {
+				return err
+			}
+			defer src.Close()
+			_, err = io.Copy(w, src)
+			return err
+		}); err != nil {
+			return "", err
+		}
+		s.record(p, h)
+		return "", nil
+
+	case actConflict:
+		return s.conflict(ctx, p)
+
+	case actDuplicate:
+		return "the vault holds this content at " + path.Join(s.st.Folder, act.from), nil
+	}
+	return "", fmt.Errorf("unknown sync action %q", act.kind)
+}
+
+// upload sends a local file to its path in the vault, replacing the file
+// there. It goes in resumable parts, so an interrupted run resumes it.
+func (s *syncer) upload(ctx context.Context, p string) (string, error) {
+	if err := s.unchanged(p); err != nil {
+		return "changed during sync", err
+	}
+	fi, err := os.Stat(s.abs(p))
+	if err != nil {
+		return "", err
+	}
+	folder, name := s.remoteDest(p)
+	t := putTask{local: s.abs(p), folder: folder, info: fi}
+	ref, err := s.a.putResumable(ctx, s.uploads, t, vaultclient.DefaultPartSize, nil)
+	if errors.Is(err, vaultclient.ErrAlreadyUploaded) {
+		return "the vault already holds this content at another path", errSyncSkipped
+	}
+	if err != nil {
+		return "", err
+	}
+	if ref.Folder != folder || ref.Filename != name {
+		return "", fmt.Errorf("the vault stored it as %s", path.Join(ref.Folder, ref.Filename))
+	}
+	h := s.local[p].hash
+	s.st.Remote[p] = remoteFile{ID: ref.ID, FileID: ref.FileID, Hash: h, Size: fi.Size()}
+	s.record(p, h)
+	return "", nil
+}
+
+// download writes the vault's file to rel, checking the content against
+// the hash the change feed gave.
+func (s *syncer) download(ctx context.Context, r remoteFile, rel string) error {
+	return s.writeFile(rel, r.Hash, func(w io.Writer) error {
+		_, err := s.a.client.Download(ctx, r.FileID, w, nil)
+		return err
+	})
+}
+
+// writeFile writes rel through a temporary file renamed into place once
+// its content is known to hash to want.
+func (s *syncer) writeFile(rel, want string, fill func(w io.Writer) error) error {
+	dest := s.abs(rel)
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".vault-sync-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	err = fill(io.MultiWriter(tmp, h))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		// Changed again since the feed was read; the next run catches up
+		return fmt.Errorf("content does not match the change feed's hash")
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+// conflict keeps both versions of a file changed on both sides: the local
+// one moves to a conflict copy, which is uploaded, and the vault's takes
+// its place.
+func (s *syncer) conflict(ctx context.Context, p string) (string, error) {
+	if err := s.unchanged(p); err != nil {
+		return "changed during sync", err
+	}
+	c := s.conflictName(p)
+	if err := os.Rename(s.abs(p), s.abs(c)); err != nil {
+		return "", err
+	}
+	s.local[c] = s.local[p]
+	delete(s.local, p)
+
+	r := s.st.Remote[p]
+	if err := s.download(ctx, r, p); err != nil {
+		return "", err
+	}
+	s.record(p, r.Hash)
+
+	note := "kept the vault's version; yours is " + c
+	if _, err := s.upload(ctx, c); err != nil && !errors.Is(err, errSyncSkipped) {
+		return note, fmt.Errorf("uploading %s: %w", c, err)
+	}
+	return note, nil
+}
+
+// conflictName returns a free path for the conflict copy of rel, such as
+// "notes (conflict from laptop 2026-10-19).txt".
+func (s *syncer) conflictName(rel string) string {
+	dir, base := path.Split(rel)
+	ext := path.Ext(base)
+	if ext == base {
+		ext = "" // a dotfile such as .profile
+	}
+	stem := strings.TrimSuffix(base, ext)
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "this computer"
+	}
+	label := fmt.Sprintf("conflict from %s %s", host, time.Now().Format("2006-01-02"))
+	for i := 1; ; i++ {
+		name := fmt.Sprintf("%s (%s)%s", stem, label, ext)
+		if i > 1 {
+			name = fmt.Sprintf("%s (%s %d)%s", stem, label, i, ext)
+		}
+		c := dir + name
+		_, local := s.local[c]
+		_, remote := s.st.Remote[c]
+		if _, err := os.Lstat(s.abs(c)); !local && !remote && errors.Is(err, fs.ErrNotExist) {
+			return c
+		}
+	}
+}
+
+// removeEmptyDirs removes dir and its parents inside the root while they
+// are empty.
+func (s *syncer) removeEmptyDirs(dir string) {
+	for dir != "." && dir != "/" && dir != "" {
+		if os.Remove(s.abs(dir)) != nil {
+			return
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+func (a *app) reportSync(r syncResult) {
+	if a.json {
+		return
+	}
+	if r.Status == "failed" {
+		fmt.Fprintf(a.stderr, "vault sync: %v\n", r.err)
+		return
+	}
+	var msg string
+	switch r.Action {
+	case actUpload:
+		msg = "upload " + r.Path
+	case actDownload:
+		msg = "download " + r.Path
+	case actDeleteRemote:
+		msg = "delete " + r.Path + " from the vault"
+	case actDeleteLocal:
+		msg = "delete " + r.Path + " here"
+	case actMoveRemote:
+		msg = "move " + r.From + " -> " + r.Path + " in the vault"
+	case actMoveLocal:
+		msg = "move " + r.From + " -> " + r.Path + " here"
+	case actCopyLocal:
+		msg = "copy " + r.From + " -> " + r.Path + " here"
+	case actConflict:
+		msg = "conflict on " + r.Path
+	case actDuplicate:
+		msg = "skip " + r.Path
+	}
+	switch {
+	case r.Status == "planned":
+		msg = "would " + msg
+	case r.Status == "skipped" && r.Action != actDuplicate:
+		msg = "skip " + r.Path
+	}
+	if r.Detail != "" {
+		msg += ": " + r.Detail
+	}
+	fmt.Fprintln(a.stdout, msg)
+}
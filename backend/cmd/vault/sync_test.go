@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPlanSync(t *testing.T) {
+	tests := []struct {
+		name                  string
+		local, remote, synced map[string]string
+		want                  string
+	}{
+		{
+			name:   "unchanged",
+			local:  map[string]string{"a": "1"},
+			remote: map[string]string{"a": "1"},
+			synced: map[string]string{"a": "1"},
+			want:   "[same a]",
+		},
+		{
+			name:   "first run merges both sides",
+			local:  map[string]string{"a": "1", "b": "2"},
+			remote: map[string]string{"b": "2", "c": "3"},
+			want:   "[download c upload a same b]",
+		},
+		{
+			name:   "edited here",
+			local:  map[string]string{"a": "2"},
+			remote: map[string]string{"a": "1"},
+			synced: map[string]string{"a": "1"},
+			want:   "[upload a]",
+		},
+		{
+			name:   "edited in the vault",
+			local:  map[string]string{"a": "1"},
+			remote: map[string]string{"a": "2"},
+			synced: map[string]string{"a": "1"},
+			want:   "[download a]",
+		},
+		{
+			name:   "deleted here",
+			remote: map[string]string{"a": "1"},
+			synced: map[string]string{"a": "1"},
+			want:   "[delete-remote a]",
+		},
+		{
+			name:   "deleted in the vault",
+			local:  map[string]string{"a": "1"},
+			synced: map[string]string{"a": "1"},
+			want:   "[delete-local a]",
+		},
+		{
+			name:   "deleted on both sides",
+			synced: map[string]string{"a": "1"},
+			want:   "[same a]",
+		},
+		{
+			name:   "deleted here but edited in the vault",
+			remote: map[string]string{"a": "2"},
+			synced: map[string]string{"a": "1"},
+			want:   "[download a]",
+		},
+		{
+			name:   "deleted in the vault but edited here",
+			local:  map[string]string{"a": "2"},
+			synced: map[string]string{"a": "1"},
+			want:   "[upload a]",
+		},
+		{
+			name:   "edited on both sides",
+			local:  map[string]string{"a": "2"},
+			remote: map[string]string{"a": "3"},
+			synced: map[string]string{"a": "1"},
+			want:   "[conflict a]",
+		},
+		{
+			name:   "edited the same way on both sides",
+			local:  map[string]string{"a": "2"},
+			remote: map[string]string{"a": "2"},
+			synced: map[string]string{"a": "1"},
+			want:   "[same a]",
+		},
+		{
+			name:   "moved here",
+			local:  map[string]string{"b": "1"},
+			remote: map[string]string{"a": "1"},
+			synced: map[string]string{"a": "1"},
+			want:   "[move-remote a->b]",
+		},
+		{
+			name:   "moved in the vault",
+			local:  map[string]string{"a": "1"},
+			remote: map[string]string{"b": "1"},
+			synced: map[string]string{"a": "1"},
+			want:   "[move-local a->b]",
+		},
+		{
+			name:   "copied in the vault",
+			local:  map[string]string{"a": "1"},
+			remote: map[string]string{"a": "1", "b": "1"},
+			synced: map[string]string{"a": "1"},
+			want:   "[copy-local a->b same a]",
+		},
+		{
+			name:   "moved and copied in the vault",
+			local:  map[string]string{"a": "1"},
+			remote: map[string]string{"b": "1", "c": "1"},
+			synced: map[string]string{"a": "1"},
+			want:   "[move-local a->b download c]",
+		},
+		{
+			name:   "new content the vault already holds",
+			local:  map[string]string{"a": "1", "b": "1"},
+			remote: map[string]string{"a": "1"},
+			synced: map[string]string{"a": "1"},
+			want:   "[duplicate a->b same a]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, act := range planSync(tt.local, tt.remote, tt.synced) {
+				s := act.kind + " " + act.path
+				if act.from != "" {
+					s = act.kind + " " + act.from + "->" + act.path
+				}
+				got = append(got, s)
+			}
+			if s := fmt.Sprint(got); s != tt.want {
+				t.Errorf("got %s, want %s", s, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+// Command vaultctl runs operator tasks against the vault's database and
+// blob store: managing accounts and quotas, finding the largest users,
+// checking and repairing storage bookkeeping, purging orphans, re-hashing
+// blobs and handing files from one user to another.
+//
+//	vaultctl user create alice --email alice@example.com --password-stdin
+//	vaultctl quota alice 50G
+//	vaultctl check --fix
+//
+// It reads the server's configuration and must run in the server's working
+// directory, where the uploads directory usually lives.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+
+	"backend/internal/config"
+	"backend/internal/db"
+	"backend/internal/repository"
+	"backend/internal/service"
+)
+
+// Exit codes.
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitProblems = 3 // check found problems it did not fix
+	exitNotFound = 4
+)
+
+const usage = `Usage: vaultctl <command> [flags] [args]
+
+Commands:
+  user create    create an account
+  user disable   stop a user signing in, by any means
+  user enable    let a disabled user sign in again
+  user delete    delete a user and all of their files
+  quota          set a user's storage quota, or put them back on the default
+  top            list the users storing the most
+  check          check reference counts, storage counters and blobs on disk
+  purge-orphans  remove unreferenced file rows, stray blobs and stale staging files
+  rehash         hash a file's blob again and compare it with its row
+  transfer       give files from one user to another
+
+Run vaultctl from the server's working directory. It reads the server's
+configuration: the same config file, environment and .env. Server flags
+such as --config FILE go before the command. Run "vaultctl <command> -h"
+for a command's flags.
+
+Exit codes: 0 ok, 1 error, 2 usage, 3 check found problems, 4 not found.
+`
+
+type command func(a *app, args []string) error
+
+var commands = map[string]command{
+	"user":          runUser,
+	"quota":         runQuota,
+	"top":           runTop,
+	"check":         runCheck,
+	"purge-orphans": runPurgeOrphans,
+	"rehash":        runRehash,
+	"transfer":      runTransfer,
+	"migrate":       runMigrate,
+}
+
+func main() {
+	// Server settings such as --config or --db-host may come before the
+	// command
+	args := os.Args[1:]
+	i := slices.IndexFunc(args, func(arg string) bool { return commands[arg] != nil })
+	if i < 0 {
+		if len(args) > 0 && args[0] != "-h" && args[0] != "--help" && args[0] != "help" {
+			fmt.Fprintf(os.Stderr, "vaultctl: unknown command %q\n\n", args[0])
+		}
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(exitUsage)
+	}
+	cmd := commands[args[i]]
+
+	a := &app{name: args[i], settings: args[:i], stdout: os.Stdout, stderr: os.Stderr}
+	err := cmd(a, args[i+1:])
+	if err == nil {
+		return
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(exitUsage)
+	}
+	code := exitCode(err)
+	if a.json {
+		json.NewEncoder(a.stderr).Encode(map[string]any{"error": err.Error(), "exit_code": code})
+	} else {
+		fmt.Fprintf(a.stderr, "vaultctl %s: %v\n", a.name, err)
+	}
+	os.Exit(code)
+}
+
+// app is what commands share: output settings and, once connected, the
+// admin service.
+type app struct {
+	name     string
+	json     bool
+	settings []string // server flags given before the command
+	stdout   io.Writer
+	stderr   io.Writer
+
+	admin *service.AdminService
+}
+
+// flags returns a flag set for the command with the flags every command
+// has.
+func (a *app) flags(synopsis string) *flag.FlagSet {
+	fs := flag.NewFlagSet("vaultctl "+a.name, flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	fs.BoolVar(&a.json, "json", false, "print JSON instead of text")
+	fs.Usage = func() {
+		fmt.Fprintf(a.stderr, "Usage: vaultctl %s %s\n\nFlags:\n", a.name, synopsis)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// connect opens the database and wires up the services the server uses,
+// without starting any of their background workers.
+func (a *app) connect() error {
+	cfg, conn, err := a.openDB()
+	if err != nil {
+		return err
+	}
+	userRepo := repository.NewUserRepository(conn)
+	userFileRepo := repository.NewUserFileRepository(conn)
+	fileRepo := repository.NewFileRepository(conn)
+	txManager := repository.NewTxManager(conn)
+	fileConfig := service.FileConfig{UploadDir: cfg.Storage.UploadDir}
+
+	blobDeleter := service.NewBlobDeleter(txManager, time.Minute)
+	previewService := service.NewPreviewService(repository.NewPreviewRepository(conn), fileRepo, userFileRepo, filepath.Join(fileConfig.UploadDir, ".previews"))
+	blobDeleter.OnBlobRemoved(previewService.Purge)
+	searchService := service.NewSearchService(repository.NewSearchRepository(conn), fileRepo)
+	blobDeleter.OnBlobRemoved(searchService.Purge)
+
+	fileService := service.NewFileService(fileRepo, userFileRepo, userRepo, repository.NewFolderRepository(conn), txManager, blobDeleter, fileConfig, cfg.Limits.UserQuotaMB)
+	// vaultctl never runs the expiry sweep, so maxAge is unused
+	multipartService := service.NewMultipartService(repository.NewMultipartRepository(conn), fileService, 0)
+	a.admin = service.NewAdminService(userRepo, userFileRepo, repository.NewConsistencyRepository(conn), fileService, multipartService, blobDeleter, txManager)
+	return nil
+}
+
+// openDB loads the server's configuration, from the same file, environment
+// and flags the server would use, and connects to the database.
+func (a *app) openDB() (*config.Config, *gorm.DB, error) {
+	cfg, err := config.Load("vaultctl", a.settings)
+	if err != nil {
+		return nil, nil, fmt.Errorf("configuration: %w", err)
+	}
+	conn, err := db.NewPostgresDB(cfg.DB)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connecting to the database: %w", err)
+	}
+	return cfg, conn, nil
+}
+
+// print writes v as JSON, or calls human to write text.
+func (a *app) print(v any, human func(w io.Writer)) {
+	if a.json {
+		enc := json.NewEncoder(a.stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(v)
+		return
+	}
+	human(a.stdout)
+}
+
+var errProblems = errors.New("problems found")
+
+// usageError marks errors in how the command was invoked.
+type usageError struct{ error }
+
+func (e usageError) Unwrap() error { return e.error }
+
+func usagef(format string, args ...any) error {
+	return usageError{fmt.Errorf(format, args...)}
+}
+
+func exitCode(err error) int {
+	var ue usageError
+	switch {
+	case errors.As(err, &ue):
+		return exitUsage
+	case errors.Is(err, errProblems):
+		return exitProblems
+	case errors.Is(err, service.ErrUserNotFound), errors.Is(err, service.ErrFileNotFound),
+		errors.Is(err, service.ErrBlobMissing):
+		return exitNotFound
+	}
+	return exitError
+}
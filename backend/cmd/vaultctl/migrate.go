@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+
+	"backend/internal/db"
+)
+
+const migrateUsage = `Usage: vaultctl migrate <status|up [VERSION]|down [STEPS]|baseline VERSION>`
+
+func runMigrate(a *app, args []string) error {
+	fs := a.flags("status | up [VERSION] | down [STEPS] | baseline VERSION")
+	if err := fs.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if fs.NArg() == 0 || fs.NArg() > 2 {
+		return usagef("%s", migrateUsage)
+	}
+	sub := fs.Arg(0)
+	n := 0
+	if fs.NArg() == 2 {
+		var err error
+		if n, err = strconv.Atoi(fs.Arg(1)); err != nil || n < 1 {
+			return usagef("%q is not a positive number", fs.Arg(1))
+		}
+	}
+
+	_, conn, err := a.openDB()
+	if err != nil {
+		return err
+	}
+	m, err := db.NewMigrator(conn)
+	if err != nil {
+		return err
+	}
+
+	switch sub {
+	case "status":
+		status, err := m.Status()
+		if err != nil {
+			return err
+		}
+		a.print(status, func(w io.Writer) {
+			tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED\t")
+			for _, s := range status {
+				applied := "pending"
+				if s.AppliedAt != nil {
+					applied = s.AppliedAt.Local().Format("2006-01-02 15:04:05")
+				}
+				if !s.Known {
+					applied += " (unknown to this binary)"
+				}
+				fmt.Fprintf(tw, "%d\t%s\t%s\t\n", s.Version, s.Name, applied)
+			}
+			tw.Flush()
+		})
+		return nil
+	case "up":
+		done, err := m.Up(n)
+		a.printMigrations(done, "applied")
+		return err
+	case "down":
+		done, err := m.Down(max(n, 1))
+		a.printMigrations(done, "reverted")
+		return err
+	case "baseline":
+		if n == 0 {
+			return usagef("name the last migration already applied by hand")
+		}
+		if err := m.Baseline(n); err != nil {
+			return err
+		}
+		a.print(map[string]int{"baseline": n}, func(w io.Writer) {
+			fmt.Fprintf(w, "recorded migrations 1 to %d as applied\n", n)
+		})
+		return nil
+	}
+	return usagef("unknown subcommand %q\n%s", sub, migrateUsage)
+}
+
+func (a *app) printMigrations(done []db.Migration, verb string) {
+	type result struct {
+		Version int    `json:"version"`
+		Name    string `json:"name"`
+	}
+	results := make([]result, 0, len(done))
+	for _, m := range done {
+		results = append(results, result{m.Version, m.Name})
+	}
+	a.print(results, func(w io.Writer) {
+		for _, r := range results {
+			fmt.Fprintf(w, "%s %d_%s\n", verb, r.Version, r.Name)
+		}
+		if len(results) == 0 {
+			fmt.Fprintf(w, "nothing %s\n", verb)
+		}
+	})
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"backend/internal/service"
+)
+
+func runCheck(a *app, args []string) error {
+	fs := a.flags("[--fix]")
+	fix := fs.Bool("fix", false, "recompute wrong reference counts and storage counters")
+	if err := fs.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if err := a.connect(); err != nil {
+		return err
+	}
+	report, err := a.admin.Check(*fix)
+	if report != nil {
+		a.print(report, func(w io.Writer) { printCheck(w, report) })
+	}
+	if err != nil {
+		return err
+	}
+	if !report.OK() {
+		return errProblems
+	}
+	return nil
+}
+
+func printCheck(w io.Writer, r *service.ConsistencyReport) {
+	verb := "wrong"
+	if r.Fixed {
+		verb = "fixed"
+	}
+	for _, m := range r.RefCounts {
+		fmt.Fprintf(w, "file %d: ref_count %d, %d references (%s)\n", m.FileID, m.RefCount, m.Actual, verb)
+	}
+	for _, m := range r.Storage {
+		fmt.Fprintf(w, "user %s: storage %d/%d, files add up to %d/%d (%s)\n", m.Username,
+			m.ActualStorage, m.ExpectedStorage, m.Actual, m.Expected, verb)
+	}
+	for _, b := range r.Blobs {
+		fmt.Fprintf(w, "file %d: blob %s %s\n", b.FileID, b.StoragePath, b.Problem)
+	}
+	for _, p := range r.OrphanBlobs {
+		fmt.Fprintf(w, "orphan blob %s\n", p)
+	}
+	if len(r.RefCounts)+len(r.Storage)+len(r.Blobs)+len(r.OrphanBlobs) == 0 {
+		fmt.Fprintln(w, "no problems found")
+	} else if len(r.OrphanBlobs) > 0 {
+		fmt.Fprintln(w, `run "vaultctl purge-orphans" to remove orphan blobs`)
+	}
+}
+
+func runPurgeOrphans(a *app, args []string) error {
+	fs := a.flags("[--min-age DURATION] [--dry-run]")
+	minAge := fs.Duration("min-age", 24*time.Hour, "leave blobs and staging files younger than this")
+	dryRun := fs.Bool("dry-run", false, "list what would be removed without removing it")
+	if err := fs.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if *minAge < 0 {
+		return usagef("--min-age must not be negative")
+	}
+	if err := a.connect(); err != nil {
+		return err
+	}
+	report, err := a.admin.PurgeOrphans(*minAge, *dryRun)
+	if report != nil {
+		a.print(report, func(w io.Writer) {
+			verb := "removed"
+			if *dryRun {
+				verb = "would remove"
+			}
+			for _, id := range report.FileRows {
+				fmt.Fprintf(w, "%s unreferenced file %d\n", verb, id)
+			}
+			for _, p := range report.Blobs {
+				fmt.Fprintf(w, "%s orphan blob %s\n", verb, p)
+			}
+			for _, p := range report.Staging {
+				fmt.Fprintf(w, "%s staging file %s\n", verb, p)
+			}
+			if !*dryRun {
+				fmt.Fprintf(w, "%d blobs deleted from disk\n", report.Removed)
+			}
+		})
+	}
+	return err
+}
+
+func runRehash(a *app, args []string) error {
+	fs := a.flags("[--fix] FILE_ID")
+	fix := fs.Bool("fix", false, "update the file row to match its blob")
+	if err := fs.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if fs.NArg() != 1 {
+		return usagef("name one file ID")
+	}
+	id, err := strconv.ParseUint(fs.Arg(0), 10, 64)
+	if err != nil {
+		return usagef("%q is not a file ID", fs.Arg(0))
+	}
+	if err := a.connect(); err != nil {
+		return err
+	}
+	res, err := a.admin.RehashFile(uint(id), *fix)
+	if err != nil {
+		return err
+	}
+	a.print(res, func(w io.Writer) {
+		switch {
+		case res.Match:
+			fmt.Fprintf(w, "file %d: matches, %s, %s\n", res.FileID, res.Hash, humanSize(res.Size))
+		case res.Fixed:
+			fmt.Fprintf(w, "file %d: fixed, now %s, %s at %s\n", res.FileID, res.ActualHash, humanSize(res.ActualSize), res.StoragePath)
+		default:
+			fmt.Fprintf(w, "file %d: row says %s, %d bytes; blob is %s, %d bytes\n",
+				res.FileID, res.Hash, res.Size, res.ActualHash, res.ActualSize)
+		}
+	})
+	if !res.Match && !res.Fixed {
+		return errProblems
+	}
+	return nil
+}
+
+func runTransfer(a *app, args []string) error {
+	fs := a.flags("--from USER --to USER (ID... | --folder FOLDER | --all) [--into FOLDER]")
+	from := fs.String("from", "", "user giving the files")
+	to := fs.String("to", "", "user receiving the files")
+	folder := fs.String("folder", "", "transfer this folder and everything in it")
+	into := fs.String("into", "", "folder to place the files under at the recipient")
+	all := fs.Bool("all", false, "transfer all of the user's files")
+	if err := fs.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if *from == "" || *to == "" {
+		return usagef("--from and --to are required")
+	}
+	sel := service.TransferSelection{Folder: *folder, Into: *into}
+	for _, arg := range fs.Args() {
+		id, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			return usagef("%q is not a file ID", arg)
+		}
+		sel.IDs = append(sel.IDs, uint(id))
+	}
+	picked := 0
+	for _, p := range []bool{len(sel.IDs) > 0, *folder != "", *all} {
+		if p {
+			picked++
+		}
+	}
+	if picked != 1 {
+		return usagef("name file IDs, or pass one of --folder and --all")
+	}
+	if err := a.connect(); err != nil {
+		return err
+	}
+	results, err := a.admin.TransferFiles(*from, *to, sel)
+	if err != nil {
+		return err
+	}
+	a.print(results, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, r := range results {
+			switch r.Status {
+			case "transferred":
+				fmt.Fprintf(tw, "%d\t%s\t-> %s\n", r.UserFileID, r.From, r.To)
+			case "merged":
+				fmt.Fprintf(tw, "%d\t%s\tmerged, %s already has it\n", r.UserFileID, r.From, *to)
+			default:
+				fmt.Fprintf(tw, "%d\t%s\tfailed: %s\n", r.UserFileID, r.From, r.Error)
+			}
+		}
+		tw.Flush()
+	})
+	for _, r := range results {
+		if r.Status == "failed" {
+			return errors.New("some files failed")
+		}
+	}
+	return nil
+}
+
+func parseSize(v string) (int64, error) {
+	mult := int64(1)
+	switch strings.ToUpper(v[len(v)-min(len(v), 1):]) {
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	case "T":
+		mult = 1 << 40
+	}
+	if mult > 1 {
+		v = v[:len(v)-1]
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("must be a size such as 4096, 512K, 10M or 2G")
+	}
+	return n * mult, nil
+}
+
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
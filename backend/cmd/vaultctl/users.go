@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/term"
+
+	"backend/internal/models"
+)
+
+const userUsage = `Usage: vaultctl user <create|disable|enable|delete> [flags] USERNAME`
+
+func runUser(a *app, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(a.stderr, userUsage)
+		return flag.ErrHelp
+	}
+	sub, args := args[0], args[1:]
+	a.name = "user " + sub
+	switch sub {
+	case "create":
+		return runUserCreate(a, args)
+	case "disable":
+		return runUserDisable(a, args, true)
+	case "enable":
+		return runUserDisable(a, args, false)
+	case "delete":
+		return runUserDelete(a, args)
+	}
+	return usagef("unknown subcommand %q\n%s", sub, userUsage)
+}
+
+type userResult struct {
+	ID         uint       `json:"id"`
+	Username   string     `json:"username"`
+	Email      string     `json:"email"`
+	IsAdmin    bool       `json:"is_admin"`
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+	Quota      int64      `json:"quota"`
+	OwnQuota   bool       `json:"own_quota"`
+}
+
+func (a *app) userResult(u *models.User) userResult {
+	return userResult{
+		ID:         u.ID,
+		Username:   u.Username,
+		Email:      u.Email,
+		IsAdmin:    u.IsAdmin,
+		DisabledAt: u.DisabledAt,
+		Quota:      a.admin.QuotaBytes(u),
+		OwnQuota:   u.QuotaBytes != nil,
+	}
+}
+
+func runUserCreate(a *app, args []string) error {
+	fs := a.flags("--email EMAIL [--admin] [--password-stdin] USERNAME")
+	email := fs.String("email", "", "the user's email address")
+	admin := fs.Bool("admin", false, "make the user an administrator")
+	fromStdin := fs.Bool("password-stdin", false, "read the password from the first line of standard input")
+	if err := fs.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if fs.NArg() != 1 {
+		return usagef("name one user")
+	}
+	if *email == "" {
+		return usagef("--email is required")
+	}
+	password, err := readPassword(a, *fromStdin)
+	if err != nil {
+		return err
+	}
+	if err := a.connect(); err != nil {
+		return err
+	}
+	u, err := a.admin.CreateUser(fs.Arg(0), *email, password, *admin)
+	if err != nil {
+		return err
+	}
+	res := a.userResult(u)
+	a.print(res, func(w io.Writer) {
+		fmt.Fprintf(w, "created user %s (id %d)\n", res.Username, res.ID)
+	})
+	return nil
+}
+
+// readPassword reads a password from standard input, asking twice when it
+// is a terminal.
+func readPassword(a *app, fromStdin bool) (string, error) {
+	if fromStdin || !term.IsTerminal(int(os.Stdin.Fd())) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+		password := strings.TrimRight(line, "\r\n")
+		if password == "" {
+			return "", usagef("no password on standard input")
+		}
+		return password, nil
+	}
+	fmt.Fprint(a.stderr, "Password: ")
+	first, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(a.stderr)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprint(a.stderr, "Again: ")
+	second, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(a.stderr)
+	if err != nil {
+		return "", err
+	}
+	if string(first) != string(second) {
+		return "", errors.New("passwords do not match")
+	}
+	if len(first) == 0 {
+		return "", errors.New("empty password")
+	}
+	return string(first), nil
+}
+
+func runUserDisable(a *app, args []string, disable bool) error {
+	fs := a.flags("USERNAME")
+	if err := fs.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if fs.NArg() != 1 {
+		return usagef("name one user")
+	}
+	if err := a.connect(); err != nil {
+		return err
+	}
+	u, err := a.admin.SetDisabled(fs.Arg(0), disable)
+	if err != nil {
+		return err
+	}
+	res := a.userResult(u)
+	a.print(res, func(w io.Writer) {
+		if disable {
+			fmt.Fprintf(w, "disabled %s\n", res.Username)
+		} else {
+			fmt.Fprintf(w, "enabled %s\n", res.Username)
+		}
+	})
+	return nil
+}
+
+func runUserDelete(a *app, args []string) error {
+	fs := a.flags("--yes USERNAME")
+	yes := fs.Bool("yes", false, "confirm deleting the user and all of their files")
+	if err := fs.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if fs.NArg() != 1 {
+		return usagef("name one user")
+	}
+	if !*yes {
+		return usagef("deleting %s removes all of their files; pass --yes to go ahead", fs.Arg(0))
+	}
+	if err := a.connect(); err != nil {
+		return err
+	}
+	n, err := a.admin.DeleteUser(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("%w (%d files deleted so far; the account is disabled)", err, n)
+	}
+	a.print(map[string]any{"username": fs.Arg(0), "files_deleted": n}, func(w io.Writer) {
+		fmt.Fprintf(w, "deleted %s and %d files\n", fs.Arg(0), n)
+	})
+	return nil
+}
+
+func runQuota(a *app, args []string) error {
+	fs := a.flags("USERNAME SIZE|default")
+	if err := fs.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if fs.NArg() != 2 {
+		return usagef("name a user and a size, such as 50G, or \"default\"")
+	}
+	var quota *int64
+	if fs.Arg(1) != "default" {
+		n, err := parseSize(fs.Arg(1))
+		if err != nil {
+			return usagef("quota %v", err)
+		}
+		quota = &n
+	}
+	if err := a.connect(); err != nil {
+		return err
+	}
+	u, err := a.admin.SetQuota(fs.Arg(0), quota)
+	if err != nil {
+		return err
+	}
+	res := a.userResult(u)
+	a.print(res, func(w io.Writer) {
+		which := "own"
+		if !res.OwnQuota {
+			which = "default"
+		}
+		fmt.Fprintf(w, "%s: %s quota of %s\n", res.Username, which, humanSize(res.Quota))
+	})
+	return nil
+}
+
+func runTop(a *app, args []string) error {
+	fs := a.flags("[-n N]")
+	n := fs.Int("n", 20, "number of users to list")
+	if err := fs.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if *n < 1 {
+		return usagef("-n must be at least 1")
+	}
+	if err := a.connect(); err != nil {
+		return err
+	}
+	rows, err := a.admin.LargestUsers(*n)
+	if err != nil {
+		return err
+	}
+	a.print(rows, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tUSER\tFILES\tSTORED\tUPLOADED\tQUOTA\tUSED\t")
+		for _, r := range rows {
+			used := "-"
+			if r.Quota > 0 {
+				used = fmt.Sprintf("%.0f%%", 100*float64(r.ActualStorage)/float64(r.Quota))
+			}
+			name := r.Username
+			if r.DisabledAt != nil {
+				name += " (disabled)"
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%d\t%s\t%s\t%s\t%s\t\n", r.ID, name, r.Files,
+				humanSize(r.ActualStorage), humanSize(r.ExpectedStorage), humanSize(r.Quota), used)
+		}
+		tw.Flush()
+	})
+	return nil
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"backend/internal/service"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AccessKeyHandler struct {
+	accessKeyService *service.AccessKeyService
+}
+
+func NewAccessKeyHandler(ks *service.AccessKeyService) *AccessKeyHandler {
+	return &AccessKeyHandler{accessKeyService: ks}
+}
+
+// Create issues an S3 access key. The response carries the secret, which is
+// never returned again.
+func (h *AccessKeyHandler) Create(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	key, secret, err := h.accessKeyService.Create(userID, req.Name)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidAccessKeyName) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create access key"})
+		return
+	}
+	auditDetail(c, gin.H{"access_key_id": key.AccessKeyID, "name": key.Name})
+	c.JSON(http.StatusCreated, gin.H{"secret_access_key": secret, "access_key": key})
+}
+
+func (h *AccessKeyHandler) List(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	keys, err := h.accessKeyService.List(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list access keys"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"access_keys": keys})
+}
+
+func (h *AccessKeyHandler) Delete(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	id, ok := parseIDParam(c, "id")
+	if !ok {
+		return
+	}
+	auditDetail(c, gin.H{"access_key": id})
+
+	if err := h.accessKeyService.Delete(userID, id); err != nil {
+		if errors.Is(err, service.ErrAccessKeyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete access key"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Access key deleted"})
+}
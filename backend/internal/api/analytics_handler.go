@@ -0,0 +1,47 @@
+package api
+
+import (
+	"backend/internal/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AnalyticsHandler struct {
+	analyticsService *service.AnalyticsService
+}
+
+func NewAnalyticsHandler(as *service.AnalyticsService) *AnalyticsHandler {
+	return &AnalyticsHandler{analyticsService: as}
+}
+
+// GetDownloadStats returns time-bucketed download counts for one of the
+// caller's files, optionally for a single link, and per-link totals.
+func (h *AnalyticsHandler) GetDownloadStats(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	userfileID, ok := parseUserFileID(c)
+	if !ok {
+		return
+	}
+
+	q, err := service.ParseDownloadStatsQuery(c.Request.URL.Query())
+	if err != nil {
+		writeFilterError(c, err)
+		return
+	}
+
+	stats, err := h.analyticsService.GetDownloadStats(userID, userfileID, q)
+	if err != nil {
+		if err.Error() == "file not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found or access denied"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load download stats"})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
@@ -0,0 +1,228 @@
+package api
+
+import (
+	"backend/internal/models"
+	"backend/internal/service"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Context keys handlers use to tell the audit middleware what they acted on.
+const (
+	auditActorIDKey    = "audit.actorID"
+	auditActorNameKey  = "audit.actorName"
+	auditUserFileIDKey = "audit.userFileID"
+	auditFileIDKey     = "audit.fileID"
+	auditDetailKey     = "audit.detail"
+)
+
+// auditActions names the audited routes, keyed by method and route pattern.
+var auditActions = map[string]string{
+	"POST /api/signup":                "auth.signup",
+	"POST /api/login":                 "auth.login",
+	"POST /api/logout":                "auth.logout",
+	"GET /api/me":                     "auth.me",
+	"POST /api/upload":                "file.upload",
+	"POST /api/upload/bulk":           "file.upload_bulk",
+	"POST /api/upload/archive":        "file.upload_archive",
+	"POST /api/uploads":               "file.upload_start",
+	"POST /api/uploads/:id/complete":  "file.upload_complete",
+	"DELETE /api/uploads/:id":         "file.upload_abort",
+	"GET /api/files":                  "file.list",
+	"GET /api/files/:id/download":     "file.download",
+	"POST /api/files/download-zip":    "file.download_zip",
+	"POST /api/files/:id/delete":      "file.delete",
+	"PATCH /api/files/:id/visibility": "file.visibility",
+	"PATCH /api/files/:id":            "file.rename",
+	"GET /api/storage-stats":          "file.storage_stats",
+	"GET /api/public/:token":          "file.download_public",
+	"POST /api/tokens":                "token.create",
+	"DELETE /api/tokens/:id":          "token.revoke",
+	"POST /api/access-keys":           "access_key.create",
+	"DELETE /api/access-keys/:id":     "access_key.delete",
+	"POST /api/ssh-keys":              "ssh_key.add",
+	"DELETE /api/ssh-keys/:id":        "ssh_key.delete",
+	"PUT /webdav/*path":               "webdav.put",
+	"DELETE /webdav/*path":            "webdav.delete",
+	"MKCOL /webdav/*path":             "webdav.mkcol",
+	"MOVE /webdav/*path":              "webdav.move",
+	"COPY /webdav/*path":              "webdav.copy",
+	"GET /api/admin/audit":            "admin.audit_query",
+	"GET /api/admin/audit/export":     "admin.audit_export",
+	"GET /api/admin/audit/verify":     "admin.audit_verify",
+}
+
+// Audit records one audit log entry per request to an audited route once
+// the handler has finished. It is installed ahead of authentication so
+// rejected requests are logged too. The outcome comes from the response
+// status; handlers add the target and any details with the audit* helpers.
+func Audit(as *service.AuditService, authService *service.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		action, ok := auditActions[c.Request.Method+" "+c.FullPath()]
+		if !ok {
+			c.Next()
+			return
+		}
+		c.Next()
+		if auditActorID(c) == 0 {
+			// Public routes such as logout still name the holder of a
+			// valid session cookie
+			if token, err := c.Cookie("auth_token"); err == nil && token != "" {
+				if id, err := authService.ParseToken(token); err == nil {
+					c.Set(auditActorIDKey, id)
+				}
+			}
+		}
+		recordAudit(as, c, action)
+	}
+}
+
+// recordAudit appends the entry for a finished request to the audit log.
+func recordAudit(as *service.AuditService, c *gin.Context, action string) {
+	status := c.Writer.Status()
+	e := &models.AuditEvent{
+		Action:    action,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		Status:    status,
+		Outcome:   auditOutcome(status),
+		ActorName: c.GetString(auditActorNameKey),
+		Detail:    c.GetString(auditDetailKey),
+	}
+	if id := auditActorID(c); id != 0 {
+		e.ActorID = &id
+	}
+	if id := c.GetUint(auditUserFileIDKey); id != 0 {
+		e.UserFileID = &id
+	}
+	if id := c.GetUint(auditFileIDKey); id != 0 {
+		e.FileID = &id
+	}
+	as.Record(e)
+}
+
+func auditOutcome(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return models.AuditDenied
+	case status >= 400:
+		return models.AuditFailure
+	}
+	return models.AuditSuccess
+}
+
+// auditActorID prefers an actor named by the handler (sign-up, login), then
+// the authenticated user.
+func auditActorID(c *gin.Context) uint {
+	if id := c.GetUint(auditActorIDKey); id != 0 {
+		return id
+	}
+	return c.GetUint("userID")
+}
+
+func auditActor(c *gin.Context, userID uint, name string) {
+	if userID != 0 {
+		c.Set(auditActorIDKey, userID)
+	}
+	c.Set(auditActorNameKey, name)
+}
+
+// auditTarget names the user file and/or stored file a request acted on;
+// pass 0 for whichever is unknown.
+func auditTarget(c *gin.Context, userFileID, fileID uint) {
+	if userFileID != 0 {
+		c.Set(auditUserFileIDKey, userFileID)
+	}
+	if fileID != 0 {
+		c.Set(auditFileIDKey, fileID)
+	}
+}
+
+// auditDetail attaches extra context, stored as JSON.
+func auditDetail(c *gin.Context, detail interface{}) {
+	raw, err := json.Marshal(detail)
+	if err != nil {
+		return
+	}
+	c.Set(auditDetailKey, string(raw))
+}
+
+type AuditHandler struct {
+	auditService *service.AuditService
+}
+
+func NewAuditHandler(as *service.AuditService) *AuditHandler {
+	return &AuditHandler{auditService: as}
+}
+
+// Query returns a page of audit entries, newest first. Pass the last ID of
+// a page as before_id to get the next one.
+func (h *AuditHandler) Query(c *gin.Context) {
+	q, err := service.ParseAuditQuery(c.Request.URL.Query())
+	if err != nil {
+		writeFilterError(c, err)
+		return
+	}
+
+	events, err := h.auditService.Query(q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query audit log"})
+		return
+	}
+
+	body := gin.H{"events": events}
+	if len(events) == q.Limit {
+		body["next_before_id"] = events[len(events)-1].ID
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// Export streams every matching entry as CSV or a JSON array.
+func (h *AuditHandler) Export(c *gin.Context) {
+	q, err := service.ParseAuditQuery(c.Request.URL.Query())
+	if err != nil {
+		writeFilterError(c, err)
+		return
+	}
+
+	format := c.DefaultQuery("format", "json")
+	switch format {
+	case "json":
+		c.Header("Content-Type", "application/json")
+	case "csv":
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or csv"})
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename=\"audit-log."+format+"\"")
+	c.Status(http.StatusOK)
+
+	// Headers are already sent, so a failure can only truncate the stream
+	if err := h.auditService.Export(c.Writer, format, q); err != nil {
+		log.Printf("audit export aborted: %v", err)
+	}
+}
+
+// Verify checks the hash chain over the whole log.
+func (h *AuditHandler) Verify(c *gin.Context) {
+	result, err := h.auditService.Verify()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify audit log"})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func writeFilterError(c *gin.Context, err error) {
+	var fieldErrs service.FilterErrors
+	if errors.As(err, &fieldErrs) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid filter", "code": codeInvalidFilter, "fields": fieldErrs})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
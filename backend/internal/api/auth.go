@@ -1,6 +1,8 @@
 package api
 
 import (
+    "backend/internal/config"
+    "backend/internal/metrics"
     "backend/internal/service"
     "errors"
     "net/http"
@@ -12,10 +14,25 @@ import (
 
 type AuthHandler struct {
 	authService *service.AuthService
+	cookie      config.Cookie
 }
 
-func NewAuthHandler(authService *service.AuthService) *AuthHandler {
-	return &AuthHandler{authService: authService}
+func NewAuthHandler(authService *service.AuthService, cookie config.Cookie) *AuthHandler {
+	return &AuthHandler{authService: authService, cookie: cookie}
+}
+
+// setSessionCookie sets the auth_token cookie, or clears it when maxAge is
+// negative, with the configured domain and flags.
+func (h *AuthHandler) setSessionCookie(c *gin.Context, token string, maxAge int) {
+	switch h.cookie.SameSite {
+	case "strict":
+		c.SetSameSite(http.SameSiteStrictMode)
+	case "none":
+		c.SetSameSite(http.SameSiteNoneMode)
+	default:
+		c.SetSameSite(http.SameSiteLaxMode)
+	}
+	c.SetCookie("auth_token", token, maxAge, "/", h.cookie.Domain, h.cookie.Secure, true)
 }
 
 
@@ -34,6 +51,8 @@ func (h *AuthHandler) SignUp(c *gin.Context) {
         return
     }
 
+    auditActor(c, 0, req.Username)
+
     // Validation
     if len(req.Username) < 3 {
         c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Username must be at least 3 characters"})
@@ -60,17 +79,9 @@ func (h *AuthHandler) SignUp(c *gin.Context) {
         }
         return
     }
+    auditActor(c, userID, req.Username)
 
-    
-    c.SetCookie(
-        "auth_token",
-        token,
-        900,   // expires in 15 min (seconds)
-        "/",     // cookie valid for all paths
-        "localhost",      // domain (set domain in production)
-        false,    // secure (set true in production with HTTPS)
-        true,    // httpOnly 
-    )
+    h.setSessionCookie(c, token, int(h.cookie.MaxAge.Seconds()))
 
     // Respond without token
     c.JSON(http.StatusCreated, gin.H{
@@ -91,21 +102,16 @@ func (h *AuthHandler) SignIn(c *gin.Context) {
         return
     }
 
+    auditActor(c, 0, req.Username)
     token, userID, err := h.authService.SignIn(req.Username, req.Password)
     if err != nil {
+        metrics.LoginFailed("api")
         c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
         return
     }
+    auditActor(c, userID, req.Username)
 
-    c.SetCookie(
-        "auth_token", 
-        token,
-        900,      // expires in 1 day
-        "/",          // path
-        "localhost",  // domain
-        false,         // secure 
-        true,         // HttpOnly
-    )
+    h.setSessionCookie(c, token, int(h.cookie.MaxAge.Seconds()))
 
     c.JSON(http.StatusOK, gin.H{
         "success": true,
@@ -116,15 +122,7 @@ func (h *AuthHandler) SignIn(c *gin.Context) {
 
 func (h *AuthHandler) SignOut(c *gin.Context) {
 	// Overwrite cookie
-	c.SetCookie(
-		"auth_token",   // cookie name 
-		"",        // empty value
-		-1,        // maxAge negative
-		"/",       // path
-		"",        // domain 
-		true,      // secure
-		true,      // httpOnly
-	)
+	h.setSessionCookie(c, "", -1)
 
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Logged out"})
 }
@@ -141,5 +139,6 @@ func (h *AuthHandler) Me(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"id":       user.ID,
 		"username": user.Username,
+		"is_admin": user.IsAdmin,
 	})
 }
\ No newline at end of file
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"backend/internal/models"
+	"backend/internal/service"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ChangesHandler struct {
+	changeService *service.ChangeService
+}
+
+func NewChangesHandler(cs *service.ChangeService) *ChangesHandler {
+	return &ChangesHandler{changeService: cs}
+}
+
+// List returns the caller's file changes after ?cursor (0 or absent for
+// everything), oldest first. ?cursor=latest returns no changes, only the
+// current cursor, for clients that start from what they already have.
+func (h *ChangesHandler) List(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	if c.Query("cursor") == "latest" {
+		cursor, err := h.changeService.Latest(userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load changes"})
+			return
+		}
+		c.JSON(http.StatusOK, service.ChangePage{Changes: []models.FileChange{}, Cursor: cursor})
+		return
+	}
+
+	var cursor int64
+	if v := c.Query("cursor"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+		cursor = n
+	}
+	limit := 0
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+		limit = n
+	}
+
+	page, err := h.changeService.Since(userID, cursor, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load changes"})
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
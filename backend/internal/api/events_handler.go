@@ -0,0 +1,99 @@
+package api
+
+import (
+	"backend/internal/service"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const sseHeartbeat = 25 * time.Second
+
+type EventsHandler struct {
+	notificationService *service.NotificationService
+	done                chan struct{}
+	closeOnce           sync.Once
+}
+
+func NewEventsHandler(ns *service.NotificationService) *EventsHandler {
+	return &EventsHandler{notificationService: ns, done: make(chan struct{})}
+}
+
+// Shutdown ends every stream, so a stopping server need not wait for
+// browsers to disconnect. They reconnect elsewhere and catch up with
+// Last-Event-ID.
+func (h *EventsHandler) Shutdown() {
+	h.closeOnce.Do(func() { close(h.done) })
+}
+
+// Stream sends the caller's file and quota events as Server-Sent Events.
+// Browsers reconnect with Last-Event-ID and are sent what they missed; a
+// "resync" event means too much was missed and the client should reload.
+func (h *EventsHandler) Stream(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	lastID, _ := strconv.ParseUint(c.GetHeader("Last-Event-ID"), 10, 64)
+	stream, err := h.notificationService.Subscribe(userID, uint(lastID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to subscribe to events"})
+		return
+	}
+	defer stream.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // keep proxies from buffering the stream
+	c.Status(http.StatusOK)
+
+	sent := make(map[uint]bool, len(stream.Backlog))
+	if stream.Truncated {
+		fmt.Fprint(c.Writer, "event: resync\ndata: {}\n\n")
+	}
+	for _, ev := range stream.Backlog {
+		writeSSE(c, ev)
+		sent[ev.ID] = true
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-h.done:
+			return
+		case ev, ok := <-stream.Live:
+			if !ok {
+				// Cut off for falling behind. The browser reconnects with
+				// the last ID it was sent and catches up from the outbox.
+				return
+			}
+			if sent[ev.ID] {
+				continue
+			}
+			writeSSE(c, ev)
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": ping\n\n")
+		}
+		c.Writer.Flush()
+	}
+}
+
+func writeSSE(c *gin.Context, ev service.EventEnvelope) {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/internal/service"
+)
+
+// testBroker hands out one channel the test controls.
+type testBroker struct {
+	live       chan service.EventEnvelope
+	subscribed chan struct{}
+}
+
+func newTestBroker() *testBroker {
+	return &testBroker{live: make(chan service.EventEnvelope, 8), subscribed: make(chan struct{}, 1)}
+}
+
+func (b *testBroker) Publish(ev service.EventEnvelope) error {
+	b.live <- ev
+	return nil
+}
+
+func (b *testBroker) Subscribe(uint) (<-chan service.EventEnvelope, func()) {
+	b.subscribed <- struct{}{}
+	return b.live, func() {}
+}
+
+// sseStream is a connected event stream read one event at a time.
+type sseStream struct {
+	t    *testing.T
+	resp *http.Response
+	r    *bufio.Reader
+}
+
+type sseEvent struct {
+	id, event, data string
+}
+
+// openStream serves the events handler for userID and connects to it.
+func openStream(t *testing.T, ns *service.NotificationService, userID uint, lastEventID string) *sseStream {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	h := NewEventsHandler(ns)
+	r := gin.New()
+	r.GET("/events", func(c *gin.Context) { c.Set("userID", userID) }, h.Stream)
+	srv := httptest.NewServer(r)
+	t.Cleanup(func() {
+		h.Shutdown()
+		srv.Close()
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/events", nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type %q", ct)
+	}
+	return &sseStream{t: t, resp: resp, r: bufio.NewReader(resp.Body)}
+}
+
+// next reads the next event, skipping comments; ok is false at the end of
+// the stream.
+func (s *sseStream) next() (ev sseEvent, ok bool) {
+	s.t.Helper()
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			return ev, false
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			if ev != (sseEvent{}) {
+				return ev, true
+			}
+		case strings.HasPrefix(line, "id: "):
+			ev.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			ev.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			ev.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestEventStreamSendsLiveEvents(t *testing.T) {
+	broker := newTestBroker()
+	s := openStream(t, service.NewNotificationService(broker, nil), 1, "")
+	<-broker.subscribed
+
+	broker.Publish(service.EventEnvelope{ID: 5, Type: models.EventFileUploaded, UserID: 1, Data: []byte(`{"user_file_id":9}`)})
+	ev, ok := s.next()
+	if !ok {
+		t.Fatal("stream ended")
+	}
+	if ev.id != "5" || ev.event != models.EventFileUploaded {
+		t.Errorf("event %+v", ev)
+	}
+	var env service.EventEnvelope
+	if err := json.Unmarshal([]byte(ev.data), &env); err != nil || string(env.Data) != `{"user_file_id":9}` {
+		t.Errorf("data %s: %v", ev.data, err)
+	}
+}
+
+func TestEventStreamEndsWhenCutOff(t *testing.T) {
+	broker := newTestBroker()
+	s := openStream(t, service.NewNotificationService(broker, nil), 1, "")
+	<-broker.subscribed
+
+	// The broker closes the channel of a subscriber that fell behind
+	close(broker.live)
+	done := make(chan bool)
+	go func() {
+		_, ok := s.next()
+		done <- ok
+	}()
+	select {
+	case ok := <-done:
+		if ok {
+			t.Error("got an event from a closed subscription")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream still open after the subscription was cut off")
+	}
+}
+
+func TestEventStreamCatchesUp(t *testing.T) {
+	conn := openTestDB(t)
+	user := createTestUser(t, conn)
+	outbox := repository.NewOutboxRepository(conn)
+	now := time.Now()
+	var ids []uint
+	for i := 0; i < 3; i++ {
+		ev := &models.OutboxEvent{Type: models.EventFileUploaded, UserID: user.ID, Payload: `{}`, DispatchedAt: &now}
+		if err := outbox.Add(ev); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, ev.ID)
+	}
+
+	broker := newTestBroker()
+	s := openStream(t, service.NewNotificationService(broker, outbox), user.ID, fmt.Sprint(ids[0]))
+	for _, want := range ids[1:] {
+		if ev, ok := s.next(); !ok || ev.id != fmt.Sprint(want) {
+			t.Fatalf("backlog event %+v, want %d", ev, want)
+		}
+	}
+	// A live event already sent from the backlog is not sent twice
+	broker.Publish(service.EventEnvelope{ID: ids[2], Type: models.EventFileUploaded, UserID: user.ID})
+	broker.Publish(service.EventEnvelope{ID: ids[2] + 1, Type: models.EventFileDeleted, UserID: user.ID})
+	if ev, ok := s.next(); !ok || ev.id != fmt.Sprint(ids[2]+1) {
+		t.Errorf("live event %+v, want %d", ev, ids[2]+1)
+	}
+}
+
+func TestEventStreamResyncsAfterLongGap(t *testing.T) {
+	conn := openTestDB(t)
+	user := createTestUser(t, conn)
+	err := conn.Exec(`INSERT INTO event_outbox (type, user_id, payload, dispatched_at)
+		SELECT ?, ?, '{}', NOW() FROM generate_series(1, 501)`, models.EventFileUploaded, user.ID).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := openStream(t, service.NewNotificationService(newTestBroker(), repository.NewOutboxRepository(conn)), user.ID, "1")
+	if ev, ok := s.next(); !ok || ev.event != "resync" {
+		t.Errorf("first event %+v, want resync", ev)
+	}
+}
@@ -1,9 +1,15 @@
 package api
 
 import (
+	"backend/internal/models"
 	"backend/internal/service"
+	"errors"
+	"io"
+	"mime/multipart"
 	"net/http"
+	"path"
 	"strconv"
+	"strings"
 	"fmt"
 	"log"
 
@@ -11,16 +17,19 @@ import (
 )
 
 type FileHandler struct {
-	fileService *service.FileService
+	fileService      *service.FileService
+	analyticsService *service.AnalyticsService
 }
 
-func NewFileHandler(fs *service.FileService) *FileHandler {
+func NewFileHandler(fs *service.FileService, as *service.AnalyticsService) *FileHandler {
 	return &FileHandler{
-		fileService: fs,
+		fileService:      fs,
+		analyticsService: as,
 	}
 }
 
-// Upload handles file upload requests
+// Upload handles file upload requests. The multipart body is streamed
+// straight into the service rather than parsed into memory or temp files.
 func (h *FileHandler) Upload(c *gin.Context) {
 	userID := c.GetUint("userID")
 	if userID == 0 {
@@ -28,45 +37,216 @@ func (h *FileHandler) Upload(c *gin.Context) {
 		return
 	}
 
-	fileHeader, err := c.FormFile("file")
+	part, err := nextFilePart(c, "file")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "no file provided"})
 		return
 	}
+	defer part.Close()
 
-    if err := h.fileService.CheckStorageQuota(userID, fileHeader.Size); err != nil {
-        c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
-        return
-    }
-
-	result, err := h.fileService.ProcessFileUpload(userID, fileHeader)
+	auditDetail(c, gin.H{"filename": part.FileName(), "folder": c.Query("folder")})
+	result, err := h.fileService.ProcessFileUpload(userID, c.Query("folder"), part.FileName(), part)
 	if err != nil {
-		switch err.Error() {
-		case "mime mismatch":
-			c.JSON(http.StatusBadRequest, gin.H{"error": "File type validation failed"})
-		case "file already uploaded by user":
-			c.JSON(http.StatusConflict, gin.H{"error": "You have already uploaded this file"})
-		case "file too large":
-			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File size exceeds limit"})
-		case "file type not allowed":
-			c.JSON(http.StatusBadRequest, gin.H{"error": "File type not allowed"})
-		case "failed to open uploaded file":
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file upload"})
-		case "failed to create file reference":
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process file"})
-		default:
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Upload failed"})
-		}
+		status, code, msg := uploadErrorResponse(err)
+		c.JSON(status, gin.H{"error": msg, "code": code})
 		return
 	}
+	auditTarget(c, result.ID, result.FileID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"id":       result.ID,
 		"file_id":  result.FileID,
 		"filename": result.FileName,
+		"folder":   result.Folder,
 	})
 }
 
+// BulkUpload accepts any number of file parts in one multipart request and
+// reports a result per file. Files are processed as they stream in, and one
+// failing file does not affect the rest.
+func (h *FileHandler) BulkUpload(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no file provided"})
+		return
+	}
+
+	folder := c.Query("folder")
+	var results []service.UploadResult
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "malformed multipart body", "results": uploadResultsJSON(results)})
+			return
+		}
+		if part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		res := service.UploadResult{Filename: part.FileName(), Folder: folder}
+		res.UserFile, res.Err = h.fileService.ProcessFileUpload(userID, folder, part.FileName(), part)
+		part.Close()
+		results = append(results, res)
+	}
+
+	if len(results) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no file provided"})
+		return
+	}
+	writeUploadResults(c, results, nil)
+}
+
+// UploadArchive extracts an uploaded ZIP or tar.gz into individual files,
+// preserving its folder structure under the optional "folder" query param.
+func (h *FileHandler) UploadArchive(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	part, err := nextFilePart(c, "file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no file provided"})
+		return
+	}
+	defer part.Close()
+
+	auditDetail(c, gin.H{"archive": part.FileName(), "folder": c.Query("folder")})
+	results, err := h.fileService.ExtractArchive(userID, c.Query("folder"), part.FileName(), part)
+	if err != nil && !errors.Is(err, service.ErrArchiveLimit) {
+		switch {
+		case errors.Is(err, service.ErrUnsupportedArchive):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Only .zip, .tar.gz and .tgz archives are supported"})
+		case errors.Is(err, service.ErrInvalidArchive):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Archive is corrupt or unreadable"})
+		default:
+			status, code, msg := uploadErrorResponse(err)
+			c.JSON(status, gin.H{"error": msg, "code": code})
+		}
+		return
+	}
+	writeUploadResults(c, results, err)
+}
+
+// writeUploadResults responds with per-file outcomes. The request succeeds
+// as long as it was well-formed; clients inspect each result.
+func writeUploadResults(c *gin.Context, results []service.UploadResult, aborted error) {
+	succeeded := 0
+	created := make([]uint, 0, len(results))
+	for _, r := range results {
+		if r.Err == nil {
+			succeeded++
+			created = append(created, r.UserFile.ID)
+		}
+	}
+	auditDetail(c, gin.H{"user_file_ids": created, "failed": len(results) - succeeded})
+
+	body := gin.H{
+		"results":   uploadResultsJSON(results),
+		"succeeded": succeeded,
+		"failed":    len(results) - succeeded,
+	}
+	if aborted != nil {
+		body["error"] = "Extraction stopped: archive exceeds size or compression limits"
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+func uploadResultsJSON(results []service.UploadResult) []gin.H {
+	out := make([]gin.H, 0, len(results))
+	for _, r := range results {
+		item := gin.H{"filename": r.Filename, "folder": r.Folder}
+		if r.Err != nil {
+			status, code, msg := uploadErrorResponse(r.Err)
+			item["status"] = status
+			item["code"] = code
+			item["error"] = msg
+		} else {
+			item["status"] = http.StatusOK
+			item["id"] = r.UserFile.ID
+			item["file_id"] = r.UserFile.FileID
+		}
+		out = append(out, item)
+	}
+	return out
+}
+
+// nextFilePart advances the request's multipart reader to the first file part
+// with the given form field name.
+func nextFilePart(c *gin.Context, field string) (*multipart.Part, error) {
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == field && part.FileName() != "" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+// Error codes sent as "code" next to "error", for clients to tell apart
+// failures that share a status. Messages may be reworded; codes may not.
+const (
+	codeQuotaExceeded    = "quota_exceeded"
+	codeAlreadyUploaded  = "already_uploaded"
+	codeFileTooLarge     = "file_too_large"
+	codeTypeNotAllowed   = "type_not_allowed"
+	codeInvalidUpload    = "invalid_upload"
+	codeInvalidPath      = "invalid_path"
+	codeInvalidFilter    = "invalid_filter"
+	codeUnsupportedEntry = "unsupported_entry"
+	codeInvalidArchive   = "invalid_archive"
+	codeArchiveLimit     = "archive_limit"
+	codeInternal         = "internal"
+)
+
+// uploadErrorResponse maps upload service errors onto HTTP status codes,
+// error codes and client-facing messages.
+func uploadErrorResponse(err error) (int, string, string) {
+	switch {
+	case errors.Is(err, service.ErrQuotaExceeded):
+		return http.StatusForbidden, codeQuotaExceeded, err.Error()
+	case errors.Is(err, service.ErrMimeMismatch):
+		return http.StatusBadRequest, codeTypeNotAllowed, "File type validation failed"
+	case errors.Is(err, service.ErrAlreadyUploaded):
+		return http.StatusConflict, codeAlreadyUploaded, "You have already uploaded this file"
+	case errors.Is(err, service.ErrFileTooLarge):
+		return http.StatusRequestEntityTooLarge, codeFileTooLarge, "File size exceeds limit"
+	case errors.Is(err, service.ErrTypeNotAllowed):
+		return http.StatusBadRequest, codeTypeNotAllowed, "File type not allowed"
+	case errors.Is(err, service.ErrUploadRead):
+		return http.StatusBadRequest, codeInvalidUpload, "Invalid file upload"
+	case errors.Is(err, service.ErrInvalidPath):
+		return http.StatusBadRequest, codeInvalidPath, "Invalid file name or folder"
+	case errors.Is(err, service.ErrUnsupportedEntry):
+		return http.StatusUnprocessableEntity, codeUnsupportedEntry, "Links and special files are not extracted"
+	case errors.Is(err, service.ErrInvalidArchive):
+		return http.StatusUnprocessableEntity, codeInvalidArchive, "Archive entry is corrupt"
+	case errors.Is(err, service.ErrArchiveLimit):
+		return http.StatusRequestEntityTooLarge, codeArchiveLimit, "Archive exceeds size or compression limits"
+	default:
+		log.Printf("upload: %v", err)
+		return http.StatusInternalServerError, codeInternal, "Upload failed"
+	}
+}
+
 // ListFiles handles file listing requests
 func (h *FileHandler) ListFiles(c *gin.Context) {
     userID, exists := c.Get("userID")
@@ -75,24 +255,39 @@ func (h *FileHandler) ListFiles(c *gin.Context) {
         return
     }
 
-    // Collect filters from query parameters
-    filters := map[string]string{
-        "filename":  c.Query("filename"),
-        "mimeType":  c.Query("mimeType"),
-        "uploader":  c.Query("uploader"),
-        "minSize":   c.Query("minSize"),
-        "maxSize":   c.Query("maxSize"),
-        "startDate": c.Query("startDate"),
-        "endDate":   c.Query("endDate"),
+    filter, err := service.ParseFileFilter(c.Request.URL.Query())
+    if err != nil {
+        writeFilterError(c, err)
+        return
     }
 
-    files, err := h.fileService.ListFilesForFrontend(userID.(uint), filters)
+    limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list files"})
+        c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+        return
+    }
+    opts := service.ListOptions{
+        Sort:      c.Query("sort"),
+        Order:     c.Query("order"),
+        Limit:     limit,
+        Cursor:    c.Query("cursor"),
+        WithTotal: c.Query("count") == "true",
+    }
+
+    page, err := h.fileService.ListFilesForFrontend(userID.(uint), filter, opts)
+    if err != nil {
+        switch {
+        case errors.Is(err, service.ErrInvalidSort):
+            c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be one of name, size, uploaded_at, downloads, mime_type and order asc or desc"})
+        case errors.Is(err, service.ErrInvalidCursor):
+            c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or stale cursor"})
+        default:
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list files"})
+        }
         return
     }
 
-    c.JSON(http.StatusOK, gin.H{"files": files})
+    c.JSON(http.StatusOK, page)
 }
 
 
@@ -112,6 +307,7 @@ func (h *FileHandler) DownloadFile(c *gin.Context) {
 		return
 	}
 	fileID := uint(fileID64)
+	auditTarget(c, 0, fileID)
 
 	fileInfo, err := h.fileService.GetFileForDownload(userID, fileID)
 	if err != nil {
@@ -126,6 +322,8 @@ func (h *FileHandler) DownloadFile(c *gin.Context) {
 		return
 	}
 
+	auditTarget(c, fileInfo.UserFileID, fileInfo.ID)
+
 	c.Header("Content-Description", "File Transfer")
 	c.Header("Content-Disposition", "attachment; filename=\""+fileInfo.Filename+"\"")
 	c.Header("Content-Type", fileInfo.MimeType)
@@ -133,6 +331,78 @@ func (h *FileHandler) DownloadFile(c *gin.Context) {
 	c.Header("Pragma", "no-cache")
 	c.Header("Expires", "0")
 	c.File(fileInfo.StoragePath)
+	h.recordDownload(c, fileInfo, userID, models.DownloadViaDirect)
+}
+
+// DownloadZip streams several files as one ZIP built on the fly. The body
+// names either explicit user_files IDs or a folder to download recursively.
+func (h *FileHandler) DownloadZip(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req struct {
+		IDs    []uint `json:"ids"`
+		Folder string `json:"folder"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	auditDetail(c, gin.H{"user_file_ids": req.IDs, "folder": req.Folder})
+	entries, err := h.fileService.PrepareZipDownload(userID, req.IDs, req.Folder)
+	if err != nil {
+		switch {
+		case err.Error() == "file not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found or access denied"})
+		case errors.Is(err, service.ErrNothingToDownload):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No files selected"})
+		case errors.Is(err, service.ErrInvalidPath):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid folder", "code": codeInvalidPath})
+		case errors.Is(err, service.ErrDownloadTooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Selection exceeds download size limit"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Download failed"})
+		}
+		return
+	}
+
+	name := "files.zip"
+	if len(req.IDs) == 0 && req.Folder != "" {
+		name = path.Base(strings.Trim(req.Folder, "/")) + ".zip"
+	}
+	c.Header("Content-Description", "File Transfer")
+	c.Header("Content-Disposition", "attachment; filename=\""+name+"\"")
+	c.Header("Content-Type", "application/zip")
+	c.Header("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
+	c.Header("Pragma", "no-cache")
+	c.Header("Expires", "0")
+	c.Status(http.StatusOK)
+
+	// Headers are already sent, so a failure can only truncate the stream
+	written, err := service.WriteZip(c.Writer, entries)
+	if err != nil {
+		log.Printf("zip download for user %d aborted: %v", userID, err)
+	}
+
+	// Entries cut off part-way are recorded as partial, later ones not at all
+	events := make([]models.DownloadEvent, 0, len(entries))
+	for i, e := range entries {
+		if i > written {
+			break
+		}
+		ev := downloadEvent(c, userID, models.DownloadViaZip)
+		ev.UserFileID, ev.FileID = e.UserFileID, e.FileID
+		ev.Completed = i < written
+		if ev.Completed {
+			ev.BytesSent = e.Size
+		}
+		events = append(events, ev)
+	}
+	h.analyticsService.RecordDownloads(events)
 }
 
 // DeleteFile handles file deletion requests
@@ -150,6 +420,7 @@ func (h *FileHandler) DeleteFile(c *gin.Context) {
 		return
 	}
 	userfileID := uint(fileID64)
+	auditTarget(c, userfileID, 0)
 
 	err = h.fileService.DeleteFile(userfileID, userID)
 	if err != nil {
@@ -185,6 +456,8 @@ func (h *FileHandler) ChangeVisibility(c *gin.Context) {
 
 	makePublicStr := c.Query("make_public")
 	makePublic := makePublicStr == "true"
+	auditTarget(c, userfileID, 0)
+	auditDetail(c, gin.H{"make_public": makePublic})
 
 	log.Printf("Request body: make_public=%v", makePublic)
 
@@ -194,6 +467,8 @@ func (h *FileHandler) ChangeVisibility(c *gin.Context) {
 		return
 	}
 
+	auditTarget(c, uf.ID, uf.FileID)
+
 	publicLink := ""
 	if uf.Visibility == "public" && uf.PublicToken != nil {
 		publicLink = "/download/" + *uf.PublicToken
@@ -213,6 +488,51 @@ func (h *FileHandler) ChangeVisibility(c *gin.Context) {
 	})
 }
 
+// RenameFile moves one of the caller's files to a new name and/or folder.
+func (h *FileHandler) RenameFile(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	userfileID, ok := parseUserFileID(c)
+	if !ok {
+		return
+	}
+	auditTarget(c, userfileID, 0)
+
+	var req struct {
+		Filename *string `json:"filename"`
+		Folder   *string `json:"folder"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Filename == nil && req.Folder == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "filename or folder required"})
+		return
+	}
+	auditDetail(c, req)
+
+	uf, err := h.fileService.RenameFile(userID, userfileID, req.Folder, req.Filename)
+	if err != nil {
+		switch {
+		case err.Error() == "file not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found or access denied"})
+		case errors.Is(err, service.ErrInvalidPath):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file name or folder", "code": codeInvalidPath})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Rename failed"})
+		}
+		return
+	}
+	auditTarget(c, uf.ID, uf.FileID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":       uf.ID,
+		"file_id":  uf.FileID,
+		"filename": uf.FileName,
+		"folder":   uf.Folder,
+	})
+}
+
 func (h *FileHandler) DownloadPublic(c *gin.Context) {
     token := c.Param("token")
     if token == "" {
@@ -226,6 +546,7 @@ func (h *FileHandler) DownloadPublic(c *gin.Context) {
         c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
         return
     }
+    auditTarget(c, file.UserFileID, file.ID)
 
 	fmt.Printf("DEBUG: Serving file from disk path: %s\n", file.StoragePath)
 
@@ -238,6 +559,7 @@ func (h *FileHandler) DownloadPublic(c *gin.Context) {
     c.Header("Expires", "0")
 
     c.File(file.StoragePath)
+    h.recordDownload(c, file, 0, models.DownloadViaLink)
 }
 
 func (h *FileHandler) GetStorageStats(c *gin.Context) {
@@ -257,3 +579,30 @@ func (h *FileHandler) GetStorageStats(c *gin.Context) {
         "savings":            savings,
     })
 }
+
+// recordDownload logs a download served with c.File. It counts as complete
+// only if the whole file went out in a 200 response; range requests and
+// dropped connections are partial.
+func (h *FileHandler) recordDownload(c *gin.Context, d *service.Download, userID uint, via string) {
+	ev := downloadEvent(c, userID, via)
+	ev.UserFileID, ev.FileID = d.UserFileID, d.ID
+	if d.LinkID != "" {
+		ev.LinkID = &d.LinkID
+	}
+	ev.BytesSent = int64(max(c.Writer.Size(), 0))
+	ev.Completed = c.Writer.Status() == http.StatusOK && ev.BytesSent == d.Size
+	h.analyticsService.RecordDownloads([]models.DownloadEvent{ev})
+}
+
+func downloadEvent(c *gin.Context, userID uint, via string) models.DownloadEvent {
+	ev := models.DownloadEvent{
+		Via:       via,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		Referrer:  c.Request.Referer(),
+	}
+	if userID != 0 {
+		ev.UserID = &userID
+	}
+	return ev
+}
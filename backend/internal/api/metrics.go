@@ -0,0 +1,91 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"backend/internal/metrics"
+)
+
+// metricMethods are the methods recorded by name; anything else is
+// recorded as "other", so clients can't create series at will.
+var metricMethods = append([]string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}, WebDAVMethods...)
+
+// Metrics records every request's count and latency. Requests are labelled
+// with the route pattern they matched, such as /api/files/:id, so IDs,
+// paths and bucket names never become labels.
+func Metrics(server string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		method := c.Request.Method
+		if !slices.Contains(metricMethods, method) {
+			method = "other"
+		}
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.ObserveRequest(server, method, route, c.Writer.Status(), time.Since(start))
+	}
+}
+
+// MeasureUpload records the bytes read from the request body and the time
+// taken by requests that succeed.
+func MeasureUpload(protocol string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		body := &countingBody{ReadCloser: c.Request.Body}
+		c.Request.Body = body
+		c.Next()
+		if c.Writer.Status() < http.StatusBadRequest {
+			metrics.ObserveUpload(protocol, body.n.Load(), time.Since(start))
+		}
+	}
+}
+
+// MeasureDownload records the bytes written to the response and the time
+// taken by requests that succeed.
+func MeasureDownload(protocol string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		if c.Writer.Status() < http.StatusBadRequest {
+			metrics.ObserveDownload(protocol, int64(max(c.Writer.Size(), 0)), time.Since(start))
+		}
+	}
+}
+
+// MeasureTransfers measures PUT requests as uploads and GET requests as
+// downloads, for the WebDAV and S3 routes where one handler serves both.
+func MeasureTransfers(protocol string) gin.HandlerFunc {
+	upload, download := MeasureUpload(protocol), MeasureDownload(protocol)
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodPut:
+			upload(c)
+		case http.MethodGet:
+			download(c)
+		}
+	}
+}
+
+type countingBody struct {
+	io.ReadCloser
+	n atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"backend/internal/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// labelValue matches one label of a sample in the text exposition format.
+var labelValue = regexp.MustCompile(`(\w+)="((?:[^"\\]|\\.)*)"`)
+
+// scrapeLabels returns the values each label takes across the vault_
+// series the exporter serves.
+func scrapeLabels(t *testing.T) map[string]map[string]bool {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("scrape: status %d", rec.Code)
+	}
+	labels := map[string]map[string]bool{}
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if !strings.HasPrefix(line, "vault_") {
+			continue
+		}
+		for _, m := range labelValue.FindAllStringSubmatch(line, -1) {
+			if labels[m[1]] == nil {
+				labels[m[1]] = map[string]bool{}
+			}
+			labels[m[1]][m[2]] = true
+		}
+	}
+	return labels
+}
+
+func TestMetricLabelsCarryNoUserData(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ok := func(c *gin.Context) {
+		if c.Request.Body != nil {
+			c.Request.Body.Read(make([]byte, 64))
+		}
+		c.String(http.StatusOK, "file contents")
+	}
+
+	r := gin.New()
+	r.Use(Metrics("api"))
+	r.GET("/api/files/:id/download", MeasureDownload("http"), ok)
+	r.POST("/api/files/upload", MeasureUpload("http"), ok)
+	dav := r.Group(WebDAVPrefix, MeasureTransfers("webdav"))
+	for _, method := range WebDAVMethods {
+		dav.Handle(method, "/*path", ok)
+	}
+	s3 := gin.New()
+	s3.Use(Metrics("s3"), MeasureTransfers("s3"))
+	s3.Any("/*path", ok)
+
+	// Everything a client controls: IDs, names, buckets, queries, methods
+	private := []string{"48151623", "tax-return", "alice-private", "s3cr3t", "BREW", "/nowhere"}
+	for _, req := range []struct {
+		router       *gin.Engine
+		method, path string
+	}{
+		{r, "GET", "/api/files/48151623/download?token=s3cr3t"},
+		{r, "POST", "/api/files/upload?name=tax-return.pdf"},
+		{r, "PUT", WebDAVPrefix + "/alice-private/tax-return.pdf"},
+		{r, "PROPFIND", WebDAVPrefix + "/alice-private/"},
+		{r, "BREW", WebDAVPrefix + "/alice-private/tax-return.pdf"},
+		{r, "GET", "/nowhere/48151623"},
+		{s3, "PUT", "/alice-private/tax-return.pdf"},
+		{s3, "GET", "/alice-private/tax-return.pdf?X-Amz-Signature=s3cr3t"},
+	} {
+		body := strings.NewReader("uploaded bytes")
+		req.router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(req.method, req.path, body))
+	}
+
+	labels := scrapeLabels(t)
+	for name, values := range labels {
+		for v := range values {
+			for _, p := range private {
+				if strings.Contains(v, p) {
+					t.Errorf("label %s=%q carries %q from a request", name, v, p)
+				}
+			}
+		}
+	}
+	for _, route := range []string{
+		"/api/files/:id/download", "/api/files/upload", WebDAVPrefix + "/*path", "/*path", "unmatched",
+	} {
+		if !labels["route"][route] {
+			t.Errorf("no series for route %s", route)
+		}
+	}
+	if !labels["method"]["PROPFIND"] || !labels["method"]["other"] {
+		t.Errorf("methods %v; want WebDAV methods by name and unknown ones as other", labels["method"])
+	}
+	for _, protocol := range []string{"http", "webdav", "s3"} {
+		if !labels["protocol"][protocol] {
+			t.Errorf("no transfer series for %s", protocol)
+		}
+	}
+}
@@ -1,18 +1,37 @@
 package api
 
 import (
+	"backend/internal/metrics"
 	"backend/internal/service"
 	"net/http"
-	"os"
 	"fmt"
+	"strings"
 	"sync"
     "time"
 
 	"github.com/gin-gonic/gin"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware accepts the session cookie set at login or, for scripts
+// and API clients, a personal token sent as "Authorization: Bearer vt_...".
+// Either is refused once its user has been disabled or deleted.
+func AuthMiddleware(authService *service.AuthService, tokens *service.TokenService) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if bearer, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+			userID, err := tokens.Resolve(strings.TrimSpace(bearer))
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+				c.Abort()
+				return
+			}
+			if !checkActive(c, authService, userID) {
+				return
+			}
+			c.Set("userID", userID)
+			c.Next()
+			return
+		}
+
 		// Read JWT from cookie instead of Authorization header
 		tokenStr, err := c.Cookie("auth_token")
 		if err != nil || tokenStr == "" {
@@ -22,13 +41,17 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		// Validate token
-		userID, err := service.ParseToken(tokenStr, []byte(os.Getenv("JWT_SECRET")))
+		userID, err := authService.ParseToken(tokenStr)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 			c.Abort()
 			return
 		}
 
+		if !checkActive(c, authService, userID) {
+			return
+		}
+
 		// Store userID in context so handlers can use it
 		c.Set("userID", uint(userID))
 
@@ -37,6 +60,30 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// checkActive aborts with 401 for users that can no longer sign in.
+func checkActive(c *gin.Context, authService *service.AuthService, userID uint) bool {
+	if err := authService.CheckActive(userID); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		c.Abort()
+		return false
+	}
+	return true
+}
+
+// AdminMiddleware restricts a route group to administrators. It must run
+// after AuthMiddleware.
+func AdminMiddleware(authService *service.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := authService.GetUserByID(c.GetUint("userID"))
+		if err != nil || user == nil || !user.IsAdmin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "admin access required"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
 
 type RateLimiter struct {
     mu       sync.Mutex
@@ -79,6 +126,7 @@ func (rl *RateLimiter) RateMiddleware() gin.HandlerFunc {
         rl.lastTime[userID] = now
 
         if rl.tokens[userID] <= 0 {
+            metrics.RateLimited()
             c.JSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
             c.Abort()
             return
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"backend/internal/models"
+	"backend/internal/service"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PreviewHandler struct {
+	previewService *service.PreviewService
+}
+
+func NewPreviewHandler(ps *service.PreviewService) *PreviewHandler {
+	return &PreviewHandler{previewService: ps}
+}
+
+// GetPreview returns preview metadata: thumbnail URLs for images or a text
+// snippet for text-like files.
+func (h *PreviewHandler) GetPreview(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	userfileID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file ID"})
+		return
+	}
+
+	preview, err := h.previewService.GetPreview(userID, uint(userfileID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found or access denied"})
+		return
+	}
+
+	resp := gin.H{"status": preview.Status, "kind": preview.Kind}
+	switch {
+	case preview.Status != models.PreviewReady:
+	case preview.Kind == models.PreviewKindImage:
+		thumbs := gin.H{}
+		for _, size := range strings.Split(preview.Sizes, ",") {
+			thumbs[size] = fmt.Sprintf("/api/files/%d/preview/%s", userfileID, size)
+		}
+		resp["thumbnails"] = thumbs
+	case preview.Kind == models.PreviewKindText && preview.Snippet != nil:
+		resp["snippet"] = *preview.Snippet
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetThumbnail serves one generated thumbnail size as JPEG.
+func (h *PreviewHandler) GetThumbnail(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	userfileID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file ID"})
+		return
+	}
+
+	path, err := h.previewService.ThumbnailPath(userID, uint(userfileID), c.Param("size"))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrPreviewNotReady):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Preview not available"})
+		case errors.Is(err, service.ErrPreviewSize):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown preview size"})
+		default:
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found or access denied"})
+		}
+		return
+	}
+
+	// Thumbnails are content-addressed, so the browser may keep them
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.Header("Content-Type", "image/jpeg")
+	c.File(path)
+}
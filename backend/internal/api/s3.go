@@ -0,0 +1,667 @@
+package api
+
+import (
+	"backend/internal/metrics"
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/internal/service"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	s3Namespace      = "http://s3.amazonaws.com/doc/2006-03-01/"
+	s3TimeFormat     = "2006-01-02T15:04:05.000Z"
+	maxS3XMLBody     = 4 << 20
+	maxDeleteObjects = 1000
+	s3StorageClass   = "STANDARD"
+	s3DirContentType = "application/x-directory"
+)
+
+// s3Error is an error response in the S3 wire format.
+type s3Error struct {
+	status  int
+	code    string
+	message string
+}
+
+var (
+	errS3AccessDenied       = &s3Error{http.StatusForbidden, "AccessDenied", "Access Denied"}
+	errS3MalformedAuth      = &s3Error{http.StatusBadRequest, "AuthorizationHeaderMalformed", "The authorization header or query parameters are malformed"}
+	errS3UnsupportedAuth    = &s3Error{http.StatusBadRequest, "InvalidRequest", "Only AWS Signature Version 4 is supported"}
+	errS3ExpiredRequest     = &s3Error{http.StatusForbidden, "AccessDenied", "Request has expired"}
+	errS3TimeSkewed         = &s3Error{http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the server's time is too large"}
+	errS3InvalidAccessKey   = &s3Error{http.StatusForbidden, "InvalidAccessKeyId", "The access key ID you provided does not exist"}
+	errS3SignatureMismatch  = &s3Error{http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided"}
+	errS3MissingContentSHA  = &s3Error{http.StatusBadRequest, "InvalidRequest", "Missing required header for this request: x-amz-content-sha256"}
+	errS3InvalidDigest      = &s3Error{http.StatusBadRequest, "InvalidArgument", "x-amz-content-sha256 must be UNSIGNED-PAYLOAD, a streaming payload type, or a SHA-256 hex digest"}
+	errS3ContentSHAMismatch = &s3Error{http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided x-amz-content-sha256 header does not match what was computed"}
+	errS3IncompleteBody     = &s3Error{http.StatusBadRequest, "IncompleteBody", "The request body was incomplete or malformed"}
+	errS3NoSuchKey          = &s3Error{http.StatusNotFound, "NoSuchKey", "The specified key does not exist"}
+	errS3NoSuchUpload       = &s3Error{http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist"}
+	errS3InvalidPart        = &s3Error{http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found or its ETag did not match"}
+	errS3InvalidPartOrder   = &s3Error{http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order"}
+	errS3InvalidKey         = &s3Error{http.StatusBadRequest, "InvalidArgument", "The key is not a valid file path"}
+	errS3InvalidArgument    = &s3Error{http.StatusBadRequest, "InvalidArgument", "Invalid argument"}
+	errS3MalformedXML       = &s3Error{http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed or did not validate"}
+	errS3EntityTooLarge     = &s3Error{http.StatusBadRequest, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed size"}
+	errS3TypeNotAllowed     = &s3Error{http.StatusBadRequest, "InvalidArgument", "The file type is not allowed"}
+	errS3QuotaExceeded      = &s3Error{http.StatusForbidden, "QuotaExceeded", "Storage quota exceeded"}
+	errS3NotImplemented     = &s3Error{http.StatusNotImplemented, "NotImplemented", "A header or query parameter you provided implies functionality that is not implemented"}
+	errS3MethodNotAllowed   = &s3Error{http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource"}
+	errS3Internal           = &s3Error{http.StatusInternalServerError, "InternalError", "We encountered an internal error. Please try again."}
+)
+
+// s3Subresources are query parameters selecting bucket or object features
+// this server does not implement.
+var s3Subresources = []string{
+	"acl", "tagging", "versioning", "versions", "policy", "cors", "lifecycle", "website",
+	"logging", "notification", "replication", "encryption", "object-lock", "retention",
+	"legal-hold", "attributes", "restore", "select", "torrent", "accelerate", "requestPayment",
+}
+
+// S3Handler serves an S3-compatible API with path-style addressing. Each
+// user owns a single bucket named after their username; keys are file
+// paths, so the same files are visible through the API, WebDAV and S3.
+// Requests are signed with SigV4 using access keys the user creates.
+type S3Handler struct {
+	files      *service.FileService
+	multipart  *service.MultipartService
+	accessKeys *service.AccessKeyService
+	audit      *service.AuditService
+}
+
+func NewS3Handler(fs *service.FileService, ms *service.MultipartService, ks *service.AccessKeyService, as *service.AuditService) *S3Handler {
+	return &S3Handler{files: fs, multipart: ms, accessKeys: ks, audit: as}
+}
+
+// Serve dispatches an S3 request by bucket, key, method and query.
+func (h *S3Handler) Serve(c *gin.Context) {
+	c.Header("x-amz-request-id", newS3RequestID())
+	c.Header("Server", "FileVault")
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(c.Request.URL.Path, "/"), "/")
+	action := s3AuditAction(c.Request, bucket, key)
+	if action != "" {
+		auditDetail(c, gin.H{"bucket": bucket, "key": key})
+		defer func() { recordAudit(h.audit, c, action) }()
+	}
+
+	req, e := h.authenticate(c.Request)
+	if e != nil {
+		if e == errS3InvalidAccessKey || e == errS3SignatureMismatch {
+			metrics.LoginFailed("s3")
+		}
+		writeS3Error(c, e)
+		return
+	}
+	c.Set("userID", req.user.ID)
+	auditActor(c, req.user.ID, req.user.Username)
+
+	q := c.Request.URL.Query()
+	for _, sub := range s3Subresources {
+		if q.Has(sub) {
+			writeS3Error(c, errS3NotImplemented)
+			return
+		}
+	}
+
+	switch {
+	case bucket == "":
+		if c.Request.Method != http.MethodGet {
+			writeS3Error(c, errS3MethodNotAllowed)
+			return
+		}
+		h.listBuckets(c, req)
+	case bucket != req.user.Username:
+		writeS3Error(c, errS3AccessDenied)
+	case key == "":
+		h.serveBucket(c, req)
+	default:
+		h.serveObject(c, req, key)
+	}
+}
+
+func (h *S3Handler) serveBucket(c *gin.Context, req *s3Request) {
+	q := c.Request.URL.Query()
+	switch c.Request.Method {
+	case http.MethodHead:
+		c.Status(http.StatusOK)
+	case http.MethodPut:
+		// The bucket always exists; creating it again is a no-op, as it is
+		// for a bucket you own in us-east-1
+		c.Header("Location", "/"+req.user.Username)
+		c.Status(http.StatusOK)
+	case http.MethodGet:
+		switch {
+		case q.Has("location"):
+			writeS3XML(c, http.StatusOK, struct {
+				XMLName xml.Name `xml:"LocationConstraint"`
+				NS      string   `xml:"xmlns,attr"`
+			}{NS: s3Namespace})
+		case q.Has("uploads"):
+			writeS3Error(c, errS3NotImplemented)
+		case q.Get("list-type") == "2":
+			h.listObjects(c, req, true)
+		default:
+			h.listObjects(c, req, false)
+		}
+	case http.MethodPost:
+		if !q.Has("delete") {
+			writeS3Error(c, errS3NotImplemented)
+			return
+		}
+		h.deleteObjects(c, req)
+	default:
+		writeS3Error(c, errS3MethodNotAllowed)
+	}
+}
+
+func (h *S3Handler) serveObject(c *gin.Context, req *s3Request, key string) {
+	q := c.Request.URL.Query()
+	uploadID := q.Get("uploadId")
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead:
+		if uploadID != "" {
+			writeS3Error(c, errS3NotImplemented)
+			return
+		}
+		h.getObject(c, req, key)
+	case http.MethodPut:
+		switch {
+		case c.GetHeader("X-Amz-Copy-Source") != "":
+			writeS3Error(c, errS3NotImplemented)
+		case uploadID != "":
+			h.uploadPart(c, req, key, uploadID)
+		default:
+			h.putObject(c, req, key)
+		}
+	case http.MethodPost:
+		switch {
+		case q.Has("uploads"):
+			h.createMultipartUpload(c, req, key)
+		case uploadID != "":
+			h.completeMultipartUpload(c, req, key, uploadID)
+		default:
+			writeS3Error(c, errS3NotImplemented)
+		}
+	case http.MethodDelete:
+		if uploadID != "" {
+			if err := h.multipart.Abort(req.user.ID, uploadID, key); err != nil {
+				writeS3Error(c, s3ErrorFor(err))
+				return
+			}
+			c.Status(http.StatusNoContent)
+			return
+		}
+		if err := h.files.DeleteObject(req.user.ID, key); err != nil {
+			writeS3Error(c, s3ErrorFor(err))
+			return
+		}
+		c.Status(http.StatusNoContent)
+	default:
+		writeS3Error(c, errS3MethodNotAllowed)
+	}
+}
+
+func (h *S3Handler) listBuckets(c *gin.Context, req *s3Request) {
+	type bucket struct {
+		Name         string `xml:"Name"`
+		CreationDate string `xml:"CreationDate"`
+	}
+	writeS3XML(c, http.StatusOK, struct {
+		XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+		NS      string   `xml:"xmlns,attr"`
+		Owner   s3Owner  `xml:"Owner"`
+		Buckets []bucket `xml:"Buckets>Bucket"`
+	}{
+		NS:      s3Namespace,
+		Owner:   ownerOf(req.user),
+		Buckets: []bucket{{Name: req.user.Username, CreationDate: time.Unix(0, 0).UTC().Format(s3TimeFormat)}},
+	})
+}
+
+type s3Owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+func ownerOf(u *models.User) s3Owner {
+	return s3Owner{ID: strconv.FormatUint(uint64(u.ID), 10), DisplayName: u.Username}
+}
+
+type s3Object struct {
+	Key          string   `xml:"Key"`
+	LastModified string   `xml:"LastModified"`
+	ETag         string   `xml:"ETag"`
+	Size         int64    `xml:"Size"`
+	StorageClass string   `xml:"StorageClass"`
+	Owner        *s3Owner `xml:"Owner,omitempty"`
+}
+
+type s3Prefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type s3ListResult struct {
+	XMLName               xml.Name   `xml:"ListBucketResult"`
+	NS                    string     `xml:"xmlns,attr"`
+	Name                  string     `xml:"Name"`
+	Prefix                string     `xml:"Prefix"`
+	Delimiter             string     `xml:"Delimiter,omitempty"`
+	EncodingType          string     `xml:"EncodingType,omitempty"`
+	MaxKeys               int        `xml:"MaxKeys"`
+	IsTruncated           bool       `xml:"IsTruncated"`
+	Marker                *string    `xml:"Marker,omitempty"`
+	NextMarker            string     `xml:"NextMarker,omitempty"`
+	KeyCount              *int       `xml:"KeyCount,omitempty"`
+	StartAfter            string     `xml:"StartAfter,omitempty"`
+	ContinuationToken     string     `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string     `xml:"NextContinuationToken,omitempty"`
+	Contents              []s3Object `xml:"Contents"`
+	CommonPrefixes        []s3Prefix `xml:"CommonPrefixes"`
+}
+
+// listObjects answers ListObjectsV2 when v2 is set and ListObjects (v1)
+// otherwise. V2 continuation tokens are opaque to clients; here they are
+// the last key returned, base64url-encoded.
+func (h *S3Handler) listObjects(c *gin.Context, req *s3Request, v2 bool) {
+	q := c.Request.URL.Query()
+	query := service.ObjectQuery{Prefix: q.Get("prefix"), Delimiter: q.Get("delimiter")}
+
+	maxKeys := 1000
+	if v := q.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeS3Error(c, errS3InvalidArgument)
+			return
+		}
+		maxKeys = n
+	}
+	query.MaxKeys = maxKeys
+	encodingType := q.Get("encoding-type")
+	if encodingType != "" && encodingType != "url" {
+		writeS3Error(c, errS3InvalidArgument)
+		return
+	}
+	encode := func(s string) string {
+		if encodingType == "url" {
+			return url.QueryEscape(s)
+		}
+		return s
+	}
+
+	res := s3ListResult{
+		NS:           s3Namespace,
+		Name:         req.user.Username,
+		Prefix:       encode(query.Prefix),
+		Delimiter:    encode(query.Delimiter),
+		EncodingType: encodingType,
+		MaxKeys:      maxKeys,
+	}
+	if v2 {
+		res.ContinuationToken = q.Get("continuation-token")
+		res.StartAfter = encode(q.Get("start-after"))
+		query.After = q.Get("start-after")
+		if res.ContinuationToken != "" {
+			after, err := base64.RawURLEncoding.DecodeString(res.ContinuationToken)
+			if err != nil {
+				writeS3Error(c, &s3Error{http.StatusBadRequest, "InvalidArgument", "The continuation token provided is incorrect"})
+				return
+			}
+			query.After = string(after)
+		}
+	} else {
+		marker := q.Get("marker")
+		query.After = marker
+		marker = encode(marker)
+		res.Marker = &marker
+	}
+
+	listing := &service.ObjectListing{}
+	if maxKeys > 0 {
+		var err error
+		listing, err = h.files.ListObjects(req.user.ID, query)
+		if err != nil {
+			writeS3Error(c, s3ErrorFor(err))
+			return
+		}
+	}
+
+	var owner *s3Owner
+	if !v2 || q.Get("fetch-owner") == "true" {
+		o := ownerOf(req.user)
+		owner = &o
+	}
+	for _, obj := range listing.Objects {
+		res.Contents = append(res.Contents, s3Object{
+			Key:          encode(obj.Key),
+			LastModified: obj.ModTime.UTC().Format(s3TimeFormat),
+			ETag:         s3ETag(obj.Hash),
+			Size:         obj.Size,
+			StorageClass: s3StorageClass,
+			Owner:        owner,
+		})
+	}
+	for _, p := range listing.CommonPrefixes {
+		res.CommonPrefixes = append(res.CommonPrefixes, s3Prefix{Prefix: encode(p)})
+	}
+	res.IsTruncated = listing.IsTruncated
+	if v2 {
+		count := len(res.Contents) + len(res.CommonPrefixes)
+		res.KeyCount = &count
+		if listing.IsTruncated {
+			res.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(listing.NextAfter))
+		}
+	} else if listing.IsTruncated {
+		res.NextMarker = encode(listing.NextAfter)
+	}
+	writeS3XML(c, http.StatusOK, res)
+}
+
+func (h *S3Handler) getObject(c *gin.Context, req *s3Request, key string) {
+	f, e, err := h.files.OpenObject(req.user.ID, key)
+	if err != nil {
+		writeS3Error(c, s3ErrorFor(err))
+		return
+	}
+	auditTarget(c, e.UserFileID, e.FileID)
+
+	var content io.ReadSeeker = bytes.NewReader(nil)
+	contentType := s3DirContentType
+	if f != nil {
+		defer f.Close()
+		content = f
+		contentType = e.MimeType
+	}
+	c.Header("Content-Type", contentType)
+	isolateContent(c.Writer.Header())
+	if e.Hash != "" {
+		c.Header("ETag", s3ETag(e.Hash))
+	}
+	c.Header("Accept-Ranges", "bytes")
+	http.ServeContent(c.Writer, c.Request, "", e.ModTime, content)
+}
+
+// isolateContent keeps a browser from running user content served from
+// the API's origin, where it could act with the visitor's session cookie:
+// the content type is taken as given and any document is sandboxed.
+func isolateContent(h http.Header) {
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Security-Policy", "sandbox")
+}
+
+func (h *S3Handler) putObject(c *gin.Context, req *s3Request, key string) {
+	e, err := h.files.PutObject(req.user.ID, key, req.body)
+	if err != nil {
+		writeS3BodyError(c, req, err)
+		return
+	}
+	auditTarget(c, e.UserFileID, e.FileID)
+	if e.Hash != "" {
+		c.Header("ETag", s3ETag(e.Hash))
+	}
+	c.Status(http.StatusOK)
+}
+
+func (h *S3Handler) deleteObjects(c *gin.Context, req *s3Request) {
+	var body struct {
+		Quiet   bool `xml:"Quiet"`
+		Objects []struct {
+			Key string `xml:"Key"`
+		} `xml:"Object"`
+	}
+	if !readS3XML(c, req, &body) {
+		return
+	}
+	if len(body.Objects) == 0 || len(body.Objects) > maxDeleteObjects {
+		writeS3Error(c, errS3MalformedXML)
+		return
+	}
+
+	type deleted struct {
+		Key string `xml:"Key"`
+	}
+	type failed struct {
+		Key     string `xml:"Key"`
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	res := struct {
+		XMLName xml.Name  `xml:"DeleteResult"`
+		NS      string    `xml:"xmlns,attr"`
+		Deleted []deleted `xml:"Deleted"`
+		Errors  []failed  `xml:"Error"`
+	}{NS: s3Namespace}
+
+	keys := make([]string, 0, len(body.Objects))
+	for _, obj := range body.Objects {
+		keys = append(keys, obj.Key)
+		if err := h.files.DeleteObject(req.user.ID, obj.Key); err != nil {
+			e := s3ErrorFor(err)
+			res.Errors = append(res.Errors, failed{Key: obj.Key, Code: e.code, Message: e.message})
+			continue
+		}
+		if !body.Quiet {
+			res.Deleted = append(res.Deleted, deleted{Key: obj.Key})
+		}
+	}
+	auditDetail(c, gin.H{"bucket": req.user.Username, "keys": keys})
+	writeS3XML(c, http.StatusOK, res)
+}
+
+func (h *S3Handler) createMultipartUpload(c *gin.Context, req *s3Request, key string) {
+	u, err := h.multipart.Create(req.user.ID, key)
+	if err != nil {
+		writeS3Error(c, s3ErrorFor(err))
+		return
+	}
+	writeS3XML(c, http.StatusOK, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		NS       string   `xml:"xmlns,attr"`
+		Bucket   string   `xml:"Bucket"`
+		Key      string   `xml:"Key"`
+		UploadID string   `xml:"UploadId"`
+	}{NS: s3Namespace, Bucket: req.user.Username, Key: u.Key, UploadID: u.ID})
+}
+
+func (h *S3Handler) uploadPart(c *gin.Context, req *s3Request, key, uploadID string) {
+	partNumber, err := strconv.Atoi(c.Query("partNumber"))
+	if err != nil {
+		writeS3Error(c, errS3InvalidArgument)
+		return
+	}
+	etag, err := h.multipart.UploadPart(req.user.ID, uploadID, key, partNumber, req.body)
+	if err != nil {
+		writeS3BodyError(c, req, err)
+		return
+	}
+	c.Header("ETag", s3ETag(etag))
+	c.Status(http.StatusOK)
+}
+
+func (h *S3Handler) completeMultipartUpload(c *gin.Context, req *s3Request, key, uploadID string) {
+	var body struct {
+		Parts []struct {
+			PartNumber int    `xml:"PartNumber"`
+			ETag       string `xml:"ETag"`
+		} `xml:"Part"`
+	}
+	if !readS3XML(c, req, &body) {
+		return
+	}
+	parts := make([]service.CompletedPart, len(body.Parts))
+	for i, p := range body.Parts {
+		parts[i] = service.CompletedPart{PartNumber: p.PartNumber, ETag: p.ETag}
+	}
+
+	e, err := h.multipart.Complete(req.user.ID, uploadID, key, parts)
+	if err != nil {
+		writeS3Error(c, s3ErrorFor(err))
+		return
+	}
+	auditTarget(c, e.UserFileID, e.FileID)
+	writeS3XML(c, http.StatusOK, struct {
+		XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+		NS       string   `xml:"xmlns,attr"`
+		Location string   `xml:"Location"`
+		Bucket   string   `xml:"Bucket"`
+		Key      string   `xml:"Key"`
+		ETag     string   `xml:"ETag"`
+	}{
+		NS:       s3Namespace,
+		Location: "/" + req.user.Username + "/" + key,
+		Bucket:   req.user.Username,
+		Key:      key,
+		ETag:     s3ETag(e.Hash),
+	})
+}
+
+// readS3XML decodes a small XML request body into v, writing the error
+// response if it cannot.
+func readS3XML(c *gin.Context, req *s3Request, v interface{}) bool {
+	raw, err := io.ReadAll(io.LimitReader(req.body, maxS3XMLBody+1))
+	switch {
+	case req.bodyErr != nil:
+		writeS3Error(c, req.bodyErr)
+		return false
+	case err != nil:
+		writeS3Error(c, errS3IncompleteBody)
+		return false
+	case len(raw) > maxS3XMLBody:
+		writeS3Error(c, errS3MalformedXML)
+		return false
+	}
+	if err := xml.Unmarshal(raw, v); err != nil {
+		writeS3Error(c, errS3MalformedXML)
+		return false
+	}
+	return true
+}
+
+// writeS3BodyError reports a failed upload, preferring the reason the
+// request body was rejected, if it was.
+func writeS3BodyError(c *gin.Context, req *s3Request, err error) {
+	if req.bodyErr != nil {
+		writeS3Error(c, req.bodyErr)
+		return
+	}
+	writeS3Error(c, s3ErrorFor(err))
+}
+
+func s3ErrorFor(err error) *s3Error {
+	switch {
+	case errors.Is(err, service.ErrPathNotFound):
+		return errS3NoSuchKey
+	case errors.Is(err, service.ErrNoSuchUpload):
+		return errS3NoSuchUpload
+	case errors.Is(err, service.ErrInvalidPart):
+		return errS3InvalidPart
+	case errors.Is(err, service.ErrInvalidPartOrder):
+		return errS3InvalidPartOrder
+	case errors.Is(err, service.ErrInvalidPartNumber):
+		return &s3Error{http.StatusBadRequest, "InvalidArgument", "Part number must be an integer between 1 and 10000, inclusive"}
+	case errors.Is(err, service.ErrInvalidPath):
+		return errS3InvalidKey
+	case errors.Is(err, service.ErrQuotaExceeded):
+		return errS3QuotaExceeded
+	case errors.Is(err, service.ErrFileTooLarge):
+		return errS3EntityTooLarge
+	case errors.Is(err, service.ErrTypeNotAllowed), errors.Is(err, service.ErrMimeMismatch):
+		return errS3TypeNotAllowed
+	case errors.Is(err, service.ErrUploadRead):
+		return errS3IncompleteBody
+	case errors.Is(err, repository.ErrFolderExists):
+		return errS3MethodNotAllowed
+	}
+	return errS3Internal
+}
+
+func writeS3Error(c *gin.Context, e *s3Error) {
+	if c.Request.Method == http.MethodHead {
+		c.AbortWithStatus(e.status)
+		return
+	}
+	writeS3XML(c, e.status, struct {
+		XMLName   xml.Name `xml:"Error"`
+		Code      string   `xml:"Code"`
+		Message   string   `xml:"Message"`
+		Resource  string   `xml:"Resource"`
+		RequestID string   `xml:"RequestId"`
+	}{
+		Code:      e.code,
+		Message:   e.message,
+		Resource:  c.Request.URL.Path,
+		RequestID: c.Writer.Header().Get("x-amz-request-id"),
+	})
+	c.Abort()
+}
+
+func writeS3XML(c *gin.Context, status int, v interface{}) {
+	out, err := xml.Marshal(v)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Data(status, "application/xml", append([]byte(xml.Header), out...))
+}
+
+// s3ETag quotes a content hash for use as an ETag. Objects are tagged with
+// their SHA-256, not the MD5 S3 itself uses, since that is what the vault
+// stores.
+func s3ETag(hash string) string {
+	return `"` + hash + `"`
+}
+
+func newS3RequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return strings.ToUpper(hex.EncodeToString(b))
+}
+
+// s3AuditAction names the audit log action for requests that change data
+// or read an object, and "" for the rest.
+func s3AuditAction(r *http.Request, bucket, key string) string {
+	if bucket == "" {
+		return ""
+	}
+	q := r.URL.Query()
+	if key == "" {
+		if r.Method == http.MethodPost && q.Has("delete") {
+			return "s3.delete_objects"
+		}
+		return ""
+	}
+	switch r.Method {
+	case http.MethodGet:
+		if !q.Has("uploadId") {
+			return "s3.get_object"
+		}
+	case http.MethodPut:
+		if !q.Has("uploadId") {
+			return "s3.put_object"
+		}
+	case http.MethodDelete:
+		if q.Has("uploadId") {
+			return "s3.abort_multipart_upload"
+		}
+		return "s3.delete_object"
+	case http.MethodPost:
+		if q.Has("uploadId") {
+			return "s3.complete_multipart_upload"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,420 @@
+package api
+
+import (
+	"backend/internal/models"
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	amzDateFormat   = "20060102T150405Z"
+	maxClockSkew    = 15 * time.Minute
+	maxPresignAge   = 7 * 24 * time.Hour
+	accessKeyTouch  = time.Minute
+	maxChunkSize    = 16 << 20
+	maxChunkLineLen = 4096
+
+	unsignedPayload          = "UNSIGNED-PAYLOAD"
+	streamingPayload         = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingPayloadTrailer  = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	streamingUnsignedTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+)
+
+var emptySHA256 = sha256Hex(nil)
+
+// sigV4 is the signature a request carries, from its Authorization header
+// or, for presigned URLs, its query string.
+type sigV4 struct {
+	accessKeyID   string
+	date          string // YYYYMMDD of the credential scope
+	region        string
+	service       string
+	signedHeaders []string
+	signature     string
+	amzDate       string
+	payloadHash   string
+	presigned     bool
+}
+
+func (s *sigV4) scope() string {
+	return s.date + "/" + s.region + "/" + s.service + "/aws4_request"
+}
+
+// s3Request is an authenticated S3 request. Body reads the payload,
+// checking it against the signature as it goes; a failed check is left in
+// bodyErr for the handler to report.
+type s3Request struct {
+	user    *models.User
+	sig     *sigV4
+	key     []byte // signing key
+	body    io.Reader
+	bodyErr *s3Error
+}
+
+func (h *S3Handler) authenticate(r *http.Request) (*s3Request, *s3Error) {
+	sig, e := parseSigV4(r)
+	if e != nil {
+		return nil, e
+	}
+
+	now := time.Now()
+	if e := checkSigTime(r, sig, now); e != nil {
+		return nil, e
+	}
+
+	key, user, err := h.accessKeys.Lookup(sig.accessKeyID)
+	if err != nil {
+		return nil, errS3InvalidAccessKey
+	}
+	signingKey := deriveSigningKey(key.Secret, sig)
+	expected := hex.EncodeToString(hmacSHA256(signingKey, stringToSign(sig, canonicalRequest(r, sig))))
+	if !hmac.Equal([]byte(expected), []byte(sig.signature)) {
+		return nil, errS3SignatureMismatch
+	}
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > accessKeyTouch {
+		h.accessKeys.Touch(key)
+	}
+
+	req := &s3Request{user: user, sig: sig, key: signingKey}
+	switch sig.payloadHash {
+	case unsignedPayload:
+		req.body = r.Body
+	case streamingPayload, streamingPayloadTrailer:
+		req.body = &chunkedReader{req: req, r: bufio.NewReader(r.Body), prevSig: sig.signature, signed: true}
+	case streamingUnsignedTrailer:
+		req.body = &chunkedReader{req: req, r: bufio.NewReader(r.Body)}
+	default:
+		if _, err := hex.DecodeString(sig.payloadHash); err != nil || len(sig.payloadHash) != sha256.Size*2 {
+			return nil, errS3InvalidDigest
+		}
+		req.body = &hashCheckReader{req: req, r: r.Body, h: sha256.New(), want: sig.payloadHash}
+	}
+	return req, nil
+}
+
+// checkSigTime reports whether a request signed at sig.amzDate may still
+// be accepted at now: within the clock skew allowed either way or, for a
+// presigned URL, before it expires.
+func checkSigTime(r *http.Request, sig *sigV4, now time.Time) *s3Error {
+	t, err := time.Parse(amzDateFormat, sig.amzDate)
+	if err != nil || !strings.HasPrefix(sig.amzDate, sig.date) {
+		return errS3MalformedAuth
+	}
+	if sig.presigned {
+		expires, err := strconv.Atoi(r.URL.Query().Get("X-Amz-Expires"))
+		if err != nil || expires < 1 || time.Duration(expires)*time.Second > maxPresignAge {
+			return errS3MalformedAuth
+		}
+		if now.After(t.Add(time.Duration(expires) * time.Second)) {
+			return errS3ExpiredRequest
+		}
+		if t.After(now.Add(maxClockSkew)) {
+			return errS3TimeSkewed
+		}
+	} else if t.Before(now.Add(-maxClockSkew)) || t.After(now.Add(maxClockSkew)) {
+		return errS3TimeSkewed
+	}
+	return nil
+}
+
+func parseSigV4(r *http.Request) (*sigV4, *s3Error) {
+	q := r.URL.Query()
+	var credential, signedHeaders string
+	sig := &sigV4{}
+
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		rest, ok := strings.CutPrefix(auth, sigV4Algorithm+" ")
+		if !ok {
+			return nil, errS3UnsupportedAuth
+		}
+		for _, part := range strings.Split(rest, ",") {
+			k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch k {
+			case "Credential":
+				credential = v
+			case "SignedHeaders":
+				signedHeaders = v
+			case "Signature":
+				sig.signature = v
+			}
+		}
+		sig.amzDate = r.Header.Get("X-Amz-Date")
+		sig.payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+		if sig.payloadHash == "" {
+			return nil, errS3MissingContentSHA
+		}
+	} else if alg := q.Get("X-Amz-Algorithm"); alg != "" {
+		if alg != sigV4Algorithm {
+			return nil, errS3UnsupportedAuth
+		}
+		credential = q.Get("X-Amz-Credential")
+		signedHeaders = q.Get("X-Amz-SignedHeaders")
+		sig.signature = q.Get("X-Amz-Signature")
+		sig.amzDate = q.Get("X-Amz-Date")
+		sig.payloadHash = unsignedPayload
+		sig.presigned = true
+	} else {
+		return nil, errS3AccessDenied
+	}
+
+	// Credential is <key>/<date>/<region>/<service>/aws4_request
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[4] != "aws4_request" || parts[3] != "s3" {
+		return nil, errS3MalformedAuth
+	}
+	sig.accessKeyID, sig.date, sig.region, sig.service = parts[0], parts[1], parts[2], parts[3]
+	if signedHeaders == "" || sig.signature == "" || sig.amzDate == "" {
+		return nil, errS3MalformedAuth
+	}
+	sig.signedHeaders = strings.Split(signedHeaders, ";")
+	if !sort.StringsAreSorted(sig.signedHeaders) || !containsString(sig.signedHeaders, "host") {
+		return nil, errS3MalformedAuth
+	}
+	return sig, nil
+}
+
+// canonicalRequest builds the canonical form of r that SigV4 signs.
+func canonicalRequest(r *http.Request, sig *sigV4) string {
+	var b strings.Builder
+	b.WriteString(r.Method + "\n")
+
+	path := r.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	b.WriteString(awsURIEncode(path, false) + "\n")
+
+	q := r.URL.Query()
+	if sig.presigned {
+		q.Del("X-Amz-Signature")
+	}
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		values := append([]string(nil), q[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, awsURIEncode(k, true)+"="+awsURIEncode(v, true))
+		}
+	}
+	b.WriteString(strings.Join(pairs, "&") + "\n")
+
+	for _, name := range sig.signedHeaders {
+		b.WriteString(name + ":" + canonicalHeaderValue(r, name) + "\n")
+	}
+	b.WriteString("\n" + strings.Join(sig.signedHeaders, ";") + "\n")
+	b.WriteString(sig.payloadHash)
+	return b.String()
+}
+
+func canonicalHeaderValue(r *http.Request, name string) string {
+	var values []string
+	switch name {
+	case "host":
+		values = []string{r.Host}
+	case "content-length":
+		values = r.Header.Values("Content-Length")
+		if len(values) == 0 && r.ContentLength >= 0 {
+			values = []string{strconv.FormatInt(r.ContentLength, 10)}
+		}
+	case "transfer-encoding":
+		values = r.TransferEncoding
+	default:
+		values = r.Header.Values(name)
+	}
+	for i, v := range values {
+		values[i] = strings.Join(strings.Fields(v), " ")
+	}
+	return strings.Join(values, ",")
+}
+
+func stringToSign(sig *sigV4, canonical string) string {
+	return sigV4Algorithm + "\n" + sig.amzDate + "\n" + sig.scope() + "\n" + sha256Hex([]byte(canonical))
+}
+
+func deriveSigningKey(secret string, sig *sigV4) []byte {
+	k := hmacSHA256([]byte("AWS4"+secret), sig.date)
+	k = hmacSHA256(k, sig.region)
+	k = hmacSHA256(k, sig.service)
+	return hmacSHA256(k, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// awsURIEncode percent-encodes everything but RFC 3986 unreserved
+// characters, and "/" unless encodeSlash is set, as SigV4 requires.
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// hashCheckReader fails the read that reaches the end of the body if the
+// body does not match the SHA-256 it was signed with.
+type hashCheckReader struct {
+	req  *s3Request
+	r    io.Reader
+	h    hashWriter
+	want string
+}
+
+type hashWriter interface {
+	io.Writer
+	Sum([]byte) []byte
+}
+
+func (hr *hashCheckReader) Read(p []byte) (int, error) {
+	n, err := hr.r.Read(p)
+	hr.h.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(hr.h.Sum(nil)) != hr.want {
+		hr.req.bodyErr = errS3ContentSHAMismatch
+		return n, errors.New(errS3ContentSHAMismatch.message)
+	}
+	return n, err
+}
+
+// chunkedReader decodes an aws-chunked body, checking each chunk's
+// signature when the payload is signed. Trailing checksums are skipped.
+type chunkedReader struct {
+	req     *s3Request
+	r       *bufio.Reader
+	signed  bool
+	prevSig string
+
+	chunk []byte
+	off   int
+	done  bool
+}
+
+func (cr *chunkedReader) Read(p []byte) (int, error) {
+	for cr.off == len(cr.chunk) {
+		if cr.done {
+			return 0, io.EOF
+		}
+		if err := cr.next(); err != nil {
+			if cr.req.bodyErr == nil {
+				cr.req.bodyErr = errS3IncompleteBody
+			}
+			// A body cut short before its final chunk must not read as
+			// complete
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+	}
+	n := copy(p, cr.chunk[cr.off:])
+	cr.off += n
+	return n, nil
+}
+
+func (cr *chunkedReader) next() error {
+	line, err := readChunkLine(cr.r)
+	if err != nil {
+		return err
+	}
+	sizeHex, ext, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeHex), 16, 64)
+	if err != nil || size < 0 || size > maxChunkSize {
+		return errors.New("malformed chunk header")
+	}
+
+	if int64(cap(cr.chunk)) < size {
+		cr.chunk = make([]byte, size)
+	}
+	cr.chunk, cr.off = cr.chunk[:size], 0
+	if _, err := io.ReadFull(cr.r, cr.chunk); err != nil {
+		return err
+	}
+
+	if cr.signed {
+		got, _ := strings.CutPrefix(strings.TrimSpace(ext), "chunk-signature=")
+		toSign := "AWS4-HMAC-SHA256-PAYLOAD\n" + cr.req.sig.amzDate + "\n" + cr.req.sig.scope() + "\n" +
+			cr.prevSig + "\n" + emptySHA256 + "\n" + sha256Hex(cr.chunk)
+		want := hex.EncodeToString(hmacSHA256(cr.req.key, toSign))
+		if !hmac.Equal([]byte(got), []byte(want)) {
+			cr.req.bodyErr = errS3SignatureMismatch
+			return errors.New("chunk signature mismatch")
+		}
+		cr.prevSig = want
+	}
+
+	if size > 0 {
+		if crlf, err := readChunkLine(cr.r); err != nil || crlf != "" {
+			return errors.New("malformed chunk")
+		}
+		return nil
+	}
+
+	// The last chunk is followed by optional trailers and a blank line
+	cr.done = true
+	for {
+		line, err := readChunkLine(cr.r)
+		if err == io.EOF || (err == nil && line == "") {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func readChunkLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		part, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		line = append(line, part...)
+		if len(line) > maxChunkLineLen {
+			return "", errors.New("chunk line too long")
+		}
+		if !isPrefix {
+			return string(bytes.TrimSuffix(line, []byte("\r"))), nil
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"backend/internal/service"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SearchHandler struct {
+	searchService *service.SearchService
+}
+
+func NewSearchHandler(ss *service.SearchService) *SearchHandler {
+	return &SearchHandler{searchService: ss}
+}
+
+// Search runs a full-text query over the contents of the caller's files.
+func (h *SearchHandler) Search(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+
+	hits, total, err := h.searchService.Search(userID, c.Query("q"), limit, offset)
+	if err != nil {
+		if errors.Is(err, service.ErrEmptyQuery) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing search query"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "search failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": hits, "total": total})
+}
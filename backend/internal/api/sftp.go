@@ -0,0 +1,455 @@
+package api
+
+import (
+	"backend/internal/metrics"
+	"backend/internal/models"
+	"backend/internal/service"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	sftpHandshakeTimeout = 30 * time.Second
+	sftpMaxAuthTries     = 6
+	sftpDrainPoll        = 100 * time.Millisecond
+
+	// sftpRecheckInterval is how often an open session checks that its
+	// sign-in still holds, so disabling the user or revoking the password,
+	// token or key used ends the session within this time.
+	sftpRecheckInterval = 30 * time.Second
+
+	// Permission extensions carrying the signed-in user from the
+	// authentication callbacks to the session
+	sftpUserIDExt   = "vault-user-id"
+	sftpUsernameExt = "vault-username"
+	sftpKeyIDExt    = "vault-ssh-key-id"
+)
+
+// SFTPServer serves each user's files over SFTP. Users sign in with their
+// password, a personal token or a registered SSH key, and see the same
+// folders and files as over WebDAV. Every change goes through FileService,
+// and each operation is recorded in the audit log.
+type SFTPServer struct {
+	files   *service.FileService
+	tokens  *service.TokenService
+	sshKeys *service.SSHKeyService
+	audit   *service.AuditService
+	config  *ssh.ServerConfig
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	transfers int // files open for reading or writing
+	draining  bool
+
+	// grants holds password and token sign-ins by SSH session ID until
+	// the session claims them
+	grants map[string]*service.Grant
+}
+
+// ErrSFTPServerClosed is returned by Serve once Shutdown has been called.
+var ErrSFTPServerClosed = errors.New("sftp: server closed")
+
+var errShuttingDown = errors.New("server is shutting down")
+
+func NewSFTPServer(fs *service.FileService, ts *service.TokenService, ks *service.SSHKeyService, as *service.AuditService, hostKey ssh.Signer) *SFTPServer {
+	s := &SFTPServer{
+		files: fs, tokens: ts, sshKeys: ks, audit: as,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+		grants:    make(map[string]*service.Grant),
+	}
+	s.config = &ssh.ServerConfig{
+		MaxAuthTries:      sftpMaxAuthTries,
+		PasswordCallback:  s.checkPassword,
+		PublicKeyCallback: s.checkPublicKey,
+		ServerVersion:     "SSH-2.0-FileVault",
+	}
+	s.config.AddHostKey(hostKey)
+	return s
+}
+
+// LoadHostKey reads the server's private host key from path, creating an
+// Ed25519 key there on first start. Clients pin the host key, so it must
+// persist across restarts.
+func LoadHostKey(path string) (ssh.Signer, error) {
+	raw, err := os.ReadFile(path)
+	if err == nil {
+		return ssh.ParsePrivateKey(raw)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		return nil, err
+	}
+	log.Printf("sftp: generated host key %s", path)
+	return ssh.NewSignerFromKey(priv)
+}
+
+func (s *SFTPServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until it is closed.
+func (s *SFTPServer) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.draining {
+		s.mu.Unlock()
+		l.Close()
+		return ErrSFTPServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			if s.isDraining() {
+				return ErrSFTPServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go s.handleConn(nc)
+	}
+}
+
+// Shutdown stops accepting connections and new transfers, waits for the
+// files open for reading or writing to be closed, then closes every
+// connection. If ctx ends first, the connections are closed anyway, which
+// discards the unfinished uploads, and ctx's error is returned.
+func (s *SFTPServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.draining = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(sftpDrainPoll)
+	defer ticker.Stop()
+	var err error
+	for err == nil && s.openTransfers() > 0 {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	s.mu.Lock()
+	for nc := range s.conns {
+		nc.Close()
+	}
+	s.mu.Unlock()
+	return err
+}
+
+func (s *SFTPServer) isDraining() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.draining
+}
+
+func (s *SFTPServer) openTransfers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.transfers
+}
+
+// startTransfer counts a file being opened, failing once the server is
+// shutting down. Each successful call is paired with endTransfer.
+func (s *SFTPServer) startTransfer() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.draining {
+		return errShuttingDown
+	}
+	s.transfers++
+	return nil
+}
+
+func (s *SFTPServer) endTransfer() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.transfers--
+}
+
+// track registers a connection so Shutdown can close it, returning false
+// if the server is already shutting down.
+func (s *SFTPServer) track(nc net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.draining {
+		return false
+	}
+	s.conns[nc] = struct{}{}
+	return true
+}
+
+func (s *SFTPServer) untrack(nc net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, nc)
+}
+
+func (s *SFTPServer) checkPassword(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	grant, err := s.tokens.Authenticate(meta.User(), string(password))
+	if err != nil {
+		metrics.LoginFailed("sftp")
+		s.record(meta, 0, meta.User(), "sftp.login", http.StatusUnauthorized, nil, map[string]string{"method": "password"})
+		return nil, err
+	}
+	s.mu.Lock()
+	s.grants[string(meta.SessionID())] = grant
+	s.mu.Unlock()
+	return sftpPermissions(grant.UserID, grant.Username, ""), nil
+}
+
+// takeGrant returns and forgets the password or token sign-in of session.
+func (s *SFTPServer) takeGrant(session []byte) *service.Grant {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g := s.grants[string(session)]
+	delete(s.grants, string(session))
+	return g
+}
+
+func (s *SFTPServer) checkPublicKey(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	k, user, err := s.sshKeys.Lookup(meta.User(), key)
+	if err != nil {
+		return nil, err
+	}
+	return sftpPermissions(user.ID, user.Username, strconv.FormatUint(uint64(k.ID), 10)), nil
+}
+
+func sftpPermissions(userID uint, username, keyID string) *ssh.Permissions {
+	ext := map[string]string{
+		sftpUserIDExt:   strconv.FormatUint(uint64(userID), 10),
+		sftpUsernameExt: username,
+	}
+	if keyID != "" {
+		ext[sftpKeyIDExt] = keyID
+	}
+	return &ssh.Permissions{Extensions: ext}
+}
+
+func (s *SFTPServer) handleConn(nc net.Conn) {
+	defer nc.Close()
+	if !s.track(nc) {
+		return
+	}
+	defer s.untrack(nc)
+
+	nc.SetDeadline(time.Now().Add(sftpHandshakeTimeout))
+	conn, chans, reqs, err := ssh.NewServerConn(nc, s.config)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	nc.SetDeadline(time.Time{})
+	go ssh.DiscardRequests(reqs)
+
+	id, _ := strconv.ParseUint(conn.Permissions.Extensions[sftpUserIDExt], 10, 64)
+	sess := &sftpSession{
+		server:   s,
+		meta:     conn,
+		userID:   uint(id),
+		username: conn.Permissions.Extensions[sftpUsernameExt],
+	}
+	detail := map[string]string{"method": "password"}
+	grant := s.takeGrant(conn.SessionID())
+	sess.recheck = func() bool { return grant != nil && s.tokens.Recheck(grant) }
+	if keyID, ok := conn.Permissions.Extensions[sftpKeyIDExt]; ok {
+		id, _ := strconv.ParseUint(keyID, 10, 64)
+		s.sshKeys.Touch(uint(id))
+		sess.recheck = func() bool { return s.sshKeys.Recheck(uint(id), sess.userID) }
+		detail = map[string]string{"method": "publickey", "ssh_key_id": keyID}
+	}
+	sess.record("sftp.login", nil, nil, detail)
+
+	done := make(chan struct{})
+	defer close(done)
+	go sess.watch(conn, done)
+
+	for newCh := range chans {
+		if newCh.ChannelType() != "session" {
+			newCh.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+		ch, chReqs, err := newCh.Accept()
+		if err != nil {
+			continue
+		}
+		go sess.serveChannel(ch, chReqs)
+	}
+}
+
+// sftpSession is a signed-in connection. Its channels may each run the
+// sftp subsystem; shells and commands are refused.
+type sftpSession struct {
+	server   *SFTPServer
+	meta     ssh.ConnMetadata
+	userID   uint
+	username string
+	recheck  func() bool // whether the sign-in still holds
+}
+
+// watch closes conn once the session's sign-in no longer holds, until
+// done is closed.
+func (sess *sftpSession) watch(conn ssh.Conn, done <-chan struct{}) {
+	t := time.NewTicker(sftpRecheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			if !sess.recheck() {
+				log.Printf("sftp: closing session for %s: sign-in revoked", sess.username)
+				conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func (sess *sftpSession) serveChannel(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+	for req := range reqs {
+		if req.Type != "subsystem" || subsystemName(req.Payload) != "sftp" {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+		go ssh.DiscardRequests(reqs)
+
+		srv := sess.requestServer(ch)
+		if err := srv.Serve(); err != nil && !errors.Is(err, io.EOF) {
+			log.Printf("sftp: session for %s ended: %v", sess.username, err)
+		}
+		srv.Close()
+		return
+	}
+}
+
+// requestServer serves the SFTP protocol on rwc for this session.
+func (sess *sftpSession) requestServer(rwc io.ReadWriteCloser) *sftp.RequestServer {
+	h := &sftpHandlers{sess: sess}
+	return sftp.NewRequestServer(rwc, sftp.Handlers{FileGet: h, FilePut: h, FileCmd: h, FileList: h},
+		sftp.WithStartDirectory("/"))
+}
+
+// subsystemName decodes the name in a subsystem request, an SSH string.
+func subsystemName(payload []byte) string {
+	if len(payload) < 4 {
+		return ""
+	}
+	n := binary.BigEndian.Uint32(payload)
+	if uint64(n) != uint64(len(payload)-4) {
+		return ""
+	}
+	return string(payload[4:])
+}
+
+// record appends an audit entry for an operation in this session, with the
+// status an HTTP client would have seen for its outcome.
+func (sess *sftpSession) record(action string, err error, e *service.Entry, detail interface{}) {
+	sess.server.record(sess.meta, sess.userID, sess.username, action, sftpStatus(err), e, detail)
+}
+
+func (s *SFTPServer) record(meta ssh.ConnMetadata, actorID uint, actorName, action string, status int, e *service.Entry, detail interface{}) {
+	ev := &models.AuditEvent{
+		Action:    action,
+		IP:        remoteIP(meta.RemoteAddr()),
+		UserAgent: string(meta.ClientVersion()),
+		Status:    status,
+		Outcome:   auditOutcome(status),
+		ActorName: actorName,
+	}
+	if actorID != 0 {
+		ev.ActorID = &actorID
+	}
+	if e != nil && e.UserFileID != 0 {
+		ev.UserFileID, ev.FileID = &e.UserFileID, &e.FileID
+	}
+	if detail != nil {
+		if raw, err := json.Marshal(detail); err == nil {
+			ev.Detail = string(raw)
+		}
+	}
+	s.audit.Record(ev)
+}
+
+func remoteIP(addr net.Addr) string {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// sftpStatus is the HTTP status matching the outcome of an SFTP operation,
+// for the audit log.
+func sftpStatus(err error) int {
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.Is(err, service.ErrPathNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errIsDirectory), errors.Is(err, errNotDirectory), errors.Is(err, errDirNotEmpty):
+		return http.StatusConflict
+	case errors.Is(err, sftp.ErrSSHFxOpUnsupported):
+		return http.StatusNotImplemented
+	}
+	if status, ok := webdavStatus(err); ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,282 @@
+package api
+
+import (
+	"backend/internal/metrics"
+	"backend/internal/service"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+var (
+	errNotDirectory = errors.New("not a directory")
+	errDirNotEmpty  = errors.New("directory not empty")
+)
+
+// sftpHandlers carries out one SFTP session's requests with FileService.
+type sftpHandlers struct {
+	sess *sftpSession
+}
+
+// fail converts a FileService error to what the sftp package reports to
+// the client. Errors the user can act on keep their message; anything else
+// is logged and reported as a plain failure.
+func (h *sftpHandlers) fail(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, service.ErrPathNotFound), errors.Is(err, service.ErrParentNotFound):
+		return os.ErrNotExist
+	case errors.Is(err, service.ErrPathExists):
+		return os.ErrExist
+	case errors.Is(err, errIsDirectory), errors.Is(err, errNotDirectory), errors.Is(err, errDirNotEmpty),
+		errors.Is(err, sftp.ErrSSHFxOpUnsupported), errors.Is(err, errShuttingDown):
+		return err
+	}
+	if _, ok := webdavStatus(err); ok {
+		return err
+	}
+	log.Printf("sftp: %s: %v", h.sess.username, err)
+	return sftp.ErrSSHFxFailure
+}
+
+func (h *sftpHandlers) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	if err := h.sess.server.startTransfer(); err != nil {
+		return nil, err
+	}
+	f, e, err := h.sess.server.files.Open(h.sess.userID, r.Filepath)
+	if err == nil && e.IsDir {
+		err = errIsDirectory
+	}
+	h.sess.record("sftp.get", err, e, map[string]string{"path": r.Filepath})
+	if err != nil {
+		h.sess.server.endTransfer()
+		return nil, h.fail(err)
+	}
+	return &sftpDownload{File: f, server: h.sess.server, start: time.Now()}, nil
+}
+
+// sftpDownload is a file open for reading, counted as a transfer in
+// progress until the client closes it.
+type sftpDownload struct {
+	*os.File
+	server *SFTPServer
+	start  time.Time
+	sent   atomic.Int64
+	once   sync.Once
+}
+
+func (d *sftpDownload) ReadAt(p []byte, off int64) (int, error) {
+	n, err := d.File.ReadAt(p, off)
+	d.sent.Add(int64(n))
+	return n, err
+}
+
+func (d *sftpDownload) Close() error {
+	d.once.Do(func() {
+		d.server.endTransfer()
+		metrics.ObserveDownload("sftp", d.sent.Load(), time.Since(d.start))
+	})
+	return d.File.Close()
+}
+
+// Filewrite starts an upload. Content can only be replaced as a whole, so
+// opening an existing file to append or to overwrite part of it is not
+// supported.
+func (h *sftpHandlers) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	files, userID := h.sess.server.files, h.sess.userID
+	flags := r.Pflags()
+	if flags.Append {
+		return nil, sftp.ErrSSHFxOpUnsupported
+	}
+	if flags.Excl || !flags.Trunc {
+		_, err := files.Stat(userID, r.Filepath)
+		switch {
+		case err == nil && flags.Excl:
+			return nil, os.ErrExist
+		case err == nil:
+			return nil, sftp.ErrSSHFxOpUnsupported
+		case !errors.Is(err, service.ErrPathNotFound):
+			return nil, h.fail(err)
+		}
+	}
+
+	if err := h.sess.server.startTransfer(); err != nil {
+		return nil, err
+	}
+	spool, err := files.NewSpool(userID, r.Filepath)
+	if err != nil {
+		h.sess.server.endTransfer()
+		h.sess.record("sftp.put", err, nil, map[string]string{"path": r.Filepath})
+		return nil, h.fail(err)
+	}
+	return &sftpUpload{h: h, path: r.Filepath, spool: spool, start: time.Now()}, nil
+}
+
+func (h *sftpHandlers) Filecmd(r *sftp.Request) error {
+	files, userID := h.sess.server.files, h.sess.userID
+	detail := map[string]string{"path": r.Filepath}
+
+	var action string
+	var err error
+	switch r.Method {
+	case "Setstat":
+		// Permissions and times are not stored; accept them so clients
+		// that preserve attributes don't fail the transfer
+		return nil
+	case "Rename":
+		action = "sftp.rename"
+		detail["target"] = r.Target
+		err = files.MovePath(userID, r.Filepath, r.Target)
+	case "Mkdir":
+		action = "sftp.mkdir"
+		err = files.MakeFolder(userID, r.Filepath)
+	case "Rmdir":
+		action = "sftp.rmdir"
+		err = h.removeDir(r.Filepath)
+	case "Remove":
+		action = "sftp.remove"
+		var e *service.Entry
+		if e, err = files.Stat(userID, r.Filepath); err == nil && e.IsDir {
+			err = errIsDirectory
+		}
+		if err == nil {
+			err = files.RemovePath(userID, r.Filepath)
+		}
+	default:
+		return sftp.ErrSSHFxOpUnsupported
+	}
+	h.sess.record(action, err, nil, detail)
+	return h.fail(err)
+}
+
+// removeDir deletes an empty folder. SFTP's rmdir never removes contents.
+func (h *sftpHandlers) removeDir(p string) error {
+	files, userID := h.sess.server.files, h.sess.userID
+	e, err := files.Stat(userID, p)
+	if err != nil {
+		return err
+	}
+	if !e.IsDir {
+		return errNotDirectory
+	}
+	entries, err := files.ReadDir(userID, p)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return errDirNotEmpty
+	}
+	return files.RemovePath(userID, p)
+}
+
+func (h *sftpHandlers) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	files, userID := h.sess.server.files, h.sess.userID
+	switch r.Method {
+	case "List":
+		entries, err := files.ReadDir(userID, r.Filepath)
+		if err != nil {
+			return nil, h.fail(err)
+		}
+		list := make(sftpListing, len(entries))
+		for i := range entries {
+			list[i] = entryInfo{&entries[i]}
+		}
+		return list, nil
+	case "Stat":
+		e, err := files.Stat(userID, r.Filepath)
+		if err != nil {
+			return nil, h.fail(err)
+		}
+		return sftpListing{entryInfo{e}}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// sftpListing pages a directory listing out to the client.
+type sftpListing []os.FileInfo
+
+func (l sftpListing) ListAt(dst []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(dst, l[offset:])
+	if n < len(dst) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// sftpUpload is a file open for writing. Writes land in a spool and the
+// file is stored when the client closes it; if the connection drops first,
+// the partial upload is thrown away.
+type sftpUpload struct {
+	h     *sftpHandlers
+	path  string
+	spool *service.Spool
+	start time.Time
+
+	mu     sync.Mutex
+	size   int64 // highest offset written
+	failed error
+	closed bool
+}
+
+func (u *sftpUpload) WriteAt(p []byte, off int64) (int, error) {
+	n, err := u.spool.WriteAt(p, off)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.size = max(u.size, off+int64(n))
+	if err != nil {
+		if u.failed == nil {
+			u.failed = err
+		}
+		return n, u.h.fail(err)
+	}
+	return n, nil
+}
+
+// TransferError is called by the sftp package when the session ends with
+// the file still open.
+func (u *sftpUpload) TransferError(err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.failed == nil {
+		u.failed = err
+	}
+}
+
+func (u *sftpUpload) Close() error {
+	u.mu.Lock()
+	failed, closed, size := u.failed, u.closed, u.size
+	u.closed = true
+	u.mu.Unlock()
+	if closed {
+		return os.ErrClosed
+	}
+	defer u.h.sess.server.endTransfer()
+
+	detail := map[string]string{"path": u.path}
+	if failed != nil {
+		u.spool.Discard()
+		u.h.sess.record("sftp.put", failed, nil, detail)
+		return u.h.fail(failed)
+	}
+
+	uf, err := u.spool.Commit()
+	var e *service.Entry
+	if uf != nil {
+		e = &service.Entry{UserFileID: uf.ID, FileID: uf.FileID}
+	}
+	u.h.sess.record("sftp.put", err, e, detail)
+	if err == nil {
+		metrics.ObserveUpload("sftp", size, time.Since(u.start))
+	}
+	return u.h.fail(err)
+}
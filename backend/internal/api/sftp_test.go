@@ -0,0 +1,220 @@
+package api
+
+import (
+	"io"
+	"net"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"gorm.io/gorm"
+
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/internal/service"
+)
+
+// testConnMeta stands in for the SSH connection a session runs on.
+type testConnMeta struct{}
+
+func (testConnMeta) User() string          { return "alice" }
+func (testConnMeta) SessionID() []byte     { return []byte("session") }
+func (testConnMeta) ClientVersion() []byte { return []byte("SSH-2.0-Test") }
+func (testConnMeta) ServerVersion() []byte { return []byte("SSH-2.0-FileVault") }
+func (testConnMeta) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2222}
+}
+func (testConnMeta) LocalAddr() net.Addr { return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22} }
+
+// newSFTPClient starts a session for a new user and returns a client
+// connected to it over a pipe.
+func newSFTPClient(t *testing.T) (*sftp.Client, *gorm.DB, uint) {
+	t.Helper()
+	conn := openTestDB(t)
+	user := createTestUser(t, conn)
+	s := &SFTPServer{
+		files: newTestFileService(t, conn),
+		audit: service.NewAuditService(repository.NewAuditRepository(conn)),
+	}
+	sess := &sftpSession{server: s, meta: testConnMeta{}, userID: user.ID, username: user.Username}
+
+	toServer, fromClient := io.Pipe()
+	toClient, fromServer := io.Pipe()
+	srv := sess.requestServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{toServer, fromServer})
+	go srv.Serve()
+
+	client, err := sftp.NewClientPipe(toClient, fromClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		srv.Close()
+	})
+	return client, conn, user.ID
+}
+
+func putFile(t *testing.T, c *sftp.Client, p, content string) error {
+	t.Helper()
+	f, err := c.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write([]byte(content)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readFile(t *testing.T, c *sftp.Client, p string) string {
+	t.Helper()
+	f, err := c.Open(p)
+	if err != nil {
+		t.Fatalf("open %s: %v", p, err)
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("read %s: %v", p, err)
+	}
+	return string(b)
+}
+
+func listNames(t *testing.T, c *sftp.Client, p string) []string {
+	t.Helper()
+	infos, err := c.ReadDir(p)
+	if err != nil {
+		t.Fatalf("list %s: %v", p, err)
+	}
+	names := make([]string, len(infos))
+	for i, fi := range infos {
+		names[i] = fi.Name()
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestSFTPPut(t *testing.T) {
+	c, conn, userID := newSFTPClient(t)
+
+	if err := putFile(t, c, "/a.txt", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, c, "/a.txt"); got != "hello" {
+		t.Errorf("read %q", got)
+	}
+	checkStorage(t, conn, userID, 5, 5)
+
+	// Overwriting replaces the content as a whole
+	if err := putFile(t, c, "/a.txt", "bye"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, c, "/a.txt"); got != "bye" {
+		t.Errorf("read %q after overwrite", got)
+	}
+	checkStorage(t, conn, userID, 3, 3)
+
+	// Appending would need the earlier content spliced in
+	if _, err := c.OpenFile("/a.txt", os.O_WRONLY|os.O_APPEND); err == nil {
+		t.Error("append allowed")
+	}
+}
+
+func TestSFTPPutOverQuota(t *testing.T) {
+	c, conn, userID := newSFTPClient(t)
+	if err := conn.Model(&models.User{}).Where("id = ?", userID).Update("quota_bytes", 4).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := putFile(t, c, "/big.txt", "more than four bytes"); err == nil {
+		t.Error("upload over quota stored")
+	}
+	if _, err := c.Stat("/big.txt"); !os.IsNotExist(err) {
+		t.Errorf("stat after failed upload: %v", err)
+	}
+	checkStorage(t, conn, userID, 0, 0)
+}
+
+func TestSFTPMkdirAndRmdir(t *testing.T) {
+	c, _, _ := newSFTPClient(t)
+
+	if err := c.Mkdir("/docs"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Mkdir("/docs"); err == nil {
+		t.Error("made /docs twice")
+	}
+	if err := c.Mkdir("/missing/docs"); err == nil {
+		t.Error("made a folder in a missing one")
+	}
+	if err := putFile(t, c, "/docs/a.txt", "abc"); err != nil {
+		t.Fatal(err)
+	}
+
+	// rmdir never removes contents
+	if err := c.RemoveDirectory("/docs"); err == nil {
+		t.Error("removed a folder that is not empty")
+	}
+	if err := c.RemoveDirectory("/docs/a.txt"); err == nil {
+		t.Error("rmdir removed a file")
+	}
+	if err := c.Remove("/docs/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RemoveDirectory("/docs"); err != nil {
+		t.Fatal(err)
+	}
+	if names := listNames(t, c, "/"); len(names) != 0 {
+		t.Errorf("left %v", names)
+	}
+}
+
+func TestSFTPRemove(t *testing.T) {
+	c, conn, userID := newSFTPClient(t)
+
+	if err := c.Mkdir("/docs"); err != nil {
+		t.Fatal(err)
+	}
+	if err := putFile(t, c, "/docs/a.txt", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Remove("/docs"); err == nil {
+		t.Error("remove deleted a folder")
+	}
+	if err := c.Remove("/docs/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Remove("/docs/a.txt"); !os.IsNotExist(err) {
+		t.Errorf("second remove: %v", err)
+	}
+	checkStorage(t, conn, userID, 0, 0)
+}
+
+func TestSFTPRename(t *testing.T) {
+	c, conn, userID := newSFTPClient(t)
+
+	if err := c.Mkdir("/inbox"); err != nil {
+		t.Fatal(err)
+	}
+	if err := putFile(t, c, "/inbox/a.txt", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Rename("/inbox/a.txt", "/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Rename("/inbox", "/archive"); err != nil {
+		t.Fatal(err)
+	}
+	if got := listNames(t, c, "/"); len(got) != 2 || got[0] != "archive" || got[1] != "b.txt" {
+		t.Errorf("root holds %v", got)
+	}
+	if got := readFile(t, c, "/b.txt"); got != "abc" {
+		t.Errorf("renamed file holds %q", got)
+	}
+	checkStorage(t, conn, userID, 3, 3)
+}
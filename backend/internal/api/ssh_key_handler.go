@@ -0,0 +1,89 @@
+package api
+
+import (
+	"backend/internal/service"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SSHKeyHandler struct {
+	sshKeyService *service.SSHKeyService
+}
+
+func NewSSHKeyHandler(ks *service.SSHKeyService) *SSHKeyHandler {
+	return &SSHKeyHandler{sshKeyService: ks}
+}
+
+// Add registers a public key for SFTP sign-in.
+func (h *SSHKeyHandler) Add(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req struct {
+		Name      string `json:"name"`
+		PublicKey string `json:"public_key"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	key, err := h.sshKeyService.Add(userID, req.Name, req.PublicKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidSSHKey), errors.Is(err, service.ErrWeakSSHKey),
+			errors.Is(err, service.ErrInvalidSSHKeyName):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrSSHKeyExists):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to add ssh key"})
+		}
+		return
+	}
+	auditDetail(c, gin.H{"ssh_key_id": key.ID, "fingerprint": key.Fingerprint})
+	c.JSON(http.StatusCreated, gin.H{"ssh_key": key})
+}
+
+func (h *SSHKeyHandler) List(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	keys, err := h.sshKeyService.List(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list ssh keys"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ssh_keys": keys})
+}
+
+func (h *SSHKeyHandler) Delete(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	id, ok := parseIDParam(c, "id")
+	if !ok {
+		return
+	}
+	auditDetail(c, gin.H{"ssh_key_id": id})
+
+	if err := h.sshKeyService.Delete(userID, id); err != nil {
+		if errors.Is(err, service.ErrSSHKeyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete ssh key"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "SSH key deleted"})
+}
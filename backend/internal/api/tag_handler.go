@@ -0,0 +1,150 @@
+package api
+
+import (
+	"backend/internal/service"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TagHandler struct {
+	tagService *service.TagService
+}
+
+func NewTagHandler(ts *service.TagService) *TagHandler {
+	return &TagHandler{tagService: ts}
+}
+
+// GetTags returns the tags and metadata of one file.
+func (h *TagHandler) GetTags(c *gin.Context) {
+	userID := c.GetUint("userID")
+	userfileID, ok := parseUserFileID(c)
+	if !ok {
+		return
+	}
+
+	tags, metadata, err := h.tagService.Get(userID, userfileID)
+	if err != nil {
+		writeTagError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": userfileID, "tags": tags, "metadata": metadata})
+}
+
+// AddTags attaches the tags in the body to one file.
+func (h *TagHandler) AddTags(c *gin.Context) {
+	var req struct {
+		Tags []string `json:"tags"`
+	}
+	h.editOne(c, &req, func() service.TagEdit { return service.TagEdit{AddTags: req.Tags} })
+}
+
+// RemoveTag detaches a single tag from one file.
+func (h *TagHandler) RemoveTag(c *gin.Context) {
+	h.editOne(c, nil, func() service.TagEdit { return service.TagEdit{RemoveTags: []string{c.Param("tag")}} })
+}
+
+// SetMetadata upserts the key/value pairs in the body on one file.
+func (h *TagHandler) SetMetadata(c *gin.Context) {
+	var req map[string]string
+	h.editOne(c, &req, func() service.TagEdit { return service.TagEdit{SetMetadata: req} })
+}
+
+// RemoveMetadata deletes one metadata key from one file.
+func (h *TagHandler) RemoveMetadata(c *gin.Context) {
+	h.editOne(c, nil, func() service.TagEdit { return service.TagEdit{RemoveMetadata: []string{c.Param("key")}} })
+}
+
+// BulkEdit applies tag and metadata changes to many files at once. Either
+// all files are updated or none are.
+func (h *TagHandler) BulkEdit(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req struct {
+		IDs []uint `json:"ids"`
+		service.TagEdit
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	if err := h.tagService.Edit(userID, req.IDs, req.TagEdit); err != nil {
+		writeTagError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"updated": len(req.IDs)})
+}
+
+// SuggestTags autocompletes the caller's existing tags.
+func (h *TagHandler) SuggestTags(c *gin.Context) {
+	userID := c.GetUint("userID")
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+
+	tags, err := h.tagService.Suggest(userID, c.Query("prefix"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load tags"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tags": tags})
+}
+
+// editOne binds an optional JSON body, applies the edit to the file in the
+// path and responds with its updated tags and metadata.
+func (h *TagHandler) editOne(c *gin.Context, body interface{}, edit func() service.TagEdit) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	userfileID, ok := parseUserFileID(c)
+	if !ok {
+		return
+	}
+	if body != nil {
+		if err := c.ShouldBindJSON(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+	}
+
+	if err := h.tagService.Edit(userID, []uint{userfileID}, edit()); err != nil {
+		writeTagError(c, err)
+		return
+	}
+	h.GetTags(c)
+}
+
+func parseUserFileID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func writeTagError(c *gin.Context, err error) {
+	switch {
+	case err.Error() == "file not found":
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found or access denied"})
+	case errors.Is(err, service.ErrInvalidTag):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Tags must be 1-64 characters and may not contain commas"})
+	case errors.Is(err, service.ErrInvalidMetadata):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Metadata keys must match [A-Za-z0-9_.-]{1,64} and values be at most 1024 bytes"})
+	case errors.Is(err, service.ErrTooManyTags):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Too many tags or metadata keys"})
+	case errors.Is(err, service.ErrTooManyFiles):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At most 1000 files can be edited at once"})
+	case errors.Is(err, service.ErrNoFilesSelected):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No files selected"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update tags"})
+	}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"backend/internal/service"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TokenHandler struct {
+	tokenService *service.TokenService
+}
+
+func NewTokenHandler(ts *service.TokenService) *TokenHandler {
+	return &TokenHandler{tokenService: ts}
+}
+
+// Create issues a personal access token. The response carries the token,
+// which is never returned again.
+func (h *TokenHandler) Create(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req struct {
+		Name          string `json:"name"`
+		ExpiresInDays int    `json:"expires_in_days"` // 0 never expires
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.ExpiresInDays < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	raw, token, err := h.tokenService.Create(userID, req.Name, time.Duration(req.ExpiresInDays)*24*time.Hour)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidTokenName), errors.Is(err, service.ErrInvalidTokenTTL):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create token"})
+		}
+		return
+	}
+	auditDetail(c, gin.H{"token_id": token.ID, "name": token.Name})
+	c.JSON(http.StatusCreated, gin.H{"token": raw, "personal_token": token})
+}
+
+func (h *TokenHandler) List(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	tokens, err := h.tokenService.List(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list tokens"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tokens": tokens})
+}
+
+func (h *TokenHandler) Revoke(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	tokenID, ok := parseIDParam(c, "id")
+	if !ok {
+		return
+	}
+	auditDetail(c, gin.H{"token_id": tokenID})
+
+	if err := h.tokenService.Revoke(userID, tokenID); err != nil {
+		if errors.Is(err, service.ErrTokenNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke token"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Token revoked"})
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"backend/internal/models"
+	"backend/internal/service"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UploadHandler serves resumable uploads: the client starts an upload, sends
+// the file in numbered parts, and completes it once every part is stored.
+// After an interruption it asks which parts arrived and sends the rest.
+type UploadHandler struct {
+	multipart *service.MultipartService
+}
+
+func NewUploadHandler(ms *service.MultipartService) *UploadHandler {
+	return &UploadHandler{multipart: ms}
+}
+
+type uploadPart struct {
+	PartNumber int    `json:"part_number"`
+	Size       int64  `json:"size,omitempty"`
+	ETag       string `json:"etag"`
+}
+
+func uploadJSON(u *models.MultipartUpload) gin.H {
+	folder, name := "", u.Key
+	if i := strings.LastIndex(u.Key, "/"); i >= 0 {
+		folder, name = u.Key[:i], u.Key[i+1:]
+	}
+	return gin.H{"upload_id": u.ID, "folder": folder, "filename": name, "created_at": u.CreatedAt}
+}
+
+// Start begins a resumable upload of filename into folder.
+func (h *UploadHandler) Start(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req struct {
+		Folder   string `json:"folder"`
+		Filename string `json:"filename"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	auditDetail(c, gin.H{"filename": req.Filename, "folder": req.Folder})
+
+	u, err := h.multipart.Start(userID, req.Folder, req.Filename)
+	if err != nil {
+		writeUploadError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, uploadJSON(u))
+}
+
+// Get describes an upload and the parts stored so far.
+func (h *UploadHandler) Get(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	u, parts, err := h.multipart.Parts(userID, c.Param("id"))
+	if err != nil {
+		writeUploadError(c, err)
+		return
+	}
+	out := uploadJSON(u)
+	list := make([]uploadPart, 0, len(parts))
+	for _, p := range parts {
+		list = append(list, uploadPart{PartNumber: p.PartNumber, Size: p.Size, ETag: p.ETag})
+	}
+	out["parts"] = list
+	c.JSON(http.StatusOK, out)
+}
+
+// PutPart stores the request body as one part. Sending a part number again
+// replaces the earlier part.
+func (h *UploadHandler) PutPart(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	n, err := strconv.Atoi(c.Param("number"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": service.ErrInvalidPartNumber.Error()})
+		return
+	}
+
+	etag, err := h.multipart.UploadPart(userID, c.Param("id"), "", n, c.Request.Body)
+	if err != nil {
+		writeUploadError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, uploadPart{PartNumber: n, ETag: etag})
+}
+
+// Complete joins the listed parts into the file, replacing any file at the
+// upload's path. The response matches a single-request upload.
+func (h *UploadHandler) Complete(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req struct {
+		Parts []uploadPart `json:"parts"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	auditDetail(c, gin.H{"upload_id": c.Param("id"), "parts": len(req.Parts)})
+
+	parts := make([]service.CompletedPart, 0, len(req.Parts))
+	for _, p := range req.Parts {
+		parts = append(parts, service.CompletedPart{PartNumber: p.PartNumber, ETag: p.ETag})
+	}
+	e, err := h.multipart.Complete(userID, c.Param("id"), "", parts)
+	if err != nil {
+		writeUploadError(c, err)
+		return
+	}
+	auditTarget(c, e.UserFileID, e.FileID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":       e.UserFileID,
+		"file_id":  e.FileID,
+		"filename": e.Name,
+		"folder":   e.Folder,
+	})
+}
+
+// Abort ends an upload and drops its parts.
+func (h *UploadHandler) Abort(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	auditDetail(c, gin.H{"upload_id": c.Param("id")})
+
+	if err := h.multipart.Abort(userID, c.Param("id"), ""); err != nil {
+		writeUploadError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Upload aborted"})
+}
+
+func writeUploadError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrNoSuchUpload):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+	case errors.Is(err, service.ErrInvalidPart),
+		errors.Is(err, service.ErrInvalidPartOrder),
+		errors.Is(err, service.ErrInvalidPartNumber):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		status, code, msg := uploadErrorResponse(err)
+		c.JSON(status, gin.H{"error": msg, "code": code})
+	}
+}
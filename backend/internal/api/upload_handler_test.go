@@ -0,0 +1,176 @@
+package api
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/repository"
+	"backend/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// uploadClient drives the resumable upload routes as one user.
+type uploadClient struct {
+	t *testing.T
+	r *gin.Engine
+}
+
+func newUploadClient(t *testing.T, ms *service.MultipartService, userID uint) *uploadClient {
+	gin.SetMode(gin.TestMode)
+	h := NewUploadHandler(ms)
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("userID", userID) })
+	r.POST("/api/uploads", h.Start)
+	r.GET("/api/uploads/:id", h.Get)
+	r.PUT("/api/uploads/:id/parts/:number", h.PutPart)
+	r.POST("/api/uploads/:id/complete", h.Complete)
+	r.DELETE("/api/uploads/:id", h.Abort)
+	return &uploadClient{t: t, r: r}
+}
+
+// expect sends a request and decodes the JSON response into out, failing
+// the test unless the status is want.
+func (u *uploadClient) expect(want int, method, path, body string, out any) {
+	u.t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	u.r.ServeHTTP(rec, req)
+	if rec.Code != want {
+		u.t.Fatalf("%s %s: status %d, want %d: %s", method, path, rec.Code, want, rec.Body)
+	}
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			u.t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+}
+
+type uploadState struct {
+	UploadID string       `json:"upload_id"`
+	Folder   string       `json:"folder"`
+	Filename string       `json:"filename"`
+	Parts    []uploadPart `json:"parts"`
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func completeBody(parts ...uploadPart) string {
+	b, _ := json.Marshal(map[string][]uploadPart{"parts": parts})
+	return string(b)
+}
+
+func TestResumableUpload(t *testing.T) {
+	conn := openTestDB(t)
+	fs := newTestFileService(t, conn)
+	ms := service.NewMultipartService(repository.NewMultipartRepository(conn), fs, time.Hour)
+	user := createTestUser(t, conn)
+	client := newUploadClient(t, ms, user.ID)
+
+	var up uploadState
+	client.expect(http.StatusCreated, "POST", "/api/uploads", `{"folder": "docs", "filename": "big.txt"}`, &up)
+	if up.UploadID == "" || up.Folder != "docs" || up.Filename != "big.txt" {
+		t.Fatalf("started %+v", up)
+	}
+	base := "/api/uploads/" + up.UploadID
+
+	// The connection drops after the first two parts, one of them sent
+	// with the wrong content
+	parts := []string{"first part, ", "second part, ", "third part"}
+	var sent uploadPart
+	client.expect(http.StatusOK, "PUT", base+"/parts/1", parts[0], &sent)
+	if sent.PartNumber != 1 || sent.ETag != md5Hex(parts[0]) {
+		t.Errorf("part 1 stored as %+v, want its MD5 as ETag", sent)
+	}
+	client.expect(http.StatusOK, "PUT", base+"/parts/2", "garbled", nil)
+
+	// Resuming, the client asks what arrived and sends the rest
+	var state uploadState
+	client.expect(http.StatusOK, "GET", base, "", &state)
+	if got := fmt.Sprint(state.Parts); got != fmt.Sprintf("[{1 %d %s} {2 7 %s}]", len(parts[0]), md5Hex(parts[0]), md5Hex("garbled")) {
+		t.Fatalf("parts after the interruption: %s", got)
+	}
+	client.expect(http.StatusOK, "PUT", base+"/parts/2", parts[1], nil) // replaces the garbled part
+	client.expect(http.StatusOK, "PUT", base+"/parts/3", parts[2], nil)
+
+	listed := []uploadPart{
+		{PartNumber: 1, ETag: md5Hex(parts[0])},
+		{PartNumber: 2, ETag: md5Hex(parts[1])},
+		{PartNumber: 3, ETag: `"` + md5Hex(parts[2]) + `"`}, // quoted, as S3 clients send them
+	}
+	for _, bad := range []string{
+		completeBody(listed[0], uploadPart{PartNumber: 2, ETag: md5Hex("garbled")}, listed[2]),
+		completeBody(listed[0], listed[2], listed[1]),
+		completeBody(listed[0], listed[1], listed[2], uploadPart{PartNumber: 4, ETag: md5Hex("")}),
+		completeBody(),
+	} {
+		client.expect(http.StatusBadRequest, "POST", base+"/complete", bad, nil)
+	}
+	client.expect(http.StatusBadRequest, "PUT", base+"/parts/0", "x", nil)
+	client.expect(http.StatusBadRequest, "PUT", base+"/parts/10001", "x", nil)
+
+	// Other users cannot see or touch the upload
+	other := newUploadClient(t, ms, createTestUser(t, conn).ID)
+	other.expect(http.StatusNotFound, "GET", base, "", nil)
+	other.expect(http.StatusNotFound, "PUT", base+"/parts/1", "x", nil)
+	other.expect(http.StatusNotFound, "POST", base+"/complete", completeBody(listed...), nil)
+
+	var done struct {
+		ID       uint   `json:"id"`
+		Filename string `json:"filename"`
+		Folder   string `json:"folder"`
+	}
+	client.expect(http.StatusOK, "POST", base+"/complete", completeBody(listed...), &done)
+	if done.ID == 0 || done.Folder != "docs" || done.Filename != "big.txt" {
+		t.Errorf("completed %+v", done)
+	}
+	f, _, err := fs.Open(user.ID, "docs/big.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if got, _ := io.ReadAll(f); string(got) != strings.Join(parts, "") {
+		t.Errorf("assembled %q", got)
+	}
+	want := int64(len(strings.Join(parts, "")))
+	checkStorage(t, conn, user.ID, want, want)
+
+	// A completed upload is gone
+	client.expect(http.StatusNotFound, "GET", base, "", nil)
+	client.expect(http.StatusNotFound, "POST", base+"/complete", completeBody(listed...), nil)
+}
+
+func TestResumableUploadAbortAndExpiry(t *testing.T) {
+	conn := openTestDB(t)
+	fs := newTestFileService(t, conn)
+	ms := service.NewMultipartService(repository.NewMultipartRepository(conn), fs, time.Hour)
+	user := createTestUser(t, conn)
+	client := newUploadClient(t, ms, user.ID)
+
+	var up uploadState
+	client.expect(http.StatusCreated, "POST", "/api/uploads", `{"filename": "a.bin"}`, &up)
+	client.expect(http.StatusOK, "PUT", "/api/uploads/"+up.UploadID+"/parts/1", "abc", nil)
+	client.expect(http.StatusOK, "DELETE", "/api/uploads/"+up.UploadID, "", nil)
+	client.expect(http.StatusNotFound, "GET", "/api/uploads/"+up.UploadID, "", nil)
+	client.expect(http.StatusNotFound, "PUT", "/api/uploads/"+up.UploadID+"/parts/2", "def", nil)
+
+	// Unfinished uploads are discarded once older than the maximum age
+	client.expect(http.StatusCreated, "POST", "/api/uploads", `{"filename": "b.bin"}`, &up)
+	conn.Exec("UPDATE multipart_uploads SET created_at = ? WHERE id = ?", time.Now().Add(-2*time.Hour), up.UploadID)
+	if n, err := ms.Expire(); err != nil || n != 1 {
+		t.Fatalf("Expire = %d, %v; want the stale upload", n, err)
+	}
+	client.expect(http.StatusNotFound, "GET", "/api/uploads/"+up.UploadID, "", nil)
+	checkStorage(t, conn, user.ID, 0, 0)
+}
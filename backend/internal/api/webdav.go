@@ -0,0 +1,222 @@
+package api
+
+import (
+	"backend/internal/metrics"
+	"backend/internal/service"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/webdav"
+)
+
+const (
+	// WebDAVPrefix is the path the WebDAV tree is served under.
+	WebDAVPrefix = "/webdav"
+
+	// webdavCredentialTTL is how long a checked username and secret are
+	// remembered. WebDAV clients send basic auth with every request, and
+	// bcrypt is too slow to run each time, so within the TTL the sign-in is
+	// only rechecked: disabling the user, changing the password or revoking
+	// the token still takes effect on the next request.
+	webdavCredentialTTL  = time.Minute
+	maxCachedCredentials = 1024
+)
+
+// WebDAVMethods lists the request methods the WebDAV tree answers.
+var WebDAVMethods = []string{
+	http.MethodOptions, http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete,
+	"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK",
+}
+
+type cachedCredential struct {
+	grant   *service.Grant
+	expires time.Time
+}
+
+// WebDAVHandler serves each user's files as a WebDAV tree, authenticated
+// with basic auth using the account password or a personal token. Every
+// change goes through FileService.
+type WebDAVHandler struct {
+	fileService  *service.FileService
+	tokenService *service.TokenService
+
+	mu    sync.Mutex
+	locks map[uint]webdav.LockSystem
+	creds map[string]cachedCredential
+}
+
+func NewWebDAVHandler(fs *service.FileService, ts *service.TokenService) *WebDAVHandler {
+	return &WebDAVHandler{
+		fileService:  fs,
+		tokenService: ts,
+		locks:        make(map[uint]webdav.LockSystem),
+		creds:        make(map[string]cachedCredential),
+	}
+}
+
+func (h *WebDAVHandler) Serve(c *gin.Context) {
+	userID, username, ok := h.authenticate(c.Request)
+	if !ok {
+		c.Header("WWW-Authenticate", `Basic realm="File Vault", charset="UTF-8"`)
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	c.Set("userID", userID)
+	auditActor(c, userID, username)
+
+	if _, audited := auditActions[c.Request.Method+" "+c.FullPath()]; audited {
+		detail := gin.H{"path": strings.TrimPrefix(c.Request.URL.Path, WebDAVPrefix)}
+		if dst := c.GetHeader("Destination"); dst != "" {
+			detail["destination"] = dst
+		}
+		auditDetail(c, detail)
+	}
+	h.serveUser(c.Writer, c.Request, userID)
+}
+
+// serveUser answers an authenticated request on userID's tree.
+func (h *WebDAVHandler) serveUser(w http.ResponseWriter, r *http.Request, userID uint) {
+	vfs := &vaultFS{files: h.fileService, userID: userID}
+	if r.Method == http.MethodGet {
+		isolateContent(w.Header())
+	}
+	if r.Method == http.MethodPut {
+		vfs.body = &trackedBody{ReadCloser: r.Body}
+		r.Body = vfs.body
+	}
+	dav := &webdav.Handler{
+		Prefix:     WebDAVPrefix,
+		FileSystem: vfs,
+		LockSystem: h.lockSystem(userID),
+	}
+	dav.ServeHTTP(&webdavResponse{ResponseWriter: w, vfs: vfs}, r)
+}
+
+// lockSystem returns userID's locks. Users have separate namespaces, so
+// they must not share lock tokens or conflict over each other's paths.
+// Locks are kept in memory and do not survive a restart.
+func (h *WebDAVHandler) lockSystem(userID uint) webdav.LockSystem {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ls, ok := h.locks[userID]
+	if !ok {
+		ls = webdav.NewMemLS()
+		h.locks[userID] = ls
+	}
+	return ls
+}
+
+func (h *WebDAVHandler) authenticate(r *http.Request) (uint, string, bool) {
+	username, secret, ok := r.BasicAuth()
+	if !ok || username == "" || secret == "" {
+		return 0, "", false
+	}
+	sum := sha256.Sum256([]byte(username + "\x00" + secret))
+	key := hex.EncodeToString(sum[:])
+	now := time.Now()
+
+	h.mu.Lock()
+	cached, hit := h.creds[key]
+	h.mu.Unlock()
+	if hit && now.Before(cached.expires) {
+		if !h.tokenService.Recheck(cached.grant) {
+			h.mu.Lock()
+			delete(h.creds, key)
+			h.mu.Unlock()
+			return 0, "", false
+		}
+		return cached.grant.UserID, cached.grant.Username, true
+	}
+
+	grant, err := h.tokenService.Authenticate(username, secret)
+	if err != nil {
+		metrics.LoginFailed("webdav")
+		return 0, "", false
+	}
+
+	h.mu.Lock()
+	if len(h.creds) >= maxCachedCredentials {
+		for k, v := range h.creds {
+			if now.After(v.expires) {
+				delete(h.creds, k)
+			}
+		}
+	}
+	if len(h.creds) < maxCachedCredentials {
+		h.creds[key] = cachedCredential{grant: grant, expires: now.Add(webdavCredentialTTL)}
+	}
+	h.mu.Unlock()
+	return grant.UserID, grant.Username, true
+}
+
+// webdavStatus maps FileService errors to the status a WebDAV client
+// should see. The webdav package only knows about os errors and reports
+// most other failures as 403 or 405.
+func webdavStatus(err error) (int, bool) {
+	switch {
+	case errors.Is(err, service.ErrQuotaExceeded):
+		return http.StatusInsufficientStorage, true
+	case errors.Is(err, service.ErrFileTooLarge):
+		return http.StatusRequestEntityTooLarge, true
+	case errors.Is(err, service.ErrTypeNotAllowed), errors.Is(err, service.ErrMimeMismatch):
+		return http.StatusUnsupportedMediaType, true
+	case errors.Is(err, service.ErrParentNotFound):
+		return http.StatusConflict, true
+	case errors.Is(err, service.ErrPathExists):
+		return http.StatusMethodNotAllowed, true
+	case errors.Is(err, service.ErrInvalidPath):
+		return http.StatusBadRequest, true
+	}
+	return 0, false
+}
+
+// webdavResponse replaces the webdav package's error status with the one
+// the failing FileService call calls for.
+type webdavResponse struct {
+	http.ResponseWriter
+	vfs        *vaultFS
+	overridden bool
+}
+
+func (w *webdavResponse) WriteHeader(status int) {
+	if status >= 400 {
+		if s, ok := webdavStatus(w.vfs.err); ok && s != status {
+			status = s
+			w.overridden = true
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+	if w.overridden {
+		w.ResponseWriter.Write([]byte(w.vfs.err.Error()))
+	}
+}
+
+func (w *webdavResponse) Write(p []byte) (int, error) {
+	if w.overridden {
+		// Drop the body written for the original status
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// trackedBody remembers a failed read of a PUT body, so an upload cut
+// short is discarded rather than stored truncated.
+type trackedBody struct {
+	io.ReadCloser
+	err error
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
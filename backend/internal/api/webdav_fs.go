@@ -0,0 +1,245 @@
+package api
+
+import (
+	"backend/internal/service"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+var (
+	errIsDirectory = errors.New("is a directory")
+	errReadOnly    = errors.New("file is open for reading")
+	errWriteOnly   = errors.New("file is open for writing")
+)
+
+// vaultFS is one request's view of a user's files as a webdav.FileSystem.
+type vaultFS struct {
+	files  *service.FileService
+	userID uint
+	body   *trackedBody // the PUT body, if any
+
+	// err is the FileService error behind the last failed call, for
+	// webdavResponse to pick the status from.
+	err error
+}
+
+// fail records err and converts it to the os errors the webdav package
+// checks for.
+func (v *vaultFS) fail(op, name string, err error) error {
+	v.err = err
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, service.ErrPathNotFound), errors.Is(err, service.ErrParentNotFound):
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	case errors.Is(err, service.ErrPathExists):
+		return &os.PathError{Op: op, Path: name, Err: os.ErrExist}
+	}
+	return err
+}
+
+func (v *vaultFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return v.fail("mkdir", name, v.files.MakeFolder(v.userID, name))
+}
+
+func (v *vaultFS) RemoveAll(ctx context.Context, name string) error {
+	return v.fail("remove", name, v.files.RemovePath(v.userID, name))
+}
+
+func (v *vaultFS) Rename(ctx context.Context, oldName, newName string) error {
+	return v.fail("rename", oldName, v.files.MovePath(v.userID, oldName, newName))
+}
+
+func (v *vaultFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	e, err := v.files.Stat(v.userID, name)
+	if err != nil {
+		return nil, v.fail("stat", name, err)
+	}
+	return entryInfo{e}, nil
+}
+
+func (v *vaultFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		return v.create(name)
+	}
+	f, e, err := v.files.Open(v.userID, name)
+	if err != nil {
+		return nil, v.fail("open", name, err)
+	}
+	if e.IsDir {
+		return &davDir{vfs: v, name: name, info: entryInfo{e}}, nil
+	}
+	return &davFile{File: f, info: entryInfo{e}}, nil
+}
+
+// create starts storing a new file at name. The written content is piped
+// into PutFile as it arrives and the upload completes on Stat or Close.
+func (v *vaultFS) create(name string) (webdav.File, error) {
+	parent, err := v.files.Stat(v.userID, path.Dir(name))
+	switch {
+	case errors.Is(err, service.ErrPathNotFound), err == nil && !parent.IsDir:
+		return nil, v.fail("open", name, service.ErrParentNotFound)
+	case err != nil:
+		return nil, v.fail("open", name, err)
+	}
+
+	pr, pw := io.Pipe()
+	u := &davUpload{vfs: v, name: name, pw: pw, done: make(chan struct{})}
+	go func() {
+		_, u.err = v.files.PutFile(v.userID, name, pr)
+		// Unblock the writer if PutFile gave up before reading everything
+		pr.Close()
+		close(u.done)
+	}()
+	return u, nil
+}
+
+// entryInfo describes an Entry as an os.FileInfo. It also supplies the
+// content type and ETag, which saves the webdav package from opening each
+// file to sniff or hash it.
+type entryInfo struct {
+	e *service.Entry
+}
+
+func (i entryInfo) Name() string       { return i.e.Name }
+func (i entryInfo) Size() int64        { return i.e.Size }
+func (i entryInfo) ModTime() time.Time { return i.e.ModTime }
+func (i entryInfo) IsDir() bool        { return i.e.IsDir }
+func (i entryInfo) Sys() interface{}   { return nil }
+
+func (i entryInfo) Mode() os.FileMode {
+	if i.e.IsDir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+func (i entryInfo) ContentType(ctx context.Context) (string, error) {
+	if i.e.MimeType == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return i.e.MimeType, nil
+}
+
+func (i entryInfo) ETag(ctx context.Context) (string, error) {
+	if i.e.Hash == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return `"` + i.e.Hash + `"`, nil
+}
+
+// davFile is a stored file opened for reading.
+type davFile struct {
+	*os.File
+	info entryInfo
+}
+
+func (f *davFile) Stat() (os.FileInfo, error)               { return f.info, nil }
+func (f *davFile) Readdir(count int) ([]os.FileInfo, error) { return nil, errReadOnly }
+func (f *davFile) Write(p []byte) (int, error)              { return 0, errReadOnly }
+
+// davDir is a folder opened for listing.
+type davDir struct {
+	vfs     *vaultFS
+	name    string
+	info    entryInfo
+	entries []os.FileInfo
+	loaded  bool
+}
+
+func (d *davDir) Readdir(count int) ([]os.FileInfo, error) {
+	if !d.loaded {
+		entries, err := d.vfs.files.ReadDir(d.vfs.userID, d.name)
+		if err != nil {
+			return nil, d.vfs.fail("readdir", d.name, err)
+		}
+		for i := range entries {
+			d.entries = append(d.entries, entryInfo{&entries[i]})
+		}
+		d.loaded = true
+	}
+
+	if count <= 0 {
+		rest := d.entries
+		d.entries = nil
+		return rest, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(d.entries) {
+		count = len(d.entries)
+	}
+	page := d.entries[:count]
+	d.entries = d.entries[count:]
+	return page, nil
+}
+
+func (d *davDir) Stat() (os.FileInfo, error)                   { return d.info, nil }
+func (d *davDir) Read(p []byte) (int, error)                   { return 0, errIsDirectory }
+func (d *davDir) Write(p []byte) (int, error)                  { return 0, errIsDirectory }
+func (d *davDir) Seek(offset int64, whence int) (int64, error) { return 0, errIsDirectory }
+func (d *davDir) Close() error                                 { return nil }
+
+// davUpload is a file being written by a PUT or COPY.
+type davUpload struct {
+	vfs  *vaultFS
+	name string
+	pw   *io.PipeWriter
+
+	done chan struct{}
+	err  error // set by the upload before done is closed
+
+	once sync.Once
+	info os.FileInfo
+}
+
+func (u *davUpload) Write(p []byte) (int, error) {
+	return u.pw.Write(p)
+}
+
+// finish ends the content and waits for PutFile. If reading the request
+// body failed the upload is aborted, so nothing truncated is stored.
+func (u *davUpload) finish() error {
+	u.once.Do(func() {
+		if u.vfs.body != nil && u.vfs.body.err != nil {
+			u.pw.CloseWithError(u.vfs.body.err)
+		} else {
+			u.pw.Close()
+		}
+		<-u.done
+		if u.err != nil {
+			u.err = u.vfs.fail("write", u.name, u.err)
+			return
+		}
+		e, err := u.vfs.files.Stat(u.vfs.userID, u.name)
+		if err != nil {
+			u.err = u.vfs.fail("stat", u.name, err)
+			return
+		}
+		u.info = entryInfo{e}
+	})
+	return u.err
+}
+
+func (u *davUpload) Stat() (os.FileInfo, error) {
+	if err := u.finish(); err != nil {
+		return nil, err
+	}
+	return u.info, nil
+}
+
+func (u *davUpload) Close() error {
+	return u.finish()
+}
+
+func (u *davUpload) Read(p []byte) (int, error)                   { return 0, errWriteOnly }
+func (u *davUpload) Seek(offset int64, whence int) (int64, error) { return 0, errWriteOnly }
+func (u *davUpload) Readdir(count int) ([]os.FileInfo, error)     { return nil, errWriteOnly }
@@ -0,0 +1,284 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"backend/internal/db"
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/internal/service"
+)
+
+// openTestDB migrates a scratch schema in the database named by
+// TEST_DATABASE_DSN, skipping the test when it is not set. The schema is
+// dropped when the test finishes.
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN not set; skipping database test")
+	}
+	cfg := &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)}
+	admin, err := gorm.Open(postgres.Open(dsn), cfg)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	schema := fmt.Sprintf("api_test_%d", time.Now().UnixNano())
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+	if strings.Contains(dsn, "://") {
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		dsn += sep + "search_path=" + schema
+	} else {
+		dsn += " search_path=" + schema
+	}
+	conn, err := gorm.Open(postgres.Open(dsn), cfg)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	m, err := db.NewMigrator(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(0); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return conn
+}
+
+func newTestFileService(t *testing.T, conn *gorm.DB) *service.FileService {
+	t.Helper()
+	txm := repository.NewTxManager(conn)
+	return service.NewFileService(
+		repository.NewFileRepository(conn),
+		repository.NewUserFileRepository(conn),
+		repository.NewUserRepository(conn),
+		repository.NewFolderRepository(conn),
+		txm,
+		service.NewBlobDeleter(txm, time.Minute),
+		service.FileConfig{UploadDir: t.TempDir()},
+		1024,
+	)
+}
+
+func createTestUser(t *testing.T, conn *gorm.DB) *models.User {
+	t.Helper()
+	u := &models.User{Username: "alice", Email: "alice@example.com", Password: "x"}
+	if err := conn.Create(u).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return u
+}
+
+// checkStorage fails the test unless the user's counters hold the given
+// values.
+func checkStorage(t *testing.T, conn *gorm.DB, userID uint, actual, expected int64) {
+	t.Helper()
+	var u models.User
+	if err := conn.First(&u, userID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if u.ActualStorage != actual || u.ExpectedStorage != expected {
+		t.Errorf("storage = %d actual, %d expected; want %d, %d", u.ActualStorage, u.ExpectedStorage, actual, expected)
+	}
+}
+
+type davClient struct {
+	t      *testing.T
+	h      *WebDAVHandler
+	userID uint
+}
+
+func newDAVClient(t *testing.T) (*davClient, *gorm.DB) {
+	conn := openTestDB(t)
+	h := NewWebDAVHandler(newTestFileService(t, conn), nil)
+	return &davClient{t: t, h: h, userID: createTestUser(t, conn).ID}, conn
+}
+
+// do sends a request for path under the WebDAV prefix. header alternates
+// names and values.
+func (d *davClient) do(method, path, body string, header ...string) *httptest.ResponseRecorder {
+	d.t.Helper()
+	req := httptest.NewRequest(method, WebDAVPrefix+path, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	d.h.serveUser(rec, req, d.userID)
+	return rec
+}
+
+func (d *davClient) expect(want int, method, path, body string, header ...string) *httptest.ResponseRecorder {
+	d.t.Helper()
+	rec := d.do(method, path, body, header...)
+	if rec.Code != want {
+		d.t.Fatalf("%s %s: status %d, want %d: %s", method, path, rec.Code, want, rec.Body)
+	}
+	return rec
+}
+
+func TestWebDAVCopyKeepsBothPaths(t *testing.T) {
+	dav, conn := newDAVClient(t)
+	const content = "hello, vault"
+	size := int64(len(content))
+
+	dav.expect(http.StatusCreated, http.MethodPut, "/a.txt", content)
+	dav.expect(http.StatusCreated, "COPY", "/a.txt", "", "Destination", WebDAVPrefix+"/b.txt")
+	if got := dav.expect(http.StatusOK, http.MethodGet, "/b.txt", "").Body.String(); got != content {
+		t.Errorf("copy holds %q", got)
+	}
+	// The copy counts against the quota but shares the blob
+	checkStorage(t, conn, dav.userID, size, 2*size)
+
+	// Deleting the original leaves the copy paying for the blob
+	dav.expect(http.StatusNoContent, http.MethodDelete, "/a.txt", "")
+	dav.expect(http.StatusOK, http.MethodGet, "/b.txt", "")
+	checkStorage(t, conn, dav.userID, size, size)
+
+	dav.expect(http.StatusNoContent, http.MethodDelete, "/b.txt", "")
+	checkStorage(t, conn, dav.userID, 0, 0)
+	var files int64
+	conn.Model(&models.File{}).Count(&files)
+	if files != 0 {
+		t.Errorf("%d file rows left", files)
+	}
+}
+
+func TestWebDAVTreeWithIdenticalFiles(t *testing.T) {
+	dav, conn := newDAVClient(t)
+
+	dav.expect(http.StatusCreated, "MKCOL", "/pkg", "")
+	dav.expect(http.StatusCreated, "MKCOL", "/pkg/sub", "")
+	dav.expect(http.StatusCreated, http.MethodPut, "/pkg/__init__.py", "")
+	dav.expect(http.StatusCreated, http.MethodPut, "/pkg/sub/__init__.py", "")
+
+	var refs []models.UserFile
+	if err := conn.Where("user_id = ?", dav.userID).Order("id").Find(&refs).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 2 || refs[0].FileID != refs[1].FileID {
+		t.Fatalf("got %d references, want 2 to the same file", len(refs))
+	}
+
+	// Writing the same content back changes nothing
+	dav.expect(http.StatusCreated, http.MethodPut, "/pkg/__init__.py", "")
+	var after int64
+	conn.Model(&models.UserFile{}).Where("id IN ?", []uint{refs[0].ID, refs[1].ID}).Count(&after)
+	if after != 2 {
+		t.Errorf("rewriting identical content replaced the file")
+	}
+	if got, err := io.ReadAll(dav.expect(http.StatusOK, http.MethodGet, "/pkg/sub/__init__.py", "").Body); err != nil || len(got) != 0 {
+		t.Errorf("read %q, %v", got, err)
+	}
+}
+
+func TestWebDAVPutReplacesContent(t *testing.T) {
+	dav, conn := newDAVClient(t)
+
+	dav.expect(http.StatusCreated, http.MethodPut, "/notes.txt", "first draft")
+	dav.expect(http.StatusCreated, http.MethodPut, "/notes.txt", "final")
+	if got := dav.expect(http.StatusOK, http.MethodGet, "/notes.txt", "").Body.String(); got != "final" {
+		t.Errorf("read %q after overwrite", got)
+	}
+	checkStorage(t, conn, dav.userID, 5, 5)
+
+	// The first draft's blob is no longer referenced
+	var files int64
+	conn.Model(&models.File{}).Count(&files)
+	if files != 1 {
+		t.Errorf("%d file rows, want 1", files)
+	}
+}
+
+func TestWebDAVPutRejected(t *testing.T) {
+	dav, conn := newDAVClient(t)
+	quota := int64(4)
+	if err := conn.Model(&models.User{}).Where("id = ?", dav.userID).Update("quota_bytes", quota).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	dav.expect(http.StatusInsufficientStorage, http.MethodPut, "/big.txt", "more than four bytes")
+	dav.expect(http.StatusNotFound, http.MethodGet, "/big.txt", "")
+	dav.expect(http.StatusConflict, http.MethodPut, "/missing/a.txt", "abc")
+	checkStorage(t, conn, dav.userID, 0, 0)
+}
+
+func TestWebDAVMkcol(t *testing.T) {
+	dav, _ := newDAVClient(t)
+
+	dav.expect(http.StatusCreated, "MKCOL", "/docs", "")
+	dav.expect(http.StatusMethodNotAllowed, "MKCOL", "/docs", "")
+	dav.expect(http.StatusConflict, "MKCOL", "/missing/docs", "")
+}
+
+func TestWebDAVMove(t *testing.T) {
+	dav, conn := newDAVClient(t)
+
+	dav.expect(http.StatusCreated, "MKCOL", "/inbox", "")
+	dav.expect(http.StatusCreated, "MKCOL", "/archive", "")
+	dav.expect(http.StatusCreated, http.MethodPut, "/inbox/a.txt", "abc")
+
+	// A file, renamed on the way
+	dav.expect(http.StatusCreated, "MOVE", "/inbox/a.txt", "", "Destination", WebDAVPrefix+"/archive/b.txt")
+	dav.expect(http.StatusNotFound, http.MethodGet, "/inbox/a.txt", "")
+	dav.expect(http.StatusOK, http.MethodGet, "/archive/b.txt", "")
+
+	// A folder, with what it holds
+	dav.expect(http.StatusCreated, "MOVE", "/archive", "", "Destination", WebDAVPrefix+"/inbox/2024")
+	if got := dav.expect(http.StatusOK, http.MethodGet, "/inbox/2024/b.txt", "").Body.String(); got != "abc" {
+		t.Errorf("moved file holds %q", got)
+	}
+	dav.expect(http.StatusNotFound, "PROPFIND", "/archive", "", "Depth", "0")
+
+	// Moving changes nothing about what is stored
+	checkStorage(t, conn, dav.userID, 3, 3)
+}
+
+func TestWebDAVDeleteFolder(t *testing.T) {
+	dav, conn := newDAVClient(t)
+
+	dav.expect(http.StatusCreated, "MKCOL", "/tmp", "")
+	dav.expect(http.StatusCreated, "MKCOL", "/tmp/sub", "")
+	dav.expect(http.StatusCreated, http.MethodPut, "/tmp/a.txt", "abc")
+	dav.expect(http.StatusCreated, http.MethodPut, "/tmp/sub/b.txt", "defg")
+	checkStorage(t, conn, dav.userID, 7, 7)
+
+	dav.expect(http.StatusNoContent, http.MethodDelete, "/tmp", "")
+	dav.expect(http.StatusNotFound, "PROPFIND", "/tmp/sub", "", "Depth", "0")
+	checkStorage(t, conn, dav.userID, 0, 0)
+}
+
+func TestWebDAVPropfind(t *testing.T) {
+	dav, _ := newDAVClient(t)
+
+	dav.expect(http.StatusCreated, "MKCOL", "/docs", "")
+	dav.expect(http.StatusCreated, http.MethodPut, "/docs/a.txt", "abc")
+	dav.expect(http.StatusCreated, "MKCOL", "/docs/sub", "")
+
+	body := dav.expect(http.StatusMultiStatus, "PROPFIND", "/docs", "", "Depth", "1").Body.String()
+	for _, href := range []string{WebDAVPrefix + "/docs/", WebDAVPrefix + "/docs/a.txt", WebDAVPrefix + "/docs/sub/"} {
+		if !strings.Contains(body, "<D:href>"+href+"</D:href>") {
+			t.Errorf("listing lacks %s:\n%s", href, body)
+		}
+	}
+	if !strings.Contains(body, "<D:getcontentlength>3</D:getcontentlength>") {
+		t.Errorf("listing lacks a.txt's size:\n%s", body)
+	}
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"backend/internal/service"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebhookHandler struct {
+	webhookService *service.WebhookService
+}
+
+func NewWebhookHandler(ws *service.WebhookService) *WebhookHandler {
+	return &WebhookHandler{webhookService: ws}
+}
+
+// Register creates a webhook. The response carries the signing secret,
+// which is never returned again.
+func (h *WebhookHandler) Register(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req service.WebhookInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	hook, secret, err := h.webhookService.Register(userID, req)
+	if err != nil {
+		writeWebhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"webhook": hook, "secret": secret})
+}
+
+func (h *WebhookHandler) List(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	hooks, err := h.webhookService.List(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list webhooks"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": hooks, "event_types": service.WebhookEventTypes})
+}
+
+func (h *WebhookHandler) Delete(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	webhookID, ok := parseIDParam(c, "id")
+	if !ok {
+		return
+	}
+
+	if err := h.webhookService.Delete(userID, webhookID); err != nil {
+		writeWebhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted"})
+}
+
+// Deliveries returns the delivery log, newest first. Pass the last ID of a
+// page as before_id to get the next one.
+func (h *WebhookHandler) Deliveries(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	webhookID, ok := parseIDParam(c, "id")
+	if !ok {
+		return
+	}
+	beforeID, _ := strconv.ParseUint(c.Query("before_id"), 10, 64)
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	deliveries, err := h.webhookService.Deliveries(userID, webhookID, uint(beforeID), limit)
+	if err != nil {
+		writeWebhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
+
+// Redeliver queues a past delivery's payload again.
+func (h *WebhookHandler) Redeliver(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	webhookID, ok := parseIDParam(c, "id")
+	if !ok {
+		return
+	}
+	deliveryID, ok := parseIDParam(c, "delivery")
+	if !ok {
+		return
+	}
+
+	d, err := h.webhookService.Redeliver(userID, webhookID, deliveryID)
+	if err != nil {
+		writeWebhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"delivery": d})
+}
+
+func parseIDParam(c *gin.Context, name string) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param(name), 10, 64)
+	if err != nil || id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func writeWebhookError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidWebhookURL),
+		errors.Is(err, service.ErrUnknownEventType),
+		errors.Is(err, service.ErrWeakWebhookSecret):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrAdminOnly):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only admins can register global webhooks"})
+	case errors.Is(err, service.ErrWebhookNotFound), errors.Is(err, service.ErrDeliveryNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "webhook request failed"})
+	}
+}
@@ -0,0 +1,424 @@
+// Package config loads the server's settings. Each setting has a default
+// and can be set in a JSON config file, in an environment variable (or a
+// .env file) and with a command-line flag, each overriding the one before.
+// Everything is validated at startup, so a typo stops the server with a
+// message naming the setting instead of being silently ignored.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+type Config struct {
+	HTTP     HTTP
+	S3       Listener
+	SFTP     SFTP
+	DB       DB
+	Storage  Storage
+	Limits   Limits
+	Auth     Auth
+	Cookie   Cookie
+	Events   Events
+	Metrics  Listener
+	Shutdown Shutdown
+
+	// PrintOnly is set by --print-config: show the effective settings and
+	// exit.
+	PrintOnly bool
+
+	settings []*setting
+}
+
+type HTTP struct {
+	Addr           string
+	AllowedOrigins []string // browser origins allowed to call the API with cookies
+}
+
+// Listener is an optional extra listener; Addr "off" disables it.
+type Listener struct {
+	Addr string
+}
+
+func (l Listener) Enabled() bool { return l.Addr != "off" }
+
+type SFTP struct {
+	Listener
+	HostKey string // path to the host key, created on first start if missing
+}
+
+type DB struct {
+	Host            string
+	Port            int
+	User            string
+	Password        string
+	Name            string
+	SSLMode         string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	AutoMigrate     bool // apply pending migrations at startup
+}
+
+// DSN returns the connection string for the database.
+func (d DB) DSN() string {
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		dsnQuote(d.Host), d.Port, dsnQuote(d.User), dsnQuote(d.Password), dsnQuote(d.Name), d.SSLMode)
+}
+
+// dsnQuote quotes a value for a key=value connection string.
+func dsnQuote(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	return "'" + strings.ReplaceAll(v, `'`, `\'`) + "'"
+}
+
+type Storage struct {
+	Backend      string // only "local" for now
+	UploadDir    string
+	AllowedTypes []string // MIME types accepted for upload; empty allows all
+}
+
+type Limits struct {
+	UserQuotaMB            int64 // default per-user quota; vaultctl can set others
+	RateLimit              int   // API requests per second per user
+	MaxFileSize            int64 // bytes, 0 for no limit
+	MaxArchiveEntries      int   // 0 uses the built-in default
+	MaxArchiveExpandedSize int64 // 0 uses the built-in default
+	MaxCompressionRatio    int   // 0 uses the built-in default
+	MaxZipDownloadSize     int64 // 0 uses the built-in default
+}
+
+type Auth struct {
+	JWTSecret string
+}
+
+type Cookie struct {
+	Domain   string // empty scopes the cookie to the host that set it
+	Secure   bool
+	SameSite string // lax, strict or none
+	MaxAge   time.Duration
+}
+
+type Events struct {
+	Broker string // memory, or postgres to share events between servers
+}
+
+type Shutdown struct {
+	DrainTimeout time.Duration // how long transfers in progress get to finish
+}
+
+// setting ties one field to its config file key, environment variable and
+// flag.
+type setting struct {
+	key    string // in the config file, as section.name
+	env    string
+	usage  string
+	secret bool
+	value  flag.Value
+	source string // where the effective value came from
+}
+
+// flagName is the setting's command-line flag, db.max_open_conns becoming
+// --db-max-open-conns.
+func (s *setting) flagName() string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(s.key)
+}
+
+func (c *Config) define() {
+	add := func(key, env string, v flag.Value, usage string) *setting {
+		s := &setting{key: key, env: env, value: v, usage: usage, source: "default"}
+		c.settings = append(c.settings, s)
+		return s
+	}
+
+	add("http.addr", "HTTP_ADDR", (*stringValue)(&c.HTTP.Addr), "address the web API listens on")
+	add("http.allowed_origins", "FRONTEND_ORIGIN", (*listValue)(&c.HTTP.AllowedOrigins), "comma-separated browser origins allowed to call the API")
+	add("s3.addr", "S3_ADDR", (*stringValue)(&c.S3.Addr), `address the S3 API listens on, such as :9000, or "off"`)
+	add("sftp.addr", "SFTP_ADDR", (*stringValue)(&c.SFTP.Addr), `address the SFTP server listens on, such as :2022, or "off"`)
+	add("sftp.host_key", "SFTP_HOST_KEY", (*stringValue)(&c.SFTP.HostKey), "SFTP host key file (default UPLOAD_DIR/.sftp_host_key)")
+
+	add("db.host", "DB_HOST", (*stringValue)(&c.DB.Host), "database host")
+	add("db.port", "DB_PORT", (*intValue)(&c.DB.Port), "database port")
+	add("db.user", "DB_USER", (*stringValue)(&c.DB.User), "database user")
+	add("db.password", "DB_PASSWORD", (*stringValue)(&c.DB.Password), "database password").secret = true
+	add("db.name", "DB_NAME", (*stringValue)(&c.DB.Name), "database name")
+	add("db.sslmode", "DB_SSLMODE", (*stringValue)(&c.DB.SSLMode), "disable, allow, prefer, require, verify-ca or verify-full")
+	add("db.max_open_conns", "DB_MAX_OPEN_CONNS", (*intValue)(&c.DB.MaxOpenConns), "most open connections, 0 for no limit")
+	add("db.max_idle_conns", "DB_MAX_IDLE_CONNS", (*intValue)(&c.DB.MaxIdleConns), "most idle connections kept open")
+	add("db.conn_max_lifetime", "DB_CONN_MAX_LIFETIME", (*durationValue)(&c.DB.ConnMaxLifetime), "close connections older than this, 0 to keep them")
+	add("db.conn_max_idle_time", "DB_CONN_MAX_IDLE_TIME", (*durationValue)(&c.DB.ConnMaxIdleTime), "close connections idle for longer than this, 0 to keep them")
+	add("db.auto_migrate", "DB_AUTO_MIGRATE", (*boolValue)(&c.DB.AutoMigrate), "apply pending migrations at startup")
+
+	add("storage.backend", "STORAGE_BACKEND", (*stringValue)(&c.Storage.Backend), `where blobs are kept; only "local" is supported`)
+	add("storage.upload_dir", "UPLOAD_DIR", (*stringValue)(&c.Storage.UploadDir), "directory blobs, staging files and previews are kept in")
+	add("storage.allowed_types", "ALLOWED_TYPES", (*listValue)(&c.Storage.AllowedTypes), "comma-separated MIME types accepted for upload, empty for all")
+
+	add("limits.user_quota_mb", "USER_STORAGE_QUOTA_MB", (*int64Value)(&c.Limits.UserQuotaMB), "default storage quota per user, in MiB")
+	add("limits.rate_limit", "API_RATE_LIMIT", (*intValue)(&c.Limits.RateLimit), "API requests per second per user")
+	add("limits.max_file_size", "MAX_FILE_SIZE", (*sizeValue)(&c.Limits.MaxFileSize), "largest file accepted, such as 2G; 0 for no limit")
+	add("limits.max_archive_entries", "MAX_ARCHIVE_ENTRIES", (*intValue)(&c.Limits.MaxArchiveEntries), "most entries extracted from one archive, 0 for the default")
+	add("limits.max_archive_expanded_size", "MAX_ARCHIVE_EXPANDED_SIZE", (*sizeValue)(&c.Limits.MaxArchiveExpandedSize), "most bytes one archive may expand to, 0 for the default")
+	add("limits.max_compression_ratio", "MAX_COMPRESSION_RATIO", (*intValue)(&c.Limits.MaxCompressionRatio), "expansion ratio treated as a zip bomb, 0 for the default")
+	add("limits.max_zip_download_size", "MAX_ZIP_DOWNLOAD_SIZE", (*sizeValue)(&c.Limits.MaxZipDownloadSize), "most bytes one ZIP download may hold, 0 for the default")
+
+	add("auth.jwt_secret", "JWT_SECRET", (*stringValue)(&c.Auth.JWTSecret), "key session tokens are signed with").secret = true
+	add("cookie.domain", "COOKIE_DOMAIN", (*stringValue)(&c.Cookie.Domain), "session cookie domain, empty for the API's host")
+	add("cookie.secure", "COOKIE_SECURE", (*boolValue)(&c.Cookie.Secure), "send the session cookie over HTTPS only")
+	add("cookie.samesite", "COOKIE_SAMESITE", (*stringValue)(&c.Cookie.SameSite), "lax, strict or none")
+	add("cookie.max_age", "COOKIE_MAX_AGE", (*durationValue)(&c.Cookie.MaxAge), "how long the session cookie lasts")
+
+	add("events.broker", "EVENT_BROKER", (*stringValue)(&c.Events.Broker), `"memory", or "postgres" to share live events between servers`)
+	add("metrics.addr", "METRICS_ADDR", (*stringValue)(&c.Metrics.Addr), `address Prometheus metrics are served on at /metrics, or "off"`)
+
+	add("shutdown.drain_timeout", "SHUTDOWN_DRAIN_TIMEOUT", (*durationValue)(&c.Shutdown.DrainTimeout), "how long transfers in progress get to finish when the server is stopped")
+}
+
+func defaults() *Config {
+	c := &Config{
+		HTTP:     HTTP{Addr: ":8080", AllowedOrigins: []string{"http://localhost:5173"}},
+		S3:       Listener{Addr: "off"},
+		SFTP:     SFTP{Listener: Listener{Addr: "off"}},
+		DB:       DB{Host: "localhost", Port: 5432, SSLMode: "disable", MaxOpenConns: 25, MaxIdleConns: 5, ConnMaxLifetime: time.Hour, ConnMaxIdleTime: 10 * time.Minute, AutoMigrate: true},
+		Storage:  Storage{Backend: "local", UploadDir: "uploads"},
+		Limits:   Limits{UserQuotaMB: 1024, RateLimit: 10},
+		Cookie:   Cookie{SameSite: "lax", MaxAge: 15 * time.Minute},
+		Events:   Events{Broker: "memory"},
+		Metrics:  Listener{Addr: "127.0.0.1:9090"},
+		Shutdown: Shutdown{DrainTimeout: 30 * time.Second},
+	}
+	c.define()
+	return c
+}
+
+// Load builds the configuration from the defaults, the config file, the
+// environment and the flags in args, in that order of precedence. The
+// config file is named by --config or CONFIG_FILE. A .env file, named by
+// --env-file or ENV_FILE and otherwise looked for in the working
+// directory, fills in environment variables that are not already set.
+func Load(name string, args []string) (*Config, error) {
+	c := defaults()
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "JSON config file")
+	envFile := fs.String("env-file", os.Getenv("ENV_FILE"), "file of KEY=value environment settings (default .env, if present)")
+	fs.BoolVar(&c.PrintOnly, "print-config", false, "print the effective configuration, secrets redacted, and exit")
+	flagged := map[*setting]string{}
+	for _, s := range c.settings {
+		fs.Func(s.flagName(), s.usage+" ($"+s.env+")", func(v string) error {
+			flagged[s] = v
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	if *envFile != "" {
+		if err := godotenv.Load(*envFile); err != nil {
+			return nil, fmt.Errorf("env file: %w", err)
+		}
+	} else if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf(".env: %w", err)
+	}
+
+	var errs []error
+	if *configFile != "" {
+		errs = append(errs, c.loadFile(*configFile)...)
+	}
+	for _, s := range c.settings {
+		if v, ok := os.LookupEnv(s.env); ok {
+			errs = append(errs, c.set(s, v, "env "+s.env))
+		}
+	}
+	for _, s := range c.settings {
+		if v, ok := flagged[s]; ok {
+			errs = append(errs, c.set(s, v, "flag --"+s.flagName()))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Config) set(s *setting, v, source string) error {
+	if err := s.value.Set(strings.TrimSpace(v)); err != nil {
+		return fmt.Errorf("%s (from %s): %w", s.key, source, err)
+	}
+	s.source = source
+	return nil
+}
+
+// loadFile applies a JSON file of sections, such as
+// {"db": {"host": "db.internal", "port": 5432}}.
+func (c *Config) loadFile(path string) []error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return []error{fmt.Errorf("config file: %w", err)}
+	}
+	// Numbers are kept as written, so a size such as 104857600 doesn't
+	// come back as 1.048576e+08
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var sections map[string]map[string]any
+	if err := dec.Decode(&sections); err != nil {
+		return []error{fmt.Errorf("config file %s: %w", path, err)}
+	}
+	byKey := map[string]*setting{}
+	for _, s := range c.settings {
+		byKey[s.key] = s
+	}
+	source := "file " + path
+	var errs []error
+	for section, values := range sections {
+		for name, raw := range values {
+			key := section + "." + name
+			s := byKey[key]
+			if s == nil {
+				errs = append(errs, fmt.Errorf("%s: unknown setting %s", source, key))
+				continue
+			}
+			var v string
+			if list, ok := raw.([]any); ok {
+				parts := make([]string, len(list))
+				for i, p := range list {
+					parts[i] = fileValue(p)
+				}
+				v = strings.Join(parts, ",")
+			} else {
+				v = fileValue(raw)
+			}
+			errs = append(errs, c.set(s, v, source))
+		}
+	}
+	return errs
+}
+
+// fileValue renders a scalar from the config file as a setting would be
+// written in the environment.
+func fileValue(raw any) string {
+	switch raw := raw.(type) {
+	case string:
+		return raw
+	case json.Number:
+		return raw.String()
+	}
+	return fmt.Sprint(raw)
+}
+
+var (
+	sslModes  = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+	sameSites = []string{"lax", "strict", "none"}
+
+	// placeholderSecrets are fragments of example secrets, which anyone who
+	// has read the example can sign tokens with.
+	placeholderSecrets = []string{"change-me", "changeme", "change_me", "replace-me", "your-secret", "example"}
+)
+
+// minJWTSecretLen is the shortest JWT secret accepted, in bytes: as long as
+// the HMAC-SHA256 output.
+const minJWTSecretLen = 32
+
+func isPlaceholder(secret string) bool {
+	secret = strings.ToLower(secret)
+	return slices.ContainsFunc(placeholderSecrets, func(p string) bool { return strings.Contains(secret, p) })
+}
+
+// Validate checks every setting, reporting all the problems at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, key, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: "+format, append([]any{key}, args...)...))
+		}
+	}
+
+	check(validAddr(c.HTTP.Addr), "http.addr", "%q is not a host:port address", c.HTTP.Addr)
+	for _, o := range c.HTTP.AllowedOrigins {
+		u, err := url.Parse(o)
+		check(o == "*" || (err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && u.Path == ""),
+			"http.allowed_origins", "%q is not an origin such as https://vault.example.com", o)
+	}
+	check(!c.S3.Enabled() || validAddr(c.S3.Addr), "s3.addr", `%q is not a host:port address or "off"`, c.S3.Addr)
+	check(!c.SFTP.Enabled() || validAddr(c.SFTP.Addr), "sftp.addr", `%q is not a host:port address or "off"`, c.SFTP.Addr)
+
+	check(c.DB.Host != "", "db.host", "must be set")
+	check(c.DB.Port > 0 && c.DB.Port < 65536, "db.port", "%d is not a port", c.DB.Port)
+	check(c.DB.User != "", "db.user", "must be set")
+	check(c.DB.Name != "", "db.name", "must be set")
+	check(slices.Contains(sslModes, c.DB.SSLMode), "db.sslmode", "must be one of %s", strings.Join(sslModes, ", "))
+	check(c.DB.MaxOpenConns >= 0, "db.max_open_conns", "must not be negative")
+	check(c.DB.MaxIdleConns >= 0, "db.max_idle_conns", "must not be negative")
+	check(c.DB.MaxOpenConns == 0 || c.DB.MaxIdleConns <= c.DB.MaxOpenConns, "db.max_idle_conns", "must not exceed db.max_open_conns")
+	check(c.DB.ConnMaxLifetime >= 0, "db.conn_max_lifetime", "must not be negative")
+	check(c.DB.ConnMaxIdleTime >= 0, "db.conn_max_idle_time", "must not be negative")
+
+	check(c.Storage.Backend == "local", "storage.backend", `%q is not supported; use "local"`, c.Storage.Backend)
+	check(c.Storage.UploadDir != "", "storage.upload_dir", "must be set")
+	for _, t := range c.Storage.AllowedTypes {
+		check(strings.Count(t, "/") == 1, "storage.allowed_types", "%q is not a MIME type", t)
+	}
+
+	check(c.Limits.UserQuotaMB > 0, "limits.user_quota_mb", "must be positive")
+	check(c.Limits.RateLimit > 0, "limits.rate_limit", "must be positive")
+	check(c.Limits.MaxFileSize >= 0, "limits.max_file_size", "must not be negative")
+	check(c.Limits.MaxArchiveEntries >= 0, "limits.max_archive_entries", "must not be negative")
+	check(c.Limits.MaxArchiveExpandedSize >= 0, "limits.max_archive_expanded_size", "must not be negative")
+	check(c.Limits.MaxCompressionRatio >= 0, "limits.max_compression_ratio", "must not be negative")
+	check(c.Limits.MaxZipDownloadSize >= 0, "limits.max_zip_download_size", "must not be negative")
+
+	if c.Auth.JWTSecret == "" {
+		check(false, "auth.jwt_secret", "must be set")
+	} else {
+		check(len(c.Auth.JWTSecret) >= minJWTSecretLen, "auth.jwt_secret", "must be at least %d bytes long", minJWTSecretLen)
+		check(!isPlaceholder(c.Auth.JWTSecret), "auth.jwt_secret", "is an example value; generate one with: openssl rand -base64 48")
+	}
+	check(slices.Contains(sameSites, c.Cookie.SameSite), "cookie.samesite", "must be one of %s", strings.Join(sameSites, ", "))
+	check(c.Cookie.SameSite != "none" || c.Cookie.Secure, "cookie.samesite", `"none" requires cookie.secure`)
+	check(c.Cookie.MaxAge > 0, "cookie.max_age", "must be positive")
+
+	check(c.Events.Broker == "memory" || c.Events.Broker == "postgres", "events.broker", `must be "memory" or "postgres"`)
+	check(!c.Metrics.Enabled() || validAddr(c.Metrics.Addr), "metrics.addr", `%q is not a host:port address or "off"`, c.Metrics.Addr)
+	check(c.Shutdown.DrainTimeout > 0, "shutdown.drain_timeout", "must be positive")
+	return errors.Join(errs...)
+}
+
+func validAddr(addr string) bool {
+	_, port, err := net.SplitHostPort(addr)
+	return err == nil && port != ""
+}
+
+// Print writes every setting, where its value came from and, for secrets,
+// only whether one is set.
+func (c *Config) Print(w io.Writer) {
+	width := 0
+	for _, s := range c.settings {
+		width = max(width, len(s.key))
+	}
+	for _, s := range c.settings {
+		v := s.value.String()
+		if s.secret && v != "" {
+			v = "[redacted]"
+		}
+		fmt.Fprintf(w, "%-*s = %-24s (%s)\n", width, s.key, v, s.source)
+	}
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSecret = "J7kQm2xVt9RwL4pZc8NbH3sYf6GdA1eU"
+
+// required sets the settings that have no default.
+func required(t *testing.T) {
+	t.Setenv("DB_USER", "vault")
+	t.Setenv("DB_NAME", "vault")
+	t.Setenv("JWT_SECRET", testSecret)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	required(t)
+	file := filepath.Join(t.TempDir(), "vault.json")
+	err := os.WriteFile(file, []byte(`{
+		"db": {"host": "file-host", "port": 6000, "max_open_conns": 7},
+		"storage": {"allowed_types": ["image/png", "text/plain"]},
+		"limits": {"max_file_size": "2G", "max_zip_download_size": "512M"}
+	}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DB_PORT", "6001")
+	t.Setenv("DB_MAX_OPEN_CONNS", "8")
+
+	c, err := Load("test", []string{"--config", file, "--db-max-open-conns", "9"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.DB.Host != "file-host" {
+		t.Errorf("db.host = %q, want the file's value", c.DB.Host)
+	}
+	if c.DB.Port != 6001 {
+		t.Errorf("db.port = %d, want the environment's value", c.DB.Port)
+	}
+	if c.DB.MaxOpenConns != 9 {
+		t.Errorf("db.max_open_conns = %d, want the flag's value", c.DB.MaxOpenConns)
+	}
+	if c.DB.MaxIdleConns != 5 {
+		t.Errorf("db.max_idle_conns = %d, want the default", c.DB.MaxIdleConns)
+	}
+	if got := strings.Join(c.Storage.AllowedTypes, ","); got != "image/png,text/plain" {
+		t.Errorf("storage.allowed_types = %q", got)
+	}
+	if c.Limits.MaxFileSize != 2<<30 {
+		t.Errorf("limits.max_file_size = %d", c.Limits.MaxFileSize)
+	}
+	if c.Limits.MaxZipDownloadSize != 512<<20 {
+		t.Errorf("limits.max_zip_download_size = %d", c.Limits.MaxZipDownloadSize)
+	}
+}
+
+func TestLoadFileKeepsLargeNumbers(t *testing.T) {
+	required(t)
+	file := filepath.Join(t.TempDir(), "vault.json")
+	err := os.WriteFile(file, []byte(`{
+		"limits": {"max_file_size": 104857600, "user_quota_mb": 1000000000, "max_archive_entries": 20000}
+	}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := Load("test", []string{"--config", file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Limits.MaxFileSize != 104857600 {
+		t.Errorf("limits.max_file_size = %d", c.Limits.MaxFileSize)
+	}
+	if c.Limits.UserQuotaMB != 1000000000 {
+		t.Errorf("limits.user_quota_mb = %d", c.Limits.UserQuotaMB)
+	}
+	if c.Limits.MaxArchiveEntries != 20000 {
+		t.Errorf("limits.max_archive_entries = %d", c.Limits.MaxArchiveEntries)
+	}
+}
+
+func TestMetricsListenOnLoopbackByDefault(t *testing.T) {
+	required(t)
+	c, err := Load("test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Metrics.Addr != "127.0.0.1:9090" {
+		t.Errorf("metrics.addr = %q", c.Metrics.Addr)
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	required(t)
+	t.Setenv("DB_PORT", "abc")
+	t.Setenv("API_RATE_LIMIT", "fast")
+	_, err := Load("test", []string{"--cookie-samesite", "sometimes"})
+	if err == nil {
+		t.Fatal("invalid settings accepted")
+	}
+	for _, want := range []string{"db.port (from env DB_PORT)", "limits.rate_limit (from env API_RATE_LIMIT)"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+
+	t.Setenv("DB_PORT", "5432")
+	t.Setenv("API_RATE_LIMIT", "0")
+	t.Setenv("JWT_SECRET", "")
+	_, err = Load("test", []string{"--cookie-samesite", "none"})
+	if err == nil {
+		t.Fatal("invalid settings accepted")
+	}
+	for _, want := range []string{"limits.rate_limit", "auth.jwt_secret", "cookie.samesite"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
+func TestLoadRejectsWeakJWTSecret(t *testing.T) {
+	required(t)
+	for _, secret := range []string{"s3cret", "change-me-to-a-long-random-string-0123"} {
+		t.Setenv("JWT_SECRET", secret)
+		_, err := Load("test", nil)
+		if err == nil || !strings.Contains(err.Error(), "auth.jwt_secret") {
+			t.Errorf("JWT_SECRET=%s: err = %v", secret, err)
+		}
+	}
+}
+
+func TestLoadRejectsUnknownFileSetting(t *testing.T) {
+	required(t)
+	file := filepath.Join(t.TempDir(), "vault.json")
+	if err := os.WriteFile(file, []byte(`{"db": {"hots": "x"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Load("test", []string{"--config", file})
+	if err == nil || !strings.Contains(err.Error(), "unknown setting db.hots") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	required(t)
+	t.Setenv("DB_PASSWORD", "hunter2")
+	c, err := Load("test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	c.Print(&out)
+	if strings.Contains(out.String(), "hunter2") || strings.Contains(out.String(), testSecret) {
+		t.Errorf("secret printed:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "[redacted]") {
+		t.Errorf("no redacted values:\n%s", out.String())
+	}
+}
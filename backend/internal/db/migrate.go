@@ -0,0 +1,279 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+
+	"backend/migrations"
+)
+
+// Migration is one numbered schema change and its reverse.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is a migration and when, if ever, it was applied. Known
+// is false for a version recorded in the database that this binary has no
+// file for.
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+	Known     bool       `json:"known"`
+}
+
+var (
+	ErrUntrackedSchema = errors.New(`database has tables but no migration history; record the migrations already applied with "vaultctl migrate baseline VERSION"`)
+	ErrSchemaTooNew    = errors.New("database schema is newer than this binary")
+)
+
+// migrationLock names the advisory lock held while migrating, so that
+// servers starting together apply each migration once.
+const migrationLock = "schema_migrations"
+
+const createSchemaMigrations = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    INT PRIMARY KEY,
+    name       TEXT NOT NULL,
+    applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+)`
+
+var migrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// LoadMigrations reads N_name.up.sql and N_name.down.sql pairs from fsys,
+// ordered by version. Versions must run from 1 without gaps.
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		m := migrationFile.FindStringSubmatch(e.Name())
+		if m == nil {
+			if e.Name() != "migrations.go" {
+				return nil, fmt.Errorf("migration %s: name must look like 1_create_users.up.sql", e.Name())
+			}
+			continue
+		}
+		version, _ := strconv.Atoi(m[1])
+		sql, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		mig := byVersion[version]
+		if mig == nil {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(sql)
+		} else {
+			mig.Down = string(sql)
+		}
+	}
+
+	list := make([]Migration, 0, len(byVersion))
+	for v := 1; v <= len(byVersion); v++ {
+		mig := byVersion[v]
+		if mig == nil {
+			return nil, fmt.Errorf("migration %d is missing", v)
+		}
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", v, mig.Name)
+		}
+		list = append(list, *mig)
+	}
+	return list, nil
+}
+
+// Migrator applies the embedded migrations and records them in
+// schema_migrations. Each migration runs in its own transaction, and the
+// whole run holds an advisory lock.
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+func NewMigrator(db *gorm.DB) (*Migrator, error) {
+	list, err := LoadMigrations(migrations.FS)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: list}, nil
+}
+
+// Latest returns the highest version this binary knows.
+func (m *Migrator) Latest() int {
+	return len(m.migrations)
+}
+
+// locked runs fn on a single connection holding the migration lock.
+func (m *Migrator) locked(fn func(conn *gorm.DB) error) error {
+	return m.db.Connection(func(conn *gorm.DB) error {
+		if err := conn.Exec("SELECT pg_advisory_lock(hashtext(?))", migrationLock).Error; err != nil {
+			return err
+		}
+		defer conn.Exec("SELECT pg_advisory_unlock(hashtext(?))", migrationLock)
+		if err := conn.Exec(createSchemaMigrations).Error; err != nil {
+			return err
+		}
+		return fn(conn)
+	})
+}
+
+type appliedMigration struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+}
+
+func applied(conn *gorm.DB) ([]appliedMigration, error) {
+	var rows []appliedMigration
+	err := conn.Raw("SELECT version, name, applied_at FROM schema_migrations ORDER BY version").Scan(&rows).Error
+	return rows, err
+}
+
+// Up applies every migration not yet applied, up to and including version
+// to, or all of them when to is 0. It returns the migrations it applied.
+func (m *Migrator) Up(to int) ([]Migration, error) {
+	if to <= 0 || to > m.Latest() {
+		to = m.Latest()
+	}
+	var done []Migration
+	err := m.locked(func(conn *gorm.DB) error {
+		rows, err := applied(conn)
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			var untracked bool
+			if err := conn.Raw("SELECT to_regclass('users') IS NOT NULL").Scan(&untracked).Error; err != nil {
+				return err
+			}
+			if untracked {
+				return ErrUntrackedSchema
+			}
+		}
+		have := map[int]bool{}
+		for _, r := range rows {
+			if r.Version > m.Latest() {
+				return fmt.Errorf("%w: database has migration %d, this binary knows up to %d", ErrSchemaTooNew, r.Version, m.Latest())
+			}
+			have[r.Version] = true
+		}
+
+		for _, mig := range m.migrations[:to] {
+			if have[mig.Version] {
+				continue
+			}
+			err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Exec(mig.Up).Error; err != nil {
+					return err
+				}
+				return tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", mig.Version, mig.Name).Error
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", mig.Version, mig.Name, err)
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts the steps most recently applied migrations, newest first,
+// and returns them.
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(func(conn *gorm.DB) error {
+		rows, err := applied(conn)
+		if err != nil {
+			return err
+		}
+		slices.Reverse(rows)
+		for _, r := range rows[:min(steps, len(rows))] {
+			if r.Version > m.Latest() {
+				return fmt.Errorf("%w: no down file for migration %d", ErrSchemaTooNew, r.Version)
+			}
+			mig := m.migrations[r.Version-1]
+			err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Exec(mig.Down).Error; err != nil {
+					return err
+				}
+				return tx.Exec("DELETE FROM schema_migrations WHERE version = ?", mig.Version).Error
+			})
+			if err != nil {
+				return fmt.Errorf("reverting migration %d_%s: %w", mig.Version, mig.Name, err)
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Baseline records migrations 1 to version as applied without running
+// them, for databases whose schema was built by hand before migrations
+// were tracked.
+func (m *Migrator) Baseline(version int) error {
+	if version < 1 || version > m.Latest() {
+		return fmt.Errorf("version must be between 1 and %d", m.Latest())
+	}
+	return m.locked(func(conn *gorm.DB) error {
+		rows, err := applied(conn)
+		if err != nil {
+			return err
+		}
+		if len(rows) > 0 {
+			return errors.New("migration history already exists")
+		}
+		return conn.Transaction(func(tx *gorm.DB) error {
+			for _, mig := range m.migrations[:version] {
+				if err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", mig.Version, mig.Name).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// Status lists every known migration and any unknown ones the database
+// has applied, in version order.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	var rows []appliedMigration
+	err := m.locked(func(conn *gorm.DB) error {
+		var err error
+		rows, err = applied(conn)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	status := make([]MigrationStatus, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		status = append(status, MigrationStatus{Version: mig.Version, Name: mig.Name, Known: true})
+	}
+	for _, r := range rows {
+		if r.Version <= m.Latest() {
+			status[r.Version-1].AppliedAt = &r.AppliedAt
+			continue
+		}
+		status = append(status, MigrationStatus{Version: r.Version, Name: r.Name, AppliedAt: &r.AppliedAt})
+	}
+	return status, nil
+}
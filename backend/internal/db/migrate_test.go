@@ -0,0 +1,298 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+
+	"backend/internal/models"
+	"backend/migrations"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	list, err := LoadMigrations(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	for i, m := range list {
+		if m.Version != i+1 {
+			t.Errorf("migration %d at position %d", m.Version, i)
+		}
+	}
+}
+
+// allModels lists every model stored in its own table.
+var allModels = []any{
+	&models.User{}, &models.File{}, &models.UserFile{}, &models.BlobDeletion{},
+	&models.FilePreview{}, &models.FileContent{}, &models.UserFileTag{}, &models.UserFileMetadata{},
+	&models.AuditEvent{}, &models.DownloadEvent{}, &models.OutboxEvent{}, &models.Webhook{},
+	&models.WebhookDelivery{}, &models.Folder{}, &models.PersonalToken{}, &models.AccessKey{},
+	&models.MultipartUpload{}, &models.MultipartPart{}, &models.SSHKey{}, &models.FileChange{},
+}
+
+// migratedDB applies every migration to a scratch schema in the database
+// named by TEST_DATABASE_DSN, skipping the test when it is not set. The
+// schema is dropped when the test finishes.
+func migratedDB(t *testing.T) (*gorm.DB, *Migrator) {
+	t.Helper()
+	conn, m := scratchDB(t)
+	if _, err := m.Up(0); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	return conn, m
+}
+
+// scratchDB is migratedDB without the migrations applied.
+func scratchDB(t *testing.T) (*gorm.DB, *Migrator) {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN not set; skipping database test")
+	}
+	cfg := &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)}
+	admin, err := gorm.Open(postgres.Open(dsn), cfg)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	name := fmt.Sprintf("migrate_test_%d", time.Now().UnixNano())
+	if err := admin.Exec("CREATE SCHEMA " + name).Error; err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() { admin.Exec("DROP SCHEMA " + name + " CASCADE") })
+
+	if strings.Contains(dsn, "://") {
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		dsn += sep + "search_path=" + name
+	} else {
+		dsn += " search_path=" + name
+	}
+	conn, err := gorm.Open(postgres.Open(dsn), cfg)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	m, err := NewMigrator(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, m
+}
+
+type dbColumn struct {
+	TableName  string
+	ColumnName string
+	DataType   string
+	IsNullable string
+}
+
+// TestModelsMatchSchema checks that every model field has a column of a
+// compatible type in the migrated schema, that fields the model requires
+// are NOT NULL and pointer fields nullable, and that the model's indexes
+// exist.
+func TestModelsMatchSchema(t *testing.T) {
+	conn, _ := migratedDB(t)
+
+	var cols []dbColumn
+	err := conn.Raw(`
+		SELECT table_name, column_name, data_type, is_nullable
+		FROM information_schema.columns WHERE table_schema = current_schema()`).Scan(&cols).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns := map[string]dbColumn{}
+	for _, c := range cols {
+		columns[c.TableName+"."+c.ColumnName] = c
+	}
+
+	// Index column lists per table, as "col1,col2", unique ones marked
+	var idx []struct {
+		TableName string
+		Columns   string
+		IsUnique  bool
+	}
+	err = conn.Raw(`
+		SELECT t.relname AS table_name, i.indisunique AS is_unique,
+		       string_agg(a.attname, ',' ORDER BY k.ord) AS columns
+		FROM pg_index i
+		JOIN pg_class t ON t.oid = i.indrelid
+		JOIN pg_namespace n ON n.oid = t.relnamespace AND n.nspname = current_schema()
+		CROSS JOIN LATERAL unnest(i.indkey) WITH ORDINALITY AS k(attnum, ord)
+		JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = k.attnum
+		GROUP BY t.relname, i.indexrelid, i.indisunique`).Scan(&idx).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasIndex := func(table string, cols []string, unique bool) bool {
+		want := strings.Join(cols, ",")
+		for _, i := range idx {
+			if i.TableName != table {
+				continue
+			}
+			// A unique index must match exactly; any other index may be
+			// the leading columns of a wider one
+			if i.Columns == want && (i.IsUnique || !unique) {
+				return true
+			}
+			if !unique && strings.HasPrefix(i.Columns, want+",") {
+				return true
+			}
+		}
+		return false
+	}
+
+	cache := &sync.Map{}
+	for _, model := range allModels {
+		s, err := schema.Parse(model, cache, conn.NamingStrategy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range s.Fields {
+			if f.DBName == "" {
+				continue
+			}
+			key := s.Table + "." + f.DBName
+			col, ok := columns[key]
+			if !ok {
+				t.Errorf("%s.%s: no column %s", s.Name, f.Name, key)
+				continue
+			}
+			if (f.NotNull || f.PrimaryKey) && col.IsNullable != "NO" {
+				t.Errorf("%s.%s: model requires a value but %s is nullable", s.Name, f.Name, key)
+			}
+			if f.FieldType.Kind() == reflect.Pointer && col.IsNullable != "YES" {
+				t.Errorf("%s.%s: model allows nil but %s is NOT NULL", s.Name, f.Name, key)
+			}
+			if !compatibleType(f, col.DataType) {
+				t.Errorf("%s.%s: %s column %s for a %s field", s.Name, f.Name, col.DataType, key, f.FieldType)
+			}
+			if f.Unique && !hasIndex(s.Table, []string{f.DBName}, true) {
+				t.Errorf("%s.%s: no unique index on %s", s.Name, f.Name, key)
+			}
+		}
+		for _, i := range s.ParseIndexes() {
+			var cols []string
+			for _, o := range i.Fields {
+				cols = append(cols, o.DBName)
+			}
+			if !hasIndex(s.Table, cols, i.Class == "UNIQUE") {
+				t.Errorf("%s: no %s index %s on %s(%s)", s.Name, strings.ToLower(i.Class), i.Name, s.Table, strings.Join(cols, ", "))
+			}
+		}
+	}
+}
+
+func compatibleType(f *schema.Field, dataType string) bool {
+	if f.TagSettings["TYPE"] != "" {
+		return strings.EqualFold(f.TagSettings["TYPE"], dataType)
+	}
+	typ := f.FieldType
+	if typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	switch typ.Kind() {
+	case reflect.Bool:
+		return dataType == "boolean"
+	case reflect.Int64:
+		return dataType == "bigint"
+	case reflect.Int, reflect.Int32, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return dataType == "integer" || dataType == "bigint"
+	case reflect.String:
+		return dataType == "text" || dataType == "character varying"
+	}
+	if typ == reflect.TypeOf(time.Time{}) {
+		return strings.HasPrefix(dataType, "timestamp")
+	}
+	return true
+}
+
+// TestMigrateDownUp reverts every migration and applies them again.
+func TestMigrateDownUp(t *testing.T) {
+	conn, m := migratedDB(t)
+	if _, err := m.Down(m.Latest()); err != nil {
+		t.Fatalf("down: %v", err)
+	}
+	var tables []string
+	conn.Raw(`SELECT table_name FROM information_schema.tables
+		WHERE table_schema = current_schema() AND table_name <> 'schema_migrations'`).Scan(&tables)
+	if len(tables) > 0 {
+		t.Errorf("tables left after reverting everything: %v", tables)
+	}
+	done, err := m.Up(0)
+	if err != nil {
+		t.Fatalf("up again: %v", err)
+	}
+	if len(done) != m.Latest() {
+		t.Errorf("applied %d migrations, want %d", len(done), m.Latest())
+	}
+}
+
+// TestUniqueFileHashCollapsesDuplicates runs 5_unique_file_hash over rows
+// left by racing uploads: one user holding both rows of one content, and
+// another user holding the second.
+func TestUniqueFileHashCollapsesDuplicates(t *testing.T) {
+	conn, m := scratchDB(t)
+	if _, err := m.Up(4); err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		`INSERT INTO users (id, username, email, password) VALUES (1, 'a', 'a@example.com', 'x'), (2, 'b', 'b@example.com', 'x')`,
+		`INSERT INTO files (id, filename, size, hash, storage_path, mime_type) VALUES
+			(1, 'a.txt', 10, 'h', 'blobs/1', 'text/plain'),
+			(2, 'a.txt', 10, 'h', 'blobs/2', 'text/plain')`,
+		`INSERT INTO user_files (id, user_id, file_id, file_name, is_owner) VALUES
+			(1, 1, 1, 'a.txt', TRUE), (2, 1, 2, 'copy.txt', TRUE), (3, 2, 2, 'b.txt', TRUE)`,
+	} {
+		if err := conn.Exec(stmt).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.Up(0); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+
+	var refs []struct {
+		ID      uint
+		FileID  uint
+		IsOwner bool
+	}
+	conn.Raw("SELECT id, file_id, is_owner FROM user_files ORDER BY id").Scan(&refs)
+	if len(refs) != 3 {
+		t.Fatalf("%d references left, want all 3", len(refs))
+	}
+	for _, r := range refs {
+		if r.FileID != 1 || r.IsOwner != (r.ID == 1) {
+			t.Errorf("reference %+v, want file 1 owned by reference 1 only", r)
+		}
+	}
+
+	var queued []string
+	conn.Raw("SELECT storage_path FROM blob_deletions").Scan(&queued)
+	if len(queued) != 1 || queued[0] != "blobs/2" {
+		t.Errorf("queued %v, want the dropped row's blob", queued)
+	}
+	var refCount int
+	conn.Raw("SELECT ref_count FROM files WHERE id = 1").Scan(&refCount)
+	if refCount != 3 {
+		t.Errorf("ref_count %d, want 3", refCount)
+	}
+	var storage []struct{ ActualStorage, ExpectedStorage int64 }
+	conn.Raw("SELECT actual_storage, expected_storage FROM users ORDER BY id").Scan(&storage)
+	if len(storage) != 2 || storage[0].ActualStorage != 10 || storage[0].ExpectedStorage != 20 ||
+		storage[1].ActualStorage != 0 || storage[1].ExpectedStorage != 10 {
+		t.Errorf("storage %+v", storage)
+	}
+}
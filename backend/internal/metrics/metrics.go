@@ -0,0 +1,205 @@
+// Package metrics defines the server's Prometheus metrics. Labels name
+// routes, protocols and outcomes, never users, files or paths, so the
+// exporter reveals nothing about who stores what and the number of series
+// stays bounded.
+package metrics
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"backend/internal/repository"
+)
+
+const namespace = "vault"
+
+// registry holds every metric the server exports, plus the Go runtime and
+// process collectors.
+var registry = prometheus.NewRegistry()
+
+// transferBuckets span a small file on a fast link to a large one on a
+// slow link: 50ms to about 7 minutes.
+var transferBuckets = prometheus.ExponentialBuckets(0.05, 2, 14)
+
+var (
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Name: "http_requests_total",
+		Help: "HTTP requests handled, by listener, method, route and status.",
+	}, []string{"server", "method", "route", "status"})
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace, Name: "http_request_duration_seconds",
+		Help:    "Time taken to handle HTTP requests, by listener, method, route and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"server", "method", "route", "status"})
+
+	uploadBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Name: "upload_bytes_total",
+		Help: "Bytes received in successful uploads, by protocol.",
+	}, []string{"protocol"})
+	uploadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace, Name: "upload_duration_seconds",
+		Help:    "Time taken by successful uploads, by protocol.",
+		Buckets: transferBuckets,
+	}, []string{"protocol"})
+	downloadBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Name: "download_bytes_total",
+		Help: "Bytes sent in downloads, by protocol.",
+	}, []string{"protocol"})
+	downloadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace, Name: "download_duration_seconds",
+		Help:    "Time taken by downloads, by protocol.",
+		Buckets: transferBuckets,
+	}, []string{"protocol"})
+
+	dedupLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Name: "dedup_lookups_total",
+		Help: `Uploads stored, by whether their content was already stored ("hit") or new ("miss").`,
+	}, []string{"result"})
+	dedupHits, dedupMisses atomic.Int64
+
+	rateLimited = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Name: "rate_limit_rejections_total",
+		Help: "API requests rejected by the per-user rate limit.",
+	})
+	loginFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Name: "login_failures_total",
+		Help: "Sign-ins rejected for wrong credentials, by protocol.",
+	}, []string{"protocol"})
+	auditDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Name: "audit_entries_dropped_total",
+		Help: "Audit entries that could not be written to the log.",
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requests, requestDuration,
+		uploadBytes, uploadDuration, downloadBytes, downloadDuration,
+		dedupLookups, rateLimited, loginFailures, auditDropped,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "dedup_hit_ratio",
+			Help: "Share of uploads since the server started whose content was already stored.",
+		}, dedupHitRatio),
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records an HTTP request. route must be the pattern the
+// request matched, not its path.
+func ObserveRequest(server, method, route string, status int, d time.Duration) {
+	code := strconv.Itoa(status)
+	requests.WithLabelValues(server, method, route, code).Inc()
+	requestDuration.WithLabelValues(server, method, route, code).Observe(d.Seconds())
+}
+
+func ObserveUpload(protocol string, bytes int64, d time.Duration) {
+	uploadBytes.WithLabelValues(protocol).Add(float64(bytes))
+	uploadDuration.WithLabelValues(protocol).Observe(d.Seconds())
+}
+
+func ObserveDownload(protocol string, bytes int64, d time.Duration) {
+	downloadBytes.WithLabelValues(protocol).Add(float64(bytes))
+	downloadDuration.WithLabelValues(protocol).Observe(d.Seconds())
+}
+
+// DedupLookup records whether a stored upload's content was already in
+// the vault.
+func DedupLookup(hit bool) {
+	if hit {
+		dedupHits.Add(1)
+		dedupLookups.WithLabelValues("hit").Inc()
+	} else {
+		dedupMisses.Add(1)
+		dedupLookups.WithLabelValues("miss").Inc()
+	}
+}
+
+func dedupHitRatio() float64 {
+	hits, misses := dedupHits.Load(), dedupMisses.Load()
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+func RateLimited() {
+	rateLimited.Inc()
+}
+
+func LoginFailed(protocol string) {
+	loginFailures.WithLabelValues(protocol).Inc()
+}
+
+// AuditDropped records n audit entries given up on after failed writes.
+func AuditDropped(n int) {
+	auditDropped.Add(float64(n))
+}
+
+// RegisterDB exports the connection pool's statistics.
+func RegisterDB(db *sql.DB) {
+	registry.MustRegister(collectors.NewDBStatsCollector(db, "vault"))
+}
+
+// RegisterStorage exports the vault's storage totals. They are read from
+// the database at most once per ttl, however often Prometheus scrapes.
+func RegisterStorage(totals func() (repository.StorageTotals, error), ttl time.Duration) {
+	registry.MustRegister(&storageCollector{totals: totals, ttl: ttl})
+}
+
+var (
+	blobsDesc = prometheus.NewDesc(namespace+"_blobs",
+		"Distinct blobs stored.", nil, nil)
+	storedBytesDesc = prometheus.NewDesc(namespace+"_stored_bytes",
+		"Bytes of blobs on disk, each stored once however many files share it.", nil, nil)
+	logicalBytesDesc = prometheus.NewDesc(namespace+"_logical_bytes",
+		"Bytes of every user's files counted in full, as quotas count them.", nil, nil)
+)
+
+type storageCollector struct {
+	totals func() (repository.StorageTotals, error)
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	last    repository.StorageTotals
+	fetched time.Time
+}
+
+func (sc *storageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- blobsDesc
+	ch <- storedBytesDesc
+	ch <- logicalBytesDesc
+}
+
+func (sc *storageCollector) Collect(ch chan<- prometheus.Metric) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if time.Since(sc.fetched) >= sc.ttl {
+		t, err := sc.totals()
+		if err != nil {
+			log.Printf("metrics: storage totals: %v", err)
+			if sc.fetched.IsZero() {
+				return
+			}
+		} else {
+			sc.last, sc.fetched = t, time.Now()
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(blobsDesc, prometheus.GaugeValue, float64(sc.last.Blobs))
+	ch <- prometheus.MustNewConstMetric(storedBytesDesc, prometheus.GaugeValue, float64(sc.last.StoredBytes))
+	ch <- prometheus.MustNewConstMetric(logicalBytesDesc, prometheus.GaugeValue, float64(sc.last.LogicalBytes))
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+// AccessKey is an S3 access key pair. SigV4 needs the secret itself to
+// check signatures, so it is stored as issued and never serialized.
+type AccessKey struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	UserID      uint       `gorm:"not null;index" json:"user_id"`
+	AccessKeyID string     `gorm:"not null;uniqueIndex" json:"access_key_id"`
+	Secret      string     `gorm:"not null" json:"-"`
+	Name        string     `gorm:"not null;default:''" json:"name"`
+	CreatedAt   time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Audit outcomes, derived from the response status.
+const (
+	AuditSuccess = "success"
+	AuditDenied  = "denied"
+	AuditFailure = "failure"
+)
+
+// AuditEvent is one entry in the append-only audit log. Each entry's Hash
+// covers its own fields and the previous entry's hash, so editing, removing
+// or reordering rows breaks the chain from that point on.
+type AuditEvent struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt  time.Time `gorm:"not null" json:"created_at"`
+	ActorID    *uint     `json:"actor_id"`
+	ActorName  string    `gorm:"not null;default:''" json:"actor_name"`
+	Action     string    `gorm:"not null" json:"action"`
+	UserFileID *uint     `json:"user_file_id"`
+	FileID     *uint     `json:"file_id"`
+	IP         string    `gorm:"not null;default:''" json:"ip"`
+	UserAgent  string    `gorm:"not null;default:''" json:"user_agent"`
+	Outcome    string    `gorm:"not null" json:"outcome"`
+	Status     int       `gorm:"not null" json:"status"`
+	Detail     string    `gorm:"not null;default:''" json:"detail"`
+	PrevHash   string    `gorm:"not null" json:"prev_hash"`
+	Hash       string    `gorm:"not null" json:"hash"`
+}
+
+func (AuditEvent) TableName() string {
+	return "audit_log"
+}
+
+// ComputeHash returns the chain hash of the event given its PrevHash. The
+// timestamp is hashed at microsecond precision in UTC, as Postgres stores it.
+func (e *AuditEvent) ComputeHash() string {
+	canonical, _ := json.Marshal([]interface{}{
+		e.ID,
+		e.CreatedAt.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano),
+		e.ActorID,
+		e.ActorName,
+		e.Action,
+		e.UserFileID,
+		e.FileID,
+		e.IP,
+		e.UserAgent,
+		e.Outcome,
+		e.Status,
+		e.Detail,
+		e.PrevHash,
+	})
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// BlobDeletion is a queued request to remove a blob from disk once its last
+// reference is gone. Rows are written in the same transaction that deletes
+// the files row and are retried until the removal succeeds.
+type BlobDeletion struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	Hash          string    `gorm:"not null" json:"hash"`
+	StoragePath   string    `gorm:"not null" json:"storage_path"`
+	Attempts      int       `gorm:"not null;default:0" json:"attempts"`
+	LastError     *string   `json:"last_error"`
+	NextAttemptAt time.Time `gorm:"not null;index" json:"next_attempt_at"`
+	CreatedAt     time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
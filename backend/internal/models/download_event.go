@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+)
+
+// How a download reached the file.
+const (
+	DownloadViaLink   = "link"
+	DownloadViaDirect = "direct"
+	DownloadViaZip    = "zip"
+)
+
+// DownloadEvent records one download of a user file. LinkID identifies the
+// public link used, if any, and IP is stored with its host bits zeroed.
+type DownloadEvent struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	UserFileID uint      `gorm:"not null" json:"user_file_id"`
+	FileID     uint      `gorm:"not null" json:"file_id"`
+	LinkID     *string   `json:"link_id"`
+	UserID     *uint     `json:"user_id"` // downloader, for authenticated downloads
+	Via        string    `gorm:"not null" json:"via"`
+	IP         string    `gorm:"not null;default:''" json:"ip"`
+	UserAgent  string    `gorm:"not null;default:''" json:"user_agent"`
+	Referrer   string    `gorm:"not null;default:''" json:"referrer"`
+	BytesSent  int64     `gorm:"not null" json:"bytes_sent"`
+	Completed  bool      `gorm:"not null" json:"completed"`
+	CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"`
+
+	UserFile UserFile `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+)
+
+// Operations recorded in the change feed.
+const (
+	ChangeCreated  = "created"
+	ChangeModified = "modified"
+	ChangeDeleted  = "deleted"
+)
+
+// FileChange records that the file at a path appeared, changed or went
+// away. Seq counts up per user, in commit order, and is the cursor sync
+// clients resume from. For deletions the file fields describe nothing and
+// are left empty.
+type FileChange struct {
+	ID         uint      `gorm:"primaryKey" json:"-"`
+	UserID     uint      `gorm:"not null;uniqueIndex:user_change_seq_idx" json:"-"`
+	Seq        int64     `gorm:"not null;uniqueIndex:user_change_seq_idx" json:"seq"`
+	Op         string    `gorm:"not null" json:"op"`
+	Folder     string    `gorm:"not null" json:"folder"`
+	FileName   string    `gorm:"not null" json:"filename"`
+	UserFileID *uint     `json:"id,omitempty"`
+	FileID     *uint     `json:"file_id,omitempty"`
+	Hash       string    `gorm:"not null;default:''" json:"hash,omitempty"`
+	Size       int64     `gorm:"not null;default:0" json:"size"`
+	ChangedAt  time.Time `gorm:"autoCreateTime" json:"changed_at"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	ContentPending     = "pending"
+	ContentIndexed     = "indexed"
+	ContentFailed      = "failed"
+	ContentUnsupported = "unsupported"
+)
+
+// FileContent holds the text extracted from a blob for full-text search. It
+// is keyed by content hash, so each distinct file is extracted and indexed
+// once no matter how many users reference it. The tsv column is generated by
+// Postgres from Content and is not mapped here.
+type FileContent struct {
+	Hash      string    `gorm:"primaryKey" json:"hash"`
+	Status    string    `gorm:"not null;default:'pending';index" json:"status"`
+	Content   *string   `json:"-"`
+	Error     *string   `json:"error"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	PreviewPending     = "pending"
+	PreviewReady       = "ready"
+	PreviewFailed      = "failed"
+	PreviewUnsupported = "unsupported"
+
+	PreviewKindImage = "image"
+	PreviewKindText  = "text"
+)
+
+// FilePreview caches generated previews per content hash, so deduplicated
+// files share one set of thumbnails and snippets.
+type FilePreview struct {
+	Hash      string    `gorm:"primaryKey" json:"hash"`
+	Status    string    `gorm:"not null;default:'pending';index" json:"status"`
+	Kind      string    `gorm:"not null;default:''" json:"kind"`
+	Sizes     string    `gorm:"not null;default:''" json:"sizes"` // comma-separated thumbnail size names
+	Snippet   *string   `json:"snippet"`
+	Error     *string   `json:"error"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+)
+
+// Folder is a folder created explicitly, for example over WebDAV, so that it
+// exists before anything is stored in it. Folders that contain files exist
+// implicitly through user_files.folder and need no row.
+type Folder struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"not null;uniqueIndex:user_folder_idx" json:"user_id"`
+	Path      string    `gorm:"not null;uniqueIndex:user_folder_idx" json:"path"` // normalized like user_files.folder
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+func (Folder) TableName() string {
+	return "user_folders"
+}
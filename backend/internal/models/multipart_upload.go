@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+)
+
+// MultipartUpload is an S3 multipart upload in progress. Its parts are
+// kept on disk until the upload is completed or aborted.
+type MultipartUpload struct {
+	ID        string    `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"not null;index" json:"user_id"`
+	Key       string    `gorm:"not null" json:"key"`
+	CreatedAt time.Time `gorm:"autoCreateTime;index" json:"created_at"`
+}
+
+// MultipartPart is one uploaded part; ETag is the MD5 of its content.
+type MultipartPart struct {
+	UploadID   string    `gorm:"primaryKey" json:"upload_id"`
+	PartNumber int       `gorm:"primaryKey" json:"part_number"`
+	Size       int64     `gorm:"not null" json:"size"`
+	ETag       string    `gorm:"not null" json:"etag"`
+	UpdatedAt  time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+)
+
+// PersonalToken is a long-lived credential a user issues for clients that
+// cannot use the session cookie, such as WebDAV mounts and scripts. Only
+// the SHA-256 of the token is stored; Prefix identifies it in listings.
+type PersonalToken struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UserID     uint       `gorm:"not null;index" json:"user_id"`
+	Name       string     `gorm:"not null" json:"name"`
+	Prefix     string     `gorm:"not null" json:"prefix"`
+	TokenHash  string     `gorm:"not null;uniqueIndex" json:"-"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
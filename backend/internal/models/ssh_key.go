@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// SSHKey is a public key a user has uploaded to sign in over SFTP. The
+// fingerprint is unique, so a key identifies exactly one account.
+type SSHKey struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	UserID      uint       `gorm:"not null;index" json:"user_id"`
+	Name        string     `gorm:"not null;default:''" json:"name"`
+	Fingerprint string     `gorm:"not null;uniqueIndex" json:"fingerprint"`
+	PublicKey   string     `gorm:"not null" json:"public_key"` // authorized_keys format
+	CreatedAt   time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+}
+
+func (SSHKey) TableName() string {
+	return "ssh_keys"
+}
@@ -0,0 +1,24 @@
+package models
+
+// UserFileTag is a user-defined label on one of their files. Tags belong to
+// the user_files entry, so users sharing deduplicated content tag it
+// independently.
+type UserFileTag struct {
+	UserFileID uint   `gorm:"primaryKey;autoIncrement:false" json:"user_file_id"`
+	Tag        string `gorm:"primaryKey;index" json:"tag"`
+
+	UserFile UserFile `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+}
+
+// UserFileMetadata is an arbitrary key/value pair attached to a user file.
+type UserFileMetadata struct {
+	UserFileID uint   `gorm:"primaryKey;autoIncrement:false" json:"user_file_id"`
+	Key        string `gorm:"primaryKey" json:"key"`
+	Value      string `gorm:"not null" json:"value"`
+
+	UserFile UserFile `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+}
+
+func (UserFileMetadata) TableName() string {
+	return "user_file_metadata"
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+    "time"
+
     "golang.org/x/crypto/bcrypt"
 )
 
@@ -12,6 +14,18 @@ type User struct {
 
     ActualStorage   int64  `gorm:"not null;default:0" json:"actual_storage"`
     ExpectedStorage int64  `gorm:"not null;default:0" json:"expected_storage"`
+
+    IsAdmin         bool   `gorm:"not null;default:false" json:"is_admin"`
+
+    ChangeSeq       int64  `gorm:"not null;default:0" json:"-"` // last file_changes.seq handed out
+
+    DisabledAt      *time.Time `json:"disabled_at,omitempty"`
+    QuotaBytes      *int64     `json:"quota_bytes,omitempty"` // nil uses the server-wide quota
+}
+
+// Disabled reports whether an administrator has disabled the account.
+func (u *User) Disabled() bool {
+    return u.DisabledAt != nil
 }
 
 
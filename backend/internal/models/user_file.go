@@ -7,9 +7,10 @@ import(
 type UserFile struct {
     ID     uint `gorm:"primaryKey" json:"id"`
 
-    UserID uint `gorm:"not null;uniqueIndex:user_file_idx" json:"user_id"`
-    FileID uint `gorm:"not null;uniqueIndex:user_file_idx" json:"file_id"`
+    UserID uint `gorm:"not null;index:user_file_idx" json:"user_id"`
+    FileID uint `gorm:"not null;index:user_file_idx" json:"file_id"`
 	FileName string  `gorm:"not null" json:"file_name"`
+	Folder   string  `gorm:"not null;default:''" json:"folder"` // slash-separated, "" is the root
 	UploadedAt  time.Time `gorm:"autoCreateTime" json:"uploaded_at"`
 	DownloadTimes int  `gorm:"not null;default:0" json:"download_times"`
 
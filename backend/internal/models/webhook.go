@@ -0,0 +1,79 @@
+package models
+
+import (
+	"time"
+)
+
+// File lifecycle event types carried by the outbox.
+const (
+	EventFileUploaded    = "file.uploaded"
+	EventFileDeleted     = "file.deleted"
+	EventFileShared      = "file.shared" // a new public link was issued
+	EventFileMadePublic  = "file.made_public"
+	EventFileMadePrivate = "file.made_private"
+	EventFileRenamed     = "file.renamed"
+	// EventFileSharedWithYou is addressed to a user another user's file
+	// was transferred to.
+	EventFileSharedWithYou = "file.shared_with_you"
+	EventQuotaThreshold    = "quota.threshold_crossed"
+)
+
+// Webhook delivery states.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// OutboxEvent is a domain event written in the same transaction as the
+// change it describes, then dispatched to subscribers by a background
+// worker. Payload is the JSON body sent to webhooks.
+type OutboxEvent struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	Type         string     `gorm:"not null" json:"type"`
+	UserID       uint       `gorm:"not null" json:"user_id"`
+	Payload      string     `gorm:"type:jsonb;not null" json:"payload"`
+	CreatedAt    time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	DispatchedAt *time.Time `json:"dispatched_at"`
+}
+
+func (OutboxEvent) TableName() string {
+	return "event_outbox"
+}
+
+// Webhook is an endpoint that receives signed event payloads. User webhooks
+// receive events about their owner's files; global ones, which only admins
+// can register, receive every event. Events is a comma-separated list of
+// event types, empty for all.
+type Webhook struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"not null;index" json:"user_id"`
+	Global    bool      `gorm:"not null;default:false" json:"global"`
+	URL       string    `gorm:"not null" json:"url"`
+	Secret    string    `gorm:"not null" json:"-"`
+	Events    string    `gorm:"not null;default:''" json:"events"`
+	Active    bool      `gorm:"not null;default:true" json:"active"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+
+	User User `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+}
+
+// WebhookDelivery is one event queued for one webhook, retried with backoff
+// until it succeeds or runs out of attempts. It doubles as the delivery log.
+type WebhookDelivery struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	WebhookID      uint       `gorm:"not null;index" json:"webhook_id"`
+	EventID        uint       `gorm:"not null" json:"event_id"`
+	EventType      string     `gorm:"not null" json:"event_type"`
+	Payload        string     `gorm:"type:jsonb;not null" json:"payload"`
+	Status         string     `gorm:"not null;default:'pending'" json:"status"`
+	Attempts       int        `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt  time.Time  `gorm:"not null" json:"next_attempt_at"`
+	LastAttemptAt  *time.Time `json:"last_attempt_at"`
+	LastStatusCode *int       `json:"last_status_code"`
+	LastError      *string    `json:"last_error"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+	CreatedAt      time.Time  `gorm:"autoCreateTime" json:"created_at"`
+
+	Webhook Webhook `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+}
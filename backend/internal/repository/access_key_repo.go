@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"backend/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type AccessKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewAccessKeyRepository(db *gorm.DB) *AccessKeyRepository {
+	return &AccessKeyRepository{db: db}
+}
+
+func (r *AccessKeyRepository) Create(k *models.AccessKey) error {
+	return r.db.Create(k).Error
+}
+
+func (r *AccessKeyRepository) ListByUser(userID uint) ([]models.AccessKey, error) {
+	var keys []models.AccessKey
+	err := r.db.Where("user_id = ?", userID).Order("id").Find(&keys).Error
+	return keys, err
+}
+
+func (r *AccessKeyRepository) GetByAccessKeyID(accessKeyID string) (*models.AccessKey, error) {
+	var k models.AccessKey
+	if err := r.db.Where("access_key_id = ?", accessKeyID).First(&k).Error; err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (r *AccessKeyRepository) Touch(id uint, at time.Time) error {
+	return r.db.Model(&models.AccessKey{}).Where("id = ?", id).UpdateColumn("last_used_at", at).Error
+}
+
+func (r *AccessKeyRepository) Delete(id, userID uint) error {
+	res := r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.AccessKey{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
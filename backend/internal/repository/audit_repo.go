@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"backend/internal/models"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type AuditRepository struct {
+	db *gorm.DB
+}
+
+func NewAuditRepository(db *gorm.DB) *AuditRepository {
+	return &AuditRepository{db: db}
+}
+
+// AuditQuery selects audit entries. Zero values leave a field unfiltered.
+type AuditQuery struct {
+	ActorID    *uint
+	Action     string
+	UserFileID *uint
+	FileID     *uint
+	Outcome    string
+	IP         string
+	From       *time.Time // inclusive
+	To         *time.Time // exclusive
+	BeforeID   uint       // newest-first paging: only entries older than this
+	Limit      int
+}
+
+// Append links events, in order, to the end of the chain and inserts
+// them. Appends are serialised, across processes too, so every entry sees
+// the hash of the one before it, and the IDs are drawn up front because
+// they are part of the hash.
+func (r *AuditRepository) Append(events []*models.AuditEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext('audit_log'))").Error; err != nil {
+			return err
+		}
+		var prev []string
+		if err := tx.Model(&models.AuditEvent{}).Order("id DESC").Limit(1).Pluck("hash", &prev).Error; err != nil {
+			return err
+		}
+		var ids []uint
+		if err := tx.Raw("SELECT nextval(pg_get_serial_sequence('audit_log', 'id')) FROM generate_series(1, ?)", len(events)).
+			Scan(&ids).Error; err != nil {
+			return err
+		}
+		slices.Sort(ids)
+
+		prevHash := ""
+		if len(prev) > 0 {
+			prevHash = prev[0]
+		}
+		now := time.Now().UTC().Truncate(time.Microsecond)
+		for i, e := range events {
+			e.ID = ids[i]
+			e.CreatedAt = now
+			e.PrevHash = prevHash
+			e.Hash = e.ComputeHash()
+			prevHash = e.Hash
+		}
+		return tx.Create(events).Error
+	})
+}
+
+// Find returns entries matching q, newest first.
+func (r *AuditRepository) Find(q AuditQuery) ([]models.AuditEvent, error) {
+	var events []models.AuditEvent
+	query := r.filtered(q)
+	if q.BeforeID > 0 {
+		query = query.Where("id < ?", q.BeforeID)
+	}
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit)
+	}
+	err := query.Order("id DESC").Find(&events).Error
+	return events, err
+}
+
+// Scan calls fn with successive batches of entries matching q, oldest
+// first, without holding the whole log in memory.
+func (r *AuditRepository) Scan(q AuditQuery, batchSize int, fn func([]models.AuditEvent) error) error {
+	var afterID uint
+	for {
+		var batch []models.AuditEvent
+		err := r.filtered(q).Where("id > ?", afterID).Order("id").Limit(batchSize).Find(&batch).Error
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := fn(batch); err != nil {
+			return err
+		}
+		afterID = batch[len(batch)-1].ID
+	}
+}
+
+func (r *AuditRepository) filtered(q AuditQuery) *gorm.DB {
+	query := r.db.Model(&models.AuditEvent{})
+	if q.ActorID != nil {
+		query = query.Where("actor_id = ?", *q.ActorID)
+	}
+	if q.Action != "" {
+		query = query.Where("action = ?", q.Action)
+	}
+	if q.UserFileID != nil {
+		query = query.Where("user_file_id = ?", *q.UserFileID)
+	}
+	if q.FileID != nil {
+		query = query.Where("file_id = ?", *q.FileID)
+	}
+	if q.Outcome != "" {
+		query = query.Where("outcome = ?", q.Outcome)
+	}
+	if q.IP != "" {
+		query = query.Where("ip = ?", q.IP)
+	}
+	if q.From != nil {
+		query = query.Where("created_at >= ?", *q.From)
+	}
+	if q.To != nil {
+		query = query.Where("created_at < ?", *q.To)
+	}
+	return query
+}
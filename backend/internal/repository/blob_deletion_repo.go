@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"backend/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type BlobDeletionRepository struct {
+	db *gorm.DB
+}
+
+func NewBlobDeletionRepository(db *gorm.DB) *BlobDeletionRepository {
+	return &BlobDeletionRepository{db: db}
+}
+
+// Enqueue schedules a blob for removal as soon as the worker next runs
+func (r *BlobDeletionRepository) Enqueue(hash, storagePath string) error {
+	return r.db.Create(&models.BlobDeletion{
+		Hash:          hash,
+		StoragePath:   storagePath,
+		NextAttemptAt: time.Now(),
+	}).Error
+}
+
+// ClaimNext locks the oldest job whose retry time has passed, returning nil
+// when none is due. Rows locked by another worker are skipped, so several
+// instances can drain the queue. Must be called inside a transaction.
+func (r *BlobDeletionRepository) ClaimNext() (*models.BlobDeletion, error) {
+	var jobs []models.BlobDeletion
+	err := r.db.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("next_attempt_at <= ?", time.Now()).
+		Order("next_attempt_at").
+		Limit(1).
+		Find(&jobs).Error
+	if err != nil || len(jobs) == 0 {
+		return nil, err
+	}
+	return &jobs[0], nil
+}
+
+func (r *BlobDeletionRepository) Complete(id uint) error {
+	return r.db.Delete(&models.BlobDeletion{}, id).Error
+}
+
+// Reschedule records a failed attempt and pushes the job back
+func (r *BlobDeletionRepository) Reschedule(id uint, lastErr string, next time.Time) error {
+	return r.db.Model(&models.BlobDeletion{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"attempts":        gorm.Expr("attempts + 1"),
+		"last_error":      lastErr,
+		"next_attempt_at": next,
+	}).Error
+}
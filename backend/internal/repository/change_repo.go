@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"backend/internal/models"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+type ChangeRepository struct {
+	db *gorm.DB
+}
+
+func NewChangeRepository(db *gorm.DB) *ChangeRepository {
+	return &ChangeRepository{db: db}
+}
+
+// Add records a change under the user's next sequence number. Bumping the
+// counter locks the user's row until the transaction ends, so concurrent
+// changes commit in sequence order. Must be called inside a transaction.
+func (r *ChangeRepository) Add(ch *models.FileChange) error {
+	var seq int64
+	err := r.db.Raw(`UPDATE users SET change_seq = change_seq + 1 WHERE id = ? RETURNING change_seq`, ch.UserID).
+		Scan(&seq).Error
+	if err != nil {
+		return err
+	}
+	if seq == 0 {
+		return errors.New("user not found")
+	}
+	ch.Seq = seq
+	return r.db.Create(ch).Error
+}
+
+// Last returns the most recent change recorded for a path, or nil if there
+// is none.
+func (r *ChangeRepository) Last(userID uint, folder, filename string) (*models.FileChange, error) {
+	var ch models.FileChange
+	err := r.db.
+		Where("user_id = ? AND folder = ? AND file_name = ?", userID, folder, filename).
+		Order("seq DESC").
+		First(&ch).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &ch, err
+}
+
+// ListAfter returns up to limit of the user's changes after seq, oldest
+// first.
+func (r *ChangeRepository) ListAfter(userID uint, seq int64, limit int) ([]models.FileChange, error) {
+	var changes []models.FileChange
+	err := r.db.
+		Where("user_id = ? AND seq > ?", userID, seq).
+		Order("seq").
+		Limit(limit).
+		Find(&changes).Error
+	return changes, err
+}
+
+// Current returns the user's latest sequence number.
+func (r *ChangeRepository) Current(userID uint) (int64, error) {
+	var user models.User
+	err := r.db.Select("change_seq").First(&user, userID).Error
+	return user.ChangeSeq, err
+}
@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// ConsistencyRepository finds where the denormalised counters and the blob
+// store have drifted from the rows they summarise.
+type ConsistencyRepository struct {
+	db *gorm.DB
+}
+
+func NewConsistencyRepository(db *gorm.DB) *ConsistencyRepository {
+	return &ConsistencyRepository{db: db}
+}
+
+// RefCountMismatch is a file whose ref_count differs from the number of
+// user_files pointing at it.
+type RefCountMismatch struct {
+	FileID   uint   `json:"file_id"`
+	Hash     string `json:"hash"`
+	RefCount int64  `json:"ref_count"`
+	Actual   int64  `json:"actual"`
+}
+
+func (r *ConsistencyRepository) RefCountMismatches() ([]RefCountMismatch, error) {
+	var rows []RefCountMismatch
+	err := r.db.Raw(`
+		SELECT f.id AS file_id, f.hash, f.ref_count, COUNT(uf.id) AS actual
+		FROM files f
+		LEFT JOIN user_files uf ON uf.file_id = f.id
+		GROUP BY f.id
+		HAVING f.ref_count <> COUNT(uf.id)
+		ORDER BY f.id`).Scan(&rows).Error
+	return rows, err
+}
+
+// StorageMismatch is a user whose storage counters differ from the sizes
+// of the files they hold. Actual counts the files they own, the blobs they
+// are charged for; expected counts every file they hold.
+type StorageMismatch struct {
+	UserID          uint   `json:"user_id"`
+	Username        string `json:"username"`
+	ActualStorage   int64  `json:"actual_storage"`
+	ExpectedStorage int64  `json:"expected_storage"`
+	Actual          int64  `json:"actual"`
+	Expected        int64  `json:"expected"`
+}
+
+const storageSums = `
+	SELECT COALESCE(SUM(f.size) FILTER (WHERE uf.is_owner), 0) AS actual,
+	       COALESCE(SUM(f.size), 0) AS expected
+	FROM user_files uf
+	JOIN files f ON f.id = uf.file_id
+	WHERE uf.user_id = u.id`
+
+func (r *ConsistencyRepository) StorageMismatches() ([]StorageMismatch, error) {
+	var rows []StorageMismatch
+	err := r.db.Raw(`
+		SELECT u.id AS user_id, u.username, u.actual_storage, u.expected_storage,
+		       s.actual, s.expected
+		FROM users u
+		CROSS JOIN LATERAL (` + storageSums + `) s
+		WHERE u.actual_storage <> s.actual OR u.expected_storage <> s.expected
+		ORDER BY u.id`).Scan(&rows).Error
+	return rows, err
+}
+
+// StorageSums returns what a user's storage counters should be.
+func (r *ConsistencyRepository) StorageSums(userID uint) (actual, expected int64, err error) {
+	var row struct{ Actual, Expected int64 }
+	err = r.db.Raw(`SELECT s.actual, s.expected FROM users u CROSS JOIN LATERAL (`+storageSums+`) s WHERE u.id = ?`, userID).
+		Scan(&row).Error
+	return row.Actual, row.Expected, err
+}
+
+// Unreferenced returns files no user holds any more.
+func (r *ConsistencyRepository) Unreferenced() ([]models.File, error) {
+	var files []models.File
+	err := r.db.
+		Where("NOT EXISTS (SELECT 1 FROM user_files uf WHERE uf.file_id = files.id)").
+		Order("id").
+		Find(&files).Error
+	return files, err
+}
+
+// EachFile calls fn with every file row, a batch at a time.
+func (r *ConsistencyRepository) EachFile(batch int, fn func(files []models.File) error) error {
+	var files []models.File
+	return r.db.Order("id").FindInBatches(&files, batch, func(tx *gorm.DB, _ int) error {
+		return fn(files)
+	}).Error
+}
+
+// PendingDeletion reports whether a blob is already queued for deletion.
+func (r *ConsistencyRepository) PendingDeletion(storagePath string) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.BlobDeletion{}).Where("storage_path = ?", storagePath).Count(&count).Error
+	return count > 0, err
+}
@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"backend/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type DownloadEventRepository struct {
+	db *gorm.DB
+}
+
+func NewDownloadEventRepository(db *gorm.DB) *DownloadEventRepository {
+	return &DownloadEventRepository{db: db}
+}
+
+// DownloadBucket aggregates the downloads in one time bucket.
+type DownloadBucket struct {
+	Start          time.Time `json:"start"`
+	Downloads      int64     `json:"downloads"`
+	Completed      int64     `json:"completed"`
+	Partial        int64     `json:"partial"`
+	UniqueVisitors int64     `json:"unique_visitors"`
+	BytesSent      int64     `json:"bytes_sent"`
+}
+
+// LinkSummary aggregates all downloads through one public link.
+type LinkSummary struct {
+	LinkID    string     `json:"link_id"`
+	Downloads int64      `json:"downloads"`
+	Completed int64      `json:"completed"`
+	FirstAt   *time.Time `json:"first_at"`
+	LastAt    *time.Time `json:"last_at"`
+}
+
+// DownloadStatsQuery selects the events of one user file to aggregate.
+type DownloadStatsQuery struct {
+	UserFileID uint
+	LinkID     string // empty for every download of the file
+	Bucket     string // a date_trunc unit: hour, day, week or month
+	From       time.Time
+	To         time.Time // exclusive
+}
+
+func (r *DownloadEventRepository) Create(events []models.DownloadEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	return r.db.Create(&events).Error
+}
+
+// Buckets returns per-bucket totals in time order. Buckets without
+// downloads are omitted. Bucket must already be validated, as it is
+// interpolated into the date_trunc call.
+func (r *DownloadEventRepository) Buckets(q DownloadStatsQuery) ([]DownloadBucket, error) {
+	var buckets []DownloadBucket
+	trunc := "date_trunc('" + q.Bucket + "', created_at AT TIME ZONE 'UTC') AT TIME ZONE 'UTC'"
+	query := r.db.Model(&models.DownloadEvent{}).
+		Select(trunc+` AS start,
+			COUNT(*) AS downloads,
+			COUNT(*) FILTER (WHERE completed) AS completed,
+			COUNT(*) FILTER (WHERE NOT completed) AS partial,
+			COUNT(DISTINCT ip) AS unique_visitors,
+			COALESCE(SUM(bytes_sent), 0) AS bytes_sent`).
+		Where("user_file_id = ? AND created_at >= ? AND created_at < ?", q.UserFileID, q.From, q.To)
+	if q.LinkID != "" {
+		query = query.Where("link_id = ?", q.LinkID)
+	}
+	err := query.Group("start").Order("start").Scan(&buckets).Error
+	return buckets, err
+}
+
+// Links summarises every public link of a user file that has been used.
+func (r *DownloadEventRepository) Links(userFileID uint) ([]LinkSummary, error) {
+	var links []LinkSummary
+	err := r.db.Model(&models.DownloadEvent{}).
+		Select(`link_id,
+			COUNT(*) AS downloads,
+			COUNT(*) FILTER (WHERE completed) AS completed,
+			MIN(created_at) AS first_at,
+			MAX(created_at) AS last_at`).
+		Where("user_file_id = ? AND link_id IS NOT NULL", userFileID).
+		Group("link_id").
+		Order("last_at DESC").
+		Scan(&links).Error
+	return links, err
+}
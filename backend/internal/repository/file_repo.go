@@ -3,6 +3,7 @@ package repository
 import (
 	"backend/internal/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"time"
 	"errors"
 )
@@ -30,25 +31,25 @@ func (r *FileRepository) GetFilesByUser(userID uint) ([]models.File, error) {
 }
 
 // GetFileForDownload retrieves a file that belongs to a specific user
-func (r *FileRepository) GetFileForDownload(userID uint, fileID uint) (*models.File, error) {
+func (r *FileRepository) GetFileForDownload(userID uint, fileID uint) (*models.File, *models.UserFile, error) {
 	var userFile models.UserFile
 
 	// Step 1: Verify user-file relation exists
 	if err := r.db.Where("user_id = ? AND file_id = ?", userID, fileID).First(&userFile).Error; err != nil {
-		return nil, errors.New("file not found for this user")
+		return nil, nil, errors.New("file not found for this user")
 	}
 
-	if err := r.db.Model(&models.UserFile{}).Where("user_id = ? AND file_id = ?", userID, fileID).UpdateColumn("download_times", gorm.Expr("download_times + 1")).Error; err != nil {
-    	return nil, errors.New("failed to update download count")
+	if err := r.db.Model(&models.UserFile{}).Where("id = ?", userFile.ID).UpdateColumn("download_times", gorm.Expr("download_times + 1")).Error; err != nil {
+    	return nil, nil, errors.New("failed to update download count")
 	}
 
 	// Step 3: Fetch actual file info
 	var file models.File
 	if err := r.db.Where("id = ?", fileID).First(&file).Error; err != nil {
-		return nil, errors.New("file metadata not found")
+		return nil, nil, errors.New("file metadata not found")
 	}
 
-	return &file, nil
+	return &file, &userFile, nil
 }
 
 
@@ -74,36 +75,62 @@ func (r *FileRepository) UserHasFile(userID uint, hash string) (bool, error) {
 	return count > 0, nil
 }
 
+// InsertOrGetByHash inserts file unless a row with the same hash already
+// exists, in which case the existing row is locked and returned instead.
+// created reports which of the two happened. Concurrent callers with the same
+// hash block on the unique index until the first transaction finishes, so
+// exactly one of them creates the row.
+func (r *FileRepository) InsertOrGetByHash(file *models.File) (*models.File, bool, error) {
+	if err := r.LockHash(file.Hash); err != nil {
+		return nil, false, err
+	}
+
+	res := r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "hash"}},
+		DoNothing: true,
+	}).Create(file)
+	if res.Error != nil {
+		return nil, false, res.Error
+	}
+	if res.RowsAffected == 1 {
+		return file, true, nil
+	}
+
+	var existing models.File
+	if err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("hash = ?", file.Hash).First(&existing).Error; err != nil {
+		return nil, false, err
+	}
+	return &existing, false, nil
+}
+
 // CreateUserReference creates a UserFile relationship for deduplication
-func (r *FileRepository) CreateUserReference(userID uint, existingFile *models.File, filename  string, ownership bool) (*models.UserFile, error) {
+func (r *FileRepository) CreateUserReference(userID uint, existingFile *models.File, folder, filename string, ownership bool) (*models.UserFile, error) {
 	// Create UserFile relationship
 	userFile := &models.UserFile{
 		UserID: userID,
 		FileID: existingFile.ID,
 		FileName : filename,
+		Folder : folder,
 		UploadedAt : time.Now(),
 		DownloadTimes : 0,
 		IsOwner : ownership,
 	}
 
-
-	// Start transaction
-	tx := r.db.Begin()
-
-	// Create the user-file relationship
-	if err := tx.Create(userFile).Error; err != nil {
-		tx.Rollback()
-		return nil, err
-	}
-
-	// Increment refCount
-	if err := tx.Model(&models.File{}).Where("id = ?", existingFile.ID).
-		Update("ref_count", gorm.Expr("ref_count + 1")).Error; err != nil {
-		tx.Rollback()
+	// Nested calls join the caller's transaction via a savepoint
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// Create the user-file relationship
+		if err := tx.Create(userFile).Error; err != nil {
+			return err
+		}
+
+		// Increment refCount
+		return tx.Model(&models.File{}).Where("id = ?", existingFile.ID).
+			Update("ref_count", gorm.Expr("ref_count + 1")).Error
+	})
+	if err != nil {
 		return nil, err
 	}
-
-	tx.Commit()
 	return userFile, nil
 }
 
@@ -125,3 +152,53 @@ func (r *FileRepository) DeleteFileRecord(fileID uint) error {
 func (r *FileRepository) UpdateReferenceCount(fileID uint, count int64) error {
     return r.db.Model(&models.File{}).Where("id = ?", fileID).Update("ref_count", count).Error
 }
+
+// LockHash takes a transaction-scoped advisory lock on a content hash. Uploads,
+// deletes and the blob deletion worker all take it, so a blob can never be
+// removed from disk while a new row for the same content is being created.
+func (r *FileRepository) LockHash(hash string) error {
+	return r.db.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", hash).Error
+}
+
+// LockByID fetches a file row and locks it for the rest of the transaction
+func (r *FileRepository) LockByID(fileID uint) (*models.File, error) {
+	var file models.File
+	err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).First(&file, fileID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// BlobInUse reports whether any file row still points at the given content
+func (r *FileRepository) BlobInUse(hash, storagePath string) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.File{}).
+		Where("hash = ? OR storage_path = ?", hash, storagePath).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// SetContent records new content details for a file row
+func (r *FileRepository) SetContent(fileID uint, hash, storagePath string, size int64) error {
+	return r.db.Model(&models.File{}).Where("id = ?", fileID).
+		Updates(map[string]any{"hash": hash, "storage_path": storagePath, "size": size}).Error
+}
+
+// StorageTotals sums up what the vault stores. LogicalBytes counts each
+// user's files in full, as their quotas do; StoredBytes counts each blob
+// once, so the difference is what deduplication saves.
+type StorageTotals struct {
+	Blobs        int64
+	StoredBytes  int64
+	LogicalBytes int64
+}
+
+func (r *FileRepository) StorageTotals() (StorageTotals, error) {
+	var t StorageTotals
+	err := r.db.Raw(`
+		SELECT (SELECT COUNT(*) FROM files) AS blobs,
+		       (SELECT COALESCE(SUM(size), 0) FROM files) AS stored_bytes,
+		       (SELECT COALESCE(SUM(expected_storage), 0) FROM users) AS logical_bytes`).Scan(&t).Error
+	return t, err
+}
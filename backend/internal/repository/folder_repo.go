@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"backend/internal/models"
+	"database/sql"
+	"errors"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+var ErrFolderExists = errors.New("folder already exists")
+
+type FolderRepository struct {
+	db *gorm.DB
+}
+
+func NewFolderRepository(db *gorm.DB) *FolderRepository {
+	return &FolderRepository{db: db}
+}
+
+// SubfolderRow is a direct child folder and the time it last changed.
+type SubfolderRow struct {
+	Name    string
+	ModTime time.Time
+}
+
+func (r *FolderRepository) Create(userID uint, path string) error {
+	err := r.db.Create(&models.Folder{UserID: userID, Path: path}).Error
+	if isUniqueViolation(err) {
+		return ErrFolderExists
+	}
+	return err
+}
+
+// Latest reports when path, or the newest folder created inside it, was
+// created; nil means neither exists.
+func (r *FolderRepository) Latest(userID uint, path string) (*time.Time, error) {
+	var latest sql.NullTime
+	err := r.db.Model(&models.Folder{}).
+		Select("MAX(created_at)").
+		Where("user_id = ? AND (path = ? OR path LIKE ? ESCAPE '\\')", userID, path, escapeLike(path)+"/%").
+		Row().Scan(&latest)
+	if err != nil || !latest.Valid {
+		return nil, err
+	}
+	return &latest.Time, nil
+}
+
+// Children returns the names of the folders directly inside folder that
+// have explicit rows at any depth below them.
+func (r *FolderRepository) Children(userID uint, folder string) ([]SubfolderRow, error) {
+	var rows []SubfolderRow
+	err := childQuery(r.db.Model(&models.Folder{}), "path", "created_at", userID, folder).Scan(&rows).Error
+	return rows, err
+}
+
+// DeleteTree removes path and every folder below it.
+func (r *FolderRepository) DeleteTree(userID uint, path string) error {
+	return r.db.
+		Where("user_id = ? AND (path = ? OR path LIKE ? ESCAPE '\\')", userID, path, escapeLike(path)+"/%").
+		Delete(&models.Folder{}).Error
+}
+
+// PathsInTree returns path and every folder below it that has a row.
+func (r *FolderRepository) PathsInTree(userID uint, path string) ([]string, error) {
+	var paths []string
+	err := r.db.Model(&models.Folder{}).
+		Where("user_id = ? AND (path = ? OR path LIKE ? ESCAPE '\\')", userID, path, escapeLike(path)+"/%").
+		Pluck("path", &paths).Error
+	return paths, err
+}
+
+// MoveTree renames path and every folder below it to live under to.
+func (r *FolderRepository) MoveTree(userID uint, from, to string) error {
+	return r.db.Model(&models.Folder{}).
+		Where("user_id = ? AND (path = ? OR path LIKE ? ESCAPE '\\')", userID, from, escapeLike(from)+"/%").
+		Update("path", gorm.Expr("? || substr(path, ?)", to, utf8.RuneCountInString(from)+1)).Error
+}
+
+// childQuery groups the rows of a table with a folder-path column by their
+// first path segment below folder.
+func childQuery(query *gorm.DB, pathColumn, timeColumn string, userID uint, folder string) *gorm.DB {
+	prefix := ""
+	if folder != "" {
+		prefix = folder + "/"
+	}
+	name := "split_part(substr(" + pathColumn + ", ?), '/', 1)"
+	return query.
+		Select(name+" AS name, MAX("+timeColumn+") AS mod_time", utf8.RuneCountInString(prefix)+1).
+		Where("user_id = ? AND "+pathColumn+" LIKE ? ESCAPE '\\' AND "+pathColumn+" <> ?", userID, escapeLike(prefix)+"%", folder).
+		Group("1").
+		Order("1")
+}
+
+func (r *FolderRepository) Get(userID uint, path string) (*models.Folder, error) {
+	var f models.Folder
+	if err := r.db.Where("user_id = ? AND path = ?", userID, path).First(&f).Error; err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// Delete removes the row for path itself, leaving folders below it.
+func (r *FolderRepository) Delete(userID uint, path string) error {
+	return r.db.Where("user_id = ? AND path = ?", userID, path).Delete(&models.Folder{}).Error
+}
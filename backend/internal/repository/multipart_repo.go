@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"backend/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type MultipartRepository struct {
+	db *gorm.DB
+}
+
+func NewMultipartRepository(db *gorm.DB) *MultipartRepository {
+	return &MultipartRepository{db: db}
+}
+
+func (r *MultipartRepository) Create(u *models.MultipartUpload) error {
+	return r.db.Create(u).Error
+}
+
+// Get returns an upload started by userID.
+func (r *MultipartRepository) Get(id string, userID uint) (*models.MultipartUpload, error) {
+	var u models.MultipartUpload
+	if err := r.db.Where("id = ? AND user_id = ?", id, userID).First(&u).Error; err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// SavePart records a part, replacing an earlier upload of the same number.
+func (r *MultipartRepository) SavePart(p *models.MultipartPart) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "upload_id"}, {Name: "part_number"}},
+		DoUpdates: clause.AssignmentColumns([]string{"size", "etag", "updated_at"}),
+	}).Create(p).Error
+}
+
+func (r *MultipartRepository) Parts(uploadID string) ([]models.MultipartPart, error) {
+	var parts []models.MultipartPart
+	err := r.db.Where("upload_id = ?", uploadID).Order("part_number").Find(&parts).Error
+	return parts, err
+}
+
+// PartsSize returns the total size of the parts uploaded so far, leaving
+// out part number except, which is about to be replaced.
+func (r *MultipartRepository) PartsSize(uploadID string, except int) (int64, error) {
+	var size int64
+	err := r.db.Model(&models.MultipartPart{}).
+		Select("COALESCE(SUM(size), 0)").
+		Where("upload_id = ? AND part_number <> ?", uploadID, except).
+		Row().Scan(&size)
+	return size, err
+}
+
+// Delete removes an upload and its parts.
+func (r *MultipartRepository) Delete(id string) error {
+	return r.db.Where("id = ?", id).Delete(&models.MultipartUpload{}).Error
+}
+
+// ListByUser returns the user's uploads.
+func (r *MultipartRepository) ListByUser(userID uint) ([]models.MultipartUpload, error) {
+	var uploads []models.MultipartUpload
+	err := r.db.Where("user_id = ?", userID).Order("created_at").Find(&uploads).Error
+	return uploads, err
+}
+
+// ListStartedBefore returns uploads started before t, oldest first.
+func (r *MultipartRepository) ListStartedBefore(t time.Time, limit int) ([]models.MultipartUpload, error) {
+	var uploads []models.MultipartUpload
+	err := r.db.Where("created_at < ?", t).Order("created_at").Limit(limit).Find(&uploads).Error
+	return uploads, err
+}
@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"backend/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type OutboxRepository struct {
+	db *gorm.DB
+}
+
+func NewOutboxRepository(db *gorm.DB) *OutboxRepository {
+	return &OutboxRepository{db: db}
+}
+
+// Add queues an event. Call it inside the transaction making the change the
+// event describes, so the two commit or roll back together.
+func (r *OutboxRepository) Add(ev *models.OutboxEvent) error {
+	return r.db.Create(ev).Error
+}
+
+// ClaimUndispatched locks up to limit events that have not been dispatched,
+// oldest first, skipping rows another worker holds. Must be called inside a
+// transaction.
+func (r *OutboxRepository) ClaimUndispatched(limit int) ([]models.OutboxEvent, error) {
+	var events []models.OutboxEvent
+	err := r.db.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("dispatched_at IS NULL").
+		Order("id").
+		Limit(limit).
+		Find(&events).Error
+	return events, err
+}
+
+func (r *OutboxRepository) MarkDispatched(ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return r.db.Model(&models.OutboxEvent{}).Where("id IN ?", ids).
+		Update("dispatched_at", time.Now()).Error
+}
+
+// ListDispatchedForUser returns the user's dispatched events after afterID,
+// oldest first, for clients catching up after a disconnect.
+func (r *OutboxRepository) ListDispatchedForUser(userID, afterID uint, limit int) ([]models.OutboxEvent, error) {
+	var events []models.OutboxEvent
+	err := r.db.
+		Where("user_id = ? AND id > ? AND dispatched_at IS NOT NULL", userID, afterID).
+		Order("id").
+		Limit(limit).
+		Find(&events).Error
+	return events, err
+}
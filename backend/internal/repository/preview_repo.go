@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"backend/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type PreviewRepository struct {
+	db *gorm.DB
+}
+
+func NewPreviewRepository(db *gorm.DB) *PreviewRepository {
+	return &PreviewRepository{db: db}
+}
+
+// MarkPending records that previews for hash still need generating. Existing
+// rows are left alone so finished previews are never redone.
+func (r *PreviewRepository) MarkPending(hash string) error {
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.FilePreview{Hash: hash, Status: models.PreviewPending}).Error
+}
+
+func (r *PreviewRepository) Get(hash string) (*models.FilePreview, error) {
+	var p models.FilePreview
+	if err := r.db.Where("hash = ?", hash).First(&p).Error; err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Save stores the outcome of a generation attempt
+func (r *PreviewRepository) Save(p *models.FilePreview) error {
+	return r.db.Save(p).Error
+}
+
+// ListPending returns hashes still waiting for generation, oldest first
+func (r *PreviewRepository) ListPending(limit int) ([]string, error) {
+	var hashes []string
+	err := r.db.Model(&models.FilePreview{}).
+		Where("status = ?", models.PreviewPending).
+		Order("created_at").
+		Limit(limit).
+		Pluck("hash", &hashes).Error
+	return hashes, err
+}
+
+func (r *PreviewRepository) Delete(hash string) error {
+	return r.db.Where("hash = ?", hash).Delete(&models.FilePreview{}).Error
+}
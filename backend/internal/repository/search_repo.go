@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"backend/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Markers wrapped around matches by ts_headline. They survive HTML-escaping
+// unchanged, so callers can escape the snippet first and then swap them for
+// real markup.
+const (
+	HighlightStart = "{{mark}}"
+	HighlightStop  = "{{/mark}}"
+)
+
+type SearchRepository struct {
+	db *gorm.DB
+}
+
+func NewSearchRepository(db *gorm.DB) *SearchRepository {
+	return &SearchRepository{db: db}
+}
+
+type SearchRow struct {
+	UserFileID uint
+	FileName   string
+	Folder     string
+	Size       int64
+	MimeType   string
+	UploadedAt time.Time
+	Rank       float64
+	Snippet    string
+}
+
+// MarkPending records that text still needs extracting for hash
+func (r *SearchRepository) MarkPending(hash string) error {
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.FileContent{Hash: hash, Status: models.ContentPending}).Error
+}
+
+func (r *SearchRepository) Get(hash string) (*models.FileContent, error) {
+	var fc models.FileContent
+	if err := r.db.Omit("content").Where("hash = ?", hash).First(&fc).Error; err != nil {
+		return nil, err
+	}
+	return &fc, nil
+}
+
+func (r *SearchRepository) Save(fc *models.FileContent) error {
+	return r.db.Save(fc).Error
+}
+
+func (r *SearchRepository) ListPending(limit int) ([]string, error) {
+	var hashes []string
+	err := r.db.Model(&models.FileContent{}).
+		Where("status = ?", models.ContentPending).
+		Order("created_at").
+		Limit(limit).
+		Pluck("hash", &hashes).Error
+	return hashes, err
+}
+
+func (r *SearchRepository) Delete(hash string) error {
+	return r.db.Where("hash = ?", hash).Delete(&models.FileContent{}).Error
+}
+
+// Search ranks the user's own files by how well their content matches query,
+// a web-search style expression ("quoted phrases", -excluded, or).
+func (r *SearchRepository) Search(userID uint, query string, limit, offset int) ([]SearchRow, int64, error) {
+	base := r.db.
+		Table("user_files AS uf").
+		Joins("JOIN files f ON f.id = uf.file_id").
+		Joins("JOIN file_contents fc ON fc.hash = f.hash").
+		Joins("CROSS JOIN websearch_to_tsquery('english', ?) AS q", query).
+		Where("uf.user_id = ? AND fc.tsv @@ q", userID)
+
+	var total int64
+	if err := base.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var rows []SearchRow
+	err := base.
+		Select(`uf.id AS user_file_id,
+                uf.file_name,
+                uf.folder,
+                f.size,
+                f.mime_type,
+                uf.uploaded_at,
+                ts_rank_cd(fc.tsv, q) AS rank,
+                ts_headline('english', fc.content, q, ?) AS snippet`,
+			`StartSel="`+HighlightStart+`", StopSel="`+HighlightStop+`", MaxFragments=2, MaxWords=20, MinWords=5`).
+		Order("rank DESC, uf.id").
+		Limit(limit).
+		Offset(offset).
+		Scan(&rows).Error
+	return rows, total, err
+}
@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"backend/internal/models"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrSSHKeyExists = errors.New("ssh key already registered")
+
+type SSHKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewSSHKeyRepository(db *gorm.DB) *SSHKeyRepository {
+	return &SSHKeyRepository{db: db}
+}
+
+func (r *SSHKeyRepository) Create(k *models.SSHKey) error {
+	err := r.db.Create(k).Error
+	if isUniqueViolation(err) {
+		return ErrSSHKeyExists
+	}
+	return err
+}
+
+func (r *SSHKeyRepository) ListByUser(userID uint) ([]models.SSHKey, error) {
+	var keys []models.SSHKey
+	err := r.db.Where("user_id = ?", userID).Order("id").Find(&keys).Error
+	return keys, err
+}
+
+func (r *SSHKeyRepository) GetByFingerprint(fingerprint string) (*models.SSHKey, error) {
+	var k models.SSHKey
+	if err := r.db.Where("fingerprint = ?", fingerprint).First(&k).Error; err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (r *SSHKeyRepository) Get(id uint) (*models.SSHKey, error) {
+	var k models.SSHKey
+	if err := r.db.First(&k, id).Error; err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (r *SSHKeyRepository) Touch(id uint, at time.Time) error {
+	return r.db.Model(&models.SSHKey{}).Where("id = ?", id).UpdateColumn("last_used_at", at).Error
+}
+
+func (r *SSHKeyRepository) Delete(id, userID uint) error {
+	res := r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.SSHKey{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"backend/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// tagInsertBatch is how many rows one INSERT carries, keeping bulk edits of
+// many files well under Postgres's limit of 65535 bind parameters.
+const tagInsertBatch = 1000
+
+type TagRepository struct {
+	db *gorm.DB
+}
+
+func NewTagRepository(db *gorm.DB) *TagRepository {
+	return &TagRepository{db: db}
+}
+
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int64  `json:"count"`
+}
+
+// CountOwnedUserFiles returns how many of ids belong to userID
+func (r *TagRepository) CountOwnedUserFiles(userID uint, ids []uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.UserFile{}).Where("user_id = ? AND id IN ?", userID, ids).Count(&count).Error
+	return count, err
+}
+
+// AddTags attaches tags to every user file in ids, ignoring ones already present
+func (r *TagRepository) AddTags(ids []uint, tags []string) error {
+	if len(ids) == 0 || len(tags) == 0 {
+		return nil
+	}
+	rows := make([]models.UserFileTag, 0, len(ids)*len(tags))
+	for _, id := range ids {
+		for _, tag := range tags {
+			rows = append(rows, models.UserFileTag{UserFileID: id, Tag: tag})
+		}
+	}
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&rows, tagInsertBatch).Error
+}
+
+func (r *TagRepository) RemoveTags(ids []uint, tags []string) error {
+	if len(ids) == 0 || len(tags) == 0 {
+		return nil
+	}
+	return r.db.Where("user_file_id IN ? AND tag IN ?", ids, tags).Delete(&models.UserFileTag{}).Error
+}
+
+func (r *TagRepository) GetTags(userfileID uint) ([]string, error) {
+	var tags []string
+	err := r.db.Model(&models.UserFileTag{}).Where("user_file_id = ?", userfileID).
+		Order("tag").Pluck("tag", &tags).Error
+	return tags, err
+}
+
+// MaxTagCount returns the largest number of tags on any of the user files
+func (r *TagRepository) MaxTagCount(ids []uint) (int64, error) {
+	var max int64
+	err := r.db.
+		Table("(SELECT COUNT(*) AS cnt FROM user_file_tags WHERE user_file_id IN ? GROUP BY user_file_id) AS counts", ids).
+		Select("COALESCE(MAX(cnt), 0)").
+		Scan(&max).Error
+	return max, err
+}
+
+// SetMetadata upserts key/value pairs on every user file in ids
+func (r *TagRepository) SetMetadata(ids []uint, values map[string]string) error {
+	if len(ids) == 0 || len(values) == 0 {
+		return nil
+	}
+	rows := make([]models.UserFileMetadata, 0, len(ids)*len(values))
+	for _, id := range ids {
+		for k, v := range values {
+			rows = append(rows, models.UserFileMetadata{UserFileID: id, Key: k, Value: v})
+		}
+	}
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_file_id"}, {Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value"}),
+	}).CreateInBatches(&rows, tagInsertBatch).Error
+}
+
+func (r *TagRepository) RemoveMetadata(ids []uint, keys []string) error {
+	if len(ids) == 0 || len(keys) == 0 {
+		return nil
+	}
+	return r.db.Where("user_file_id IN ? AND key IN ?", ids, keys).Delete(&models.UserFileMetadata{}).Error
+}
+
+func (r *TagRepository) GetMetadata(userfileID uint) (map[string]string, error) {
+	var rows []models.UserFileMetadata
+	if err := r.db.Where("user_file_id = ?", userfileID).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(rows))
+	for _, m := range rows {
+		values[m.Key] = m.Value
+	}
+	return values, nil
+}
+
+// MaxMetadataCount returns the largest number of keys on any of the user files
+func (r *TagRepository) MaxMetadataCount(ids []uint) (int64, error) {
+	var max int64
+	err := r.db.
+		Table("(SELECT COUNT(*) AS cnt FROM user_file_metadata WHERE user_file_id IN ? GROUP BY user_file_id) AS counts", ids).
+		Select("COALESCE(MAX(cnt), 0)").
+		Scan(&max).Error
+	return max, err
+}
+
+// SuggestTags autocompletes the user's existing tags, most used first
+func (r *TagRepository) SuggestTags(userID uint, prefix string, limit int) ([]TagCount, error) {
+	var tags []TagCount
+	err := r.db.
+		Table("user_file_tags AS t").
+		Select("t.tag, COUNT(*) AS count").
+		Joins("JOIN user_files uf ON uf.id = t.user_file_id").
+		Where("uf.user_id = ? AND t.tag LIKE ?", userID, escapeLike(prefix)+"%").
+		Group("t.tag").
+		Order("count DESC, t.tag").
+		Limit(limit).
+		Scan(&tags).Error
+	return tags, err
+}
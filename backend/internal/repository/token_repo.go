@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"backend/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type TokenRepository struct {
+	db *gorm.DB
+}
+
+func NewTokenRepository(db *gorm.DB) *TokenRepository {
+	return &TokenRepository{db: db}
+}
+
+func (r *TokenRepository) Create(t *models.PersonalToken) error {
+	return r.db.Create(t).Error
+}
+
+func (r *TokenRepository) ListByUser(userID uint) ([]models.PersonalToken, error) {
+	var tokens []models.PersonalToken
+	err := r.db.Where("user_id = ?", userID).Order("id").Find(&tokens).Error
+	return tokens, err
+}
+
+// GetByHash returns the unexpired token with the given hash.
+func (r *TokenRepository) GetByHash(hash string) (*models.PersonalToken, error) {
+	var t models.PersonalToken
+	err := r.db.Where("token_hash = ? AND (expires_at IS NULL OR expires_at > NOW())", hash).First(&t).Error
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (r *TokenRepository) Touch(id uint, at time.Time) error {
+	return r.db.Model(&models.PersonalToken{}).Where("id = ?", id).UpdateColumn("last_used_at", at).Error
+}
+
+func (r *TokenRepository) Delete(id, userID uint) error {
+	res := r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.PersonalToken{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
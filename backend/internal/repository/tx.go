@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// Repos bundles the repositories bound to a single database transaction.
+type Repos struct {
+	Files     *FileRepository
+	UserFiles *UserFileRepository
+	Users     *UserRepository
+	Deletions *BlobDeletionRepository
+	Previews  *PreviewRepository
+	Contents  *SearchRepository
+	Tags      *TagRepository
+	Outbox    *OutboxRepository
+	Webhooks  *WebhookRepository
+	Folders   *FolderRepository
+	Changes   *ChangeRepository
+	Checks    *ConsistencyRepository
+}
+
+// TxManager runs units of work that span several repositories atomically.
+type TxManager struct {
+	db *gorm.DB
+}
+
+func NewTxManager(db *gorm.DB) *TxManager {
+	return &TxManager{db: db}
+}
+
+// Do runs fn inside a transaction, committing if it returns nil and rolling
+// back otherwise.
+func (m *TxManager) Do(fn func(r Repos) error) error {
+	return m.db.Transaction(func(tx *gorm.DB) error {
+		return fn(Repos{
+			Files:     NewFileRepository(tx),
+			UserFiles: NewUserFileRepository(tx),
+			Users:     NewUserRepository(tx),
+			Deletions: NewBlobDeletionRepository(tx),
+			Previews:  NewPreviewRepository(tx),
+			Contents:  NewSearchRepository(tx),
+			Tags:      NewTagRepository(tx),
+			Outbox:    NewOutboxRepository(tx),
+			Webhooks:  NewWebhookRepository(tx),
+			Folders:   NewFolderRepository(tx),
+			Changes:   NewChangeRepository(tx),
+			Checks:    NewConsistencyRepository(tx),
+		})
+	})
+}
+
+// isUniqueViolation reports whether err is a Postgres unique_violation.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
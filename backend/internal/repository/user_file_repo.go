@@ -1,11 +1,13 @@
 package repository
 
 import (
+	"database/sql"
     "backend/internal/models"  
     "gorm.io/gorm" 
+    "gorm.io/gorm/clause"
 	"errors"      
 	"time"  
-	"strconv"   
+	"strings"
 )
 
 type UserFileRepository struct {
@@ -20,6 +22,7 @@ type JoinedFileRow struct {
     UserFileID    uint
     FileID        uint
     FileName      string
+    Folder        string
     Size          int64
     MimeType      string
     UploaderName  string
@@ -28,6 +31,60 @@ type JoinedFileRow struct {
     IsOwner       bool
     DownloadTimes int
     PublicToken   *string
+    PreviewStatus *string
+    PreviewKind   *string
+    Tags          string // comma-separated, sorted
+    Metadata      string // JSON object
+}
+
+// ArchiveRow is a user file joined with the blob it points at, as needed to
+// stream it into a ZIP download.
+type ArchiveRow struct {
+    UserFileID  uint
+    FileID      uint
+    FileName    string
+    Folder      string
+    Size        int64
+    StoragePath string
+}
+
+func (r *UserFileRepository) archiveQuery(userID uint) *gorm.DB {
+    return r.db.
+        Table("user_files AS uf").
+        Select("uf.id AS user_file_id, f.id AS file_id, uf.file_name, uf.folder, f.size, f.storage_path").
+        Joins("JOIN files f ON f.id = uf.file_id").
+        Where("uf.user_id = ?", userID).
+        Order("uf.folder, uf.file_name, uf.id")
+}
+
+// GetArchiveRowsByIDs returns the requested user files that belong to userID;
+// IDs owned by anyone else are silently absent from the result.
+func (r *UserFileRepository) GetArchiveRowsByIDs(userID uint, ids []uint) ([]ArchiveRow, error) {
+    var rows []ArchiveRow
+    err := r.archiveQuery(userID).Where("uf.id IN ?", ids).Scan(&rows).Error
+    return rows, err
+}
+
+// GetArchiveRowsInFolder returns every file in folder and its subfolders
+func (r *UserFileRepository) GetArchiveRowsInFolder(userID uint, folder string) ([]ArchiveRow, error) {
+    var rows []ArchiveRow
+    query := r.archiveQuery(userID)
+    if folder != "" {
+        query = query.Where("(uf.folder = ? OR uf.folder LIKE ? ESCAPE '\\')", folder, escapeLike(folder)+"/%")
+    }
+    err := query.Scan(&rows).Error
+    return rows, err
+}
+
+// IncrementDownloadTimesBatch bumps the download counter of several user files
+func (r *UserFileRepository) IncrementDownloadTimesBatch(ids []uint) error {
+    return r.db.Model(&models.UserFile{}).Where("id IN ?", ids).
+        UpdateColumn("download_times", gorm.Expr("download_times + 1")).Error
+}
+
+// escapeLike escapes LIKE wildcards so s matches literally
+func escapeLike(s string) string {
+    return strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(s)
 }
 
 func (r *UserFileRepository) UserHasFile(userID uint, hash string) (bool, error) {
@@ -64,6 +121,16 @@ func (r *UserFileRepository) GetOwnerUserFile(userID, userfileID uint) (*models.
 	return &uf, nil
 }
 
+// Rename moves a user file to another name and/or folder
+func (r *UserFileRepository) Rename(uf *models.UserFile, folder, filename string) error {
+    uf.Folder = folder
+    uf.FileName = filename
+    return r.db.Model(uf).Updates(map[string]interface{}{
+        "folder":    folder,
+        "file_name": filename,
+    }).Error
+}
+
 // Update visibility and public token
 func (r *UserFileRepository) UpdateVisibility(uf *models.UserFile, visibility string, token *string) error {
     uf.Visibility = visibility
@@ -106,8 +173,10 @@ func (r *UserFileRepository) GetUserFileByID(userfileID, userID uint) (*models.U
 }
 
 
-func (r *UserFileRepository) DeleteUserFile(userID, fileID uint) error {
-    res := r.db.Where("user_id = ? AND file_id = ?", userID, fileID).Delete(&models.UserFile{})
+// DeleteUserFile deletes one of the user's files. Other references the
+// user holds to the same content are left alone.
+func (r *UserFileRepository) DeleteUserFile(userID, userfileID uint) error {
+    res := r.db.Where("id = ? AND user_id = ?", userfileID, userID).Delete(&models.UserFile{})
     if res.Error != nil {
         return res.Error
     }
@@ -117,62 +186,304 @@ func (r *UserFileRepository) DeleteUserFile(userID, fileID uint) error {
     return nil
 }
 
-// user_file_repository.go
-func (r *UserFileRepository) ListUserFilesWithFilters(userID uint, filters map[string]string) ([]JoinedFileRow, error) {
+// ListSortColumns maps the public sort keys of the file listing onto the
+// expressions used for ordering and keyset comparison.
+var ListSortColumns = map[string]string{
+    "name":        "uf.file_name",
+    "size":        "f.size",
+    "uploaded_at": "uf.uploaded_at",
+    "downloads":   "uf.download_times",
+    "mime_type":   "f.mime_type",
+}
+
+// ListPage controls ordering and keyset pagination of the file listing.
+// Rows are ordered by the sort column with uf.id as a tie-breaker, and a
+// page starts strictly after (AfterValue, AfterID) in that order.
+type ListPage struct {
+    Sort       string // key of ListSortColumns
+    Desc       bool
+    Limit      int         // 0 returns every matching row
+    AfterValue interface{} // nil for the first page
+    AfterID    uint
+    WithTotal  bool
+}
+
+// ListFilter narrows the file listing. Zero values leave a field unfiltered;
+// every set field must match.
+type ListFilter struct {
+    Filename       string // case-insensitive substring of the file name
+    MimeType       string // exact media type
+    MimePrefix     string // e.g. "image/" for image/*
+    Uploader       string // case-insensitive substring of the original owner's username
+    MinSize        *int64
+    MaxSize        *int64
+    UploadedFrom   *time.Time // inclusive
+    UploadedBefore *time.Time // exclusive
+    Tags           []string   // all must be present
+    Metadata       []MetadataMatch
+    Visibility     string
+}
+
+// MetadataMatch requires key to be set, and to equal Value when HasValue.
+type MetadataMatch struct {
+    Key      string
+    Value    string
+    HasValue bool
+}
+
+// ListUserFilesWithFilters returns one page of the user's files plus, when
+// requested, the total number of rows matching the filters.
+func (r *UserFileRepository) ListUserFilesWithFilters(userID uint, filter ListFilter, page ListPage) ([]JoinedFileRow, int64, error) {
     var rows []JoinedFileRow
-query := r.db.
-    Table("user_files AS uf").
-    Select(`uf.id AS user_file_id, 
+    // The uploader is whoever first stored the content: the owning reference,
+    // or the oldest remaining one if the owner has since deleted theirs
+    query := r.db.
+        Table("user_files AS uf").
+        Joins("JOIN files f ON f.id = uf.file_id").
+        Joins("LEFT JOIN file_previews fp ON fp.hash = f.hash").
+        Joins(`LEFT JOIN LATERAL (
+            SELECT o.user_id FROM user_files o
+            WHERE o.file_id = uf.file_id
+            ORDER BY o.is_owner DESC, o.uploaded_at, o.id
+            LIMIT 1) orig ON TRUE`).
+        Joins("LEFT JOIN users uploader ON uploader.id = orig.user_id").
+        Where("uf.user_id = ?", userID)
+
+    if filter.Filename != "" {
+        query = query.Where("uf.file_name ILIKE ? ESCAPE '\\'", "%"+escapeLike(filter.Filename)+"%")
+    }
+    if filter.MimeType != "" {
+        // Stored types may carry parameters, as in "text/plain; charset=utf-8"
+        query = query.Where("lower(trim(split_part(f.mime_type, ';', 1))) = ?", filter.MimeType)
+    }
+    if filter.MimePrefix != "" {
+        query = query.Where("f.mime_type LIKE ? ESCAPE '\\'", escapeLike(filter.MimePrefix)+"%")
+    }
+    if filter.Uploader != "" {
+        query = query.Where("uploader.username ILIKE ? ESCAPE '\\'", "%"+escapeLike(filter.Uploader)+"%")
+    }
+    if filter.MinSize != nil {
+        query = query.Where("f.size >= ?", *filter.MinSize)
+    }
+    if filter.MaxSize != nil {
+        query = query.Where("f.size <= ?", *filter.MaxSize)
+    }
+    if filter.UploadedFrom != nil {
+        query = query.Where("uf.uploaded_at >= ?", *filter.UploadedFrom)
+    }
+    if filter.UploadedBefore != nil {
+        query = query.Where("uf.uploaded_at < ?", *filter.UploadedBefore)
+    }
+    for _, tag := range filter.Tags {
+        query = query.Where("EXISTS (SELECT 1 FROM user_file_tags t WHERE t.user_file_id = uf.id AND t.tag = ?)", tag)
+    }
+    for _, m := range filter.Metadata {
+        if m.HasValue {
+            query = query.Where("EXISTS (SELECT 1 FROM user_file_metadata m WHERE m.user_file_id = uf.id AND m.key = ? AND m.value = ?)", m.Key, m.Value)
+        } else {
+            query = query.Where("EXISTS (SELECT 1 FROM user_file_metadata m WHERE m.user_file_id = uf.id AND m.key = ?)", m.Key)
+        }
+    }
+    if filter.Visibility != "" {
+        query = query.Where("uf.visibility = ?", filter.Visibility)
+    }
+
+    var total int64
+    if page.WithTotal {
+        if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+            return nil, 0, err
+        }
+    }
+
+    sortCol, ok := ListSortColumns[page.Sort]
+    if !ok {
+        sortCol = ListSortColumns["uploaded_at"]
+    }
+    dir, cmp := "ASC", ">"
+    if page.Desc {
+        dir, cmp = "DESC", "<"
+    }
+    if page.AfterValue != nil {
+        query = query.Where("("+sortCol+", uf.id) "+cmp+" (?, ?)", page.AfterValue, page.AfterID)
+    }
+    if page.Limit > 0 {
+        query = query.Limit(page.Limit)
+    }
+
+    err := query.
+        Select(`uf.id AS user_file_id, 
             f.id AS file_id, 
-            f.filename AS file_name, 
+            uf.file_name, 
+            uf.folder,
             f.size, 
             f.mime_type,
-            u.username AS uploader_name,
+            COALESCE(uploader.username, '') AS uploader_name,
             uf.uploaded_at, 
             uf.visibility, 
             uf.is_owner, 
             uf.download_times, 
-            uf.public_token`).
-    Joins("JOIN files f ON f.id = uf.file_id").
-    Joins("JOIN users u ON u.id = uf.user_id").
-    Where("uf.user_id = ?", userID)
+            uf.public_token,
+            fp.status AS preview_status,
+            fp.kind AS preview_kind,
+            COALESCE((SELECT string_agg(t.tag, ',' ORDER BY t.tag) FROM user_file_tags t WHERE t.user_file_id = uf.id), '') AS tags,
+            COALESCE((SELECT json_object_agg(m.key, m.value) FROM user_file_metadata m WHERE m.user_file_id = uf.id)::text, '{}') AS metadata`).
+        Order(sortCol + " " + dir + ", uf.id " + dir).
+        Scan(&rows).Error
+    if err != nil {
+        return nil, 0, err
+    }
 
-    // Apply filters here as before
-    if v, ok := filters["filename"]; ok && v != "" {
-    query = query.Where("f.filename ILIKE ?", "%"+v+"%")
+    return rows, total, nil
 }
 
-if v, ok := filters["mimeType"]; ok && v != "" {
-    query = query.Where("f.mime_type ?", "%"+v+"%") 
+// PathRow is a user file joined with its content, as needed to serve it by
+// path over WebDAV and similar protocols.
+type PathRow struct {
+    UserFileID  uint
+    FileID      uint
+    FileName    string
+    Folder      string
+    Size        int64
+    MimeType    string
+    Hash        string
+    StoragePath string
+    UploadedAt  time.Time
 }
 
-if v, ok := filters["uploader"]; ok && v != "" {
-    query = query.Where("owner.username ILIKE ?", "%"+v+"%") // match actual uploader
+func (r *UserFileRepository) pathQuery(userID uint) *gorm.DB {
+    return r.db.
+        Table("user_files AS uf").
+        Select("uf.id AS user_file_id, f.id AS file_id, uf.file_name, uf.folder, f.size, f.mime_type, f.hash, f.storage_path, uf.uploaded_at").
+        Joins("JOIN files f ON f.id = uf.file_id").
+        Where("uf.user_id = ?", userID)
 }
 
-if v, ok := filters["minSize"]; ok && v != "" {
-    if minSize, err := strconv.ParseInt(v, 10, 64); err == nil {
-        query = query.Where("f.size >= ?", minSize)
-    }
+// FindByPath returns the user's files named filename in folder, newest
+// first. Nothing stops two files from sharing a path, so callers treat the
+// first row as the one the path refers to.
+func (r *UserFileRepository) FindByPath(userID uint, folder, filename string) ([]PathRow, error) {
+    var rows []PathRow
+    err := r.pathQuery(userID).
+        Where("uf.folder = ? AND uf.file_name = ?", folder, filename).
+        Order("uf.uploaded_at DESC, uf.id DESC").
+        Scan(&rows).Error
+    return rows, err
+}
+
+// ListInFolder returns the files directly inside folder, by name and then
+// newest first.
+func (r *UserFileRepository) ListInFolder(userID uint, folder string) ([]PathRow, error) {
+    var rows []PathRow
+    err := r.pathQuery(userID).
+        Where("uf.folder = ?", folder).
+        Order("uf.file_name, uf.uploaded_at DESC, uf.id DESC").
+        Scan(&rows).Error
+    return rows, err
+}
+
+// ListSubfolders returns the folders directly inside folder that hold files
+// at any depth, with the time of the newest upload below each.
+func (r *UserFileRepository) ListSubfolders(userID uint, folder string) ([]SubfolderRow, error) {
+    var rows []SubfolderRow
+    err := childQuery(r.db.Model(&models.UserFile{}), "folder", "uploaded_at", userID, folder).Scan(&rows).Error
+    return rows, err
 }
 
-if v, ok := filters["maxSize"]; ok && v != "" {
-    if maxSize, err := strconv.ParseInt(v, 10, 64); err == nil {
-        query = query.Where("f.size <= ?", maxSize)
+// LatestInFolder returns the time of the newest upload in folder or below
+// it; nil means the folder holds no files.
+func (r *UserFileRepository) LatestInFolder(userID uint, folder string) (*time.Time, error) {
+    var latest sql.NullTime
+    err := r.db.Model(&models.UserFile{}).
+        Select("MAX(uploaded_at)").
+        Where("user_id = ? AND (folder = ? OR folder LIKE ? ESCAPE '\\')", userID, folder, escapeLike(folder)+"/%").
+        Row().Scan(&latest)
+    if err != nil || !latest.Valid {
+        return nil, err
     }
+    return &latest.Time, nil
+}
+
+// GetInFolderTree returns every user file in folder and its subfolders,
+// locked for update.
+func (r *UserFileRepository) GetInFolderTree(userID uint, folder string) ([]models.UserFile, error) {
+    var ufs []models.UserFile
+    err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).
+        Where("user_id = ? AND (folder = ? OR folder LIKE ? ESCAPE '\\')", userID, folder, escapeLike(folder)+"/%").
+        Order("id").
+        Find(&ufs).Error
+    return ufs, err
 }
 
-if v, ok := filters["startDate"]; ok && v != "" {
-    query = query.Where("uf.uploaded_at >= ?", v)
+// ObjectRow is a file or explicitly created folder seen as an object-store
+// key: folder/file_name for files, folder path plus "/" for folders.
+type ObjectRow struct {
+    Key        string
+    Size       int64
+    Hash       string
+    MimeType   string
+    ModTime    time.Time
 }
 
-if v, ok := filters["endDate"]; ok && v != "" {
-    query = query.Where("uf.uploaded_at <= ?", v)
+// ListObjectKeys returns up to limit keys that start with prefix and sort
+// after after, in byte order. Where several files share a path only the
+// newest is returned.
+func (r *UserFileRepository) ListObjectKeys(userID uint, prefix, after string, limit int) ([]ObjectRow, error) {
+    var rows []ObjectRow
+    like := escapeLike(prefix) + "%"
+    err := r.db.Raw(`
+        SELECT key, size, hash, mime_type, mod_time FROM (
+            SELECT DISTINCT ON (key) key, size, hash, mime_type, mod_time FROM (
+                SELECT (CASE WHEN uf.folder = '' THEN uf.file_name ELSE uf.folder || '/' || uf.file_name END) COLLATE "C" AS key,
+                       f.size, f.hash, f.mime_type, uf.uploaded_at AS mod_time, uf.id
+                FROM user_files uf
+                JOIN files f ON f.id = uf.file_id
+                WHERE uf.user_id = ?
+            ) files
+            WHERE key LIKE ? ESCAPE '\' AND key > ?
+            ORDER BY key, mod_time DESC, id DESC
+        ) newest
+        UNION ALL
+        SELECT (path || '/') COLLATE "C", 0, '', '', created_at
+        FROM user_folders
+        WHERE user_id = ? AND (path || '/') COLLATE "C" LIKE ? ESCAPE '\' AND (path || '/') COLLATE "C" > ?
+        ORDER BY 1
+        LIMIT ?`,
+        userID, like, after, userID, like, after, limit).Scan(&rows).Error
+    return rows, err
+}
+
+// ListByFile returns every user's reference to a blob.
+func (r *UserFileRepository) ListByFile(fileID uint) ([]models.UserFile, error) {
+	var ufs []models.UserFile
+	err := r.db.Where("file_id = ?", fileID).Order("id").Find(&ufs).Error
+	return ufs, err
+}
+
+// GetByUserAndFile returns one of the user's references to a blob, the one
+// paying for it if any, or nil if they hold no copy of it.
+func (r *UserFileRepository) GetByUserAndFile(userID, fileID uint) (*models.UserFile, error) {
+	var ufs []models.UserFile
+	if err := r.db.Where("user_id = ? AND file_id = ?", userID, fileID).
+		Order("is_owner DESC, id").Limit(1).Find(&ufs).Error; err != nil {
+		return nil, err
+	}
+	if len(ufs) == 0 {
+		return nil, nil
+	}
+	return &ufs[0], nil
 }
 
-if err := query.Scan(&rows).Error; err != nil {
-    return nil, err
+// Transfer hands a user file to another user, placing it in folder.
+func (r *UserFileRepository) Transfer(uf *models.UserFile, toUserID uint, folder string) error {
+	uf.UserID = toUserID
+	uf.Folder = folder
+	return r.db.Model(uf).Updates(map[string]interface{}{
+		"user_id": toUserID,
+		"folder":  folder,
+	}).Error
 }
 
-    return rows, nil
+func (r *UserFileRepository) SetOwner(uf *models.UserFile, owner bool) error {
+	uf.IsOwner = owner
+	return r.db.Model(uf).Update("is_owner", owner).Error
 }
@@ -3,7 +3,9 @@ package repository
 import (
 	"backend/internal/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"errors"
+	"time"
 )
 
 type UserRepository struct {
@@ -74,3 +76,71 @@ func (r *UserRepository) UpdateUserStorage(userID uint, actualDelta int64, expec
         "expected_storage":  gorm.Expr("expected_storage + ?", expectedDelta),
     }).Error
 }
+
+// Delete removes a user row; their tokens, keys and other rows go with it
+// by cascade. Their files must have been released first.
+func (r *UserRepository) Delete(userID uint) error {
+	return r.db.Delete(&models.User{}, userID).Error
+}
+
+// SetDisabled disables the account as of at, or enables it when at is nil.
+func (r *UserRepository) SetDisabled(userID uint, at *time.Time) error {
+	return r.db.Model(&models.User{}).Where("id = ?", userID).Update("disabled_at", at).Error
+}
+
+// SetQuota sets the user's own storage quota, or clears it when quota is nil.
+func (r *UserRepository) SetQuota(userID uint, quota *int64) error {
+	return r.db.Model(&models.User{}).Where("id = ?", userID).Update("quota_bytes", quota).Error
+}
+
+func (r *UserRepository) SetAdmin(userID uint, admin bool) error {
+	return r.db.Model(&models.User{}).Where("id = ?", userID).Update("is_admin", admin).Error
+}
+
+// LockByID fetches a user row and locks it for the rest of the transaction,
+// holding off uploads and deletions that update the storage counters.
+func (r *UserRepository) LockByID(userID uint) (*models.User, error) {
+	var user models.User
+	err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *UserRepository) SetStorage(userID uint, actual, expected int64) error {
+	return r.db.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"actual_storage":   actual,
+		"expected_storage": expected,
+	}).Error
+}
+
+// UserUsage is a user with how much they store.
+type UserUsage struct {
+	ID              uint       `json:"id"`
+	Username        string     `json:"username"`
+	Email           string     `json:"email"`
+	IsAdmin         bool       `json:"is_admin"`
+	DisabledAt      *time.Time `json:"disabled_at,omitempty"`
+	QuotaBytes      *int64     `json:"quota_bytes,omitempty"`
+	ActualStorage   int64      `json:"actual_storage"`
+	ExpectedStorage int64      `json:"expected_storage"`
+	Files           int64      `json:"files"`
+
+	Quota int64 `gorm:"-" json:"quota"` // effective quota, filled in by the service
+}
+
+// ListLargest returns the users storing the most, by the bytes they are
+// charged for and then by the bytes they hold.
+func (r *UserRepository) ListLargest(limit int) ([]UserUsage, error) {
+	var rows []UserUsage
+	err := r.db.
+		Table("users AS u").
+		Select(`u.id, u.username, u.email, u.is_admin, u.disabled_at, u.quota_bytes,
+			u.actual_storage, u.expected_storage,
+			(SELECT COUNT(*) FROM user_files uf WHERE uf.user_id = u.id) AS files`).
+		Order("u.actual_storage DESC, u.expected_storage DESC, u.id").
+		Limit(limit).
+		Scan(&rows).Error
+	return rows, err
+}
@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"backend/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type WebhookRepository struct {
+	db *gorm.DB
+}
+
+func NewWebhookRepository(db *gorm.DB) *WebhookRepository {
+	return &WebhookRepository{db: db}
+}
+
+func (r *WebhookRepository) Create(w *models.Webhook) error {
+	return r.db.Create(w).Error
+}
+
+func (r *WebhookRepository) ListByUser(userID uint) ([]models.Webhook, error) {
+	var hooks []models.Webhook
+	err := r.db.Where("user_id = ?", userID).Order("id").Find(&hooks).Error
+	return hooks, err
+}
+
+// Get returns a webhook registered by userID.
+func (r *WebhookRepository) Get(id, userID uint) (*models.Webhook, error) {
+	var w models.Webhook
+	if err := r.db.Where("id = ? AND user_id = ?", id, userID).First(&w).Error; err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (r *WebhookRepository) GetByID(id uint) (*models.Webhook, error) {
+	var w models.Webhook
+	if err := r.db.First(&w, id).Error; err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (r *WebhookRepository) Delete(id, userID uint) error {
+	res := r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.Webhook{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Subscribers returns the active webhooks that want an event of eventType
+// about userID's files: the user's own and every global one.
+func (r *WebhookRepository) Subscribers(eventType string, userID uint) ([]models.Webhook, error) {
+	var hooks []models.Webhook
+	err := r.db.
+		Where("active AND (global OR user_id = ?)", userID).
+		Where("events = '' OR ? = ANY(string_to_array(events, ','))", eventType).
+		Find(&hooks).Error
+	return hooks, err
+}
+
+func (r *WebhookRepository) CreateDeliveries(deliveries []models.WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return r.db.Create(&deliveries).Error
+}
+
+// ClaimDueDelivery takes the oldest pending delivery whose retry time has
+// passed and pushes that time out by lease, so no other worker picks it up
+// while the request is in flight. Returns nil when nothing is due.
+func (r *WebhookRepository) ClaimDueDelivery(lease time.Duration) (*models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	now := time.Now()
+	err := r.db.Raw(`
+		UPDATE webhook_deliveries SET next_attempt_at = ?
+		WHERE id = (
+			SELECT id FROM webhook_deliveries
+			WHERE status = ? AND next_attempt_at <= ?
+			ORDER BY next_attempt_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING *`, now.Add(lease), models.DeliveryPending, now).
+		Scan(&deliveries).Error
+	if err != nil || len(deliveries) == 0 {
+		return nil, err
+	}
+	return &deliveries[0], nil
+}
+
+func (r *WebhookRepository) SaveDelivery(d *models.WebhookDelivery) error {
+	return r.db.Save(d).Error
+}
+
+// ListDeliveries returns a webhook's deliveries newest first, starting
+// below beforeID when it is non-zero.
+func (r *WebhookRepository) ListDeliveries(webhookID uint, beforeID uint, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	query := r.db.Where("webhook_id = ?", webhookID)
+	if beforeID > 0 {
+		query = query.Where("id < ?", beforeID)
+	}
+	err := query.Order("id DESC").Limit(limit).Find(&deliveries).Error
+	return deliveries, err
+}
+
+func (r *WebhookRepository) GetDelivery(webhookID, deliveryID uint) (*models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	if err := r.db.Where("id = ? AND webhook_id = ?", deliveryID, webhookID).First(&d).Error; err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	accessKeyIDPrefix = "VK"
+	maxAccessKeyName  = 100
+)
+
+var (
+	ErrAccessKeyNotFound    = errors.New("access key not found")
+	ErrInvalidAccessKeyName = errors.New("access key name must be at most 100 characters")
+)
+
+// AccessKeyService issues the key pairs S3 clients sign requests with.
+type AccessKeyService struct {
+	repo     *repository.AccessKeyRepository
+	userRepo *repository.UserRepository
+}
+
+func NewAccessKeyService(repo *repository.AccessKeyRepository, userRepo *repository.UserRepository) *AccessKeyService {
+	return &AccessKeyService{repo: repo, userRepo: userRepo}
+}
+
+// Create issues a key pair for userID. The secret is returned only here.
+func (as *AccessKeyService) Create(userID uint, name string) (*models.AccessKey, string, error) {
+	name = strings.TrimSpace(name)
+	if len(name) > maxAccessKeyName || (name != "" && !validName(name)) {
+		return nil, "", ErrInvalidAccessKeyName
+	}
+
+	id := make([]byte, 10)
+	secret := make([]byte, 30)
+	if _, err := rand.Read(id); err != nil {
+		return nil, "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	k := &models.AccessKey{
+		UserID:      userID,
+		AccessKeyID: accessKeyIDPrefix + base32.StdEncoding.EncodeToString(id),
+		Secret:      base64.StdEncoding.EncodeToString(secret),
+		Name:        name,
+	}
+	if err := as.repo.Create(k); err != nil {
+		return nil, "", err
+	}
+	return k, k.Secret, nil
+}
+
+func (as *AccessKeyService) List(userID uint) ([]models.AccessKey, error) {
+	return as.repo.ListByUser(userID)
+}
+
+func (as *AccessKeyService) Delete(userID, id uint) error {
+	err := as.repo.Delete(id, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrAccessKeyNotFound
+	}
+	return err
+}
+
+// Lookup returns an access key with its secret and the user it belongs to,
+// for checking a request signature.
+func (as *AccessKeyService) Lookup(accessKeyID string) (*models.AccessKey, *models.User, error) {
+	k, err := as.repo.GetByAccessKeyID(accessKeyID)
+	if err != nil {
+		return nil, nil, ErrAccessKeyNotFound
+	}
+	user, err := as.userRepo.GetByID(k.UserID)
+	if err != nil || user == nil || user.Disabled() {
+		return nil, nil, ErrAccessKeyNotFound
+	}
+	return k, user, nil
+}
+
+// Touch records that a key signed a valid request.
+func (as *AccessKeyService) Touch(k *models.AccessKey) {
+	as.repo.Touch(k.ID, time.Now())
+}
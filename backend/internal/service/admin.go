@@ -0,0 +1,732 @@
+package service
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrSameUser     = errors.New("source and destination are the same user")
+	ErrHashTaken    = errors.New("another file row already holds this content")
+	ErrFileNotFound = errors.New("file not found")
+	ErrBlobMissing  = errors.New("blob missing from disk")
+)
+
+// AdminService carries out the operator tasks behind vaultctl. It goes
+// through the same repositories and transactions as the API, so counters,
+// events and the change feed stay right.
+type AdminService struct {
+	users     *repository.UserRepository
+	userFiles *repository.UserFileRepository
+	checks    *repository.ConsistencyRepository
+	files     *FileService
+	multipart *MultipartService
+	deleter   *BlobDeleter
+	txm       *repository.TxManager
+}
+
+func NewAdminService(
+	users *repository.UserRepository,
+	userFiles *repository.UserFileRepository,
+	checks *repository.ConsistencyRepository,
+	files *FileService,
+	multipart *MultipartService,
+	deleter *BlobDeleter,
+	txm *repository.TxManager,
+) *AdminService {
+	return &AdminService{
+		users:     users,
+		userFiles: userFiles,
+		checks:    checks,
+		files:     files,
+		multipart: multipart,
+		deleter:   deleter,
+		txm:       txm,
+	}
+}
+
+// User looks a user up by name.
+func (as *AdminService) User(username string) (*models.User, error) {
+	user, err := as.users.GetByUsername(username)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+	return user, err
+}
+
+// CreateUser adds an account, as signing up would, optionally as an
+// administrator.
+func (as *AdminService) CreateUser(username, email, password string, admin bool) (*models.User, error) {
+	if username == "" || email == "" || password == "" {
+		return nil, errors.New("username, email and password are required")
+	}
+	if u, _ := as.users.GetByUsername(username); u != nil {
+		return nil, ErrUserExists
+	}
+	if u, _ := as.users.GetByEmail(email); u != nil {
+		return nil, ErrEmailExists
+	}
+	hashed, err := models.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	user := &models.User{Username: username, Email: email, Password: hashed, IsAdmin: admin}
+	if err := as.users.Create(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// SetDisabled disables or re-enables an account. A disabled user cannot
+// sign in by any means, and sessions and tokens issued earlier stop
+// working: API and WebDAV requests are refused at once, and open SFTP
+// sessions are closed within half a minute. Their files and public links
+// are left alone.
+func (as *AdminService) SetDisabled(username string, disabled bool) (*models.User, error) {
+	user, err := as.User(username)
+	if err != nil {
+		return nil, err
+	}
+	var at *time.Time
+	if disabled {
+		now := time.Now()
+		at = &now
+	}
+	if err := as.users.SetDisabled(user.ID, at); err != nil {
+		return nil, err
+	}
+	user.DisabledAt = at
+	return user, nil
+}
+
+// DeleteUser disables the account, aborts its unfinished multipart
+// uploads, deletes each of its files as the user would, so shared blobs
+// keep their other references, and then removes the account. It returns
+// how many files were deleted.
+func (as *AdminService) DeleteUser(username string) (int, error) {
+	user, err := as.SetDisabled(username, true)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := as.multipart.AbortAll(user.ID); err != nil {
+		return 0, fmt.Errorf("aborting multipart uploads: %w", err)
+	}
+	ufs, err := as.userFiles.GetUserFiles(user.ID)
+	if err != nil {
+		return 0, err
+	}
+	for i, uf := range ufs {
+		if err := as.files.DeleteFile(uf.ID, user.ID); err != nil {
+			return i, fmt.Errorf("deleting %s: %w", joinFolder(uf.Folder, uf.FileName), err)
+		}
+	}
+	return len(ufs), as.users.Delete(user.ID)
+}
+
+// SetQuota gives a user their own storage quota in bytes, or puts them back
+// on the server-wide quota when quota is nil.
+func (as *AdminService) SetQuota(username string, quota *int64) (*models.User, error) {
+	if quota != nil && *quota < 0 {
+		return nil, errors.New("quota must not be negative")
+	}
+	user, err := as.User(username)
+	if err != nil {
+		return nil, err
+	}
+	if err := as.users.SetQuota(user.ID, quota); err != nil {
+		return nil, err
+	}
+	user.QuotaBytes = quota
+	return user, nil
+}
+
+// QuotaBytes returns the quota that applies to the user.
+func (as *AdminService) QuotaBytes(user *models.User) int64 {
+	return as.files.quotaBytes(user)
+}
+
+// LargestUsers returns the limit users storing the most.
+func (as *AdminService) LargestUsers(limit int) ([]repository.UserUsage, error) {
+	rows, err := as.users.ListLargest(limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		rows[i].Quota = as.files.quotaBytes(&models.User{QuotaBytes: rows[i].QuotaBytes})
+	}
+	return rows, nil
+}
+
+// BlobProblem is a file row whose blob is missing or the wrong size.
+type BlobProblem struct {
+	FileID      uint   `json:"file_id"`
+	Hash        string `json:"hash"`
+	StoragePath string `json:"storage_path"`
+	Problem     string `json:"problem"`
+}
+
+// ConsistencyReport lists what Check found. Fixed is set when the counters
+// were repaired; blob problems need a person to look at them, and orphans
+// are removed by PurgeOrphans.
+type ConsistencyReport struct {
+	RefCounts   []repository.RefCountMismatch `json:"ref_counts"`
+	Storage     []repository.StorageMismatch  `json:"storage"`
+	Blobs       []BlobProblem                 `json:"blobs"`
+	OrphanBlobs []string                      `json:"orphan_blobs"`
+	Fixed       bool                          `json:"fixed"`
+}
+
+// OK reports whether nothing was found, or everything found was fixed.
+func (r *ConsistencyReport) OK() bool {
+	counters := len(r.RefCounts) == 0 && len(r.Storage) == 0
+	return (counters || r.Fixed) && len(r.Blobs) == 0 && len(r.OrphanBlobs) == 0
+}
+
+// consistencyBatch is how many file rows are checked against the disk at
+// a time.
+const consistencyBatch = 1000
+
+// Check compares files.ref_count with the user_files pointing at each file,
+// the users' storage counters with the files they hold, and the file rows
+// with the blobs on disk. With fix, the counters are recomputed, each under
+// the row lock uploads and deletions take.
+func (as *AdminService) Check(fix bool) (*ConsistencyReport, error) {
+	uploadDir := as.files.config.UploadDir
+	if fi, err := os.Stat(uploadDir); err != nil || !fi.IsDir() {
+		return nil, fmt.Errorf("upload directory %q not found; run from the server's working directory", uploadDir)
+	}
+
+	report := &ConsistencyReport{
+		RefCounts:   []repository.RefCountMismatch{},
+		Storage:     []repository.StorageMismatch{},
+		Blobs:       []BlobProblem{},
+		OrphanBlobs: []string{},
+	}
+	var err error
+	if report.RefCounts, err = as.checks.RefCountMismatches(); err != nil {
+		return nil, err
+	}
+	if report.Storage, err = as.checks.StorageMismatches(); err != nil {
+		return nil, err
+	}
+
+	hashes := map[string]bool{}
+	err = as.checks.EachFile(consistencyBatch, func(files []models.File) error {
+		for _, f := range files {
+			hashes[f.Hash] = true
+			fi, err := os.Stat(f.StoragePath)
+			switch {
+			case errors.Is(err, fs.ErrNotExist):
+				report.Blobs = append(report.Blobs, BlobProblem{f.ID, f.Hash, f.StoragePath, "missing"})
+			case err != nil:
+				report.Blobs = append(report.Blobs, BlobProblem{f.ID, f.Hash, f.StoragePath, err.Error()})
+			case fi.Size() != f.Size:
+				report.Blobs = append(report.Blobs, BlobProblem{f.ID, f.Hash, f.StoragePath,
+					fmt.Sprintf("size %d on disk, %d recorded", fi.Size(), f.Size)})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	orphans, err := as.orphanBlobs(hashes, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range orphans {
+		report.OrphanBlobs = append(report.OrphanBlobs, b.path)
+	}
+
+	if fix {
+		for _, m := range report.RefCounts {
+			if err := as.txm.Do(func(r repository.Repos) error {
+				return recountRefs(r, m.FileID)
+			}); err != nil {
+				return report, fmt.Errorf("fixing file %d: %w", m.FileID, err)
+			}
+		}
+		for _, m := range report.Storage {
+			if err := as.txm.Do(func(r repository.Repos) error {
+				return recountStorage(r, m.UserID)
+			}); err != nil {
+				return report, fmt.Errorf("fixing user %s: %w", m.Username, err)
+			}
+		}
+		report.Fixed = true
+	}
+	return report, nil
+}
+
+// recountRefs sets a file's ref_count from its references. A file left
+// with none keeps its row for PurgeOrphans to remove.
+func recountRefs(r repository.Repos, fileID uint) error {
+	if _, err := r.Files.LockByID(fileID); errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	count, err := r.UserFiles.CountFileReferences(fileID)
+	if err != nil {
+		return err
+	}
+	return r.Files.UpdateReferenceCount(fileID, count)
+}
+
+// recountStorage sets a user's storage counters from the files they hold.
+func recountStorage(r repository.Repos, userID uint) error {
+	if _, err := r.Users.LockByID(userID); errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	actual, expected, err := r.Checks.StorageSums(userID)
+	if err != nil {
+		return err
+	}
+	return r.Users.SetStorage(userID, actual, expected)
+}
+
+type diskBlob struct {
+	path string
+	hash string
+}
+
+// blobName matches the names blobPath gives blobs.
+var blobName = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// orphanBlobs walks the blob store for blobs whose hash no file row holds,
+// last modified at least minAge ago. Staging, previews and other dot
+// directories are not blobs and are skipped.
+func (as *AdminService) orphanBlobs(known map[string]bool, minAge time.Duration) ([]diskBlob, error) {
+	root := as.files.config.UploadDir
+	cutoff := time.Now().Add(-minAge)
+	var blobs []diskBlob
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != root && (len(d.Name()) != 2 || filepath.Dir(p) != root) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := d.Name()
+		if !d.Type().IsRegular() || !blobName.MatchString(name) || known[name] || filepath.Base(filepath.Dir(p)) != name[:2] {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if fi.ModTime().Before(cutoff) || minAge == 0 {
+			blobs = append(blobs, diskBlob{path: p, hash: name})
+		}
+		return nil
+	})
+	return blobs, err
+}
+
+// PurgeReport lists what PurgeOrphans removed, or would remove.
+type PurgeReport struct {
+	FileRows []uint   `json:"file_rows"` // unreferenced file rows dropped
+	Blobs    []string `json:"blobs"`     // blobs no row points at
+	Staging  []string `json:"staging"`   // abandoned staging files
+	Removed  int      `json:"removed"`   // blobs the deletion queue removed
+}
+
+// PurgeOrphans drops file rows no user references and queues their blobs,
+// and blobs on disk that no row points at, for deletion, then drains the
+// deletion queue. Removal goes through the queue because its worker takes
+// the same hash lock as uploads, so a blob being uploaded again is never
+// removed. Blobs and staging files younger than minAge are left alone, as
+// they may belong to uploads still in progress.
+func (as *AdminService) PurgeOrphans(minAge time.Duration, dryRun bool) (*PurgeReport, error) {
+	report := &PurgeReport{FileRows: []uint{}, Blobs: []string{}, Staging: []string{}}
+
+	rows, err := as.checks.Unreferenced()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range rows {
+		if dryRun {
+			report.FileRows = append(report.FileRows, f.ID)
+			continue
+		}
+		dropped := false
+		err := as.txm.Do(func(r repository.Repos) error {
+			file, err := r.Files.LockByID(f.ID)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			count, err := r.UserFiles.CountFileReferences(file.ID)
+			if err != nil || count > 0 {
+				// Referenced again since the scan
+				return err
+			}
+			if err := r.Files.DeleteFileRecord(file.ID); err != nil {
+				return err
+			}
+			dropped = true
+			return r.Deletions.Enqueue(file.Hash, file.StoragePath)
+		})
+		if err != nil {
+			return report, fmt.Errorf("dropping file %d: %w", f.ID, err)
+		}
+		if dropped {
+			report.FileRows = append(report.FileRows, f.ID)
+		}
+	}
+
+	hashes := map[string]bool{}
+	err = as.checks.EachFile(consistencyBatch, func(files []models.File) error {
+		for _, f := range files {
+			hashes[f.Hash] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	blobs, err := as.orphanBlobs(hashes, minAge)
+	if err != nil {
+		return report, err
+	}
+	for _, b := range blobs {
+		pending, err := as.checks.PendingDeletion(b.path)
+		if err != nil {
+			return report, err
+		}
+		if pending {
+			continue
+		}
+		if !dryRun {
+			if err := as.files.txm.Do(func(r repository.Repos) error {
+				return r.Deletions.Enqueue(b.hash, b.path)
+			}); err != nil {
+				return report, err
+			}
+		}
+		report.Blobs = append(report.Blobs, b.path)
+	}
+
+	staging := as.files.stagingDir()
+	entries, err := os.ReadDir(staging)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return report, err
+	}
+	cutoff := time.Now().Add(-minAge)
+	for _, e := range entries {
+		fi, err := e.Info()
+		if err != nil || !fi.Mode().IsRegular() || fi.ModTime().After(cutoff) {
+			continue
+		}
+		p := filepath.Join(staging, e.Name())
+		if !dryRun {
+			if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return report, err
+			}
+		}
+		report.Staging = append(report.Staging, p)
+	}
+
+	if !dryRun {
+		report.Removed, err = as.deleter.Drain()
+	}
+	return report, err
+}
+
+// RehashResult compares a file row with its blob.
+type RehashResult struct {
+	FileID      uint   `json:"file_id"`
+	StoragePath string `json:"storage_path"`
+	Hash        string `json:"hash"`
+	ActualHash  string `json:"actual_hash"`
+	Size        int64  `json:"size"`
+	ActualSize  int64  `json:"actual_size"`
+	Match       bool   `json:"match"`
+	Fixed       bool   `json:"fixed"`
+}
+
+// RehashFile hashes a file's blob again and compares it with the row. With
+// fix, a row that disagrees is brought in line with the blob: the blob
+// moves to its new content address, the holders' storage counters are
+// recomputed and sync clients see the paths as modified. A blob whose real
+// content another row already holds is left for a person to sort out.
+func (as *AdminService) RehashFile(fileID uint, fix bool) (*RehashResult, error) {
+	file, err := as.files.fileRepo.GetFileByID(fileID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrFileNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	res := &RehashResult{FileID: file.ID, StoragePath: file.StoragePath, Hash: file.Hash, Size: file.Size}
+	if res.ActualHash, res.ActualSize, err = hashBlob(file.StoragePath); err != nil {
+		return res, err
+	}
+	res.Match = res.ActualHash == file.Hash && res.ActualSize == file.Size
+	if res.Match || !fix {
+		return res, nil
+	}
+
+	moved := ""
+	err = as.txm.Do(func(r repository.Repos) error {
+		file, err := r.Files.LockByID(fileID)
+		if err != nil {
+			return err
+		}
+		if err := r.Files.LockHash(res.ActualHash); err != nil {
+			return err
+		}
+		if other, err := r.Files.GetFileByHash(res.ActualHash); err == nil && other.ID != file.ID {
+			return fmt.Errorf("%w: file %d", ErrHashTaken, other.ID)
+		}
+
+		if res.ActualHash != file.Hash {
+			dest := as.files.blobPath(res.ActualHash)
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				return err
+			}
+			if err := os.Rename(file.StoragePath, dest); err != nil {
+				return err
+			}
+			moved = dest
+			file.Hash, file.StoragePath = res.ActualHash, dest
+		}
+		file.Size = res.ActualSize
+		if err := r.Files.SetContent(file.ID, file.Hash, file.StoragePath, file.Size); err != nil {
+			return err
+		}
+
+		ufs, err := r.UserFiles.ListByFile(file.ID)
+		if err != nil {
+			return err
+		}
+		for _, uf := range ufs {
+			if err := recountStorage(r, uf.UserID); err != nil {
+				return err
+			}
+			if err := trackPath(r, uf.UserID, uf.Folder, uf.FileName); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if moved != "" {
+			// The row still names the old location
+			os.Rename(moved, res.StoragePath)
+		}
+		return res, err
+	}
+	res.Fixed = true
+	if moved != "" {
+		res.StoragePath = moved
+	}
+	return res, nil
+}
+
+func hashBlob(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", 0, fmt.Errorf("%w: %s", ErrBlobMissing, path)
+	} else if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// TransferResult reports what happened to one file of a transfer.
+type TransferResult struct {
+	UserFileID uint   `json:"id"`
+	From       string `json:"from"`
+	To         string `json:"to,omitempty"`
+	Status     string `json:"status"` // transferred, merged or failed
+	Error      string `json:"error,omitempty"`
+}
+
+// TransferSelection picks the files of a transfer: the listed IDs, or
+// everything in Folder and below, or, when both are empty, everything.
+type TransferSelection struct {
+	IDs    []uint
+	Folder string
+	Into   string // folder the files are placed under at the recipient
+}
+
+// TransferFiles hands files from one user to another, keeping their names,
+// tags, visibility and public links. Storage is moved between the users'
+// counters without checking the recipient's quota. If the recipient
+// already holds the same content, the sender's copy is dropped instead
+// ("merged"). Each file moves in its own transaction, so a failure part way
+// leaves the files done so far with the recipient.
+func (as *AdminService) TransferFiles(from, to string, sel TransferSelection) ([]TransferResult, error) {
+	src, err := as.User(from)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := as.User(to)
+	if err != nil {
+		return nil, err
+	}
+	if src.ID == dst.ID {
+		return nil, ErrSameUser
+	}
+	into, err := normalizeFolder(sel.Into)
+	if err != nil {
+		return nil, err
+	}
+
+	var ufs []models.UserFile
+	switch {
+	case len(sel.IDs) > 0:
+		for _, id := range sel.IDs {
+			uf, err := as.userFiles.GetUserFileByID(id, src.ID)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, fmt.Errorf("%s holds no file %d", from, id)
+			} else if err != nil {
+				return nil, err
+			}
+			ufs = append(ufs, *uf)
+		}
+	case sel.Folder != "":
+		folder, err := normalizeFolder(sel.Folder)
+		if err != nil {
+			return nil, err
+		}
+		if ufs, err = as.userFiles.GetInFolderTree(src.ID, folder); err != nil {
+			return nil, err
+		}
+	default:
+		if ufs, err = as.userFiles.GetUserFiles(src.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]TransferResult, 0, len(ufs))
+	for _, uf := range ufs {
+		res := TransferResult{UserFileID: uf.ID, From: joinFolder(uf.Folder, uf.FileName)}
+		folder := joinFolder(into, uf.Folder)
+		merged := false
+		err := as.txm.Do(func(r repository.Repos) error {
+			var err error
+			merged, err = transferOne(r, uf.ID, src, dst.ID, folder)
+			return err
+		})
+		switch {
+		case err != nil:
+			res.Status, res.Error = "failed", err.Error()
+		case merged:
+			res.Status = "merged"
+		default:
+			res.Status, res.To = "transferred", joinFolder(folder, uf.FileName)
+		}
+		results = append(results, res)
+	}
+	as.files.eventsQueued()
+	return results, nil
+}
+
+// transferOne moves one user file to another user inside r's transaction,
+// reporting whether the recipient already held the content.
+func transferOne(r repository.Repos, userFileID uint, from *models.User, toID uint, folder string) (bool, error) {
+	fromID := from.ID
+	uf, err := r.UserFiles.GetUserFileByID(userFileID, fromID)
+	if err != nil {
+		return false, err
+	}
+	file, err := r.Files.LockByID(uf.FileID)
+	if err != nil {
+		return false, err
+	}
+	if uf.IsOwner {
+		// If the sender keeps the content at another path, that copy goes
+		// on paying for the blob
+		refs, err := r.UserFiles.ListByFile(file.ID)
+		if err != nil {
+			return false, err
+		}
+		for i := range refs {
+			if refs[i].UserID == fromID && refs[i].ID != uf.ID {
+				if err := r.UserFiles.SetOwner(&refs[i], true); err != nil {
+					return false, err
+				}
+				if err := r.UserFiles.SetOwner(uf, false); err != nil {
+					return false, err
+				}
+				break
+			}
+		}
+	}
+	owned := int64(0)
+	if uf.IsOwner {
+		owned = file.Size
+	}
+	oldFolder, oldName := uf.Folder, uf.FileName
+	if err := emit(r, models.EventFileDeleted, fromID, fileEventData(uf, file)); err != nil {
+		return false, err
+	}
+	if err := r.Users.UpdateUserStorage(fromID, -owned, -file.Size); err != nil {
+		return false, err
+	}
+
+	existing, err := r.UserFiles.GetByUserAndFile(toID, file.ID)
+	if err != nil {
+		return false, err
+	}
+	if existing != nil {
+		// The recipient keeps their copy and, if the sender paid for the
+		// blob, takes over paying for it
+		if uf.IsOwner && !existing.IsOwner {
+			if err := r.UserFiles.SetOwner(existing, true); err != nil {
+				return false, err
+			}
+			if err := r.Users.UpdateUserStorage(toID, owned, 0); err != nil {
+				return false, err
+			}
+		}
+		if err := r.UserFiles.DeleteUserFile(fromID, uf.ID); err != nil {
+			return false, err
+		}
+		if err := recountRefs(r, file.ID); err != nil {
+			return false, err
+		}
+		return true, trackPath(r, fromID, oldFolder, oldName)
+	}
+
+	if err := r.UserFiles.Transfer(uf, toID, folder); err != nil {
+		return false, err
+	}
+	if err := r.Users.UpdateUserStorage(toID, owned, file.Size); err != nil {
+		return false, err
+	}
+	shared := SharedFileEventData{FileEventData: fileEventData(uf, file), From: from.Username}
+	if err := emit(r, models.EventFileSharedWithYou, toID, shared); err != nil {
+		return false, err
+	}
+	if err := trackPath(r, fromID, oldFolder, oldName); err != nil {
+		return false, err
+	}
+	return false, trackPath(r, toID, uf.Folder, uf.FileName)
+}
@@ -0,0 +1,299 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/models"
+	"backend/internal/repository"
+
+	"gorm.io/gorm"
+)
+
+func newTestAdminService(t *testing.T, conn *gorm.DB, svc *FileService) *AdminService {
+	t.Helper()
+	return NewAdminService(
+		repository.NewUserRepository(conn),
+		repository.NewUserFileRepository(conn),
+		repository.NewConsistencyRepository(conn),
+		svc,
+		NewMultipartService(repository.NewMultipartRepository(conn), svc, time.Hour),
+		svc.deleter,
+		repository.NewTxManager(conn),
+	)
+}
+
+func upload(t *testing.T, svc *FileService, userID uint, folder, name, content string) *models.UserFile {
+	t.Helper()
+	uf, err := svc.ProcessFileUpload(userID, folder, name, strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uf
+}
+
+// writeBlob puts a file in the blob store as if it had been uploaded,
+// last modified age ago.
+func writeBlob(t *testing.T, path, content string, age time.Duration) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(-age)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckReportsAndFixesDrift(t *testing.T) {
+	conn := openTestDB(t)
+	svc := newTestFileService(t, conn)
+	as := newTestAdminService(t, conn, svc)
+	users := createTestUsers(t, conn, 2)
+
+	shared := upload(t, svc, users[0].ID, "", "shared.txt", "held by both")
+	upload(t, svc, users[1].ID, "", "copy.txt", "held by both")
+	truncated := upload(t, svc, users[0].ID, "", "truncated.txt", "cut short on disk")
+	missing := upload(t, svc, users[1].ID, "", "missing.txt", "gone from disk")
+
+	report, err := as.Check(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || len(report.RefCounts)+len(report.Storage)+len(report.Blobs)+len(report.OrphanBlobs) != 0 {
+		t.Fatalf("consistent store reported as %+v", report)
+	}
+
+	conn.Exec("UPDATE files SET ref_count = 5 WHERE id = ?", shared.FileID)
+	conn.Exec("UPDATE users SET actual_storage = 1, expected_storage = 2 WHERE id = ?", users[1].ID)
+	if err := os.WriteFile(svc.blobPath(fileHash(t, conn, truncated.FileID)), []byte("cut"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(svc.blobPath(fileHash(t, conn, missing.FileID))); err != nil {
+		t.Fatal(err)
+	}
+	_, orphanHash := randomContent(t, 16)
+	orphan := svc.blobPath(orphanHash)
+	writeBlob(t, orphan, "nobody's", 0)
+	// Not blobs: wrong directory, wrong name, staging and previews
+	writeBlob(t, filepath.Join(svc.config.UploadDir, "zz", orphanHash), "x", 0)
+	writeBlob(t, filepath.Join(filepath.Dir(orphan), "notes.txt"), "x", 0)
+	writeBlob(t, filepath.Join(svc.stagingDir(), orphanHash), "x", 0)
+	writeBlob(t, filepath.Join(svc.config.UploadDir, ".previews", orphanHash[:2], orphanHash), "x", 0)
+
+	report, err = as.Check(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() || report.Fixed {
+		t.Errorf("drifted store reported OK")
+	}
+	if len(report.RefCounts) != 1 || report.RefCounts[0].FileID != shared.FileID || report.RefCounts[0].Actual != 2 {
+		t.Errorf("ref counts %+v, want file %d held twice", report.RefCounts, shared.FileID)
+	}
+	if len(report.Storage) != 1 || report.Storage[0].UserID != users[1].ID {
+		t.Errorf("storage %+v, want only user %d", report.Storage, users[1].ID)
+	}
+	problems := map[uint]string{}
+	for _, b := range report.Blobs {
+		problems[b.FileID] = b.Problem
+	}
+	if len(problems) != 2 || problems[missing.FileID] != "missing" ||
+		!strings.HasPrefix(problems[truncated.FileID], "size 3 on disk") {
+		t.Errorf("blob problems %+v", report.Blobs)
+	}
+	if len(report.OrphanBlobs) != 1 || report.OrphanBlobs[0] != orphan {
+		t.Errorf("orphan blobs %v, want [%s]", report.OrphanBlobs, orphan)
+	}
+	// Checking alone changes nothing
+	var refCount int
+	conn.Raw("SELECT ref_count FROM files WHERE id = ?", shared.FileID).Scan(&refCount)
+	if refCount != 5 {
+		t.Errorf("ref_count changed to %d by a check without fix", refCount)
+	}
+
+	report, err = as.Check(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Fixed {
+		t.Error("fix not reported")
+	}
+	report, err = as.Check(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.RefCounts) != 0 || len(report.Storage) != 0 {
+		t.Errorf("counters still off after fixing: %+v, %+v", report.RefCounts, report.Storage)
+	}
+	if len(report.Blobs) != 2 || len(report.OrphanBlobs) != 1 {
+		t.Errorf("blob problems %+v and orphans %v should be left for the operator", report.Blobs, report.OrphanBlobs)
+	}
+	size := int64(len("held by both") + len("gone from disk"))
+	checkUserStorage(t, conn, users[1].ID, int64(len("gone from disk")), size)
+
+	svc.config.UploadDir = filepath.Join(t.TempDir(), "absent")
+	if _, err := as.Check(false); err == nil {
+		t.Error("checked without an upload directory")
+	}
+}
+
+func TestPurgeOrphans(t *testing.T) {
+	conn := openTestDB(t)
+	svc := newTestFileService(t, conn)
+	as := newTestAdminService(t, conn, svc)
+	user := createTestUsers(t, conn, 1)[0]
+
+	kept := upload(t, svc, user.ID, "", "kept.txt", "still referenced")
+	dropped := upload(t, svc, user.ID, "", "dropped.txt", "lost its reference")
+	conn.Exec("DELETE FROM user_files WHERE id = ?", dropped.ID)
+	droppedBlob := svc.blobPath(fileHash(t, conn, dropped.FileID))
+
+	_, oldHash := randomContent(t, 16)
+	_, youngHash := randomContent(t, 16)
+	oldBlob, youngBlob := svc.blobPath(oldHash), svc.blobPath(youngHash)
+	writeBlob(t, oldBlob, "left by a crash", 2*time.Hour)
+	writeBlob(t, youngBlob, "maybe uploading", 0)
+	oldStaging := filepath.Join(svc.stagingDir(), "upload-1")
+	youngStaging := filepath.Join(svc.stagingDir(), "upload-2")
+	writeBlob(t, oldStaging, "abandoned", 2*time.Hour)
+	writeBlob(t, youngStaging, "in progress", 0)
+
+	exists := func(paths ...string) []bool {
+		var found []bool
+		for _, p := range paths {
+			_, err := os.Stat(p)
+			found = append(found, err == nil)
+		}
+		return found
+	}
+	all := []string{droppedBlob, oldBlob, youngBlob, oldStaging, youngStaging}
+
+	report, err := as.PurgeOrphans(time.Hour, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.FileRows) != 1 || report.FileRows[0] != dropped.FileID ||
+		len(report.Blobs) != 1 || report.Blobs[0] != oldBlob ||
+		len(report.Staging) != 1 || report.Staging[0] != oldStaging || report.Removed != 0 {
+		t.Errorf("dry run = %+v", report)
+	}
+	for i, ok := range exists(all...) {
+		if !ok {
+			t.Errorf("dry run removed %s", all[i])
+		}
+	}
+	var rows int64
+	conn.Model(&models.File{}).Where("id = ?", dropped.FileID).Count(&rows)
+	if rows != 1 || len(queuedDeletions(t, conn)) != 0 {
+		t.Errorf("dry run dropped the row or queued deletions")
+	}
+
+	report, err = as.PurgeOrphans(time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.FileRows) != 1 || len(report.Blobs) != 1 || len(report.Staging) != 1 || report.Removed != 2 {
+		t.Errorf("purge = %+v; want the row, both old blobs and the old staging file", report)
+	}
+	want := []bool{false, false, true, false, true}
+	for i, ok := range exists(all...) {
+		if ok != want[i] {
+			t.Errorf("%s exists = %v after purging, want %v", all[i], ok, want[i])
+		}
+	}
+	conn.Model(&models.File{}).Where("id = ?", dropped.FileID).Count(&rows)
+	if rows != 0 {
+		t.Error("unreferenced file row kept")
+	}
+	if _, err := os.Stat(svc.blobPath(fileHash(t, conn, kept.FileID))); err != nil {
+		t.Errorf("referenced blob removed: %v", err)
+	}
+
+	// Without a minimum age the young leftovers go too
+	report, err = as.PurgeOrphans(0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Blobs) != 1 || len(report.Staging) != 1 || report.Removed != 1 {
+		t.Errorf("purge without a minimum age = %+v", report)
+	}
+	report, err = as.PurgeOrphans(0, false)
+	if err != nil || len(report.FileRows)+len(report.Blobs)+len(report.Staging)+report.Removed != 0 {
+		t.Errorf("second purge = %+v, %v; want nothing left", report, err)
+	}
+}
+
+func TestTransferFiles(t *testing.T) {
+	conn := openTestDB(t)
+	svc := newTestFileService(t, conn)
+	as := newTestAdminService(t, conn, svc)
+	users := createTestUsers(t, conn, 2)
+	alice, bob := users[0], users[1]
+
+	report := upload(t, svc, alice.ID, "docs", "report.txt", "quarterly report")
+	dup := upload(t, svc, alice.ID, "docs/old", "dup.txt", "both have this")
+	upload(t, svc, bob.ID, "", "mine.txt", "both have this")
+	stays := upload(t, svc, alice.ID, "photos", "cat.txt", "not transferred")
+	if _, err := svc.ChangeVisibility(alice.ID, report.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	var before models.UserFile
+	conn.First(&before, report.ID)
+
+	results, err := as.TransferFiles(alice.Username, bob.Username, TransferSelection{Folder: "docs", Into: "from-alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := map[uint]TransferResult{}
+	for _, r := range results {
+		status[r.UserFileID] = r
+	}
+	if len(results) != 2 ||
+		status[report.ID].Status != "transferred" || status[report.ID].To != "from-alice/docs/report.txt" ||
+		status[dup.ID].Status != "merged" || status[dup.ID].From != "docs/old/dup.txt" {
+		t.Fatalf("results %+v", results)
+	}
+
+	var after models.UserFile
+	conn.First(&after, report.ID)
+	if after.UserID != bob.ID || after.Folder != "from-alice/docs" || after.PublicToken == nil ||
+		before.PublicToken == nil || *after.PublicToken != *before.PublicToken {
+		t.Errorf("transferred file = %+v; want it with bob, under from-alice, keeping its link", after)
+	}
+	var n int64
+	conn.Model(&models.UserFile{}).Where("id = ?", dup.ID).Count(&n)
+	if n != 0 {
+		t.Error("merged copy kept for alice")
+	}
+	var mine models.UserFile
+	conn.Where("user_id = ? AND file_name = ?", bob.ID, "mine.txt").First(&mine)
+	if !mine.IsOwner {
+		t.Error("bob's copy of merged content is not the owner")
+	}
+
+	left := int64(len("not transferred"))
+	checkUserStorage(t, conn, alice.ID, left, left)
+	got := int64(len("quarterly report") + len("both have this"))
+	checkUserStorage(t, conn, bob.ID, got, got)
+	if check, err := as.Check(false); err != nil || len(check.RefCounts)+len(check.Storage) != 0 {
+		t.Errorf("counters after transfer: %+v, %v", check, err)
+	}
+
+	if _, err := as.TransferFiles(alice.Username, bob.Username, TransferSelection{IDs: []uint{report.ID}}); err == nil {
+		t.Error("transferred a file alice no longer holds")
+	}
+	if _, err := as.TransferFiles(alice.Username, alice.Username, TransferSelection{IDs: []uint{stays.ID}}); err != ErrSameUser {
+		t.Errorf("transfer to oneself: err %v, want ErrSameUser", err)
+	}
+	if _, err := as.TransferFiles(alice.Username, "nobody", TransferSelection{}); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("transfer to an unknown user: err %v, want ErrUserNotFound", err)
+	}
+}
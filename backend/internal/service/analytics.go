@@ -0,0 +1,186 @@
+package service
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/netip"
+	"net/url"
+	"time"
+)
+
+const (
+	defaultStatsRange = 30 * 24 * time.Hour
+	maxStatsBuckets   = 5000
+	maxReferrerLen    = 1024
+)
+
+// statsBuckets are the supported bucket sizes and their approximate length,
+// used to bound how many buckets a query can produce.
+var statsBuckets = map[string]time.Duration{
+	"hour":  time.Hour,
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+}
+
+// AnalyticsService records downloads and aggregates them for file owners.
+type AnalyticsService struct {
+	repo         *repository.DownloadEventRepository
+	userFileRepo *repository.UserFileRepository
+}
+
+func NewAnalyticsService(repo *repository.DownloadEventRepository, userFileRepo *repository.UserFileRepository) *AnalyticsService {
+	return &AnalyticsService{repo: repo, userFileRepo: userFileRepo}
+}
+
+// LinkID identifies a public link by a digest of its token, so analytics
+// never store the token itself and outlive the link being revoked.
+func LinkID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// AnonymizeIP zeroes the host part of an address: IPv4 is kept to its /24
+// and IPv6 to its /48.
+func AnonymizeIP(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+	bits := 24
+	if addr.Is6() {
+		bits = 48
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+	return prefix.Addr().String()
+}
+
+// RecordDownloads stores download events. Like auditing, a failure here
+// never affects the download itself, so errors are only logged.
+func (as *AnalyticsService) RecordDownloads(events []models.DownloadEvent) {
+	for i := range events {
+		e := &events[i]
+		e.IP = AnonymizeIP(e.IP)
+		e.UserAgent = truncateText(e.UserAgent, maxUserAgentLen)
+		e.Referrer = truncateText(e.Referrer, maxReferrerLen)
+	}
+	if err := as.repo.Create(events); err != nil {
+		log.Printf("analytics: failed to record %d downloads: %v", len(events), err)
+	}
+}
+
+// ParseDownloadStatsQuery validates the bucket size, time range and link of
+// a stats request. The range defaults to the last 30 days.
+func ParseDownloadStatsQuery(q url.Values) (repository.DownloadStatsQuery, error) {
+	sq := repository.DownloadStatsQuery{Bucket: q.Get("bucket"), LinkID: q.Get("link")}
+	errs := FilterErrors{}
+
+	if sq.Bucket == "" {
+		sq.Bucket = "day"
+	}
+	width, ok := statsBuckets[sq.Bucket]
+	if !ok {
+		errs["bucket"] = "must be hour, day, week or month"
+	}
+
+	sq.To = time.Now()
+	if to := parseFilterDate(q.Get("to"), "to", true, errs); to != nil {
+		sq.To = *to
+	}
+	sq.From = sq.To.Add(-defaultStatsRange)
+	if from := parseFilterDate(q.Get("from"), "from", false, errs); from != nil {
+		sq.From = *from
+	}
+	if !sq.From.Before(sq.To) {
+		errs["to"] = "must be after from"
+	} else if ok && sq.To.Sub(sq.From)/width > maxStatsBuckets {
+		errs["bucket"] = "too many buckets for this range; use a larger bucket"
+	}
+
+	if len(errs) > 0 {
+		return repository.DownloadStatsQuery{}, errs
+	}
+	return sq, nil
+}
+
+// LinkStats is the usage of one public link. Current marks the link the
+// file is shared under right now.
+type LinkStats struct {
+	repository.LinkSummary
+	Current bool `json:"current"`
+}
+
+// DownloadTotals sums the buckets of a stats response. Unique visitors are
+// per bucket only, as they don't add up across buckets.
+type DownloadTotals struct {
+	Downloads int64 `json:"downloads"`
+	Completed int64 `json:"completed"`
+	Partial   int64 `json:"partial"`
+	BytesSent int64 `json:"bytes_sent"`
+}
+
+// DownloadStats is the download history of one user file.
+type DownloadStats struct {
+	Bucket  string                      `json:"bucket"`
+	From    time.Time                   `json:"from"`
+	To      time.Time                   `json:"to"`
+	Totals  DownloadTotals              `json:"totals"`
+	Buckets []repository.DownloadBucket `json:"buckets"`
+	Links   []LinkStats                 `json:"links"`
+}
+
+// GetDownloadStats returns time-bucketed downloads of a file the caller
+// owns, optionally restricted to one link, plus a summary of every link.
+func (as *AnalyticsService) GetDownloadStats(userID, userfileID uint, q repository.DownloadStatsQuery) (*DownloadStats, error) {
+	uf, err := as.userFileRepo.GetUserFileByID(userfileID, userID)
+	if err != nil {
+		return nil, errors.New("file not found")
+	}
+	q.UserFileID = uf.ID
+
+	buckets, err := as.repo.Buckets(q)
+	if err != nil {
+		return nil, err
+	}
+	summaries, err := as.repo.Links(uf.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &DownloadStats{
+		Bucket:  q.Bucket,
+		From:    q.From,
+		To:      q.To,
+		Buckets: buckets,
+		Links:   make([]LinkStats, 0, len(summaries)+1),
+	}
+	for _, b := range buckets {
+		stats.Totals.Downloads += b.Downloads
+		stats.Totals.Completed += b.Completed
+		stats.Totals.Partial += b.Partial
+		stats.Totals.BytesSent += b.BytesSent
+	}
+
+	current := ""
+	if uf.Visibility == "public" && uf.PublicToken != nil {
+		current = LinkID(*uf.PublicToken)
+	}
+	seenCurrent := false
+	for _, s := range summaries {
+		isCurrent := s.LinkID == current
+		seenCurrent = seenCurrent || isCurrent
+		stats.Links = append(stats.Links, LinkStats{LinkSummary: s, Current: isCurrent})
+	}
+	if current != "" && !seenCurrent {
+		stats.Links = append([]LinkStats{{LinkSummary: repository.LinkSummary{LinkID: current}, Current: true}}, stats.Links...)
+	}
+	return stats, nil
+}
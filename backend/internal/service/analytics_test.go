@@ -0,0 +1,167 @@
+package service
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/models"
+	"backend/internal/repository"
+)
+
+func TestAnonymizeIP(t *testing.T) {
+	for in, want := range map[string]string{
+		"203.0.113.77":                 "203.0.113.0",
+		"203.0.113.0":                  "203.0.113.0",
+		"::ffff:198.51.100.9":          "198.51.100.0", // mapped IPv4 is kept as IPv4
+		"2001:db8:abcd:12:34:56:78:9a": "2001:db8:abcd::",
+		"2001:db8:abcd:ffff::1%eth0":   "2001:db8:abcd::",
+		"fe80::1":                      "fe80::",
+		"":                             "",
+		"not an address":               "",
+		"203.0.113.77:443":             "", // callers pass the host only
+	} {
+		if got := AnonymizeIP(in); got != want {
+			t.Errorf("AnonymizeIP(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestLinkID(t *testing.T) {
+	token := "4f1c2a9be0d34c77a1e5f0b2c3d4e5f6"
+	id := LinkID(token)
+	if len(id) != 16 || strings.Contains(token, id) || strings.Contains(id, token[:8]) {
+		t.Errorf("LinkID = %q, want 16 hex digits unrelated to the token", id)
+	}
+	if LinkID(token) != id || LinkID(token+"x") == id {
+		t.Error("LinkID is not a stable digest of the token")
+	}
+}
+
+func TestParseDownloadStatsQuery(t *testing.T) {
+	before := time.Now()
+	sq, err := ParseDownloadStatsQuery(url.Values{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sq.Bucket != "day" || sq.To.Before(before) || sq.To.Sub(sq.From) != defaultStatsRange {
+		t.Errorf("defaults = %+v; want daily buckets over the last 30 days", sq)
+	}
+
+	sq, err = ParseDownloadStatsQuery(url.Values{
+		"bucket": {"hour"}, "from": {"2026-03-01"}, "to": {"2026-03-02"}, "link": {"abc"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	if !sq.From.Equal(from) || !sq.To.Equal(from.AddDate(0, 0, 2)) || sq.LinkID != "abc" {
+		t.Errorf("query = %+v; want both days whole, for link abc", sq)
+	}
+
+	for _, tt := range []struct {
+		query, field string
+	}{
+		{"bucket=minute", "bucket"},
+		{"from=2026-03-05&to=2026-03-01", "to"},
+		{"from=yesterday", "from"},
+		{"bucket=hour&from=2025-01-01&to=2026-01-01", "bucket"}, // 8760 buckets
+	} {
+		v, _ := url.ParseQuery(tt.query)
+		_, err := ParseDownloadStatsQuery(v)
+		fe, ok := err.(FilterErrors)
+		if !ok || fe[tt.field] == "" {
+			t.Errorf("%s: err %v, want a problem with %s", tt.query, err, tt.field)
+		}
+	}
+	v, _ := url.ParseQuery("bucket=month&from=2025-01-01&to=2026-01-01")
+	if _, err := ParseDownloadStatsQuery(v); err != nil {
+		t.Errorf("a year in months: %v", err)
+	}
+}
+
+func TestDownloadStats(t *testing.T) {
+	conn := openTestDB(t)
+	svc := newTestFileService(t, conn)
+	as := NewAnalyticsService(repository.NewDownloadEventRepository(conn), repository.NewUserFileRepository(conn))
+	users := createTestUsers(t, conn, 2)
+	owner := users[0].ID
+
+	uf, err := svc.ProcessFileUpload(owner, "", "a.txt", strings.NewReader("shared file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.ChangeVisibility(owner, uf.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	var shared models.UserFile
+	conn.First(&shared, uf.ID)
+	current := LinkID(*shared.PublicToken)
+	revoked := LinkID("an earlier token")
+
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	event := func(at time.Duration, link string, ip string, bytes int64, completed bool) models.DownloadEvent {
+		e := models.DownloadEvent{
+			UserFileID: uf.ID, FileID: uf.FileID, Via: models.DownloadViaDirect,
+			IP: ip, BytesSent: bytes, Completed: completed, CreatedAt: day.Add(at),
+		}
+		if link != "" {
+			e.LinkID, e.Via = &link, models.DownloadViaLink
+		}
+		return e
+	}
+	as.RecordDownloads([]models.DownloadEvent{
+		event(9*time.Hour, revoked, "203.0.113.5", 11, true),
+		event(9*time.Hour+59*time.Minute, current, "203.0.113.200", 11, true), // same /24: one visitor
+		event(10*time.Hour, current, "198.51.100.1", 4, false),
+		event(24*time.Hour-time.Microsecond, "", "2001:db8::1", 11, true),
+		event(24*time.Hour, current, "2001:db8::2", 11, true),    // next day, UTC
+		event(-time.Microsecond, current, "192.0.2.1", 11, true), // before the range
+	})
+
+	var ips []string
+	conn.Model(&models.DownloadEvent{}).Where("user_file_id = ?", uf.ID).Distinct().Order("ip").Pluck("ip", &ips)
+	if got := strings.Join(ips, " "); got != "192.0.2.0 198.51.100.0 2001:db8:: 203.0.113.0" {
+		t.Errorf("stored IPs %s, want host bits zeroed", got)
+	}
+
+	q := repository.DownloadStatsQuery{Bucket: "day", From: day, To: day.AddDate(0, 0, 2)}
+	stats, err := as.GetDownloadStats(owner, uf.ID, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Buckets) != 2 || !stats.Buckets[0].Start.Equal(day) || !stats.Buckets[1].Start.Equal(day.AddDate(0, 0, 1)) {
+		t.Fatalf("buckets %+v, want the two days", stats.Buckets)
+	}
+	b := stats.Buckets[0]
+	if b.Downloads != 4 || b.Completed != 3 || b.Partial != 1 || b.UniqueVisitors != 3 || b.BytesSent != 37 {
+		t.Errorf("first day = %+v", b)
+	}
+	if stats.Totals != (DownloadTotals{Downloads: 5, Completed: 4, Partial: 1, BytesSent: 48}) {
+		t.Errorf("totals = %+v", stats.Totals)
+	}
+
+	q.Bucket = "hour"
+	q.LinkID = current
+	stats, err = as.GetDownloadStats(owner, uf.ID, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var starts []string
+	for _, b := range stats.Buckets {
+		starts = append(starts, b.Start.UTC().Format("02T15"))
+	}
+	if got := strings.Join(starts, " "); got != "01T09 01T10 02T00" {
+		t.Errorf("hourly buckets for the current link start at %s", got)
+	}
+
+	if len(stats.Links) != 2 || stats.Links[0].LinkID != current || !stats.Links[0].Current ||
+		stats.Links[0].Downloads != 4 || stats.Links[1].LinkID != revoked || stats.Links[1].Current {
+		t.Errorf("links = %+v; want the current link first, then the revoked one", stats.Links)
+	}
+
+	if _, err := as.GetDownloadStats(users[1].ID, uf.ID, q); err == nil {
+		t.Error("another user read the file's download stats")
+	}
+}
@@ -0,0 +1,255 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"backend/internal/models"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+)
+
+const (
+	defaultMaxArchiveEntries      = 10000
+	defaultMaxArchiveExpandedSize = 10 << 30 // 10 GiB
+	defaultMaxCompressionRatio    = 200
+
+	// ratioSlack lets small, highly compressible archives through the
+	// compression ratio check.
+	ratioSlack = 1 << 20
+)
+
+var (
+	ErrUnsupportedArchive = errors.New("unsupported archive format")
+	ErrInvalidArchive     = errors.New("invalid archive")
+	ErrArchiveLimit       = errors.New("archive exceeds extraction limits")
+	ErrUnsupportedEntry   = errors.New("unsupported archive entry type")
+)
+
+// UploadResult reports the outcome of one file in a bulk upload or archive
+// extraction. Err is nil on success.
+type UploadResult struct {
+	Filename string
+	Folder   string
+	UserFile *models.UserFile
+	Err      error
+}
+
+// ExtractArchive unpacks a ZIP or tar.gz upload into individual user files
+// under folder, preserving the archive's directory structure. Every entry
+// is stored with ProcessFileUpload, and a failing entry does not stop the
+// others. Extraction is aborted with
+// ErrArchiveLimit if the archive looks like a decompression bomb; results
+// for the entries processed so far are still returned.
+func (fs *FileService) ExtractArchive(userID uint, folder, archiveName string, src io.Reader) ([]UploadResult, error) {
+	folder, err := normalizeFolder(folder)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.ToLower(archiveName)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return fs.extractZip(userID, folder, src)
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return fs.extractTarGz(userID, folder, src)
+	default:
+		return nil, ErrUnsupportedArchive
+	}
+}
+
+// extractZip spools the archive to the staging area, since the ZIP central
+// directory lives at the end of the file, then extracts entries from it.
+func (fs *FileService) extractZip(userID uint, folder string, src io.Reader) ([]UploadResult, error) {
+	spool, err := fs.createStaging(fs.stagingDir(), "archive-*")
+	if err != nil {
+		return nil, err
+	}
+	defer fs.removeStaging(spool.Name())
+	defer spool.Close()
+
+	body := src
+	if fs.config.MaxFileSize > 0 {
+		body = io.LimitReader(src, fs.config.MaxFileSize+1)
+	}
+	compressed, err := io.Copy(spool, body)
+	if err != nil {
+		return nil, ErrUploadRead
+	}
+	if fs.config.MaxFileSize > 0 && compressed > fs.config.MaxFileSize {
+		return nil, ErrFileTooLarge
+	}
+
+	zr, err := zip.NewReader(spool, compressed)
+	if err != nil {
+		return nil, ErrInvalidArchive
+	}
+	if len(zr.File) > fs.maxArchiveEntries() {
+		return nil, ErrArchiveLimit
+	}
+
+	budget := fs.newExpansionBudget(func() int64 { return compressed })
+	results := make([]UploadResult, 0, len(zr.File))
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		res := UploadResult{Filename: f.Name}
+		res.Folder, res.Filename, err = splitEntryPath(folder, f.Name)
+		if err != nil {
+			res.Filename, res.Err = f.Name, err
+			results = append(results, res)
+			continue
+		}
+
+		if !f.Mode().IsRegular() {
+			res.Err = ErrUnsupportedEntry
+			results = append(results, res)
+			continue
+		}
+		// Reject entries whose declared sizes already give them away
+		if !budget.allows(f.UncompressedSize64, f.CompressedSize64) {
+			res.Err = ErrArchiveLimit
+			return append(results, res), ErrArchiveLimit
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			res.Err = ErrInvalidArchive
+			results = append(results, res)
+			continue
+		}
+		res.UserFile, res.Err = fs.ProcessFileUpload(userID, res.Folder, res.Filename, budget.reader(rc))
+		rc.Close()
+		if budget.exceeded {
+			res.Err = ErrArchiveLimit
+			return append(results, res), ErrArchiveLimit
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// extractTarGz streams entries straight out of the gzip stream; no spooling
+// is needed because tar is sequential.
+func (fs *FileService) extractTarGz(userID uint, folder string, src io.Reader) ([]UploadResult, error) {
+	counted := &countingReader{r: src}
+	gz, err := gzip.NewReader(counted)
+	if err != nil {
+		return nil, ErrInvalidArchive
+	}
+	defer gz.Close()
+
+	budget := fs.newExpansionBudget(func() int64 { return counted.n })
+	tr := tar.NewReader(gz)
+	var results []UploadResult
+	for entries := 0; ; entries++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return results, nil
+		}
+		if err != nil {
+			return results, ErrInvalidArchive
+		}
+		if entries >= fs.maxArchiveEntries() {
+			return results, ErrArchiveLimit
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+
+		res := UploadResult{Filename: hdr.Name}
+		res.Folder, res.Filename, err = splitEntryPath(folder, hdr.Name)
+		if err != nil {
+			res.Filename, res.Err = hdr.Name, err
+			results = append(results, res)
+			continue
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			// Symlinks, hard links and devices are never materialised
+			res.Err = ErrUnsupportedEntry
+			results = append(results, res)
+			continue
+		}
+
+		res.UserFile, res.Err = fs.ProcessFileUpload(userID, res.Folder, res.Filename, budget.reader(tr))
+		if budget.exceeded {
+			res.Err = ErrArchiveLimit
+			return append(results, res), ErrArchiveLimit
+		}
+		results = append(results, res)
+	}
+}
+
+func (fs *FileService) maxArchiveEntries() int {
+	if fs.config.MaxArchiveEntries > 0 {
+		return fs.config.MaxArchiveEntries
+	}
+	return defaultMaxArchiveEntries
+}
+
+// expansionBudget tracks how many bytes an archive has expanded to, failing
+// reads once the total or the ratio to compressed input gets out of hand.
+type expansionBudget struct {
+	maxTotal   int64
+	maxRatio   int64
+	compressed func() int64
+	expanded   int64
+	exceeded   bool
+}
+
+func (fs *FileService) newExpansionBudget(compressed func() int64) *expansionBudget {
+	b := &expansionBudget{
+		maxTotal:   fs.config.MaxArchiveExpandedSize,
+		maxRatio:   int64(fs.config.MaxCompressionRatio),
+		compressed: compressed,
+	}
+	if b.maxTotal <= 0 {
+		b.maxTotal = defaultMaxArchiveExpandedSize
+	}
+	if b.maxRatio <= 0 {
+		b.maxRatio = defaultMaxCompressionRatio
+	}
+	return b
+}
+
+// allows checks an entry's declared sizes before it is opened.
+func (b *expansionBudget) allows(uncompressed, compressed uint64) bool {
+	if uncompressed > uint64(b.maxTotal-b.expanded) {
+		return false
+	}
+	return uncompressed <= compressed*uint64(b.maxRatio)+ratioSlack
+}
+
+func (b *expansionBudget) reader(r io.Reader) io.Reader {
+	return &budgetReader{r: r, b: b}
+}
+
+type budgetReader struct {
+	r io.Reader
+	b *expansionBudget
+}
+
+func (br *budgetReader) Read(p []byte) (int, error) {
+	n, err := br.r.Read(p)
+	br.b.expanded += int64(n)
+	if br.b.expanded > br.b.maxTotal ||
+		br.b.expanded > br.b.compressed()*br.b.maxRatio+ratioSlack {
+		br.b.exceeded = true
+		return n, ErrArchiveLimit
+	}
+	return n, err
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
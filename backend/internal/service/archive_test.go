@@ -0,0 +1,212 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+func TestSplitEntryPath(t *testing.T) {
+	deep := strings.Repeat("d/", maxFolderDepth-1)
+	for _, tc := range []struct {
+		base, name   string
+		folder, file string
+		wantErr      bool
+	}{
+		{"", "a.txt", "", "a.txt", false},
+		{"in", "docs/a.txt", "in/docs", "a.txt", false},
+		{"in", `docs\a.txt`, "in/docs", "a.txt", false},
+		{"in", "./docs/./a.txt", "in/docs", "a.txt", false},
+		{"in", "../a.txt", "", "", true},
+		{"in", "docs/../../a.txt", "", "", true},
+		{"in", "/etc/passwd", "", "", true},
+		{"in", `C:\a.txt`, "", "", true},
+		{"in", "docs/", "", "", true},
+		{"", deep + "a.txt", strings.TrimSuffix(deep, "/"), "a.txt", false},
+		// Fine on its own, too deep once joined onto the base
+		{"in", deep + "d/a.txt", "", "", true},
+	} {
+		folder, file, err := splitEntryPath(tc.base, tc.name)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%q in %q: got %q, %q, want an error", tc.name, tc.base, folder, file)
+			}
+			continue
+		}
+		if err != nil || folder != tc.folder || file != tc.file {
+			t.Errorf("%q in %q: got %q, %q, %v, want %q, %q", tc.name, tc.base, folder, file, err, tc.folder, tc.file)
+		}
+	}
+}
+
+type archiveEntry struct {
+	name    string
+	content string
+	link    string // symlink target; hardlink target when hard is set
+	hard    bool
+}
+
+func zipArchive(t *testing.T, entries ...archiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		content := e.content
+		if e.link != "" {
+			hdr.SetMode(fs.ModeSymlink | 0o777)
+			content = e.link
+		}
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarGzArchive(t *testing.T, entries ...archiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0o644, Typeflag: tar.TypeReg, Size: int64(len(e.content))}
+		switch {
+		case e.hard:
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeLink, e.link, 0
+		case e.link != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.link, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size > 0 {
+			tw.Write([]byte(e.content))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// resultErrors maps each result's entry name to its error.
+func resultErrors(results []UploadResult) map[string]error {
+	errs := make(map[string]error, len(results))
+	for _, r := range results {
+		errs[r.Filename] = r.Err
+	}
+	return errs
+}
+
+func TestExtractArchiveRejectsUnsafeEntries(t *testing.T) {
+	conn := openTestDB(t)
+	svc := newTestFileService(t, conn)
+	user := createTestUsers(t, conn, 1)[0]
+
+	entries := []archiveEntry{
+		{name: "docs/ok.txt", content: "fine"},
+		{name: "../escape.txt", content: "x"},
+		{name: "docs/../../escape2.txt", content: "x"},
+		{name: "/etc/passwd", content: "x"},
+		{name: "link", link: "/etc/passwd"},
+	}
+	archives := map[string][]byte{
+		"a.zip":    zipArchive(t, entries...),
+		"a.tar.gz": tarGzArchive(t, append(entries, archiveEntry{name: "hard", link: "docs/ok.txt", hard: true})...),
+	}
+	for name, data := range archives {
+		results, err := svc.ExtractArchive(user.ID, "in/"+name, name, bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		errs := resultErrors(results)
+		if errs["ok.txt"] != nil {
+			t.Errorf("%s: ok.txt: %v", name, errs["ok.txt"])
+		}
+		for _, entry := range []string{"../escape.txt", "docs/../../escape2.txt", "/etc/passwd"} {
+			if !errors.Is(errs[entry], ErrInvalidPath) {
+				t.Errorf("%s: %s: %v, want ErrInvalidPath", name, entry, errs[entry])
+			}
+		}
+		if !errors.Is(errs["link"], ErrUnsupportedEntry) {
+			t.Errorf("%s: symlink: %v, want ErrUnsupportedEntry", name, errs["link"])
+		}
+		if _, ok := errs["hard"]; ok && !errors.Is(errs["hard"], ErrUnsupportedEntry) {
+			t.Errorf("%s: hard link: %v, want ErrUnsupportedEntry", name, errs["hard"])
+		}
+
+		var paths []string
+		conn.Raw("SELECT folder || '/' || file_name FROM user_files WHERE user_id = ? AND folder LIKE ?", user.ID, "in/"+name+"%").Scan(&paths)
+		if len(paths) != 1 || paths[0] != "in/"+name+"/docs/ok.txt" {
+			t.Errorf("%s: stored %v", name, paths)
+		}
+	}
+}
+
+func TestExtractArchiveRejectsDeepFolders(t *testing.T) {
+	conn := openTestDB(t)
+	svc := newTestFileService(t, conn)
+	user := createTestUsers(t, conn, 1)[0]
+
+	// As deep as a folder can be, one level too deep under "in"
+	name := strings.Repeat("d/", maxFolderDepth) + "a.txt"
+	data := zipArchive(t, archiveEntry{name: name, content: "x"})
+	results, err := svc.ExtractArchive(user.ID, "in", "a.zip", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !errors.Is(results[0].Err, ErrInvalidPath) {
+		t.Errorf("results %+v, want the entry rejected", results)
+	}
+}
+
+func TestExtractArchiveLimits(t *testing.T) {
+	conn := openTestDB(t)
+	svc := newTestFileService(t, conn)
+	user := createTestUsers(t, conn, 1)[0]
+
+	zeros := strings.Repeat("\x00", 4<<20)
+	for _, tc := range []struct {
+		name    string
+		config  FileConfig
+		entries []archiveEntry
+	}{
+		{"entries", FileConfig{MaxArchiveEntries: 2}, []archiveEntry{
+			{name: "a.txt", content: "a"}, {name: "b.txt", content: "b"}, {name: "c.txt", content: "c"},
+		}},
+		{"expanded size", FileConfig{MaxArchiveExpandedSize: 1000}, []archiveEntry{
+			{name: "a.txt", content: strings.Repeat("abc", 500)},
+		}},
+		// 4 MiB of zeros compresses far beyond the default ratio
+		{"compression ratio", FileConfig{}, []archiveEntry{
+			{name: "bomb.bin", content: zeros},
+		}},
+	} {
+		tc.config.UploadDir = svc.config.UploadDir
+		svc.config = tc.config
+		for _, archive := range []string{"a.zip", "a.tar.gz"} {
+			data := zipArchive(t, tc.entries...)
+			if archive == "a.tar.gz" {
+				data = tarGzArchive(t, tc.entries...)
+			}
+			_, err := svc.ExtractArchive(user.ID, "", archive, bytes.NewReader(data))
+			if !errors.Is(err, ErrArchiveLimit) {
+				t.Errorf("%s, %s: %v, want ErrArchiveLimit", tc.name, archive, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package service
+
+import (
+	"backend/internal/metrics"
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+	auditBatchSize    = 500
+	maxUserAgentLen   = 512
+
+	// auditQueueSize bounds the entries waiting to be written. Once it is
+	// full, Record waits for the writer rather than drop entries.
+	auditQueueSize = 1024
+	// auditWriteBatch is the most entries appended in one transaction.
+	auditWriteBatch = 100
+	// auditWriteAttempts is how often a batch is tried before its entries
+	// are given up on and counted as dropped.
+	auditWriteAttempts = 5
+)
+
+// auditRetryDelay is the wait before the first retry of a failed write;
+// each retry waits twice as long as the one before.
+var auditRetryDelay = 200 * time.Millisecond
+
+var ErrExportFormat = errors.New("unsupported export format")
+
+// AuditService records security-relevant actions and lets administrators
+// query, export and verify the log. Entries are appended to the hash chain
+// by a single writer, Run, so requests don't queue on the chain's lock.
+type AuditService struct {
+	repo  *repository.AuditRepository
+	queue chan *models.AuditEvent
+
+	stopOnce sync.Once
+	stopped  chan struct{} // closed by Drain, once the writer has stopped
+}
+
+func NewAuditService(repo *repository.AuditRepository) *AuditService {
+	return &AuditService{
+		repo:    repo,
+		queue:   make(chan *models.AuditEvent, auditQueueSize),
+		stopped: make(chan struct{}),
+	}
+}
+
+// Record queues an entry for the writer, or writes it directly once the
+// writer has stopped. Auditing never fails the request being audited, so
+// entries that cannot be written are logged and counted as dropped.
+func (as *AuditService) Record(e *models.AuditEvent) {
+	e.UserAgent = truncateText(e.UserAgent, maxUserAgentLen)
+	select {
+	case as.queue <- e:
+	case <-as.stopped:
+		as.write(e)
+		return
+	}
+	// Drain may have emptied the queue just before e joined it
+	select {
+	case <-as.stopped:
+		as.Drain()
+	default:
+	}
+}
+
+// Run writes queued entries until ctx is cancelled.
+func (as *AuditService) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-as.queue:
+			as.write(e)
+		}
+	}
+}
+
+// Drain writes the entries still queued, once Run has stopped. Entries
+// recorded after that are written as they come.
+func (as *AuditService) Drain() {
+	as.stopOnce.Do(func() { close(as.stopped) })
+	for {
+		select {
+		case e := <-as.queue:
+			as.write(e)
+		default:
+			return
+		}
+	}
+}
+
+// write appends first and whatever else is already queued, up to a batch,
+// in one transaction.
+func (as *AuditService) write(first *models.AuditEvent) {
+	batch := []*models.AuditEvent{first}
+collect:
+	for len(batch) < auditWriteBatch {
+		select {
+		case e := <-as.queue:
+			batch = append(batch, e)
+		default:
+			break collect
+		}
+	}
+	delay := auditRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := as.repo.Append(batch)
+		if err == nil {
+			return
+		}
+		if attempt == auditWriteAttempts {
+			log.Printf("audit: dropped %d entries after %d attempts: %v", len(batch), attempt, err)
+			metrics.AuditDropped(len(batch))
+			return
+		}
+		log.Printf("audit: failed to record %d entries, retrying in %s: %v", len(batch), delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// ParseAuditQuery validates the admin query parameters, reporting every bad
+// field like ParseFileFilter does.
+func ParseAuditQuery(q url.Values) (repository.AuditQuery, error) {
+	var aq repository.AuditQuery
+	errs := FilterErrors{}
+
+	aq.ActorID = parseID(q.Get("actor_id"), "actor_id", errs)
+	aq.UserFileID = parseID(q.Get("user_file_id"), "user_file_id", errs)
+	aq.FileID = parseID(q.Get("file_id"), "file_id", errs)
+	aq.Action = q.Get("action")
+	aq.IP = q.Get("ip")
+
+	switch o := q.Get("outcome"); o {
+	case "", models.AuditSuccess, models.AuditDenied, models.AuditFailure:
+		aq.Outcome = o
+	default:
+		errs["outcome"] = "must be success, denied or failure"
+	}
+
+	aq.From = parseFilterDate(q.Get("from"), "from", false, errs)
+	aq.To = parseFilterDate(q.Get("to"), "to", true, errs)
+
+	if v := q.Get("before_id"); v != "" {
+		if id := parseID(v, "before_id", errs); id != nil {
+			aq.BeforeID = *id
+		}
+	}
+	aq.Limit = defaultAuditLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			errs["limit"] = "must be a positive number"
+		}
+		aq.Limit = min(n, maxAuditLimit)
+	}
+
+	if len(errs) > 0 {
+		return repository.AuditQuery{}, errs
+	}
+	return aq, nil
+}
+
+// Query returns one page of matching entries, newest first.
+func (as *AuditService) Query(q repository.AuditQuery) ([]models.AuditEvent, error) {
+	return as.repo.Find(q)
+}
+
+// Export streams every entry matching q to w, oldest first, as a JSON array
+// or CSV. Paging fields in q are ignored.
+func (as *AuditService) Export(w io.Writer, format string, q repository.AuditQuery) error {
+	q.BeforeID, q.Limit = 0, 0
+	switch format {
+	case "json":
+		return as.exportJSON(w, q)
+	case "csv":
+		return as.exportCSV(w, q)
+	}
+	return ErrExportFormat
+}
+
+func (as *AuditService) exportJSON(w io.Writer, q repository.AuditQuery) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	first := true
+	err := as.repo.Scan(q, auditBatchSize, func(batch []models.AuditEvent) error {
+		for i := range batch {
+			if !first {
+				if _, err := io.WriteString(w, ","); err != nil {
+					return err
+				}
+			}
+			first = false
+			line, err := json.Marshal(&batch[i])
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(line); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "]\n")
+	return err
+}
+
+func (as *AuditService) exportCSV(w io.Writer, q repository.AuditQuery) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"id", "created_at", "actor_id", "actor_name", "action", "user_file_id", "file_id",
+		"ip", "user_agent", "outcome", "status", "detail", "prev_hash", "hash",
+	})
+	err := as.repo.Scan(q, auditBatchSize, func(batch []models.AuditEvent) error {
+		for _, e := range batch {
+			cw.Write([]string{
+				strconv.FormatUint(uint64(e.ID), 10),
+				e.CreatedAt.UTC().Format(time.RFC3339Nano),
+				optionalID(e.ActorID),
+				e.ActorName,
+				e.Action,
+				optionalID(e.UserFileID),
+				optionalID(e.FileID),
+				e.IP,
+				e.UserAgent,
+				e.Outcome,
+				strconv.Itoa(e.Status),
+				e.Detail,
+				e.PrevHash,
+				e.Hash,
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// AuditVerification reports whether the hash chain is intact.
+type AuditVerification struct {
+	Valid    bool   `json:"valid"`
+	Checked  int    `json:"checked"`
+	BrokenAt *uint  `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// errChainBroken stops the scan at the first bad entry.
+var errChainBroken = errors.New("audit chain broken")
+
+// Verify walks the whole log recomputing every hash and checking each entry
+// links to its predecessor.
+func (as *AuditService) Verify() (*AuditVerification, error) {
+	result := &AuditVerification{Valid: true}
+	prev := ""
+	err := as.repo.Scan(repository.AuditQuery{}, auditBatchSize, func(batch []models.AuditEvent) error {
+		for i := range batch {
+			e := &batch[i]
+			switch {
+			case e.PrevHash != prev:
+				result.Reason = "entry does not link to the previous entry"
+			case e.ComputeHash() != e.Hash:
+				result.Reason = "entry contents do not match its hash"
+			default:
+				prev = e.Hash
+				result.Checked++
+				continue
+			}
+			result.Valid = false
+			result.BrokenAt = &e.ID
+			return errChainBroken
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errChainBroken) {
+		return nil, err
+	}
+	return result, nil
+}
+
+func parseID(v, field string, errs FilterErrors) *uint {
+	if v == "" {
+		return nil
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil || n == 0 {
+		errs[field] = "must be a positive integer ID"
+		return nil
+	}
+	id := uint(n)
+	return &id
+}
+
+func optionalID(id *uint) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.FormatUint(uint64(*id), 10)
+}
@@ -4,7 +4,6 @@ import (
 	"backend/internal/models"
 	"backend/internal/repository"
 	"errors"
-	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -15,6 +14,7 @@ var (
     ErrUserExists   = errors.New("user already exists")
     ErrEmailExists  = errors.New("email already exists")
     ErrInvalidCreds = errors.New("invalid username or password")
+    ErrAccountDisabled = errors.New("account disabled")
 )
 
 
@@ -23,9 +23,8 @@ type AuthService struct {
 	jwtKey   []byte
 }
 
-func NewAuthService(userRepo *repository.UserRepository) *AuthService {
-	secret := os.Getenv("JWT_SECRET")
-	return &AuthService{userRepo: userRepo, jwtKey: []byte(secret)}
+func NewAuthService(userRepo *repository.UserRepository, jwtSecret string) *AuthService {
+	return &AuthService{userRepo: userRepo, jwtKey: []byte(jwtSecret)}
 }
 
 // SignUp creates a new user
@@ -89,14 +88,9 @@ func (s *AuthService) SignUpAndGenerateToken(username, email, password string) (
 
 
 func (s *AuthService) SignIn(username, password string) (string, uint, error) {
-	user, err := s.userRepo.GetByUsername(username)
-	if err != nil || user == nil {
-		return "", 0, ErrInvalidCreds
-	}
-
-	// Compare password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return "", 0, ErrInvalidCreds
+	user, err := verifyPassword(s.userRepo, username, password)
+	if err != nil {
+		return "", 0, err
 	}
 
 	// Create JWT
@@ -116,10 +110,45 @@ func (s *AuthService) SignIn(username, password string) (string, uint, error) {
 }
 
 
+// verifyPassword returns the user the username and password belong to, or
+// ErrInvalidCreds.
+func verifyPassword(users *repository.UserRepository, username, password string) (*models.User, error) {
+	user, err := users.GetByUsername(username)
+	if err != nil || user == nil {
+		return nil, ErrInvalidCreds
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return nil, ErrInvalidCreds
+	}
+	if user.Disabled() {
+		return nil, ErrAccountDisabled
+	}
+	return user, nil
+}
+
 func (s *AuthService) GetUserByID(id uint) (*models.User, error) {
 	return s.userRepo.GetByID(id)
 }
 
+// CheckActive fails for users that have been deleted or disabled since
+// their session or token was issued.
+func (s *AuthService) CheckActive(userID uint) error {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil || user == nil {
+		return ErrInvalidCreds
+	}
+	if user.Disabled() {
+		return ErrAccountDisabled
+	}
+	return nil
+}
+
+// ParseToken returns the user a session token signed with this service's
+// key belongs to.
+func (s *AuthService) ParseToken(tokenStr string) (uint, error) {
+	return ParseToken(tokenStr, s.jwtKey)
+}
+
 // JWT PARSE
 func ParseToken(tokenStr string, jwtKey []byte) (uint, error) {
 	claims := jwt.MapClaims{}
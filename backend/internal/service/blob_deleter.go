@@ -0,0 +1,124 @@
+package service
+
+import (
+	"backend/internal/repository"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	deletionBaseBackoff = 10 * time.Second
+	deletionMaxBackoff  = time.Hour
+)
+
+// errDeletionQueueEmpty stops the drain loop once no job is due.
+var errDeletionQueueEmpty = errors.New("no blob deletion due")
+
+// BlobDeleter drains the blob_deletions queue, removing blobs from disk once
+// the database no longer references them. Failed removals are retried with
+// exponential backoff until they succeed.
+type BlobDeleter struct {
+	txm      *repository.TxManager
+	interval time.Duration
+	wake     chan struct{}
+	hooks    []func(r repository.Repos, hash string) error
+}
+
+func NewBlobDeleter(txm *repository.TxManager, interval time.Duration) *BlobDeleter {
+	return &BlobDeleter{
+		txm:      txm,
+		interval: interval,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// OnBlobRemoved registers fn to clean up data derived from a blob, such as
+// previews, in the same transaction that completes its deletion job.
+func (d *BlobDeleter) OnBlobRemoved(fn func(r repository.Repos, hash string) error) {
+	d.hooks = append(d.hooks, fn)
+}
+
+// Wake asks the worker to drain the queue now rather than at the next tick.
+func (d *BlobDeleter) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run drains the queue on every tick or wake-up until ctx is cancelled.
+func (d *BlobDeleter) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := d.Drain(); err != nil {
+			log.Printf("blob deleter: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// Drain processes every due job and returns how many were handled.
+func (d *BlobDeleter) Drain() (int, error) {
+	handled := 0
+	for {
+		err := d.txm.Do(d.processNext)
+		if errors.Is(err, errDeletionQueueEmpty) {
+			return handled, nil
+		}
+		if err != nil {
+			return handled, err
+		}
+		handled++
+	}
+}
+
+func (d *BlobDeleter) processNext(r repository.Repos) error {
+	job, err := r.Deletions.ClaimNext()
+	if err != nil {
+		return err
+	}
+	if job == nil {
+		return errDeletionQueueEmpty
+	}
+
+	// Serialise with uploads of the same content, which may have recreated
+	// the file row (and the blob) since this job was queued
+	if err := r.Files.LockHash(job.Hash); err != nil {
+		return err
+	}
+	inUse, err := r.Files.BlobInUse(job.Hash, job.StoragePath)
+	if err != nil {
+		return err
+	}
+
+	if !inUse {
+		if err := os.Remove(job.StoragePath); err != nil && !os.IsNotExist(err) {
+			backoff := deletionBaseBackoff << job.Attempts
+			if backoff > deletionMaxBackoff || backoff <= 0 {
+				backoff = deletionMaxBackoff
+			}
+			return r.Deletions.Reschedule(job.ID, err.Error(), time.Now().Add(backoff))
+		}
+		// Jobs without a hash remove a stray copy of content stored
+		// elsewhere, so what was derived from the content stays
+		if job.Hash != "" {
+			for _, hook := range d.hooks {
+				if err := hook(r, job.Hash); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return r.Deletions.Complete(job.ID)
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+func queuedDeletions(t *testing.T, conn *gorm.DB) []models.BlobDeletion {
+	t.Helper()
+	var jobs []models.BlobDeletion
+	if err := conn.Order("id").Find(&jobs).Error; err != nil {
+		t.Fatal(err)
+	}
+	return jobs
+}
+
+func checkUserStorage(t *testing.T, conn *gorm.DB, userID uint, actual, expected int64) {
+	t.Helper()
+	var u models.User
+	if err := conn.First(&u, userID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if u.ActualStorage != actual || u.ExpectedStorage != expected {
+		t.Errorf("user %d storage = %d actual, %d expected; want %d, %d",
+			userID, u.ActualStorage, u.ExpectedStorage, actual, expected)
+	}
+}
+
+func TestDeleteFileQueuesBlobAfterLastReference(t *testing.T) {
+	conn := openTestDB(t)
+	svc := newTestFileService(t, conn)
+	users := createTestUsers(t, conn, 2)
+	content, hash := randomContent(t, 4096)
+
+	var ufs []*models.UserFile
+	for _, u := range users {
+		uf, err := svc.ProcessFileUpload(u.ID, "", "a.bin", bytes.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ufs = append(ufs, uf)
+	}
+
+	// The first delete leaves a reference, so nothing is queued
+	if err := svc.DeleteFile(ufs[0].ID, users[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	var file models.File
+	if err := conn.Where("hash = ?", hash).First(&file).Error; err != nil {
+		t.Fatal(err)
+	}
+	if file.RefCount != 1 {
+		t.Errorf("ref_count = %d, want 1", file.RefCount)
+	}
+	if jobs := queuedDeletions(t, conn); len(jobs) != 0 {
+		t.Fatalf("queued %d deletions while the blob is referenced", len(jobs))
+	}
+
+	// The last delete drops the row and queues the blob, which stays on
+	// disk until the worker runs
+	if err := svc.DeleteFile(ufs[1].ID, users[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	var rows int64
+	conn.Model(&models.File{}).Where("hash = ?", hash).Count(&rows)
+	if rows != 0 {
+		t.Errorf("files row still present")
+	}
+	jobs := queuedDeletions(t, conn)
+	if len(jobs) != 1 || jobs[0].Hash != hash || jobs[0].StoragePath != file.StoragePath {
+		t.Fatalf("queued deletions = %+v, want one for %s", jobs, file.StoragePath)
+	}
+	if _, err := os.Stat(file.StoragePath); err != nil {
+		t.Fatalf("blob removed before the worker ran: %v", err)
+	}
+
+	n, err := svc.deleter.Drain()
+	if err != nil || n != 1 {
+		t.Fatalf("Drain = %d, %v; want 1 job", n, err)
+	}
+	if _, err := os.Stat(file.StoragePath); !os.IsNotExist(err) {
+		t.Errorf("blob still on disk: %v", err)
+	}
+	if jobs := queuedDeletions(t, conn); len(jobs) != 0 {
+		t.Errorf("%d deletions left in the queue", len(jobs))
+	}
+	for _, u := range users {
+		checkUserStorage(t, conn, u.ID, 0, 0)
+	}
+}
+
+func TestDeleteFileIsAtomic(t *testing.T) {
+	conn := openTestDB(t)
+	svc := newTestFileService(t, conn)
+	user := createTestUsers(t, conn, 1)[0]
+	content, hash := randomContent(t, 4096)
+
+	uf, err := svc.ProcessFileUpload(user.ID, "", "a.bin", bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fail the last step, after the reference and storage were updated
+	for _, stmt := range []string{
+		`CREATE FUNCTION refuse_enqueue() RETURNS trigger LANGUAGE plpgsql AS $$
+		BEGIN RAISE EXCEPTION 'enqueue refused'; END $$`,
+		`CREATE TRIGGER refuse_enqueue BEFORE INSERT ON blob_deletions
+		FOR EACH ROW EXECUTE FUNCTION refuse_enqueue()`,
+	} {
+		if err := conn.Exec(stmt).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := svc.DeleteFile(uf.ID, user.ID); err == nil {
+		t.Fatal("DeleteFile succeeded despite the failing step")
+	}
+
+	var refs, rows int64
+	conn.Model(&models.UserFile{}).Where("id = ?", uf.ID).Count(&refs)
+	conn.Model(&models.File{}).Where("hash = ? AND ref_count = 1", hash).Count(&rows)
+	if refs != 1 || rows != 1 {
+		t.Errorf("after rollback: %d references and %d files rows, want 1 and 1", refs, rows)
+	}
+	checkUserStorage(t, conn, user.ID, int64(len(content)), int64(len(content)))
+}
+
+func TestBlobDeleterRetriesFailedRemoval(t *testing.T) {
+	conn := openTestDB(t)
+	svc := newTestFileService(t, conn)
+
+	// A non-empty directory cannot be removed with os.Remove
+	blob := filepath.Join(t.TempDir(), "blob")
+	if err := os.MkdirAll(filepath.Join(blob, "busy"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	job := models.BlobDeletion{Hash: "deadbeef", StoragePath: blob, NextAttemptAt: time.Now()}
+	if err := conn.Create(&job).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.deleter.Drain(); err != nil {
+		t.Fatal(err)
+	}
+	jobs := queuedDeletions(t, conn)
+	if len(jobs) != 1 {
+		t.Fatalf("job dropped after a failed removal")
+	}
+	if jobs[0].Attempts != 1 || jobs[0].LastError == nil || !jobs[0].NextAttemptAt.After(time.Now()) {
+		t.Errorf("failed job = %+v, want one attempt, its error and a later retry", jobs[0])
+	}
+
+	// Not due yet, so draining again leaves it alone
+	if n, err := svc.deleter.Drain(); err != nil || n != 0 {
+		t.Errorf("Drain before the retry time = %d, %v", n, err)
+	}
+
+	if err := os.Remove(filepath.Join(blob, "busy")); err != nil {
+		t.Fatal(err)
+	}
+	conn.Model(&models.BlobDeletion{}).Where("id = ?", job.ID).Update("next_attempt_at", time.Now())
+	if n, err := svc.deleter.Drain(); err != nil || n != 1 {
+		t.Fatalf("retry Drain = %d, %v", n, err)
+	}
+	if _, err := os.Stat(blob); !os.IsNotExist(err) {
+		t.Errorf("blob still on disk after the retry: %v", err)
+	}
+	if jobs := queuedDeletions(t, conn); len(jobs) != 0 {
+		t.Errorf("%d deletions left in the queue", len(jobs))
+	}
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+)
+
+const (
+	DefaultChangesLimit = 500
+	MaxChangesLimit     = 5000
+)
+
+// trackPath records in the change feed what happened to a path, by
+// comparing the file now at the path with the last change recorded for it.
+// Call it in the transaction that touched the path, after the change. Only
+// the newest file at a path counts, as for WebDAV, so replacing a file
+// reads as one modification and an older duplicate going away as nothing.
+func trackPath(r repository.Repos, userID uint, folder, filename string) error {
+	rows, err := r.UserFiles.FindByPath(userID, folder, filename)
+	if err != nil {
+		return err
+	}
+	last, err := r.Changes.Last(userID, folder, filename)
+	if err != nil {
+		return err
+	}
+	existed := last != nil && last.Op != models.ChangeDeleted
+
+	ch := &models.FileChange{UserID: userID, Folder: folder, FileName: filename}
+	if len(rows) == 0 {
+		if !existed {
+			return nil
+		}
+		ch.Op = models.ChangeDeleted
+		return r.Changes.Add(ch)
+	}
+
+	cur := rows[0]
+	if existed && *last.UserFileID == cur.UserFileID && last.Hash == cur.Hash {
+		return nil
+	}
+	ch.Op = models.ChangeCreated
+	if existed {
+		ch.Op = models.ChangeModified
+	}
+	ch.UserFileID, ch.FileID = &cur.UserFileID, &cur.FileID
+	ch.Hash, ch.Size = cur.Hash, cur.Size
+	return r.Changes.Add(ch)
+}
+
+// ChangeService serves the change feed sync clients poll.
+type ChangeService struct {
+	changes *repository.ChangeRepository
+}
+
+func NewChangeService(changes *repository.ChangeRepository) *ChangeService {
+	return &ChangeService{changes: changes}
+}
+
+// ChangePage is one page of the feed. Cursor is the sequence number of the
+// last change in the page, or the cursor asked for if there were none.
+type ChangePage struct {
+	Changes []models.FileChange `json:"changes"`
+	Cursor  int64               `json:"cursor"`
+	HasMore bool                `json:"has_more"`
+}
+
+// Since returns the user's changes after cursor. Reading from cursor 0
+// replays every file the user holds.
+func (cs *ChangeService) Since(userID uint, cursor int64, limit int) (*ChangePage, error) {
+	if limit <= 0 {
+		limit = DefaultChangesLimit
+	}
+	limit = min(limit, MaxChangesLimit)
+
+	changes, err := cs.changes.ListAfter(userID, cursor, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	if changes == nil {
+		changes = []models.FileChange{}
+	}
+	page := &ChangePage{Changes: changes, Cursor: cursor}
+	if len(changes) > limit {
+		page.Changes, page.HasMore = changes[:limit], true
+	}
+	if n := len(page.Changes); n > 0 {
+		page.Cursor = page.Changes[n-1].Seq
+	}
+	return page, nil
+}
+
+// Latest returns the user's current cursor, for clients that want only
+// changes from now on.
+func (cs *ChangeService) Latest(userID uint) (int64, error) {
+	return cs.changes.Current(userID)
+}
@@ -0,0 +1,287 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/models"
+	"backend/internal/repository"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openTestDB migrates a scratch schema in the database named by
+// TEST_DATABASE_DSN, skipping the test when it is not set. The schema is
+// dropped when the test finishes.
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN not set; skipping database test")
+	}
+	cfg := &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)}
+	admin, err := gorm.Open(postgres.Open(dsn), cfg)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	schema := fmt.Sprintf("service_test_%d", time.Now().UnixNano())
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+	if strings.Contains(dsn, "://") {
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		dsn += sep + "search_path=" + schema
+	} else {
+		dsn += " search_path=" + schema
+	}
+	conn, err := gorm.Open(postgres.Open(dsn), cfg)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	m, err := db.NewMigrator(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(0); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return conn
+}
+
+func newTestFileService(t *testing.T, conn *gorm.DB) *FileService {
+	t.Helper()
+	txm := repository.NewTxManager(conn)
+	return NewFileService(
+		repository.NewFileRepository(conn),
+		repository.NewUserFileRepository(conn),
+		repository.NewUserRepository(conn),
+		repository.NewFolderRepository(conn),
+		txm,
+		NewBlobDeleter(txm, time.Minute),
+		FileConfig{UploadDir: t.TempDir()},
+		1024,
+	)
+}
+
+// createTestUsers inserts n users and removes them, with their files, when
+// the test finishes.
+func createTestUsers(t *testing.T, conn *gorm.DB, n int) []models.User {
+	t.Helper()
+	prefix := fmt.Sprintf("t%d", time.Now().UnixNano())
+	users := make([]models.User, n)
+	for i := range users {
+		users[i] = models.User{
+			Username: fmt.Sprintf("%s-%d", prefix, i),
+			Email:    fmt.Sprintf("%s-%d@example.com", prefix, i),
+			Password: "x",
+		}
+		if err := conn.Create(&users[i]).Error; err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+	}
+	t.Cleanup(func() {
+		for _, u := range users {
+			conn.Exec("DELETE FROM files WHERE id IN (SELECT file_id FROM user_files WHERE user_id = ?)", u.ID)
+			conn.Delete(&models.User{}, u.ID)
+		}
+	})
+	return users
+}
+
+func randomContent(t *testing.T, size int) ([]byte, string) {
+	t.Helper()
+	content := make([]byte, size)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+	return content, hex.EncodeToString(sum[:])
+}
+
+// countBlobs returns the number of promoted blobs and leftover staging files.
+func countBlobs(t *testing.T, dir string) (blobs, staged int) {
+	t.Helper()
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if filepath.Base(filepath.Dir(path)) == ".staging" {
+			staged++
+		} else {
+			blobs++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blobs, staged
+}
+
+func TestConcurrentIdenticalUploadsStoreOneBlob(t *testing.T) {
+	conn := openTestDB(t)
+	svc := newTestFileService(t, conn)
+
+	const uploaders = 16
+	users := createTestUsers(t, conn, uploaders)
+	content, hash := randomContent(t, 256*1024)
+
+	start := make(chan struct{})
+	errs := make(chan error, uploaders)
+	var wg sync.WaitGroup
+	for _, u := range users {
+		wg.Add(1)
+		go func(userID uint) {
+			defer wg.Done()
+			<-start
+			_, err := svc.ProcessFileUpload(userID, "", "same.bin", bytes.NewReader(content))
+			errs <- err
+		}(u.ID)
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("upload failed: %v", err)
+		}
+	}
+
+	var files []models.File
+	if err := conn.Where("hash = ?", hash).Find(&files).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("got %d file rows for hash, want 1", len(files))
+	}
+	if files[0].RefCount != uploaders {
+		t.Errorf("ref_count = %d, want %d", files[0].RefCount, uploaders)
+	}
+
+	var owners int64
+	conn.Model(&models.UserFile{}).Where("file_id = ? AND is_owner", files[0].ID).Count(&owners)
+	if owners != 1 {
+		t.Errorf("got %d owners, want 1", owners)
+	}
+
+	blobs, staged := countBlobs(t, svc.config.UploadDir)
+	if blobs != 1 {
+		t.Errorf("got %d blobs on disk, want 1", blobs)
+	}
+	if staged != 0 {
+		t.Errorf("got %d leftover staging files, want 0", staged)
+	}
+
+	var actual int64
+	conn.Model(&models.User{}).Where("id IN ?", userIDs(users)).
+		Select("COALESCE(SUM(actual_storage), 0)").Scan(&actual)
+	if actual != int64(len(content)) {
+		t.Errorf("total actual_storage = %d, want %d", actual, len(content))
+	}
+}
+
+func TestConcurrentUploadsBySameUserKeepOneReference(t *testing.T) {
+	conn := openTestDB(t)
+	svc := newTestFileService(t, conn)
+
+	user := createTestUsers(t, conn, 1)[0]
+	content, hash := randomContent(t, 64*1024)
+
+	const attempts = 8
+	start := make(chan struct{})
+	errs := make(chan error, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, err := svc.ProcessFileUpload(user.ID, "", "same.bin", bytes.NewReader(content))
+			errs <- err
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case errors.Is(err, ErrAlreadyUploaded):
+		default:
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("%d uploads succeeded, want 1", succeeded)
+	}
+
+	var file models.File
+	if err := conn.Where("hash = ?", hash).First(&file).Error; err != nil {
+		t.Fatal(err)
+	}
+	if file.RefCount != 1 {
+		t.Errorf("ref_count = %d, want 1", file.RefCount)
+	}
+	if blobs, staged := countBlobs(t, svc.config.UploadDir); blobs != 1 || staged != 0 {
+		t.Errorf("got %d blobs and %d staging files, want 1 and 0", blobs, staged)
+	}
+}
+
+func userIDs(users []models.User) []uint {
+	ids := make([]uint, len(users))
+	for i, u := range users {
+		ids[i] = u.ID
+	}
+	return ids
+}
+
+func TestUploadRemovesBlobWhenCommitFails(t *testing.T) {
+	conn := openTestDB(t)
+	svc := newTestFileService(t, conn)
+	user := createTestUsers(t, conn, 1)[0]
+
+	// A deferred constraint trigger only fires at COMMIT, after the blob
+	// has been put in place
+	for _, stmt := range []string{
+		`CREATE FUNCTION refuse_commit() RETURNS trigger LANGUAGE plpgsql AS $$
+		BEGIN RAISE EXCEPTION 'commit refused'; END $$`,
+		`CREATE CONSTRAINT TRIGGER refuse_commit AFTER INSERT ON user_files
+		DEFERRABLE INITIALLY DEFERRED FOR EACH ROW EXECUTE FUNCTION refuse_commit()`,
+	} {
+		if err := conn.Exec(stmt).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	content, _ := randomContent(t, 4096)
+	_, err := svc.ProcessFileUpload(user.ID, "", "a.bin", bytes.NewReader(content))
+	if err == nil || !strings.Contains(err.Error(), "commit refused") {
+		t.Fatalf("upload error %v, want the cause of the failed commit", err)
+	}
+	if blobs, staged := countBlobs(t, svc.config.UploadDir); blobs != 0 || staged != 0 {
+		t.Errorf("left %d blobs and %d staging files", blobs, staged)
+	}
+}
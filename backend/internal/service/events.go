@@ -0,0 +1,160 @@
+package service
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+const outboxBatchSize = 100
+
+// FileEventData describes the user file an event is about.
+type FileEventData struct {
+	UserFileID uint   `json:"user_file_id"`
+	FileID     uint   `json:"file_id"`
+	Filename   string `json:"filename"`
+	Folder     string `json:"folder"`
+	Size       int64  `json:"size"`
+	MimeType   string `json:"mime_type"`
+	Visibility string `json:"visibility"`
+	PublicLink string `json:"public_link,omitempty"`
+}
+
+func fileEventData(uf *models.UserFile, file *models.File) FileEventData {
+	data := FileEventData{
+		UserFileID: uf.ID,
+		FileID:     file.ID,
+		Filename:   uf.FileName,
+		Folder:     uf.Folder,
+		Size:       file.Size,
+		MimeType:   file.MimeType,
+		Visibility: uf.Visibility,
+	}
+	if uf.Visibility == "public" && uf.PublicToken != nil {
+		data.PublicLink = "/public/" + *uf.PublicToken
+	}
+	return data
+}
+
+// SharedFileEventData describes a file another user handed over.
+type SharedFileEventData struct {
+	FileEventData
+	From string `json:"from"` // the previous holder's username
+}
+
+// emit queues an event in the caller's transaction.
+func emit(r repository.Repos, eventType string, userID uint, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return r.Outbox.Add(&models.OutboxEvent{Type: eventType, UserID: userID, Payload: string(payload)})
+}
+
+// EventEnvelope is the JSON document subscribers receive for an event.
+type EventEnvelope struct {
+	ID        uint            `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	UserID    uint            `json:"user_id"`
+	Data      json.RawMessage `json:"data"`
+}
+
+func envelope(ev models.OutboxEvent) EventEnvelope {
+	return EventEnvelope{
+		ID:        ev.ID,
+		Type:      ev.Type,
+		CreatedAt: ev.CreatedAt.UTC(),
+		UserID:    ev.UserID,
+		Data:      json.RawMessage(ev.Payload),
+	}
+}
+
+// EventDispatcher drains the event outbox. Subscribers run inside the
+// transaction that marks events dispatched, so work they queue there is
+// never lost or duplicated; after-dispatch hooks run once it has committed.
+type EventDispatcher struct {
+	txm         *repository.TxManager
+	interval    time.Duration
+	wake        chan struct{}
+	subscribers []func(r repository.Repos, ev models.OutboxEvent) error
+	after       []func(events []models.OutboxEvent)
+}
+
+func NewEventDispatcher(txm *repository.TxManager, interval time.Duration) *EventDispatcher {
+	return &EventDispatcher{
+		txm:      txm,
+		interval: interval,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Subscribe registers fn to handle every event inside the dispatch
+// transaction. An error rolls the batch back for a later retry.
+func (d *EventDispatcher) Subscribe(fn func(r repository.Repos, ev models.OutboxEvent) error) {
+	d.subscribers = append(d.subscribers, fn)
+}
+
+// AfterDispatch registers fn to be called with each batch once committed.
+func (d *EventDispatcher) AfterDispatch(fn func(events []models.OutboxEvent)) {
+	d.after = append(d.after, fn)
+}
+
+// Wake asks the worker to drain the outbox now rather than at the next tick.
+func (d *EventDispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run drains the outbox on every tick or wake-up until ctx is cancelled.
+func (d *EventDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.Drain(); err != nil {
+			log.Printf("event dispatcher: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// Drain dispatches every queued event.
+func (d *EventDispatcher) Drain() error {
+	for {
+		var batch []models.OutboxEvent
+		err := d.txm.Do(func(r repository.Repos) error {
+			var err error
+			batch, err = r.Outbox.ClaimUndispatched(outboxBatchSize)
+			if err != nil || len(batch) == 0 {
+				return err
+			}
+			ids := make([]uint, 0, len(batch))
+			for _, ev := range batch {
+				for _, fn := range d.subscribers {
+					if err := fn(r, ev); err != nil {
+						return err
+					}
+				}
+				ids = append(ids, ev.ID)
+			}
+			return r.Outbox.MarkDispatched(ids)
+		})
+		if err != nil || len(batch) == 0 {
+			return err
+		}
+		for _, fn := range d.after {
+			fn(batch)
+		}
+	}
+}
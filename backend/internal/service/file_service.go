@@ -1,30 +1,43 @@
 package service
 
 import (
+	"backend/internal/metrics"
 	"backend/internal/models"
 	"backend/internal/repository"
-	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"mime"
-	"mime/multipart"
-	"net/http"
 	"os"
-	"path/filepath"
+	"strings"
 	"gorm.io/gorm"
 	"crypto/rand"
-	"time"
 
 )
 
+var (
+	ErrMimeMismatch    = errors.New("mime mismatch")
+	ErrFileTooLarge    = errors.New("file too large")
+	ErrTypeNotAllowed  = errors.New("file type not allowed")
+	ErrUploadRead      = errors.New("failed to read uploaded file")
+	ErrAlreadyUploaded = errors.New("file already uploaded by user")
+	ErrQuotaExceeded   = errors.New("storage quota exceeded")
+	ErrInvalidPath     = errors.New("invalid file name or folder")
+)
+
 type FileService struct {
     fileRepo     *repository.FileRepository
     userFileRepo *repository.UserFileRepository
     userRepo     *repository.UserRepository   
+    folderRepo   *repository.FolderRepository
+    txm          *repository.TxManager
+    deleter      *BlobDeleter
+    newBlobHooks []func(file *models.File)
+    eventHooks   []func()
     config       FileConfig
 	storageQuotaMB int64
+	staging      stagingFiles
 }
 
 
@@ -32,12 +45,20 @@ type FileConfig struct {
 	MaxFileSize   int64  // Maximum file size in bytes
 	UploadDir     string // Directory to store uploaded files
 	AllowedTypes  []string // Allowed MIME types (empty means all allowed)
+
+	MaxArchiveEntries      int   // Entries allowed in one extracted archive (0 uses the default)
+	MaxArchiveExpandedSize int64 // Total bytes an archive may expand to (0 uses the default)
+	MaxCompressionRatio    int   // Expanded-to-compressed ratio treated as a zip bomb (0 uses the default)
+	MaxZipDownloadSize     int64 // Total bytes allowed in one multi-file ZIP download (0 uses the default)
 }
 
 func NewFileService(
     fileRepo *repository.FileRepository,
     userFileRepo *repository.UserFileRepository,
     userRepo *repository.UserRepository,
+    folderRepo *repository.FolderRepository,
+    txm *repository.TxManager,
+    deleter *BlobDeleter,
     config FileConfig,
 	quota int64,
 ) *FileService {
@@ -45,114 +66,194 @@ func NewFileService(
         fileRepo:     fileRepo,
         userFileRepo: userFileRepo,
         userRepo:     userRepo,
+        folderRepo:   folderRepo,
+        txm:          txm,
+        deleter:      deleter,
         config:       config,
 		storageQuotaMB: quota,
     }
 }
 
-type FileFilter struct {
-    Filename string
-    MimeType string
-    Uploader string
-    MinSize  *int64
-    MaxSize  *int64
-    StartDate *time.Time
-    EndDate   *time.Time
+// ProcessFileUpload handles the complete file upload business logic. The
+// content is read from src exactly once: it is sniffed, hashed and written to
+// a staging blob in a single pass, and the blob is promoted or discarded once
+// the dedup lookup has completed. Content the user already stores is
+// rejected with ErrAlreadyUploaded.
+func (fs *FileService) ProcessFileUpload(userID uint, folder, filename string, src io.Reader) (*models.UserFile, error) {
+	return fs.upload(userID, folder, filename, src, nil)
 }
 
+// pathWrite describes an upload to a path, as WebDAV, S3 and SFTP clients
+// write files. Unlike an API upload it may hold content the user already
+// stores elsewhere.
+type pathWrite struct {
+	// current are the files at the path now. If one of them already holds
+	// the content, upload returns it and writes nothing.
+	current []repository.PathRow
+}
 
-
-// ProcessFileUpload handles the complete file upload business logic
-func (fs *FileService) ProcessFileUpload(userID uint, fileHeader *multipart.FileHeader) (*models.UserFile, error) {
-	fmt.Printf("service: File:%d", userID)
-	// 1. Validate file size
-	if err := fs.validateFileSize(fileHeader.Size); err != nil {
-		return nil, err
-	}
-
-	// 2. Open and validate file content
-	file, err := fileHeader.Open()
+// upload stores src as folder/filename. pw is nil for API uploads.
+func (fs *FileService) upload(userID uint, folder, filename string, src io.Reader, pw *pathWrite) (*models.UserFile, error) {
+	folder, err := normalizeFolder(folder)
 	if err != nil {
-		return nil, errors.New("failed to open uploaded file")
+		return nil, err
 	}
-	defer file.Close()
-
-	// 3. Validate MIME type
-	mimeType, err := fs.validateMimeType(file, fileHeader.Filename)
+	filename, err = cleanFilename(filename)
 	if err != nil {
 		return nil, err
 	}
-	file.Seek(0, io.SeekStart) // Reset file pointer
 
-	// 4. Compute file hash for deduplication
-	hash, err := fs.computeFileHash(file)
+	// 1. Stream into staging while sniffing, hashing and enforcing size
+	blob, err := fs.stageUpload(src, filename)
 	if err != nil {
 		return nil, err
 	}
-	file.Seek(0, io.SeekStart) // Reset file pointer
 
-	// 5. Check if user already uploaded this file
-	exists, err := fs.userFileRepo.UserHasFile(userID, hash)
-	if err != nil {
-		return nil, errors.New("failed to check for existing file")
-	}
-	if exists {
-		return nil, errors.New("file already uploaded by user")
+	// 2. Enforce quota now that the real size is known
+	if err := fs.CheckStorageQuota(userID, blob.size); err != nil {
+		fs.discard(blob)
+		return nil, err
 	}
 
-	// 6. Check for global file deduplication
-	existingFile, err := fs.fileRepo.GetFileByHash(hash)
-	if err == nil && existingFile != nil {
-		// File exists globally, create user reference
-		userFile, err := fs.fileRepo.CreateUserReference(userID, existingFile , fileHeader.Filename, false);
+	// 3. Insert the file row or reuse the existing one for this hash. The
+	// unique index on files.hash makes this safe under concurrent uploads:
+	// the loser of the race reuses the winner's row and drops its staging blob.
+	var userFile *models.UserFile
+	var stored *models.File
+	created, unchanged := false, false
+	err = fs.txm.Do(func(r repository.Repos) error {
+		file, isNew, err := r.Files.InsertOrGetByHash(&models.File{
+			Filename:    filename,
+			Hash:        blob.hash,
+			StoragePath: fs.blobPath(blob.hash),
+			MimeType:    blob.mimeType,
+			Size:        blob.size,
+			RefCount:    0, // Incremented by CreateUserReference
+		})
 		if err != nil {
-			return nil, errors.New("failed to create file reference")
+			return err
 		}
-		
-		_ = fs.userRepo.IncrementExpectedStorage(userID, existingFile.Size)
-		// Return the existing file info (user will see it as their file)
-		return userFile, nil
-	}
 
-	// 7. Generate unique storage path
-	storagePath, err := fs.generateStoragePath(fileHeader.Filename)
-	if err != nil {
-		return nil, err
-	}
+		// 4. Check if user already uploaded this file. The hash lock taken
+		// above serialises this with the user's concurrent uploads of it.
+		if pw == nil && !isNew {
+			exists, err := r.UserFiles.UserHasFile(userID, blob.hash)
+			if err != nil {
+				return err
+			}
+			if exists {
+				return ErrAlreadyUploaded
+			}
+		}
+		if pw != nil && !isNew {
+			for _, row := range pw.current {
+				if row.FileID == file.ID {
+					unchanged = true
+					userFile, err = r.UserFiles.GetUserFileByID(row.UserFileID, userID)
+					return err
+				}
+			}
+		}
 
-	// 8. Save file to disk
-	if err := fs.saveFileToDisk(file, storagePath); err != nil {
-		return nil, err
-	}
+		// 5. Promote the staged blob while the new row is still uncommitted,
+		// so nobody can reference it before the content is in place
+		if isNew {
+			if _, err := fs.promote(blob); err != nil {
+				return err
+			}
+			created = true
+			stored = file
+		}
 
-	// 9. Save file metadata to database with initial refCount of 1
-	newFile := &models.File{ 
-		Filename:    fileHeader.Filename,
-		Hash:        hash,
-		StoragePath: storagePath,
-		MimeType:    mimeType,
-		Size:        fileHeader.Size,
-		RefCount:    0, // Initial reference count
+		// A second copy of the user's own content is charged like a file
+		// shared with them: in full against the quota, but not for the blob
+		userFile, err = r.Files.CreateUserReference(userID, file, folder, filename, isNew)
+		if err == nil {
+			actualDelta := int64(0)
+			if isNew {
+				actualDelta = file.Size
+			}
+			err = r.Users.UpdateUserStorage(userID, actualDelta, file.Size)
+		}
+		if err == nil {
+			err = emit(r, models.EventFileUploaded, userID, fileEventData(userFile, file))
+		}
+		if err == nil {
+			err = trackPath(r, userID, userFile.Folder, userFile.FileName)
+		}
+		if err == nil && isNew {
+			err = fs.emitQuotaCrossing(r, userID, file.Size)
+		}
+		return err
+	})
+	if err != nil && created {
+		// The transaction, or its commit, failed after the blob was put in
+		// place, so no row refers to it
+		fs.removeUnusedBlob(blob.hash)
+		created = false
+	}
+	if !created {
+		fs.discard(blob)
+	}
+	if err != nil {
+		if errors.Is(err, ErrAlreadyUploaded) {
+			return nil, ErrAlreadyUploaded
+		}
+		return nil, fmt.Errorf("failed to create file reference: %w", err)
+	}
+	if unchanged {
+		return userFile, nil
 	}
 
-	if err := fs.fileRepo.Save(newFile); err != nil {
-		// Clean up file if database save fails
-		os.Remove(storagePath)
-		return nil, errors.New("failed to save file metadata")
+	metrics.DedupLookup(!created)
+	if created {
+		for _, hook := range fs.newBlobHooks {
+			hook(stored)
+		}
 	}
+	fs.eventsQueued()
+	return userFile, nil
+}
 
-	// Create the UserFile relationship
-	userFile, err := fs.fileRepo.CreateUserReference(userID, newFile , fileHeader.Filename, true);
+// removeUnusedBlob removes the blob for hash unless a file row refers to it.
+// It takes the hash lock first, as a concurrent upload of the same content
+// may have stored the blob again since the caller's transaction ended.
+func (fs *FileService) removeUnusedBlob(hash string) {
+	path := fs.blobPath(hash)
+	err := fs.txm.Do(func(r repository.Repos) error {
+		if err := r.Files.LockHash(hash); err != nil {
+			return err
+		}
+		inUse, err := r.Files.BlobInUse(hash, path)
+		if err != nil || inUse {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
 	if err != nil {
-		os.Remove(storagePath)
-		fs.fileRepo.DeleteFileRecord(newFile.ID) // Clean up the file record
-		return nil, errors.New("failed to create user file relationship")
+		fmt.Printf("Warning: failed to remove unused blob %s: %v\n", path, err)
 	}
+}
 
-	_ = fs.userRepo.IncrementExpectedStorage(userID, newFile.Size)
-	_ = fs.userRepo.IncrementActualStorage(userID, newFile.Size)
+// OnNewBlob registers fn to run after an upload stores content the vault has
+// not seen before. Hooks run synchronously and should hand off slow work.
+func (fs *FileService) OnNewBlob(fn func(file *models.File)) {
+	fs.newBlobHooks = append(fs.newBlobHooks, fn)
+}
 
-	return userFile, nil
+// OnEventsQueued registers fn to run after a change has committed events to
+// the outbox, typically to wake the EventDispatcher.
+func (fs *FileService) OnEventsQueued(fn func()) {
+	fs.eventHooks = append(fs.eventHooks, fn)
+}
+
+func (fs *FileService) eventsQueued() {
+	for _, hook := range fs.eventHooks {
+		hook()
+	}
 }
 
 // GetFilesByUser retrieves all files for a user
@@ -166,6 +267,7 @@ type FileFrontend struct {
 	ID            uint   `json:"id"`
 	FileID        uint   `json:"file_id"`
 	Filename      string `json:"filename"`
+	Folder        string `json:"folder"`
 	Size          int64  `json:"size"`
 	Uploader      string `json:"uploader"`
 	UploadDate    string `json:"upload_date"`
@@ -178,14 +280,54 @@ type FileFrontend struct {
 		ShowDownloadCount bool `json:"showDownloadCount"`
 	} `json:"actions"`
 	PublicLink string `json:"public_link,omitempty"`
+	Tags         []string          `json:"tags"`
+	Metadata     map[string]string `json:"metadata"`
+	PreviewURL   string `json:"preview_url,omitempty"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
 }
 
-func (fs *FileService) ListFilesForFrontend(userID uint, filters map[string]string) ([]FileFrontend, error) {
-    rows, err := fs.userFileRepo.ListUserFilesWithFilters(userID, filters)
+// ListFilesForFrontend returns the user's files matching filter, ordered
+// and paged according to opts.
+func (fs *FileService) ListFilesForFrontend(userID uint, filter FileFilter, opts ListOptions) (*FilePage, error) {
+    page, err := opts.pageRequest()
+    if err != nil {
+        return nil, err
+    }
+    limit := page.Limit
+    if limit > 0 {
+        page.Limit++ // one extra row tells us whether another page exists
+    }
+
+    rows, total, err := fs.userFileRepo.ListUserFilesWithFilters(userID, filter.listFilter(), page)
     if err != nil {
         return nil, err
     }
 
+    result := &FilePage{}
+    if page.WithTotal {
+        result.Total = &total
+    }
+    if limit > 0 && len(rows) > limit {
+        rows = rows[:limit]
+        last := rows[limit-1]
+        result.HasMore = true
+        result.NextCursor = encodeCursor(listCursor{
+            Sort:  page.Sort,
+            Desc:  page.Desc,
+            Value: sortValue(page.Sort, last),
+            ID:    last.UserFileID,
+        })
+    }
+    files, err := toFrontendFiles(rows)
+    if err != nil {
+        return nil, err
+    }
+    result.Files = files
+    return result, nil
+}
+
+func toFrontendFiles(rows []repository.JoinedFileRow) ([]FileFrontend, error) {
+
     result := make([]FileFrontend, 0, len(rows))
     for _, r := range rows {
         isPublic := "no"
@@ -203,18 +345,34 @@ func (fs *FileService) ListFilesForFrontend(userID uint, filters map[string]stri
             ID:            r.UserFileID,
             FileID:        r.FileID,
             Filename:      r.FileName,
+            Folder:        r.Folder,
             Size:          r.Size,
             Uploader:      r.UploaderName,
             UploadDate:    r.UploadedAt.Format("2006-01-02"),
             IsPublic:      isPublic,
             DownloadCount: nil,
             PublicLink:    publicLink,
+            Tags:          []string{},
+            Metadata:      map[string]string{},
+        }
+        if r.Tags != "" {
+            f.Tags = strings.Split(r.Tags, ",")
+        }
+        if err := json.Unmarshal([]byte(r.Metadata), &f.Metadata); err != nil {
+            return nil, err
         }
 
         if showDownloadCount {
             f.DownloadCount = &r.DownloadTimes
         }
 
+        if r.PreviewStatus != nil && *r.PreviewStatus == models.PreviewReady {
+            f.PreviewURL = fmt.Sprintf("/api/files/%d/preview", r.UserFileID)
+            if r.PreviewKind != nil && *r.PreviewKind == models.PreviewKindImage {
+                f.ThumbnailURL = fmt.Sprintf("/api/files/%d/preview/small", r.UserFileID)
+            }
+        }
+
         f.Actions.CanDownload = true
         f.Actions.CanMakePublic = r.IsOwner
         f.Actions.CanDelete = true
@@ -228,8 +386,16 @@ func (fs *FileService) ListFilesForFrontend(userID uint, filters map[string]stri
 
 
 // GetFileForDownload retrieves file information for download
-func (fs *FileService) GetFileForDownload(userID uint, fileID uint) (*models.File, error) {
-	file, err := fs.fileRepo.GetFileForDownload(userID, fileID)
+// Download is a file resolved for serving, along with the user file and
+// public link it was reached through.
+type Download struct {
+	*models.File
+	UserFileID uint
+	LinkID     string // empty for authenticated downloads
+}
+
+func (fs *FileService) GetFileForDownload(userID uint, fileID uint) (*Download, error) {
+	file, uf, err := fs.fileRepo.GetFileForDownload(userID, fileID)
 	if err != nil {
 		return nil, errors.New("file not found")
 	}
@@ -239,86 +405,14 @@ func (fs *FileService) GetFileForDownload(userID uint, fileID uint) (*models.Fil
 		return nil, errors.New("file not found on disk")
 	}
 
-	return file, nil
-}
-
-
-func (fs *FileService) validateFileSize(size int64) error {
-	if fs.config.MaxFileSize > 0 && size > fs.config.MaxFileSize {
-		return errors.New("file too large")
-	}
-	return nil
-}
-
-func (fs *FileService) validateMimeType(file multipart.File, filename string) (string, error) {
-	// Detect MIME type from file content
-	buf := make([]byte, 512)
-	n, _ := file.Read(buf)
-	detectedMime := http.DetectContentType(buf[:n])
-
-	// Get expected MIME type from file extension
-	expectedMime := mime.TypeByExtension(filepath.Ext(filename))
-
-	// Validate if expected MIME type exists and matches
-	if expectedMime != "" && detectedMime != expectedMime {
-		return "", errors.New("mime mismatch")
-	}
-
-	// Check against allowed types if configured
-	if len(fs.config.AllowedTypes) > 0 {
-		allowed := false
-		for _, allowedType := range fs.config.AllowedTypes {
-			if detectedMime == allowedType {
-				allowed = true
-				break
-			}
-		}
-		if !allowed {
-			return "", errors.New("file type not allowed")
-		}
-	}
-
-	return detectedMime, nil
-}
-
-func (fs *FileService) computeFileHash(file multipart.File) (string, error) {
-	hasher := sha256.New()
-	if _, err := io.Copy(hasher, file); err != nil {
-		return "", errors.New("failed to compute file hash")
-	}
-	return hex.EncodeToString(hasher.Sum(nil)), nil
-}
-
-func (fs *FileService) generateStoragePath(filename string) (string, error) {
-	// Ensure upload directory exists
-	if err := os.MkdirAll(fs.config.UploadDir, 0755); err != nil {
-		return "", errors.New("failed to create upload directory")
-	}
-
-	// Generate unique filename to avoid conflicts
-	ext := filepath.Ext(filename)
-	baseName := filename[:len(filename)-len(ext)]
-	uniqueFilename := fmt.Sprintf("%s%s", baseName, ext)
-	
-	return filepath.Join(fs.config.UploadDir, uniqueFilename), nil
+	return &Download{File: file, UserFileID: uf.ID}, nil
 }
 
-func (fs *FileService) saveFileToDisk(file multipart.File, storagePath string) error {
-	dst, err := os.Create(storagePath)
-	if err != nil {
-		return errors.New("failed to create file on disk")
-	}
-	defer dst.Close()
-
-	if _, err := io.Copy(dst, file); err != nil {
-		os.Remove(storagePath) // Clean up on failure
-		return errors.New("failed to save file to disk")
-	}
-
-	return nil
-}
 
-//
+// DeleteFile removes a user's reference to a file. Counters, the reference
+// and the files row are updated in one transaction; if that was the last
+// reference the blob is queued for removal in the same transaction and taken
+// off disk by the BlobDeleter, so a crash can never leave the two out of step.
 func (fs *FileService) DeleteFile(userfileID, userID uint) error {
     // Step 1: Fetch user_file entry to get file_id and ownership
     userFile, err := fs.userFileRepo.GetUserFileByID(userfileID, userID)
@@ -328,55 +422,84 @@ func (fs *FileService) DeleteFile(userfileID, userID uint) error {
         }
         return errors.New("failed to find user file relation")
     }
-    fileID := userFile.FileID
-    isOwner := userFile.IsOwner
 
-    // Step 1b: Fetch file metadata to get size
-    file, err := fs.fileRepo.GetFileByID(fileID)
+    file, err := fs.fileRepo.GetFileByID(userFile.FileID)
     if err != nil {
         return errors.New("file metadata not found")
     }
-    fileSize := file.Size
 
-    // Step 1c: Update user storage
-    if isOwner {
-        // Subtract from both actual and expected storage
-        if err := fs.userRepo.UpdateUserStorage(userID, -fileSize, -fileSize); err != nil {
-            return errors.New("failed to update user storage")
+    err = fs.txm.Do(func(r repository.Repos) error {
+        // Serialise with uploads and the deletion worker for this content,
+        // then re-read both rows under lock
+        if err := r.Files.LockHash(file.Hash); err != nil {
+            return err
         }
-    } else {
-        // Subtract only from expected storage
-        if err := fs.userRepo.UpdateUserStorage(userID, 0, -fileSize); err != nil {
-            return errors.New("failed to update user expected storage")
+        file, err := r.Files.LockByID(file.ID)
+        if err != nil {
+            return err
+        }
+        userFile, err := r.UserFiles.GetUserFileByID(userfileID, userID)
+        if err != nil {
+            return err
         }
-    }
 
-    // Step 2: Delete user <-> file relation
-    if err := fs.userFileRepo.DeleteUserFile(userID, fileID); err != nil {
-        return errors.New("failed to delete file relationship")
-    }
+        // Step 2: Delete user <-> file relation
+        if err := r.UserFiles.DeleteUserFile(userID, userFile.ID); err != nil {
+            return err
+        }
 
-    // Step 3: Check remaining references
-    count, err := fs.userFileRepo.CountFileReferences(fileID)
-    if err != nil {
-        return errors.New("failed to check file references")
-    }
+        // Step 3: Update user storage
+        actualDelta := int64(0)
+        if userFile.IsOwner {
+            // Owners are also charged for the physical copy, unless another
+            // of their paths holds the same content and takes over paying
+            other, err := r.UserFiles.GetByUserAndFile(userID, file.ID)
+            if err != nil {
+                return err
+            }
+            if other != nil {
+                err = r.UserFiles.SetOwner(other, true)
+            } else {
+                actualDelta = -file.Size
+            }
+            if err != nil {
+                return err
+            }
+        }
+        if err := r.Users.UpdateUserStorage(userID, actualDelta, -file.Size); err != nil {
+            return err
+        }
+        if err := emit(r, models.EventFileDeleted, userID, fileEventData(userFile, file)); err != nil {
+            return err
+        }
+        if err := trackPath(r, userID, userFile.Folder, userFile.FileName); err != nil {
+            return err
+        }
 
-    // Step 3b: Update reference count in files table
-    if err := fs.fileRepo.UpdateReferenceCount(fileID, count); err != nil {
-        return errors.New("failed to update file reference count")
-    }
+        // Step 4: Recount remaining references
+        count, err := r.UserFiles.CountFileReferences(file.ID)
+        if err != nil {
+            return err
+        }
+        if count > 0 {
+            return r.Files.UpdateReferenceCount(file.ID, count)
+        }
 
-    // Step 4: If no references left, delete the file
-    if count == 0 {
-        if err := fs.fileRepo.DeleteFileRecord(fileID); err != nil {
-            return errors.New("failed to delete file record")
+        // Step 5: Last reference gone; drop the row and queue the blob
+        if err := r.Files.DeleteFileRecord(file.ID); err != nil {
+            return err
         }
-        if err := os.Remove(file.StoragePath); err != nil && !os.IsNotExist(err) {
-            return fmt.Errorf("failed to delete file from disk: %w", err)
+        return r.Deletions.Enqueue(file.Hash, file.StoragePath)
+    })
+    if err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return errors.New("file not found")
         }
+        return errors.New("failed to delete file relationship")
     }
 
+    fs.deleter.Wake()
+    fs.eventsQueued()
     return nil
 }
 
@@ -388,39 +511,64 @@ func generatePublicToken() string {
 	return hex.EncodeToString(b)
 }
 
-// Change file visibility; only owner allowed
+// Change file visibility; only owner allowed. Making a file public always
+// issues a new link, which is announced as file.shared.
 func (fs *FileService) ChangeVisibility(userID, userfileID uint, makePublic bool) (*models.UserFile, error) {
-	uf, err := fs.userFileRepo.GetOwnerUserFile(userID, userfileID)
-	if err != nil {
-		return nil, err
-	}
+	var updated *models.UserFile
+	err := fs.txm.Do(func(r repository.Repos) error {
+		uf, err := r.UserFiles.GetOwnerUserFile(userID, userfileID)
+		if err != nil {
+			return err
+		}
+		wasPublic := uf.Visibility == "public"
 
-	var token *string
-	if makePublic {
-		t := generatePublicToken()
-		token = &t
-	}
+		var token *string
+		if makePublic {
+			t := generatePublicToken()
+			token = &t
+		}
 
-	visibility := "private"
-	if makePublic {
-		visibility = "public"
-	}
+		visibility := "private"
+		if makePublic {
+			visibility = "public"
+		}
 
-	if err := fs.userFileRepo.UpdateVisibility(uf, visibility, token); err != nil {
-		return nil, err
-	}
+		if err := r.UserFiles.UpdateVisibility(uf, visibility, token); err != nil {
+			return err
+		}
 
-	updated, err := fs.userFileRepo.GetOwnerUserFile(userID, userfileID)
+		updated, err = r.UserFiles.GetOwnerUserFile(userID, userfileID)
+		if err != nil {
+			return err
+		}
+		file, err := r.Files.GetFileByID(updated.FileID)
+		if err != nil {
+			return err
+		}
+
+		data := fileEventData(updated, file)
+		switch {
+		case makePublic && !wasPublic:
+			err = emit(r, models.EventFileMadePublic, userID, data)
+		case !makePublic && wasPublic:
+			err = emit(r, models.EventFileMadePrivate, userID, data)
+		}
+		if err == nil && makePublic {
+			err = emit(r, models.EventFileShared, userID, data)
+		}
+		return err
+	})
 	if err != nil {
-    return nil, err
+		return nil, err
 	}
 
+	fs.eventsQueued()
 	return updated, nil
 }
 
 
 // It validates that the token exists and the file is still public.
-func (fs *FileService) GetFileByPublicToken(token string) (*models.File, error) {
+func (fs *FileService) GetFileByPublicToken(token string) (*Download, error) {
     // Step 1: Lookup user_files entry via repository
     uf, err := fs.userFileRepo.GetByPublicToken(token)
     if err != nil {
@@ -439,7 +587,7 @@ func (fs *FileService) GetFileByPublicToken(token string) (*models.File, error)
         return nil , errors.New("file metadata not found")
     }
 
-    return file, nil
+    return &Download{File: file, UserFileID: uf.ID, LinkID: LinkID(token)}, nil
 }
 
 // file_service.go
@@ -453,18 +601,138 @@ func (s *FileService) GetStorageStats(userID uint) (int64, int64, error) {
 
 
 func (fs *FileService) CheckStorageQuota(userID uint, newFileSize int64) error {
-    // Get used storage from user table
-    used, err := fs.userRepo.GetUserStorageUsed(userID)
+    // Get used storage and any quota of the user's own
+    user, err := fs.userRepo.GetByID(userID)
     if err != nil {
         return err
     }
-
-    // Convert quota from MB (env) to bytes
-    quotaBytes := fs.storageQuotaMB * 1024 * 1024
+    used, quotaBytes := user.ActualStorage, fs.quotaBytes(user)
 
     if used+newFileSize > quotaBytes {
-        return fmt.Errorf("storage quota exceeded: %d/%d bytes used", used, quotaBytes)
+        return fmt.Errorf("%w: %d/%d bytes used", ErrQuotaExceeded, used, quotaBytes)
     }
 
     return nil
 }
+
+// quotaBytes returns the user's storage quota: their own if an
+// administrator set one, or the server-wide quota (from MB in the env).
+func (fs *FileService) quotaBytes(user *models.User) int64 {
+    if user.QuotaBytes != nil {
+        return *user.QuotaBytes
+    }
+    return fs.storageQuotaMB * 1024 * 1024
+}
+
+// quotaThresholds are the percentages of the storage quota at which users
+// are notified.
+var quotaThresholds = []int64{80, 95}
+
+// QuotaEventData reports a quota threshold crossed by an upload.
+type QuotaEventData struct {
+	ThresholdPercent int64 `json:"threshold_percent"`
+	UsedBytes        int64 `json:"used_bytes"`
+	QuotaBytes       int64 `json:"quota_bytes"`
+}
+
+// emitQuotaCrossing queues an event for the highest threshold that growing
+// the user's stored bytes by delta took them past.
+func (fs *FileService) emitQuotaCrossing(r repository.Repos, userID uint, delta int64) error {
+	if delta <= 0 {
+		return nil
+	}
+	user, err := r.Users.GetByID(userID)
+	if err != nil {
+		return err
+	}
+	quotaBytes, used := fs.quotaBytes(user), user.ActualStorage
+	if quotaBytes <= 0 {
+		return nil
+	}
+	before := used - delta
+
+	for i := len(quotaThresholds) - 1; i >= 0; i-- {
+		limit := quotaBytes * quotaThresholds[i] / 100
+		if before < limit && used >= limit {
+			return emit(r, models.EventQuotaThreshold, userID, QuotaEventData{
+				ThresholdPercent: quotaThresholds[i],
+				UsedBytes:        used,
+				QuotaBytes:       quotaBytes,
+			})
+		}
+	}
+	return nil
+}
+
+// RenameEventData is a file event with the name and folder it had before.
+type RenameEventData struct {
+	FileEventData
+	OldFilename string `json:"old_filename"`
+	OldFolder   string `json:"old_folder"`
+}
+
+// RenameFile moves a user file to a new name and/or folder; nil leaves that
+// part unchanged, and folder "" is the root.
+func (fs *FileService) RenameFile(userID, userfileID uint, folder, filename *string) (*models.UserFile, error) {
+	var err error
+	if folder != nil {
+		f, err := normalizeFolder(*folder)
+		if err != nil {
+			return nil, err
+		}
+		folder = &f
+	}
+	if filename != nil {
+		n, err := cleanFilename(*filename)
+		if err != nil {
+			return nil, err
+		}
+		filename = &n
+	}
+
+	var updated *models.UserFile
+	err = fs.txm.Do(func(r repository.Repos) error {
+		uf, err := r.UserFiles.GetUserFileByID(userfileID, userID)
+		if err != nil {
+			return err
+		}
+		old := RenameEventData{OldFilename: uf.FileName, OldFolder: uf.Folder}
+		newFolder, newName := uf.Folder, uf.FileName
+		if folder != nil {
+			newFolder = *folder
+		}
+		if filename != nil {
+			newName = *filename
+		}
+		if newFolder == uf.Folder && newName == uf.FileName {
+			updated = uf
+			return nil
+		}
+
+		if err := r.UserFiles.Rename(uf, newFolder, newName); err != nil {
+			return err
+		}
+		file, err := r.Files.GetFileByID(uf.FileID)
+		if err != nil {
+			return err
+		}
+		updated = uf
+		old.FileEventData = fileEventData(uf, file)
+		if err := emit(r, models.EventFileRenamed, userID, old); err != nil {
+			return err
+		}
+		if err := trackPath(r, userID, old.OldFolder, old.OldFilename); err != nil {
+			return err
+		}
+		return trackPath(r, userID, uf.Folder, uf.FileName)
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("file not found")
+		}
+		return nil, err
+	}
+
+	fs.eventsQueued()
+	return updated, nil
+}
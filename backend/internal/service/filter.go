@@ -0,0 +1,187 @@
+package service
+
+import (
+	"backend/internal/repository"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const maxFilterTextLen = 255
+
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// mimeFilterPattern accepts "type/subtype" or "type/*".
+var mimeFilterPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9!#$&^_.+-]*/([a-z0-9][a-z0-9!#$&^_.+-]*|\*)$`)
+
+// FileFilter is the validated form of the file listing's query parameters.
+// Build one with ParseFileFilter.
+type FileFilter struct {
+	Filename  string
+	MimeType  string // exact type, or a prefix such as "image/*"
+	Uploader  string
+	MinSize   *int64
+	MaxSize   *int64
+	StartDate *time.Time // inclusive
+	EndDate   *time.Time // exclusive
+	Tags      []string
+	Metadata  []repository.MetadataMatch
+	IsPublic  *bool
+}
+
+// FilterErrors maps query parameter names to what is wrong with them.
+type FilterErrors map[string]string
+
+func (e FilterErrors) Error() string {
+	fields := make([]string, 0, len(e))
+	for f, msg := range e {
+		fields = append(fields, f+": "+msg)
+	}
+	sort.Strings(fields)
+	return fmt.Sprintf("%s: %s", ErrInvalidFilter, strings.Join(fields, "; "))
+}
+
+func (e FilterErrors) Unwrap() error { return ErrInvalidFilter }
+
+// ParseFileFilter validates the listing filters in q. Every bad parameter is
+// reported, not just the first, so clients can flag all offending fields.
+//
+// Dates are RFC 3339 timestamps or YYYY-MM-DD days in UTC. A day given as
+// endDate includes the whole day.
+func ParseFileFilter(q url.Values) (FileFilter, error) {
+	var f FileFilter
+	errs := FilterErrors{}
+
+	f.Filename = strings.TrimSpace(q.Get("filename"))
+	if !validFilterText(f.Filename) {
+		errs["filename"] = "must be valid UTF-8 of at most 255 bytes"
+	}
+
+	if v := strings.ToLower(strings.TrimSpace(q.Get("mimeType"))); v != "" {
+		if mimeFilterPattern.MatchString(v) {
+			f.MimeType = v
+		} else {
+			errs["mimeType"] = `must be a media type such as "image/png" or "image/*"`
+		}
+	}
+
+	f.Uploader = strings.TrimSpace(q.Get("uploader"))
+	if !validFilterText(f.Uploader) {
+		errs["uploader"] = "must be valid UTF-8 of at most 255 bytes"
+	}
+
+	f.MinSize = parseSize(q.Get("minSize"), "minSize", errs)
+	f.MaxSize = parseSize(q.Get("maxSize"), "maxSize", errs)
+	if f.MinSize != nil && f.MaxSize != nil && *f.MinSize > *f.MaxSize {
+		errs["maxSize"] = "must not be less than minSize"
+	}
+
+	f.StartDate = parseFilterDate(q.Get("startDate"), "startDate", false, errs)
+	f.EndDate = parseFilterDate(q.Get("endDate"), "endDate", true, errs)
+	if f.StartDate != nil && f.EndDate != nil && !f.StartDate.Before(*f.EndDate) {
+		errs["endDate"] = "must be after startDate"
+	}
+
+	if v := q.Get("tags"); v != "" {
+		tags, err := normalizeTags(strings.Split(v, ","))
+		if err != nil {
+			errs["tags"] = "must be a comma-separated list of tags"
+		}
+		f.Tags = tags
+	}
+
+	if v := q.Get("metadata"); v != "" {
+		for _, pred := range strings.Split(v, ",") {
+			key, value, hasValue := strings.Cut(strings.TrimSpace(pred), "=")
+			if !validMetadataKey(key) {
+				errs["metadata"] = `must be comma-separated "key" or "key=value" predicates`
+				break
+			}
+			f.Metadata = append(f.Metadata, repository.MetadataMatch{Key: key, Value: value, HasValue: hasValue})
+		}
+	}
+
+	if v := q.Get("isPublic"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			errs["isPublic"] = "must be true or false"
+		}
+		f.IsPublic = &b
+	}
+
+	if len(errs) > 0 {
+		return FileFilter{}, errs
+	}
+	return f, nil
+}
+
+// listFilter translates the filter into the repository's terms.
+func (f FileFilter) listFilter() repository.ListFilter {
+	lf := repository.ListFilter{
+		Filename:       f.Filename,
+		Uploader:       f.Uploader,
+		MinSize:        f.MinSize,
+		MaxSize:        f.MaxSize,
+		UploadedFrom:   f.StartDate,
+		UploadedBefore: f.EndDate,
+		Tags:           f.Tags,
+		Metadata:       f.Metadata,
+	}
+	if prefix, ok := strings.CutSuffix(f.MimeType, "*"); ok {
+		lf.MimePrefix = prefix
+	} else {
+		lf.MimeType = f.MimeType
+	}
+	if f.IsPublic != nil {
+		lf.Visibility = "private"
+		if *f.IsPublic {
+			lf.Visibility = "public"
+		}
+	}
+	return lf
+}
+
+func validFilterText(s string) bool {
+	return len(s) <= maxFilterTextLen && utf8.ValidString(s)
+}
+
+func parseSize(v, field string, errs FilterErrors) *int64 {
+	if v == "" {
+		return nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		errs[field] = "must be a non-negative number of bytes"
+		return nil
+	}
+	return &n
+}
+
+// parseFilterDate parses a timestamp or a whole day. For an end bound the
+// result is exclusive: the day after a date, or just past a timestamp at the
+// database's microsecond resolution.
+func parseFilterDate(v, field string, end bool, errs FilterErrors) *time.Time {
+	if v == "" {
+		return nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		if end {
+			t = t.Truncate(time.Microsecond).Add(time.Microsecond)
+		}
+		return &t
+	}
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return &t
+	}
+	errs[field] = "must be a date (YYYY-MM-DD) or RFC 3339 timestamp"
+	return nil
+}
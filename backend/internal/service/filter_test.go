@@ -0,0 +1,173 @@
+package service
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"backend/internal/repository"
+)
+
+func TestParseFileFilter(t *testing.T) {
+	day := func(s string) *time.Time {
+		d, _ := time.Parse(time.DateOnly, s)
+		return &d
+	}
+	size := func(n int64) *int64 { return &n }
+
+	tests := []struct {
+		name    string
+		query   string
+		want    repository.ListFilter
+		badKeys []string
+	}{
+		{
+			name:  "empty",
+			query: "",
+			want:  repository.ListFilter{},
+		},
+		{
+			name:  "exact mime type",
+			query: "mimeType=Application/PDF",
+			want:  repository.ListFilter{MimeType: "application/pdf"},
+		},
+		{
+			name:  "mime prefix",
+			query: "mimeType=image/*",
+			want:  repository.ListFilter{MimePrefix: "image/"},
+		},
+		{
+			name:    "malformed mime type",
+			query:   "mimeType=image",
+			badKeys: []string{"mimeType"},
+		},
+		{
+			name:    "wildcard type",
+			query:   "mimeType=*/*",
+			badKeys: []string{"mimeType"},
+		},
+		{
+			name:  "filename and uploader",
+			query: "filename=+report+&uploader=alice",
+			want:  repository.ListFilter{Filename: "report", Uploader: "alice"},
+		},
+		{
+			name:  "size range",
+			query: "minSize=1024&maxSize=2048",
+			want:  repository.ListFilter{MinSize: size(1024), MaxSize: size(2048)},
+		},
+		{
+			name:    "size not a number",
+			query:   "minSize=1k&maxSize=Infinity",
+			badKeys: []string{"minSize", "maxSize"},
+		},
+		{
+			name:    "negative size",
+			query:   "minSize=-1",
+			badKeys: []string{"minSize"},
+		},
+		{
+			name:    "inverted size range",
+			query:   "minSize=10&maxSize=5",
+			badKeys: []string{"maxSize"},
+		},
+		{
+			name:  "date range includes the whole end day",
+			query: "startDate=2024-03-01&endDate=2024-03-31",
+			want:  repository.ListFilter{UploadedFrom: day("2024-03-01"), UploadedBefore: day("2024-04-01")},
+		},
+		{
+			name:  "same start and end day",
+			query: "startDate=2024-03-01&endDate=2024-03-01",
+			want:  repository.ListFilter{UploadedFrom: day("2024-03-01"), UploadedBefore: day("2024-03-02")},
+		},
+		{
+			name:  "timestamps",
+			query: "startDate=2024-03-01T10:00:00Z&endDate=2024-03-01T12:00:00Z",
+			want: repository.ListFilter{
+				UploadedFrom:   ptrTime(time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)),
+				UploadedBefore: ptrTime(time.Date(2024, 3, 1, 12, 0, 0, 1000, time.UTC)),
+			},
+		},
+		{
+			name:    "unparseable dates",
+			query:   "startDate=yesterday&endDate=03/31/2024",
+			badKeys: []string{"startDate", "endDate"},
+		},
+		{
+			name:    "end before start",
+			query:   "startDate=2024-04-01&endDate=2024-03-01",
+			badKeys: []string{"endDate"},
+		},
+		{
+			name:  "tags are normalised",
+			query: "tags=Work,%20taxes,work",
+			want:  repository.ListFilter{Tags: []string{"taxes", "work"}},
+		},
+		{
+			name:    "empty tag",
+			query:   "tags=work,,taxes",
+			badKeys: []string{"tags"},
+		},
+		{
+			name:  "metadata predicates",
+			query: "metadata=project=apollo,reviewed",
+			want: repository.ListFilter{Metadata: []repository.MetadataMatch{
+				{Key: "project", Value: "apollo", HasValue: true},
+				{Key: "reviewed"},
+			}},
+		},
+		{
+			name:    "metadata without key",
+			query:   "metadata==apollo",
+			badKeys: []string{"metadata"},
+		},
+		{
+			name:  "public only",
+			query: "isPublic=true",
+			want:  repository.ListFilter{Visibility: "public"},
+		},
+		{
+			name:    "bad boolean",
+			query:   "isPublic=maybe",
+			badKeys: []string{"isPublic"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			filter, err := ParseFileFilter(q)
+
+			if len(tt.badKeys) > 0 {
+				var fieldErrs FilterErrors
+				if !errors.As(err, &fieldErrs) || !errors.Is(err, ErrInvalidFilter) {
+					t.Fatalf("want FilterErrors, got %v", err)
+				}
+				if len(fieldErrs) != len(tt.badKeys) {
+					t.Errorf("want errors for %v, got %v", tt.badKeys, fieldErrs)
+				}
+				for _, k := range tt.badKeys {
+					if _, ok := fieldErrs[k]; !ok {
+						t.Errorf("missing error for %q in %v", k, fieldErrs)
+					}
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := filter.listFilter(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func ptrTime(t time.Time) *time.Time { return &t }
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	hashQueueSize    = 256
+	hashRescanPeriod = time.Minute
+)
+
+// hashQueue runs background work keyed by content hash, such as preview
+// generation or text extraction. Pending work is recorded in the database by
+// the caller; the in-memory queue only makes it prompt, and a periodic rescan
+// picks up anything dropped when the queue was full or the process restarted.
+type hashQueue struct {
+	name        string
+	queue       chan string
+	listPending func(limit int) ([]string, error)
+	process     func(hash string)
+
+	mu       sync.Mutex
+	inFlight map[string]bool
+}
+
+func newHashQueue(name string, listPending func(limit int) ([]string, error), process func(hash string)) *hashQueue {
+	return &hashQueue{
+		name:        name,
+		queue:       make(chan string, hashQueueSize),
+		listPending: listPending,
+		process:     process,
+		inFlight:    make(map[string]bool),
+	}
+}
+
+// push queues hash without blocking; the rescan catches it if the queue is full.
+func (q *hashQueue) push(hash string) {
+	select {
+	case q.queue <- hash:
+	default:
+	}
+}
+
+// run starts workers and rescans for pending work until ctx is cancelled,
+// then waits for in-progress jobs to finish.
+func (q *hashQueue) run(ctx context.Context, workers int) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case hash := <-q.queue:
+					q.processOnce(hash)
+				}
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(hashRescanPeriod)
+	defer ticker.Stop()
+	for {
+		q.requeuePending(ctx)
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (q *hashQueue) requeuePending(ctx context.Context) {
+	hashes, err := q.listPending(hashQueueSize)
+	if err != nil {
+		log.Printf("%s: failed to list pending: %v", q.name, err)
+		return
+	}
+	for _, hash := range hashes {
+		select {
+		case q.queue <- hash:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// processOnce skips hashes another worker is already handling.
+func (q *hashQueue) processOnce(hash string) {
+	q.mu.Lock()
+	if q.inFlight[hash] {
+		q.mu.Unlock()
+		return
+	}
+	q.inFlight[hash] = true
+	q.mu.Unlock()
+	defer func() {
+		q.mu.Lock()
+		delete(q.inFlight, hash)
+		q.mu.Unlock()
+	}()
+
+	q.process(hash)
+}
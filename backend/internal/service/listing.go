@@ -0,0 +1,134 @@
+package service
+
+import (
+	"backend/internal/repository"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+const maxListLimit = 200
+
+var (
+	ErrInvalidSort   = errors.New("invalid sort")
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// ListOptions selects ordering and a page of the file listing. Without a
+// limit or cursor every matching file is returned, as before pagination
+// existed.
+type ListOptions struct {
+	Sort      string // name, size, uploaded_at, downloads or mime_type
+	Order     string // asc or desc
+	Limit     int
+	Cursor    string // opaque, from a previous FilePage.NextCursor
+	WithTotal bool
+}
+
+// FilePage is one page of the file listing.
+type FilePage struct {
+	Files      []FileFrontend `json:"files"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+	HasMore    bool           `json:"has_more"`
+	Total      *int64         `json:"total,omitempty"`
+}
+
+// listCursor is the decoded form of an opaque cursor: the sort it was issued
+// for and the sort value and ID of the last row on the previous page.
+type listCursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d"`
+	Value string `json:"v"`
+	ID    uint   `json:"i"`
+}
+
+// pageRequest validates options and resolves the cursor into typed keyset
+// values for the repository.
+func (o ListOptions) pageRequest() (repository.ListPage, error) {
+	page := repository.ListPage{Sort: o.Sort, WithTotal: o.WithTotal}
+	if page.Sort == "" {
+		page.Sort = "uploaded_at"
+	}
+	if _, ok := repository.ListSortColumns[page.Sort]; !ok {
+		return page, ErrInvalidSort
+	}
+	switch o.Order {
+	case "":
+		// Newest first by default, otherwise ascending
+		page.Desc = page.Sort == "uploaded_at"
+	case "asc":
+	case "desc":
+		page.Desc = true
+	default:
+		return page, ErrInvalidSort
+	}
+
+	if o.Limit < 0 {
+		return page, ErrInvalidSort
+	}
+	page.Limit = min(o.Limit, maxListLimit)
+	if o.Cursor != "" && page.Limit == 0 {
+		page.Limit = maxListLimit
+	}
+
+	if o.Cursor != "" {
+		c, err := decodeCursor(o.Cursor)
+		if err != nil || c.Sort != page.Sort || c.Desc != page.Desc {
+			return page, ErrInvalidCursor
+		}
+		value, err := parseSortValue(page.Sort, c.Value)
+		if err != nil {
+			return page, ErrInvalidCursor
+		}
+		page.AfterValue, page.AfterID = value, c.ID
+	}
+	return page, nil
+}
+
+func encodeCursor(c listCursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s string) (listCursor, error) {
+	var c listCursor
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(raw, &c)
+	return c, err
+}
+
+// sortValue renders the sort column of a row for embedding in a cursor.
+func sortValue(sort string, r repository.JoinedFileRow) string {
+	switch sort {
+	case "name":
+		return r.FileName
+	case "size":
+		return strconv.FormatInt(r.Size, 10)
+	case "downloads":
+		return strconv.Itoa(r.DownloadTimes)
+	case "mime_type":
+		return r.MimeType
+	default:
+		return r.UploadedAt.UTC().Format(time.RFC3339Nano)
+	}
+}
+
+// parseSortValue turns a cursor value back into the column's Go type so the
+// keyset comparison is typed correctly.
+func parseSortValue(sort, v string) (interface{}, error) {
+	switch sort {
+	case "name", "mime_type":
+		return v, nil
+	case "size":
+		return strconv.ParseInt(v, 10, 64)
+	case "downloads":
+		return strconv.Atoi(v)
+	default:
+		return time.Parse(time.RFC3339Nano, v)
+	}
+}
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"cmp"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListFilterMatchesTypeWithCharset(t *testing.T) {
+	conn := openTestDB(t)
+	svc := newTestFileService(t, conn)
+	user := createTestUsers(t, conn, 1)[0]
+
+	if _, err := svc.ProcessFileUpload(user.ID, "", "notes.txt", strings.NewReader("plain words\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.ProcessFileUpload(user.ID, "", "data.json", strings.NewReader(`{"a": 1}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	var stored string
+	conn.Raw("SELECT mime_type FROM files WHERE size = ?", len("plain words\n")).Scan(&stored)
+	if !strings.Contains(stored, "charset=") {
+		t.Fatalf("stored type %q has no charset; the test needs one", stored)
+	}
+
+	for query, want := range map[string]string{
+		"mimeType=text/plain": "notes.txt",
+		"mimeType=text/*":     "notes.txt",
+	} {
+		v, _ := url.ParseQuery(query)
+		filter, err := ParseFileFilter(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, err := svc.ListFilesForFrontend(user.ID, filter, ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Files) != 1 || page.Files[0].Filename != want {
+			t.Errorf("%s: got %+v, want only %s", query, page.Files, want)
+		}
+	}
+}
+
+func TestPageRequest(t *testing.T) {
+	page, err := ListOptions{}.pageRequest()
+	if err != nil || page.Sort != "uploaded_at" || !page.Desc || page.Limit != 0 || page.AfterValue != nil {
+		t.Errorf("defaults = %+v, %v; want every file, newest first", page, err)
+	}
+	if page, _ := (ListOptions{Sort: "name"}).pageRequest(); page.Desc {
+		t.Error("name sorts descending by default")
+	}
+	if page, _ := (ListOptions{Limit: 5000}).pageRequest(); page.Limit != maxListLimit {
+		t.Errorf("limit 5000 became %d, want %d", page.Limit, maxListLimit)
+	}
+
+	for _, opts := range []ListOptions{
+		{Sort: "owner"},
+		{Sort: "f.size"},
+		{Order: "up"},
+		{Limit: -1},
+	} {
+		if _, err := opts.pageRequest(); err != ErrInvalidSort {
+			t.Errorf("%+v: err %v, want ErrInvalidSort", opts, err)
+		}
+	}
+
+	uploaded := time.Date(2026, 3, 1, 12, 0, 0, 123456000, time.UTC)
+	for _, tt := range []struct {
+		sort, value string
+		want        interface{}
+	}{
+		{"name", "report, final.pdf", "report, final.pdf"},
+		{"mime_type", "", ""},
+		{"size", "1099511627776", int64(1 << 40)},
+		{"downloads", "3", 3},
+		{"uploaded_at", uploaded.Format(time.RFC3339Nano), uploaded},
+	} {
+		cursor := encodeCursor(listCursor{Sort: tt.sort, Value: tt.value, ID: 42})
+		if strings.ContainsAny(cursor, "+/=") {
+			t.Errorf("%s cursor %q is not URL-safe", tt.sort, cursor)
+		}
+		page, err := ListOptions{Sort: tt.sort, Order: "asc", Cursor: cursor}.pageRequest()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.sort, err)
+		}
+		if page.AfterValue != tt.want || page.AfterID != 42 {
+			t.Errorf("%s: after (%#v, %d), want (%#v, 42)", tt.sort, page.AfterValue, page.AfterID, tt.want)
+		}
+		if page.Limit != maxListLimit {
+			t.Errorf("%s: a cursor without a limit pages by %d", tt.sort, page.Limit)
+		}
+	}
+
+	sizeCursor := encodeCursor(listCursor{Sort: "size", Value: "10", ID: 1})
+	for _, opts := range []ListOptions{
+		{Sort: "name", Order: "asc", Cursor: sizeCursor},  // issued for another sort
+		{Sort: "size", Order: "desc", Cursor: sizeCursor}, // or another order
+		{Sort: "size", Order: "asc", Cursor: encodeCursor(listCursor{Sort: "size", Value: "ten"})},
+		{Sort: "size", Order: "asc", Cursor: "not a cursor"},
+		{Sort: "size", Order: "asc", Cursor: base64.RawURLEncoding.EncodeToString([]byte("[1,2]"))},
+	} {
+		if _, err := opts.pageRequest(); err != ErrInvalidCursor {
+			t.Errorf("%+v: err %v, want ErrInvalidCursor", opts, err)
+		}
+	}
+}
+
+// listAll pages through the listing and returns the IDs in the order seen.
+func listAll(t *testing.T, svc *FileService, userID uint, opts ListOptions) []uint {
+	t.Helper()
+	var ids []uint
+	for pages := 0; ; pages++ {
+		if pages > 20 {
+			t.Fatal("paging never ends")
+		}
+		page, err := svc.ListFilesForFrontend(userID, FileFilter{}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opts.Limit > 0 && len(page.Files) > opts.Limit {
+			t.Fatalf("page of %d files, limit %d", len(page.Files), opts.Limit)
+		}
+		for _, f := range page.Files {
+			ids = append(ids, f.ID)
+		}
+		if !page.HasMore {
+			if page.NextCursor != "" {
+				t.Error("last page has a cursor")
+			}
+			return ids
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+func TestListPagesThroughTies(t *testing.T) {
+	conn := openTestDB(t)
+	svc := newTestFileService(t, conn)
+	user := createTestUsers(t, conn, 1)[0]
+
+	// Seven files in three sizes, all named alike and uploaded at the same
+	// instant, so pages often end in the middle of a run of ties
+	sizes := []int{3, 1, 3, 2, 3, 1, 3}
+	ids := make([]uint, len(sizes))
+	sizeOf := map[uint]int{}
+	for i, size := range sizes {
+		content := fmt.Sprintf("%0*d", size, i)
+		uf, err := svc.ProcessFileUpload(user.ID, fmt.Sprintf("f%d", i), "same.txt", strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i], sizeOf[uf.ID] = uf.ID, size
+	}
+	conn.Exec("UPDATE user_files SET uploaded_at = ? WHERE user_id = ?", time.Now(), user.ID)
+
+	for _, tt := range []struct {
+		opts ListOptions
+		key  func(id uint) int
+		desc bool
+	}{
+		{ListOptions{Sort: "size", Order: "asc"}, func(id uint) int { return sizeOf[id] }, false},
+		{ListOptions{Sort: "size", Order: "desc"}, func(id uint) int { return sizeOf[id] }, true},
+		{ListOptions{Sort: "name"}, func(uint) int { return 0 }, false},
+		{ListOptions{Sort: "uploaded_at"}, func(uint) int { return 0 }, true},
+		{ListOptions{Sort: "downloads", Order: "desc"}, func(uint) int { return 0 }, true},
+	} {
+		// Ties are broken by ID in the direction of the sort
+		want := slices.Clone(ids)
+		slices.SortFunc(want, func(a, b uint) int {
+			c := cmp.Or(cmp.Compare(tt.key(a), tt.key(b)), cmp.Compare(a, b))
+			if tt.desc {
+				return -c
+			}
+			return c
+		})
+		for _, limit := range []int{0, 1, 2, 3} {
+			opts := tt.opts
+			opts.Limit = limit
+			if got := listAll(t, svc, user.ID, opts); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("%+v: listed %v, want %v", opts, got, want)
+			}
+		}
+	}
+
+	page, err := svc.ListFilesForFrontend(user.ID, FileFilter{}, ListOptions{Limit: 2, WithTotal: true})
+	if err != nil || page.Total == nil || *page.Total != int64(len(ids)) {
+		t.Errorf("total = %v, %v; want %d on a partial page", page.Total, err, len(ids))
+	}
+}
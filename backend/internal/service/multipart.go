@@ -0,0 +1,290 @@
+package service
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	maxPartNumber   = 10000
+	maxPartSize     = 5 << 30 // 5 GiB, as in S3
+	multipartSweep  = time.Hour
+	expireBatchSize = 100
+)
+
+var (
+	ErrNoSuchUpload      = errors.New("multipart upload not found")
+	ErrInvalidPart       = errors.New("part missing or its ETag does not match")
+	ErrInvalidPartOrder  = errors.New("parts must be listed in ascending order")
+	ErrInvalidPartNumber = errors.New("part number must be between 1 and 10000")
+)
+
+// CompletedPart names a part to assemble, as listed by the client.
+type CompletedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+// MultipartService assembles objects uploaded in parts. Parts are kept in
+// a staging area until the upload is completed, and the assembled object
+// is then stored with PutObject. Uploads left unfinished are discarded
+// after maxAge.
+type MultipartService struct {
+	repo   *repository.MultipartRepository
+	files  *FileService
+	dir    string
+	maxAge time.Duration
+}
+
+func NewMultipartService(repo *repository.MultipartRepository, files *FileService, maxAge time.Duration) *MultipartService {
+	return &MultipartService{
+		repo:   repo,
+		files:  files,
+		dir:    filepath.Join(files.config.UploadDir, ".multipart"),
+		maxAge: maxAge,
+	}
+}
+
+// Create starts an upload to key.
+func (ms *MultipartService) Create(userID uint, key string) (*models.MultipartUpload, error) {
+	if _, isFolder, err := folderKey(key); err != nil {
+		return nil, err
+	} else if !isFolder {
+		if _, _, err := splitKey(key); err != nil {
+			return nil, err
+		}
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	u := &models.MultipartUpload{ID: hex.EncodeToString(b), UserID: userID, Key: key}
+	if err := ms.repo.Create(u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// Start begins a resumable upload of name into folder, for clients of the
+// JSON API. It ends like any other multipart upload, so completing it
+// replaces the file at that path.
+func (ms *MultipartService) Start(userID uint, folder, name string) (*models.MultipartUpload, error) {
+	folder, err := normalizeFolder(folder)
+	if err != nil {
+		return nil, err
+	}
+	if !validName(name) || strings.ContainsAny(name, "/\\") {
+		return nil, ErrInvalidPath
+	}
+	return ms.Create(userID, joinFolder(folder, name))
+}
+
+// Parts returns an upload with the parts stored so far, so a client that
+// was interrupted can tell which parts it still has to send.
+func (ms *MultipartService) Parts(userID uint, uploadID string) (*models.MultipartUpload, []models.MultipartPart, error) {
+	u, err := ms.get(userID, uploadID, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	parts, err := ms.repo.Parts(u.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return u, parts, nil
+}
+
+// get loads one of the user's uploads. S3 requests name the key as well as
+// the upload ID, and like S3 an upload started for another key is treated
+// as unknown; clients of the JSON API name uploads by ID alone and pass "".
+func (ms *MultipartService) get(userID uint, uploadID, key string) (*models.MultipartUpload, error) {
+	u, err := ms.repo.Get(uploadID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || err == nil && key != "" && u.Key != key {
+		return nil, ErrNoSuchUpload
+	}
+	return u, err
+}
+
+func (ms *MultipartService) partPath(uploadID string, partNumber int) string {
+	return filepath.Join(ms.dir, uploadID, strconv.Itoa(partNumber))
+}
+
+// UploadPart stores one part and returns its ETag, the hex MD5 of its
+// content. Uploading a part number again replaces the earlier part.
+func (ms *MultipartService) UploadPart(userID uint, uploadID, key string, partNumber int, src io.Reader) (string, error) {
+	if partNumber < 1 || partNumber > maxPartNumber {
+		return "", ErrInvalidPartNumber
+	}
+	u, err := ms.get(userID, uploadID, key)
+	if err != nil {
+		return "", err
+	}
+	// A part uploaded again replaces the earlier one, so only the other
+	// parts count towards the limits
+	sofar, err := ms.repo.PartsSize(u.ID, partNumber)
+	if err != nil {
+		return "", err
+	}
+
+	limit := int64(maxPartSize)
+	if max := ms.files.config.MaxFileSize; max > 0 && max-sofar < limit {
+		limit = max - sofar
+	}
+
+	tmp, err := ms.files.createStaging(filepath.Join(ms.dir, u.ID), "part-*")
+	if err != nil {
+		return "", err
+	}
+	defer ms.files.removeStaging(tmp.Name())
+
+	h := md5.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(src, limit+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", ErrUploadRead
+	}
+	if size > limit {
+		return "", ErrFileTooLarge
+	}
+	if err := ms.files.CheckStorageQuota(userID, sofar+size); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(tmp.Name(), ms.partPath(u.ID, partNumber)); err != nil {
+		return "", errors.New("failed to store part")
+	}
+	etag := hex.EncodeToString(h.Sum(nil))
+	if err := ms.repo.SavePart(&models.MultipartPart{UploadID: u.ID, PartNumber: partNumber, Size: size, ETag: etag}); err != nil {
+		return "", err
+	}
+	return etag, nil
+}
+
+// Complete joins the listed parts into the object and ends the upload.
+// If storing the object fails, the upload stays open so the client can
+// retry or abort it.
+func (ms *MultipartService) Complete(userID uint, uploadID, key string, parts []CompletedPart) (*Entry, error) {
+	u, err := ms.get(userID, uploadID, key)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := ms.repo.Parts(u.ID)
+	if err != nil {
+		return nil, err
+	}
+	etags := make(map[int]string, len(stored))
+	for _, p := range stored {
+		etags[p.PartNumber] = p.ETag
+	}
+
+	if len(parts) == 0 {
+		return nil, ErrInvalidPart
+	}
+	readers := make([]io.Reader, 0, len(parts))
+	for i, p := range parts {
+		if i > 0 && p.PartNumber <= parts[i-1].PartNumber {
+			return nil, ErrInvalidPartOrder
+		}
+		etag, ok := etags[p.PartNumber]
+		if !ok || strings.Trim(p.ETag, `"`) != etag {
+			return nil, ErrInvalidPart
+		}
+		f, err := os.Open(ms.partPath(u.ID, p.PartNumber))
+		if err != nil {
+			return nil, ErrInvalidPart
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+
+	e, err := ms.files.PutObject(userID, u.Key, io.MultiReader(readers...))
+	if err != nil {
+		return nil, err
+	}
+	if err := ms.discard(u); err != nil {
+		log.Printf("multipart: failed to clean up upload %s: %v", u.ID, err)
+	}
+	return e, nil
+}
+
+// Abort ends an upload and drops its parts.
+func (ms *MultipartService) Abort(userID uint, uploadID, key string) error {
+	u, err := ms.get(userID, uploadID, key)
+	if err != nil {
+		return err
+	}
+	return ms.discard(u)
+}
+
+// AbortAll ends every upload the user has started and returns how many
+// there were.
+func (ms *MultipartService) AbortAll(userID uint) (int, error) {
+	uploads, err := ms.repo.ListByUser(userID)
+	if err != nil {
+		return 0, err
+	}
+	for i := range uploads {
+		if err := ms.discard(&uploads[i]); err != nil {
+			return i, err
+		}
+	}
+	return len(uploads), nil
+}
+
+func (ms *MultipartService) discard(u *models.MultipartUpload) error {
+	if err := ms.repo.Delete(u.ID); err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(ms.dir, u.ID))
+}
+
+// Run discards expired uploads every hour until ctx is cancelled.
+func (ms *MultipartService) Run(ctx context.Context) {
+	ticker := time.NewTicker(multipartSweep)
+	defer ticker.Stop()
+
+	for {
+		if _, err := ms.Expire(); err != nil {
+			log.Printf("multipart: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Expire discards uploads started more than maxAge ago and returns how
+// many there were.
+func (ms *MultipartService) Expire() (int, error) {
+	expired := 0
+	for {
+		uploads, err := ms.repo.ListStartedBefore(time.Now().Add(-ms.maxAge), expireBatchSize)
+		if err != nil || len(uploads) == 0 {
+			return expired, err
+		}
+		for i := range uploads {
+			if err := ms.discard(&uploads[i]); err != nil {
+				return expired, err
+			}
+			expired++
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/repository"
+)
+
+func TestMultipartRejectsOtherKey(t *testing.T) {
+	conn := openTestDB(t)
+	svc := newTestFileService(t, conn)
+	ms := NewMultipartService(repository.NewMultipartRepository(conn), svc, time.Hour)
+	user := createTestUsers(t, conn, 1)[0]
+
+	u, err := ms.Create(user.ID, "docs/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ms.UploadPart(user.ID, u.ID, "docs/b.txt", 1, strings.NewReader("abc")); !errors.Is(err, ErrNoSuchUpload) {
+		t.Errorf("part for another key: %v, want ErrNoSuchUpload", err)
+	}
+	etag, err := ms.UploadPart(user.ID, u.ID, "docs/a.txt", 1, strings.NewReader("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := []CompletedPart{{PartNumber: 1, ETag: etag}}
+
+	if _, err := ms.Complete(user.ID, u.ID, "docs/b.txt", parts); !errors.Is(err, ErrNoSuchUpload) {
+		t.Errorf("complete for another key: %v, want ErrNoSuchUpload", err)
+	}
+	if err := ms.Abort(user.ID, u.ID, "docs/b.txt"); !errors.Is(err, ErrNoSuchUpload) {
+		t.Errorf("abort for another key: %v, want ErrNoSuchUpload", err)
+	}
+	if _, err := svc.Stat(user.ID, "docs/b.txt"); !errors.Is(err, ErrPathNotFound) {
+		t.Errorf("stat docs/b.txt: %v", err)
+	}
+
+	// The upload is untouched and completes under its own key
+	e, err := ms.Complete(user.ID, u.ID, "docs/a.txt", parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Folder != "docs" || e.Name != "a.txt" || e.Size != 3 {
+		t.Errorf("completed %+v", e)
+	}
+}
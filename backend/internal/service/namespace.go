@@ -0,0 +1,446 @@
+package service
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The methods in this file address a user's files by path, folder/name,
+// for protocols such as WebDAV that have no notion of file IDs. Folders
+// exist while they hold files or have been created explicitly. Several
+// files may share a path; the newest one is the one the path refers to.
+
+var (
+	ErrPathNotFound   = errors.New("path not found")
+	ErrPathExists     = errors.New("path already exists")
+	ErrParentNotFound = errors.New("parent folder does not exist")
+)
+
+// Entry is a file or folder found at a path.
+type Entry struct {
+	Name       string
+	Folder     string // folder containing the entry, "" for the root
+	IsDir      bool
+	Size       int64
+	ModTime    time.Time
+	MimeType   string
+	Hash       string
+	UserFileID uint
+	FileID     uint
+
+	storagePath string
+}
+
+// splitPath normalizes a slash-separated path and splits off its last
+// segment. The root is ("", "").
+func splitPath(p string) (string, string, error) {
+	clean, err := normalizeFolder(p)
+	if err != nil {
+		return "", "", err
+	}
+	i := strings.LastIndex(clean, "/")
+	if i < 0 {
+		return "", clean, nil
+	}
+	return clean[:i], clean[i+1:], nil
+}
+
+func fileEntry(row repository.PathRow) Entry {
+	return Entry{
+		Name:        row.FileName,
+		Folder:      row.Folder,
+		Size:        row.Size,
+		ModTime:     row.UploadedAt,
+		MimeType:    row.MimeType,
+		Hash:        row.Hash,
+		UserFileID:  row.UserFileID,
+		FileID:      row.FileID,
+		storagePath: row.StoragePath,
+	}
+}
+
+// folderTime reports whether folder exists and when it last changed.
+func (fs *FileService) folderTime(userID uint, folder string) (time.Time, bool, error) {
+	if folder == "" {
+		return time.Time{}, true, nil
+	}
+	files, err := fs.userFileRepo.LatestInFolder(userID, folder)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	created, err := fs.folderRepo.Latest(userID, folder)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	switch {
+	case files == nil && created == nil:
+		return time.Time{}, false, nil
+	case files == nil:
+		return *created, true, nil
+	case created == nil || files.After(*created):
+		return *files, true, nil
+	}
+	return *created, true, nil
+}
+
+func (fs *FileService) stat(userID uint, folder, name string) (*Entry, error) {
+	if name == "" {
+		return &Entry{IsDir: true}, nil
+	}
+	// A folder hides a file of the same name
+	modTime, ok, err := fs.folderTime(userID, joinFolder(folder, name))
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return &Entry{Name: name, Folder: folder, IsDir: true, ModTime: modTime}, nil
+	}
+
+	rows, err := fs.userFileRepo.FindByPath(userID, folder, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, ErrPathNotFound
+	}
+	e := fileEntry(rows[0])
+	return &e, nil
+}
+
+// Stat describes the file or folder at p.
+func (fs *FileService) Stat(userID uint, p string) (*Entry, error) {
+	folder, name, err := splitPath(p)
+	if err != nil {
+		return nil, err
+	}
+	return fs.stat(userID, folder, name)
+}
+
+// ReadDir lists the folder at p, folders before files, each by name.
+func (fs *FileService) ReadDir(userID uint, p string) ([]Entry, error) {
+	folder, err := normalizeFolder(p)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok, err := fs.folderTime(userID, folder); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrPathNotFound
+	}
+
+	withFiles, err := fs.userFileRepo.ListSubfolders(userID, folder)
+	if err != nil {
+		return nil, err
+	}
+	created, err := fs.folderRepo.Children(userID, folder)
+	if err != nil {
+		return nil, err
+	}
+	dirs := make(map[string]time.Time)
+	for _, sub := range append(withFiles, created...) {
+		if t, ok := dirs[sub.Name]; !ok || sub.ModTime.After(t) {
+			dirs[sub.Name] = sub.ModTime
+		}
+	}
+
+	entries := make([]Entry, 0, len(dirs))
+	for name, modTime := range dirs {
+		entries = append(entries, Entry{Name: name, Folder: folder, IsDir: true, ModTime: modTime})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	rows, err := fs.userFileRepo.ListInFolder(userID, folder)
+	if err != nil {
+		return nil, err
+	}
+	for i, row := range rows {
+		if _, ok := dirs[row.FileName]; ok || (i > 0 && rows[i-1].FileName == row.FileName) {
+			continue
+		}
+		entries = append(entries, fileEntry(row))
+	}
+	return entries, nil
+}
+
+// Open opens the content of the file at p. Folders are described but not
+// opened, so the returned file is nil when the entry is a folder.
+func (fs *FileService) Open(userID uint, p string) (*os.File, *Entry, error) {
+	e, err := fs.Stat(userID, p)
+	if err != nil || e.IsDir {
+		return nil, e, err
+	}
+	f, err := os.Open(e.storagePath)
+	if err != nil {
+		return nil, nil, errors.New("file not found on disk")
+	}
+	return f, e, nil
+}
+
+// MakeFolder creates an empty folder at p inside an existing folder.
+func (fs *FileService) MakeFolder(userID uint, p string) error {
+	folder, name, err := splitPath(p)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return ErrPathExists
+	}
+	if _, ok, err := fs.folderTime(userID, folder); err != nil {
+		return err
+	} else if !ok {
+		return ErrParentNotFound
+	}
+	if _, err := fs.stat(userID, folder, name); err == nil {
+		return ErrPathExists
+	} else if !errors.Is(err, ErrPathNotFound) {
+		return err
+	}
+
+	if err := fs.folderRepo.Create(userID, joinFolder(folder, name)); err != nil {
+		if errors.Is(err, repository.ErrFolderExists) {
+			return ErrPathExists
+		}
+		return err
+	}
+	return nil
+}
+
+// PutFile stores src at p, replacing whatever file was there. The content
+// is deduplicated and counted against the quota like any other upload, but
+// unlike ProcessFileUpload it may be content the user already stores at
+// another path, so copying a file or writing a tree with two identical files
+// works. The replaced file is deleted only once the new one is in place.
+// Putting back the content a path already holds changes nothing.
+func (fs *FileService) PutFile(userID uint, p string, src io.Reader) (*models.UserFile, error) {
+	folder, name, err := splitPath(p)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, ErrPathExists
+	}
+	if _, ok, err := fs.folderTime(userID, folder); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrParentNotFound
+	}
+	if _, ok, err := fs.folderTime(userID, joinFolder(folder, name)); err != nil {
+		return nil, err
+	} else if ok {
+		return nil, ErrPathExists
+	}
+	return fs.putFile(userID, folder, name, src)
+}
+
+// putFile stores src as folder/name, then deletes the files that held the
+// path before.
+func (fs *FileService) putFile(userID uint, folder, name string, src io.Reader) (*models.UserFile, error) {
+	replaced, err := fs.userFileRepo.FindByPath(userID, folder, name)
+	if err != nil {
+		return nil, err
+	}
+
+	uf, err := fs.upload(userID, folder, name, src, &pathWrite{current: replaced})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range replaced {
+		if row.UserFileID == uf.ID {
+			continue
+		}
+		if err := fs.DeleteFile(row.UserFileID, userID); err != nil {
+			// The new file is newer, so the path already refers to it
+			log.Printf("put %q: failed to delete replaced file %d: %v", joinFolder(folder, name), row.UserFileID, err)
+		}
+	}
+	return uf, nil
+}
+
+// RemovePath deletes the file at p, or the folder at p with everything in
+// it. Each file is deleted with DeleteFile.
+func (fs *FileService) RemovePath(userID uint, p string) error {
+	folder, name, err := splitPath(p)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return ErrInvalidPath
+	}
+	e, err := fs.stat(userID, folder, name)
+	if err != nil {
+		return err
+	}
+
+	if !e.IsDir {
+		rows, err := fs.userFileRepo.FindByPath(userID, folder, name)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err := fs.DeleteFile(row.UserFileID, userID); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	full := joinFolder(folder, name)
+	rows, err := fs.userFileRepo.GetArchiveRowsInFolder(userID, full)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := fs.DeleteFile(row.UserFileID, userID); err != nil {
+			return err
+		}
+	}
+	return fs.folderRepo.DeleteTree(userID, full)
+}
+
+// MovePath moves the file or folder at from to the free path to, inside an
+// existing folder. Moved files keep their content and sharing, and each
+// one emits file.renamed. All files sharing the source path move together,
+// and a folder is not moved if anything in it would end up deeper than
+// maxFolderDepth.
+func (fs *FileService) MovePath(userID uint, from, to string) error {
+	srcFolder, srcName, err := splitPath(from)
+	if err != nil {
+		return err
+	}
+	dstFolder, dstName, err := splitPath(to)
+	if err != nil {
+		return err
+	}
+	if srcName == "" || dstName == "" {
+		return ErrInvalidPath
+	}
+
+	src, err := fs.stat(userID, srcFolder, srcName)
+	if err != nil {
+		return err
+	}
+	if _, ok, err := fs.folderTime(userID, dstFolder); err != nil {
+		return err
+	} else if !ok {
+		return ErrParentNotFound
+	}
+	if _, err := fs.stat(userID, dstFolder, dstName); err == nil {
+		return ErrPathExists
+	} else if !errors.Is(err, ErrPathNotFound) {
+		return err
+	}
+
+	if !src.IsDir {
+		// Every file at the path moves, as RemovePath deletes every one
+		err = fs.txm.Do(func(r repository.Repos) error {
+			rows, err := r.UserFiles.FindByPath(userID, srcFolder, srcName)
+			if err != nil {
+				return err
+			}
+			ufs := make([]models.UserFile, 0, len(rows))
+			for _, row := range rows {
+				uf, err := r.UserFiles.GetUserFileByID(row.UserFileID, userID)
+				if err != nil {
+					return err
+				}
+				ufs = append(ufs, *uf)
+			}
+			return moveFiles(r, userID, ufs, func(*models.UserFile) (string, string) {
+				return dstFolder, dstName
+			})
+		})
+		if err != nil {
+			return err
+		}
+		fs.eventsQueued()
+		return nil
+	}
+
+	srcPath, dstPath := joinFolder(srcFolder, srcName), joinFolder(dstFolder, dstName)
+	if strings.HasPrefix(dstPath+"/", srcPath+"/") {
+		// A folder cannot be moved into itself
+		return ErrInvalidPath
+	}
+	err = fs.txm.Do(func(r repository.Repos) error {
+		ufs, err := r.UserFiles.GetInFolderTree(userID, srcPath)
+		if err != nil {
+			return err
+		}
+		folders, err := r.Folders.PathsInTree(userID, srcPath)
+		if err != nil {
+			return err
+		}
+		// Nothing in the moved tree may end up deeper than a folder can be
+		// created
+		extra := folderDepth(dstPath) - folderDepth(srcPath)
+		for _, uf := range ufs {
+			folders = append(folders, uf.Folder)
+		}
+		for _, f := range folders {
+			if folderDepth(f)+extra > maxFolderDepth {
+				return ErrInvalidPath
+			}
+		}
+
+		err = moveFiles(r, userID, ufs, func(uf *models.UserFile) (string, string) {
+			return dstPath + strings.TrimPrefix(uf.Folder, srcPath), uf.FileName
+		})
+		if err != nil {
+			return err
+		}
+		return r.Folders.MoveTree(userID, srcPath, dstPath)
+	})
+	if err != nil {
+		return err
+	}
+
+	fs.eventsQueued()
+	return nil
+}
+
+// moveFiles renames each of ufs to the folder and name dst gives it and
+// emits file.renamed for each.
+func moveFiles(r repository.Repos, userID uint, ufs []models.UserFile, dst func(*models.UserFile) (string, string)) error {
+	var moved [][2]string
+	for i := range ufs {
+		uf := &ufs[i]
+		data := RenameEventData{OldFilename: uf.FileName, OldFolder: uf.Folder}
+		folder, name := dst(uf)
+		if err := r.UserFiles.Rename(uf, folder, name); err != nil {
+			return err
+		}
+		file, err := r.Files.GetFileByID(uf.FileID)
+		if err != nil {
+			return err
+		}
+		data.FileEventData = fileEventData(uf, file)
+		if err := emit(r, models.EventFileRenamed, userID, data); err != nil {
+			return err
+		}
+		moved = append(moved, [2]string{data.OldFolder, data.OldFilename}, [2]string{uf.Folder, uf.FileName})
+	}
+	// Once everything has moved, so duplicates at a path don't show up
+	// as passing modifications
+	for _, p := range moved {
+		if err := trackPath(r, userID, p[0], p[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// folderDepth counts the segments of a normalized folder path.
+func folderDepth(folder string) int {
+	if folder == "" {
+		return 0
+	}
+	return strings.Count(folder, "/") + 1
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMovePathMovesEveryFileAtThePath(t *testing.T) {
+	conn := openTestDB(t)
+	svc := newTestFileService(t, conn)
+	user := createTestUsers(t, conn, 1)[0]
+
+	// Uploads never replace, so two files can share a path
+	for _, content := range []string{"first", "second"} {
+		if _, err := svc.ProcessFileUpload(user.ID, "", "a.txt", strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := svc.MovePath(user.ID, "/a.txt", "/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	conn.Raw("SELECT folder || '/' || file_name FROM user_files WHERE user_id = ?", user.ID).Scan(&paths)
+	if len(paths) != 2 || paths[0] != "/b.txt" || paths[1] != "/b.txt" {
+		t.Errorf("files at %v, want both at /b.txt", paths)
+	}
+	if _, err := svc.Stat(user.ID, "/a.txt"); !errors.Is(err, ErrPathNotFound) {
+		t.Errorf("stat /a.txt after move: %v", err)
+	}
+}
+
+func TestMovePathKeepsFolderDepth(t *testing.T) {
+	conn := openTestDB(t)
+	svc := newTestFileService(t, conn)
+	user := createTestUsers(t, conn, 1)[0]
+
+	// /deep/x/x/... is as deep as a folder can be
+	deep := "deep" + strings.Repeat("/x", maxFolderDepth-1)
+	if err := svc.MakeFolder(user.ID, "/deep"); err != nil {
+		t.Fatal(err)
+	}
+	for p := "deep/x"; len(p) <= len(deep); p += "/x" {
+		if err := svc.MakeFolder(user.ID, "/"+p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := svc.ProcessFileUpload(user.ID, deep, "a.txt", strings.NewReader("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.MakeFolder(user.ID, "/other"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.MovePath(user.ID, "/deep", "/other/deep"); !errors.Is(err, ErrInvalidPath) {
+		t.Fatalf("moving the tree one level down: %v, want ErrInvalidPath", err)
+	}
+	if _, err := svc.Stat(user.ID, "/"+deep+"/a.txt"); err != nil {
+		t.Errorf("file left behind after a refused move: %v", err)
+	}
+
+	// Same depth is fine
+	if err := svc.MovePath(user.ID, "/deep", "/shallow"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Stat(user.ID, "/shallow"+strings.TrimPrefix(deep, "deep")+"/a.txt"); err != nil {
+		t.Errorf("stat after move: %v", err)
+	}
+}
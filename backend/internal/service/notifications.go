@@ -0,0 +1,55 @@
+package service
+
+import (
+	"backend/internal/repository"
+)
+
+// maxEventBacklog bounds how many missed events a reconnecting client is
+// sent; beyond that it should reload its file list.
+const maxEventBacklog = 500
+
+// NotificationService streams a user's events to connected clients.
+type NotificationService struct {
+	broker Broker
+	outbox *repository.OutboxRepository
+}
+
+func NewNotificationService(broker Broker, outbox *repository.OutboxRepository) *NotificationService {
+	return &NotificationService{broker: broker, outbox: outbox}
+}
+
+// EventStream is a subscription: Backlog holds events missed since the
+// client's last event ID, then Live carries new ones. Close must be called
+// when the client goes away.
+type EventStream struct {
+	Backlog []EventEnvelope
+	Live    <-chan EventEnvelope
+	Close   func()
+	// Truncated is set when more events were missed than fit the backlog.
+	Truncated bool
+}
+
+// Subscribe starts streaming the user's events. Live events that were also
+// in the backlog are the caller's to skip, by ID.
+func (ns *NotificationService) Subscribe(userID, lastEventID uint) (*EventStream, error) {
+	// Subscribe before reading the backlog so nothing falls in between
+	live, cancel := ns.broker.Subscribe(userID)
+	stream := &EventStream{Live: live, Close: cancel}
+	if lastEventID == 0 {
+		return stream, nil
+	}
+
+	missed, err := ns.outbox.ListDispatchedForUser(userID, lastEventID, maxEventBacklog+1)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if len(missed) > maxEventBacklog {
+		missed = missed[:maxEventBacklog]
+		stream.Truncated = true
+	}
+	for _, ev := range missed {
+		stream.Backlog = append(stream.Backlog, envelope(ev))
+	}
+	return stream, nil
+}
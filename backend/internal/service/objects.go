@@ -0,0 +1,198 @@
+package service
+
+import (
+	"backend/internal/repository"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// The methods in this file present a user's files as an object store, for
+// the S3 API. A key is a file's folder and name joined with "/". Folders
+// have no keys of their own, except that a key ending in "/" names an
+// explicitly created folder, the way S3 tools mark empty directories.
+
+const (
+	defaultMaxKeys = 1000
+	listBatchSize  = 1000
+)
+
+// ObjectQuery selects keys to list, as in S3's ListObjects.
+type ObjectQuery struct {
+	Prefix    string
+	Delimiter string
+	After     string // list keys sorting after this one
+	MaxKeys   int
+}
+
+// ObjectListing is one page of keys. Keys that contain Delimiter after
+// Prefix are rolled up into CommonPrefixes; NextAfter continues the listing
+// when IsTruncated is set.
+type ObjectListing struct {
+	Objects        []repository.ObjectRow
+	CommonPrefixes []string
+	IsTruncated    bool
+	NextAfter      string
+}
+
+// splitKey turns a key naming a file into its folder and name. Keys are
+// normalized like any other path, so "a//b" and "a/./b" name "a/b".
+func splitKey(key string) (string, string, error) {
+	folder, name, err := splitPath(key)
+	if err == nil && name == "" {
+		err = ErrInvalidPath
+	}
+	return folder, name, err
+}
+
+// folderKey reports whether key marks a folder, and its normalized path.
+func folderKey(key string) (string, bool, error) {
+	if !strings.HasSuffix(key, "/") {
+		return "", false, nil
+	}
+	path, err := normalizeFolder(key)
+	if err == nil && path == "" {
+		err = ErrInvalidPath
+	}
+	return path, true, err
+}
+
+// HeadObject describes the object at key.
+func (fs *FileService) HeadObject(userID uint, key string) (*Entry, error) {
+	if path, ok, err := folderKey(key); err != nil {
+		return nil, err
+	} else if ok {
+		f, err := fs.folderRepo.Get(userID, path)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPathNotFound
+		} else if err != nil {
+			return nil, err
+		}
+		return &Entry{Name: path, IsDir: true, ModTime: f.CreatedAt}, nil
+	}
+
+	folder, name, err := splitKey(key)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := fs.userFileRepo.FindByPath(userID, folder, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, ErrPathNotFound
+	}
+	e := fileEntry(rows[0])
+	return &e, nil
+}
+
+// OpenObject opens the content of the object at key. Folder markers have
+// no content, so the returned file is nil for them.
+func (fs *FileService) OpenObject(userID uint, key string) (*os.File, *Entry, error) {
+	e, err := fs.HeadObject(userID, key)
+	if err != nil || e.IsDir {
+		return nil, e, err
+	}
+	f, err := os.Open(e.storagePath)
+	if err != nil {
+		return nil, nil, errors.New("file not found on disk")
+	}
+	return f, e, nil
+}
+
+// PutObject stores src at key, replacing the object there. Unlike PutFile,
+// missing folders are created implicitly. A key ending in "/" creates an
+// empty folder and src is ignored.
+func (fs *FileService) PutObject(userID uint, key string, src io.Reader) (*Entry, error) {
+	if path, ok, err := folderKey(key); err != nil {
+		return nil, err
+	} else if ok {
+		if err := fs.folderRepo.Create(userID, path); err != nil && !errors.Is(err, repository.ErrFolderExists) {
+			return nil, err
+		}
+		return fs.HeadObject(userID, key)
+	}
+
+	folder, name, err := splitKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fs.putFile(userID, folder, name, src); err != nil {
+		return nil, err
+	}
+	return fs.HeadObject(userID, key)
+}
+
+// DeleteObject deletes the object at key. Deleting a key that does not
+// exist succeeds, as in S3, and deleting a folder marker leaves the files
+// inside the folder alone.
+func (fs *FileService) DeleteObject(userID uint, key string) error {
+	if path, ok, err := folderKey(key); err != nil {
+		return err
+	} else if ok {
+		return fs.folderRepo.Delete(userID, path)
+	}
+
+	folder, name, err := splitKey(key)
+	if err != nil {
+		return err
+	}
+	rows, err := fs.userFileRepo.FindByPath(userID, folder, name)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := fs.DeleteFile(row.UserFileID, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListObjects returns one page of the user's keys in byte order.
+func (fs *FileService) ListObjects(userID uint, q ObjectQuery) (*ObjectListing, error) {
+	if q.MaxKeys <= 0 || q.MaxKeys > defaultMaxKeys {
+		q.MaxKeys = defaultMaxKeys
+	}
+	out := &ObjectListing{}
+	after := q.After
+	if after < q.Prefix {
+		// Nothing before the prefix can match
+		after = ""
+	}
+
+	for {
+		rows, err := fs.userFileRepo.ListObjectKeys(userID, q.Prefix, after, listBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == 0 {
+			return out, nil
+		}
+
+		for _, row := range rows {
+			if len(out.Objects)+len(out.CommonPrefixes) == q.MaxKeys {
+				out.IsTruncated = true
+				out.NextAfter = after
+				return out, nil
+			}
+
+			if q.Delimiter != "" {
+				if i := strings.Index(row.Key[len(q.Prefix):], q.Delimiter); i >= 0 {
+					// Roll the key up and skip past everything else under
+					// the same prefix
+					prefix := row.Key[:len(q.Prefix)+i+len(q.Delimiter)]
+					out.CommonPrefixes = append(out.CommonPrefixes, prefix)
+					after = prefix + string(utf8.MaxRune)
+					break
+				}
+			}
+			out.Objects = append(out.Objects, row)
+			after = row.Key
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxNameLen     = 255
+	maxFolderDepth = 32
+)
+
+// normalizeFolder turns a user-supplied folder into the canonical
+// slash-separated form stored in user_files.folder ("" for the root).
+// Absolute paths are treated as relative to the user's root; any ".."
+// segment is rejected rather than resolved.
+func normalizeFolder(folder string) (string, error) {
+	folder = strings.ReplaceAll(folder, "\\", "/")
+	folder = strings.Trim(folder, "/")
+	if folder == "" {
+		return "", nil
+	}
+
+	segments := strings.Split(folder, "/")
+	if len(segments) > maxFolderDepth {
+		return "", ErrInvalidPath
+	}
+	kept := segments[:0]
+	for _, seg := range segments {
+		switch seg {
+		case "", ".":
+			continue
+		case "..":
+			return "", ErrInvalidPath
+		}
+		if !validName(seg) {
+			return "", ErrInvalidPath
+		}
+		kept = append(kept, seg)
+	}
+	return strings.Join(kept, "/"), nil
+}
+
+// cleanFilename strips any directory components a client may have sent
+// along with the name and rejects names that cannot be stored.
+func cleanFilename(name string) (string, error) {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" || name == ".." || !validName(name) {
+		return "", ErrInvalidPath
+	}
+	return name, nil
+}
+
+// splitEntryPath splits an archive entry name, extracted into base, into
+// folder and filename. It rejects absolute paths, traversal outside base and
+// folders nested deeper than maxFolderDepth once joined onto base.
+func splitEntryPath(base, name string) (string, string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || path.IsAbs(name) || (len(name) > 1 && name[1] == ':') {
+		return "", "", ErrInvalidPath
+	}
+	dir, file := path.Split(name)
+	folder, err := normalizeFolder(dir)
+	if err != nil {
+		return "", "", err
+	}
+	folder, err = normalizeFolder(joinFolder(base, folder))
+	if err != nil {
+		return "", "", err
+	}
+	file, err = cleanFilename(file)
+	if err != nil {
+		return "", "", err
+	}
+	return folder, file, nil
+}
+
+// joinFolder appends a relative folder to a base folder, both normalized.
+func joinFolder(base, rel string) string {
+	switch {
+	case base == "":
+		return rel
+	case rel == "":
+		return base
+	}
+	return base + "/" + rel
+}
+
+func validName(name string) bool {
+	if name == "" || len(name) > maxNameLen || !utf8.ValidString(name) {
+		return false
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	eventChannel       = "vault_events"
+	listenRetryBackoff = 5 * time.Second
+)
+
+// PostgresBroker publishes with NOTIFY and delivers what it hears on a
+// dedicated LISTEN connection to local subscribers, so a user connected to
+// any instance gets events dispatched by any other.
+type PostgresBroker struct {
+	db    *gorm.DB
+	dsn   string
+	local *MemoryBroker
+}
+
+func NewPostgresBroker(db *gorm.DB, dsn string) *PostgresBroker {
+	return &PostgresBroker{db: db, dsn: dsn, local: NewMemoryBroker()}
+}
+
+func (b *PostgresBroker) Publish(ev EventEnvelope) error {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return b.db.Exec("SELECT pg_notify(?, ?)", eventChannel, string(payload)).Error
+}
+
+func (b *PostgresBroker) Subscribe(userID uint) (<-chan EventEnvelope, func()) {
+	return b.local.Subscribe(userID)
+}
+
+// Run listens for notifications until ctx is cancelled, reconnecting after
+// failures. Events sent while disconnected are missed, so subscribers are
+// cut off when the connection is lost and recover them by reconnecting
+// with Last-Event-ID.
+func (b *PostgresBroker) Run(ctx context.Context) {
+	for {
+		if err := b.listen(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("event broker: %v", err)
+		}
+		b.local.dropAll()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(listenRetryBackoff):
+		}
+	}
+}
+
+func (b *PostgresBroker) listen(ctx context.Context) error {
+	conn, err := pgx.Connect(ctx, b.dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+eventChannel); err != nil {
+		return err
+	}
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		var ev EventEnvelope
+		if err := json.Unmarshal([]byte(n.Payload), &ev); err != nil {
+			continue
+		}
+		b.local.Publish(ev)
+	}
+}
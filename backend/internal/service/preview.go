@@ -0,0 +1,307 @@
+package service
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	_ "image/gif"
+	_ "image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	maxPreviewPixels = 50_000_000 // refuse to decode anything larger
+	snippetReadLimit = 8 << 10
+	snippetMaxLines  = 20
+)
+
+var (
+	ErrPreviewNotReady = errors.New("preview not ready")
+	ErrPreviewSize     = errors.New("unknown preview size")
+)
+
+// previewSizes are the thumbnail variants generated for every image,
+// bounded by their longest edge. Images are never upscaled.
+var previewSizes = []struct {
+	Name   string
+	MaxDim int
+}{
+	{"small", 128},
+	{"medium", 512},
+	{"large", 1024},
+}
+
+// PreviewService generates thumbnails and text snippets in the background
+// and serves them. Previews are keyed by content hash, so every user file
+// pointing at the same blob shares them.
+type PreviewService struct {
+	repo         *repository.PreviewRepository
+	fileRepo     *repository.FileRepository
+	userFileRepo *repository.UserFileRepository
+	dir          string
+	queue        *hashQueue
+}
+
+func NewPreviewService(
+	repo *repository.PreviewRepository,
+	fileRepo *repository.FileRepository,
+	userFileRepo *repository.UserFileRepository,
+	dir string,
+) *PreviewService {
+	ps := &PreviewService{
+		repo:         repo,
+		fileRepo:     fileRepo,
+		userFileRepo: userFileRepo,
+		dir:          dir,
+	}
+	ps.queue = newHashQueue("preview", repo.ListPending, ps.process)
+	return ps
+}
+
+// Enqueue schedules preview generation for a newly stored blob. The pending
+// row makes the request durable; if the in-memory queue is full, the next
+// rescan picks it up.
+func (ps *PreviewService) Enqueue(file *models.File) {
+	if err := ps.repo.MarkPending(file.Hash); err != nil {
+		log.Printf("preview: failed to queue %s: %v", file.Hash, err)
+		return
+	}
+	ps.queue.push(file.Hash)
+}
+
+// Run generates previews in the background until ctx is cancelled.
+func (ps *PreviewService) Run(ctx context.Context, workers int) {
+	ps.queue.run(ctx, workers)
+}
+
+func (ps *PreviewService) process(hash string) {
+	preview, err := ps.repo.Get(hash)
+	if err != nil || preview.Status != models.PreviewPending {
+		return
+	}
+	file, err := ps.fileRepo.GetFileByHash(hash)
+	if err != nil {
+		// Content was deleted before we got to it
+		ps.repo.Delete(hash)
+		return
+	}
+
+	if err := ps.generate(file, preview); err != nil {
+		msg := err.Error()
+		preview.Status = models.PreviewFailed
+		preview.Error = &msg
+	}
+	if err := ps.repo.Save(preview); err != nil {
+		log.Printf("preview: failed to save %s: %v", hash, err)
+	}
+}
+
+func (ps *PreviewService) generate(file *models.File, preview *models.FilePreview) error {
+	switch {
+	case isPreviewableImage(file.MimeType):
+		sizes, err := ps.generateThumbnails(file)
+		if err != nil {
+			return err
+		}
+		preview.Kind = models.PreviewKindImage
+		preview.Sizes = strings.Join(sizes, ",")
+	case isPreviewableText(file.MimeType, file.Filename):
+		snippet, err := textSnippet(file.StoragePath)
+		if err != nil {
+			return err
+		}
+		preview.Kind = models.PreviewKindText
+		preview.Snippet = &snippet
+	default:
+		preview.Status = models.PreviewUnsupported
+		return nil
+	}
+	preview.Status = models.PreviewReady
+	return nil
+}
+
+func (ps *PreviewService) generateThumbnails(file *models.File) ([]string, error) {
+	src, err := os.Open(file.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	// Check dimensions first so a tiny file can't claim a huge canvas
+	cfg, _, err := image.DecodeConfig(src)
+	if err != nil {
+		return nil, fmt.Errorf("decode image header: %w", err)
+	}
+	if cfg.Width*cfg.Height > maxPreviewPixels {
+		return nil, errors.New("image too large to preview")
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(src)
+	if err != nil {
+		return nil, fmt.Errorf("decode image: %w", err)
+	}
+
+	dir := filepath.Join(ps.dir, file.Hash)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	var sizes []string
+	for _, size := range previewSizes {
+		if err := writeThumbnail(img, size.MaxDim, filepath.Join(dir, size.Name+".jpg")); err != nil {
+			return nil, err
+		}
+		sizes = append(sizes, size.Name)
+	}
+	return sizes, nil
+}
+
+// writeThumbnail scales img to fit within maxDim and writes it as JPEG,
+// flattening any transparency onto white.
+func writeThumbnail(img image.Image, maxDim int, dst string) error {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > maxDim || h > maxDim {
+		if w >= h {
+			w, h = maxDim, max(1, h*maxDim/b.Dx())
+		} else {
+			w, h = max(1, w*maxDim/b.Dy()), maxDim
+		}
+	}
+
+	thumb := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(thumb, thumb.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(thumb, thumb.Bounds(), img, b, draw.Over, nil)
+
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := jpeg.Encode(out, thumb, &jpeg.Options{Quality: 80}); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// textSnippet returns the first lines of a text file, cut on a valid
+// UTF-8 boundary.
+func textSnippet(path string) (string, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	buf, err := io.ReadAll(io.LimitReader(src, snippetReadLimit))
+	if err != nil {
+		return "", err
+	}
+	for len(buf) > 0 && !utf8.Valid(buf) {
+		buf = buf[:len(buf)-1]
+	}
+
+	lines := strings.SplitN(string(buf), "\n", snippetMaxLines+1)
+	if len(lines) > snippetMaxLines {
+		lines = lines[:snippetMaxLines]
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\r\n"), nil
+}
+
+func isPreviewableImage(mimeType string) bool {
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		return true
+	}
+	return false
+}
+
+// isPreviewableText covers plain text, markdown and CSV. Content sniffing
+// reports all three as text/plain, so the extension tells them apart.
+func isPreviewableText(mimeType, filename string) bool {
+	if strings.HasPrefix(mimeType, "text/plain") ||
+		strings.HasPrefix(mimeType, "text/markdown") ||
+		strings.HasPrefix(mimeType, "text/csv") {
+		return true
+	}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".txt", ".md", ".markdown", ".csv":
+		return strings.HasPrefix(mimeType, "text/")
+	}
+	return false
+}
+
+// Purge removes cached previews for a blob that no longer exists. It is
+// registered as a BlobDeleter hook.
+func (ps *PreviewService) Purge(r repository.Repos, hash string) error {
+	if err := os.RemoveAll(filepath.Join(ps.dir, hash)); err != nil {
+		return err
+	}
+	return r.Previews.Delete(hash)
+}
+
+// GetPreview returns preview metadata for a user file the caller can access.
+func (ps *PreviewService) GetPreview(userID, userfileID uint) (*models.FilePreview, error) {
+	file, err := ps.accessibleFile(userID, userfileID)
+	if err != nil {
+		return nil, err
+	}
+	preview, err := ps.repo.Get(file.Hash)
+	if err != nil {
+		// Content uploaded before previews existed; generate on demand
+		ps.Enqueue(file)
+		return &models.FilePreview{Hash: file.Hash, Status: models.PreviewPending}, nil
+	}
+	return preview, nil
+}
+
+// ThumbnailPath returns the on-disk thumbnail for a user file and size name.
+func (ps *PreviewService) ThumbnailPath(userID, userfileID uint, size string) (string, error) {
+	preview, err := ps.GetPreview(userID, userfileID)
+	if err != nil {
+		return "", err
+	}
+	if preview.Status != models.PreviewReady || preview.Kind != models.PreviewKindImage {
+		return "", ErrPreviewNotReady
+	}
+	for _, s := range strings.Split(preview.Sizes, ",") {
+		if s == size {
+			return filepath.Join(ps.dir, preview.Hash, size+".jpg"), nil
+		}
+	}
+	return "", ErrPreviewSize
+}
+
+func (ps *PreviewService) accessibleFile(userID, userfileID uint) (*models.File, error) {
+	uf, err := ps.userFileRepo.GetUserFileByID(userfileID, userID)
+	if err != nil {
+		return nil, errors.New("file not found")
+	}
+	file, err := ps.fileRepo.GetFileByID(uf.FileID)
+	if err != nil {
+		return nil, errors.New("file not found")
+	}
+	return file, nil
+}
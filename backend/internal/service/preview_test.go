@@ -0,0 +1,285 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"backend/internal/models"
+	"backend/internal/repository"
+
+	"gorm.io/gorm"
+)
+
+func decodeJPEG(t *testing.T, path string) image.Image {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := jpeg.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestWriteThumbnail(t *testing.T) {
+	tests := []struct {
+		w, h, maxDim int
+		wantW, wantH int
+	}{
+		{1000, 500, 128, 128, 64},
+		{500, 1000, 128, 64, 128},
+		{1000, 1, 128, 128, 1},  // never collapses to zero
+		{100, 50, 512, 100, 50}, // never upscaled
+	}
+	for _, tt := range tests {
+		// Fully transparent, so the thumbnail shows the white backdrop
+		src := image.NewNRGBA(image.Rect(0, 0, tt.w, tt.h))
+		dst := filepath.Join(t.TempDir(), "thumb.jpg")
+		if err := writeThumbnail(src, tt.maxDim, dst); err != nil {
+			t.Fatal(err)
+		}
+		img := decodeJPEG(t, dst)
+		if b := img.Bounds(); b.Dx() != tt.wantW || b.Dy() != tt.wantH {
+			t.Errorf("%dx%d within %d: got %dx%d, want %dx%d",
+				tt.w, tt.h, tt.maxDim, b.Dx(), b.Dy(), tt.wantW, tt.wantH)
+		}
+		if r, g, b, _ := img.At(0, 0).RGBA(); r>>8 < 250 || g>>8 < 250 || b>>8 < 250 {
+			t.Errorf("%dx%d: transparent pixel rendered as %v, want white", tt.w, tt.h, img.At(0, 0))
+		}
+		if _, err := os.Stat(dst + ".tmp"); !os.IsNotExist(err) {
+			t.Errorf("temporary file left behind")
+		}
+	}
+}
+
+func TestTextSnippet(t *testing.T) {
+	write := func(content string) string {
+		p := filepath.Join(t.TempDir(), "doc.txt")
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	var lines []string
+	for i := range 30 {
+		lines = append(lines, strings.Repeat("x", i))
+	}
+	got, err := textSnippet(write(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Join(lines[:snippetMaxLines], "\n"); got != want {
+		t.Errorf("snippet has %d lines, want the first %d", strings.Count(got, "\n")+1, snippetMaxLines)
+	}
+
+	got, err = textSnippet(write("short\r\n\n"))
+	if err != nil || got != "short" {
+		t.Errorf("snippet = %q, %v; want trailing line breaks trimmed", got, err)
+	}
+
+	// A two-byte character straddling the read limit is dropped whole
+	long := strings.Repeat("a", snippetReadLimit-1) + "é"
+	got, err = textSnippet(write(long))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != strings.Repeat("a", snippetReadLimit-1) {
+		t.Errorf("snippet of %d bytes ends in %q, want it cut before the split character", len(got), got[len(got)-3:])
+	}
+}
+
+func TestIsPreviewable(t *testing.T) {
+	for _, tt := range []struct {
+		mime, name  string
+		image, text bool
+	}{
+		{"image/png", "a.png", true, false},
+		{"image/webp", "a.webp", true, false},
+		{"image/svg+xml", "a.svg", false, false},
+		{"text/plain; charset=utf-8", "notes", false, true},
+		{"text/markdown", "README", false, true},
+		{"text/html; charset=utf-8", "notes.md", false, true}, // sniffed as HTML, named as markdown
+		{"text/html; charset=utf-8", "page.html", false, false},
+		{"application/octet-stream", "data.csv", false, false},
+	} {
+		if got := isPreviewableImage(tt.mime); got != tt.image {
+			t.Errorf("isPreviewableImage(%q) = %v", tt.mime, got)
+		}
+		if got := isPreviewableText(tt.mime, tt.name); got != tt.text {
+			t.Errorf("isPreviewableText(%q, %q) = %v", tt.mime, tt.name, got)
+		}
+	}
+}
+
+func pngContent(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := range w {
+		for y := range h {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 0x80, 0xff})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// pngHeader returns a PNG that declares a w by h canvas but holds no pixels.
+func pngHeader(w, h uint32) []byte {
+	ihdr := binary.BigEndian.AppendUint32([]byte("IHDR"), w)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, h)
+	ihdr = append(ihdr, 8, 6, 0, 0, 0)
+	b := []byte("\x89PNG\r\n\x1a\n")
+	b = binary.BigEndian.AppendUint32(b, uint32(len(ihdr)-4))
+	b = append(b, ihdr...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(ihdr))
+}
+
+func newTestPreviewService(t *testing.T, conn *gorm.DB, svc *FileService) *PreviewService {
+	t.Helper()
+	ps := NewPreviewService(
+		repository.NewPreviewRepository(conn),
+		repository.NewFileRepository(conn),
+		repository.NewUserFileRepository(conn),
+		filepath.Join(svc.config.UploadDir, ".previews"),
+	)
+	svc.OnNewBlob(ps.Enqueue)
+	svc.deleter.OnBlobRemoved(ps.Purge)
+	return ps
+}
+
+func fileHash(t *testing.T, conn *gorm.DB, fileID uint) string {
+	t.Helper()
+	var file models.File
+	if err := conn.First(&file, fileID).Error; err != nil {
+		t.Fatal(err)
+	}
+	return file.Hash
+}
+
+// generatePreview uploads content and generates its preview in place of the
+// background workers.
+func generatePreview(t *testing.T, conn *gorm.DB, svc *FileService, ps *PreviewService, userID uint, name string, content []byte) *models.UserFile {
+	t.Helper()
+	uf, err := svc.ProcessFileUpload(userID, "", name, bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps.process(fileHash(t, conn, uf.FileID))
+	return uf
+}
+
+func TestPreviewSharedByIdenticalFiles(t *testing.T) {
+	conn := openTestDB(t)
+	svc := newTestFileService(t, conn)
+	ps := newTestPreviewService(t, conn, svc)
+	users := createTestUsers(t, conn, 2)
+	content := pngContent(t, 600, 300)
+
+	first := generatePreview(t, conn, svc, ps, users[0].ID, "a.png", content)
+	second, err := svc.ProcessFileUpload(users[1].ID, "", "b.png", bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ref := range []struct{ user, file uint }{{users[0].ID, first.ID}, {users[1].ID, second.ID}} {
+		p, err := ps.GetPreview(ref.user, ref.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Status != models.PreviewReady || p.Kind != models.PreviewKindImage || p.Sizes != "small,medium,large" {
+			t.Fatalf("preview = %+v", p)
+		}
+		thumb, err := ps.ThumbnailPath(ref.user, ref.file, "medium")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := decodeJPEG(t, thumb).Bounds(); b.Dx() != 512 || b.Dy() != 256 {
+			t.Errorf("medium thumbnail is %dx%d, want 512x256", b.Dx(), b.Dy())
+		}
+	}
+	if _, err := ps.ThumbnailPath(users[0].ID, first.ID, "huge"); err != ErrPreviewSize {
+		t.Errorf("unknown size: err %v, want ErrPreviewSize", err)
+	}
+	if _, err := ps.GetPreview(users[1].ID, first.ID); err == nil {
+		t.Error("preview of another user's file was served")
+	}
+
+	// Removing the last reference purges the thumbnails with the blob
+	dir := filepath.Join(ps.dir, fileHash(t, conn, first.FileID))
+	for i, ref := range []struct{ user, file uint }{{users[0].ID, first.ID}, {users[1].ID, second.ID}} {
+		if err := svc.DeleteFile(ref.file, ref.user); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := svc.deleter.Drain(); err != nil {
+			t.Fatal(err)
+		}
+		_, err := os.Stat(dir)
+		if i == 0 && err != nil {
+			t.Fatalf("thumbnails purged while a reference remains: %v", err)
+		}
+		if i == 1 && !os.IsNotExist(err) {
+			t.Errorf("thumbnails left after the blob was removed: %v", err)
+		}
+	}
+}
+
+func TestPreviewOutcomes(t *testing.T) {
+	conn := openTestDB(t)
+	svc := newTestFileService(t, conn)
+	ps := newTestPreviewService(t, conn, svc)
+	user := createTestUsers(t, conn, 1)[0]
+
+	text := generatePreview(t, conn, svc, ps, user.ID, "notes.txt", []byte("first line\nsecond line\n"))
+	p, err := ps.GetPreview(user.ID, text.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Status != models.PreviewReady || p.Kind != models.PreviewKindText ||
+		p.Snippet == nil || *p.Snippet != "first line\nsecond line" {
+		t.Errorf("text preview = %+v", p)
+	}
+	if _, err := ps.ThumbnailPath(user.ID, text.ID, "small"); err != ErrPreviewNotReady {
+		t.Errorf("thumbnail of a text file: err %v, want ErrPreviewNotReady", err)
+	}
+
+	data, _ := randomContent(t, 1024)
+	bin := generatePreview(t, conn, svc, ps, user.ID, "data.bin", data)
+	if p, _ := ps.GetPreview(user.ID, bin.ID); p == nil || p.Status != models.PreviewUnsupported {
+		t.Errorf("binary preview = %+v, want unsupported", p)
+	}
+
+	bad := generatePreview(t, conn, svc, ps, user.ID, "bomb.png", pngHeader(65536, 65536))
+	p, _ = ps.GetPreview(user.ID, bad.ID)
+	if p == nil || p.Status != models.PreviewFailed || p.Error == nil || !strings.Contains(*p.Error, "too large") {
+		t.Errorf("oversized image preview = %+v, want failed as too large", p)
+	}
+
+	// Content stored before previews existed is queued on first request
+	legacy := generatePreview(t, conn, svc, ps, user.ID, "old.txt", []byte("old\n"))
+	hash := fileHash(t, conn, legacy.FileID)
+	conn.Where("hash = ?", hash).Delete(&models.FilePreview{})
+	p, err = ps.GetPreview(user.ID, legacy.ID)
+	if err != nil || p.Status != models.PreviewPending {
+		t.Fatalf("preview of legacy content = %+v, %v; want pending", p, err)
+	}
+	ps.process(hash)
+	if p, _ := ps.GetPreview(user.ID, legacy.ID); p == nil || p.Status != models.PreviewReady {
+		t.Errorf("legacy preview = %+v, want ready once processed", p)
+	}
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"backend/internal/models"
+	"log"
+	"sync"
+)
+
+const subscriberBuffer = 64
+
+// Broker fans events out to the live subscribers of each user. The
+// in-process MemoryBroker suits a single server; PostgresBroker relays
+// through LISTEN/NOTIFY so every instance sees every event.
+type Broker interface {
+	Publish(ev EventEnvelope) error
+	// Subscribe returns a channel of the user's events and a function that
+	// unsubscribes and closes it. Publishers never wait: a subscriber that
+	// falls a buffer behind is unsubscribed and its channel closed, so it
+	// never silently misses an event. Clients catch up by reconnecting with
+	// the last event ID they saw.
+	Subscribe(userID uint) (<-chan EventEnvelope, func())
+}
+
+type MemoryBroker struct {
+	mu   sync.Mutex
+	subs map[uint]map[chan EventEnvelope]struct{}
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{subs: make(map[uint]map[chan EventEnvelope]struct{})}
+}
+
+func (b *MemoryBroker) Publish(ev EventEnvelope) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs[ev.UserID] {
+		select {
+		case ch <- ev:
+		default:
+			b.remove(ev.UserID, ch)
+		}
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(userID uint) (<-chan EventEnvelope, func()) {
+	ch := make(chan EventEnvelope, subscriberBuffer)
+	b.mu.Lock()
+	if b.subs[userID] == nil {
+		b.subs[userID] = make(map[chan EventEnvelope]struct{})
+	}
+	b.subs[userID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		// Publish may have cut the subscriber off already
+		if _, ok := b.subs[userID][ch]; ok {
+			b.remove(userID, ch)
+		}
+	}
+}
+
+// dropAll cuts off every subscriber, for when events may have been missed.
+func (b *MemoryBroker) dropAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for userID, chans := range b.subs {
+		for ch := range chans {
+			b.remove(userID, ch)
+		}
+	}
+}
+
+// remove unsubscribes ch and closes it. b.mu must be held.
+func (b *MemoryBroker) remove(userID uint, ch chan EventEnvelope) {
+	delete(b.subs[userID], ch)
+	if len(b.subs[userID]) == 0 {
+		delete(b.subs, userID)
+	}
+	close(ch)
+}
+
+// PublishDispatched publishes a committed outbox batch. It is registered as
+// an EventDispatcher after-dispatch hook.
+func PublishDispatched(b Broker) func(events []models.OutboxEvent) {
+	return func(events []models.OutboxEvent) {
+		for _, ev := range events {
+			if err := b.Publish(envelope(ev)); err != nil {
+				log.Printf("event broker: failed to publish event %d: %v", ev.ID, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMemoryBrokerDeliversToTheUser(t *testing.T) {
+	b := NewMemoryBroker()
+	alice, cancelAlice := b.Subscribe(1)
+	defer cancelAlice()
+	bob, cancelBob := b.Subscribe(2)
+	defer cancelBob()
+
+	b.Publish(EventEnvelope{ID: 7, UserID: 1, Type: "file.uploaded"})
+	select {
+	case ev := <-alice:
+		if ev.ID != 7 {
+			t.Errorf("got event %d", ev.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event not delivered")
+	}
+	select {
+	case ev := <-bob:
+		t.Errorf("another user got event %d", ev.ID)
+	default:
+	}
+}
+
+func TestMemoryBrokerCutsOffSlowSubscriber(t *testing.T) {
+	b := NewMemoryBroker()
+	slow, cancel := b.Subscribe(1)
+	fast, cancelFast := b.Subscribe(1)
+	defer cancelFast()
+
+	// fast keeps up; slow never reads and overflows on the last event
+	for i := 1; i <= subscriberBuffer+1; i++ {
+		b.Publish(EventEnvelope{ID: uint(i), UserID: 1})
+		if ev := <-fast; ev.ID != uint(i) {
+			t.Fatalf("fast subscriber got %d, want %d", ev.ID, i)
+		}
+	}
+
+	// What was buffered is still read, then the channel is closed rather
+	// than skipping ahead
+	n := 0
+	for ev := range slow {
+		n++
+		if ev.ID != uint(n) {
+			t.Fatalf("slow subscriber got %d, want %d", ev.ID, n)
+		}
+	}
+	if n != subscriberBuffer {
+		t.Errorf("read %d events before the close, want %d", n, subscriberBuffer)
+	}
+	// Unsubscribing after being cut off is harmless
+	cancel()
+
+	b.Publish(EventEnvelope{ID: 100, UserID: 1})
+	if ev := <-fast; ev.ID != 100 {
+		t.Errorf("fast subscriber got %d after the cut-off", ev.ID)
+	}
+}
+
+func TestPostgresBroker(t *testing.T) {
+	conn := openTestDB(t)
+	b := NewPostgresBroker(conn, os.Getenv("TEST_DATABASE_DSN"))
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		b.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		stop()
+		<-done
+	}()
+
+	live, cancel := b.Subscribe(1)
+	defer cancel()
+
+	// The listener connects in the background, so publish until heard
+	deadline := time.After(10 * time.Second)
+	tick := time.NewTicker(50 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		if err := b.Publish(EventEnvelope{ID: 1, UserID: 1, Type: "file.uploaded", Data: []byte(`{"a":1}`)}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case ev := <-live:
+			if ev.ID != 1 || ev.Type != "file.uploaded" || string(ev.Data) != `{"a":1}` {
+				t.Errorf("heard %+v", ev)
+			}
+			return
+		case <-tick.C:
+		case <-deadline:
+			t.Fatal("no event heard")
+		}
+	}
+}
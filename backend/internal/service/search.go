@@ -0,0 +1,266 @@
+package service
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ledongthuc/pdf"
+	xhtml "golang.org/x/net/html"
+)
+
+const (
+	// maxIndexedText keeps extracted text well below the 1 MB tsvector limit
+	maxIndexedText   = 512 << 10
+	defaultSearchCap = 20
+	maxSearchCap     = 100
+)
+
+var ErrEmptyQuery = errors.New("empty search query")
+
+// SearchService extracts text from uploaded documents in the background and
+// answers full-text queries over it. Extraction happens once per content
+// hash; results are always restricted to the requesting user's files.
+type SearchService struct {
+	repo     *repository.SearchRepository
+	fileRepo *repository.FileRepository
+	queue    *hashQueue
+}
+
+func NewSearchService(repo *repository.SearchRepository, fileRepo *repository.FileRepository) *SearchService {
+	ss := &SearchService{repo: repo, fileRepo: fileRepo}
+	ss.queue = newHashQueue("search index", repo.ListPending, ss.process)
+	return ss
+}
+
+// SearchHit is one ranked match. Snippet is HTML-safe, with matched terms
+// wrapped in <mark>.
+type SearchHit struct {
+	ID         uint    `json:"id"`
+	Filename   string  `json:"filename"`
+	Folder     string  `json:"folder"`
+	Size       int64   `json:"size"`
+	MimeType   string  `json:"mime_type"`
+	UploadDate string  `json:"upload_date"`
+	Rank       float64 `json:"rank"`
+	Snippet    string  `json:"snippet"`
+}
+
+// Enqueue schedules text extraction for a newly stored blob.
+func (ss *SearchService) Enqueue(file *models.File) {
+	if extractorFor(file) == nil {
+		return
+	}
+	if err := ss.repo.MarkPending(file.Hash); err != nil {
+		log.Printf("search index: failed to queue %s: %v", file.Hash, err)
+		return
+	}
+	ss.queue.push(file.Hash)
+}
+
+// Run indexes documents in the background until ctx is cancelled.
+func (ss *SearchService) Run(ctx context.Context, workers int) {
+	ss.queue.run(ctx, workers)
+}
+
+func (ss *SearchService) process(hash string) {
+	fc, err := ss.repo.Get(hash)
+	if err != nil || fc.Status != models.ContentPending {
+		return
+	}
+	file, err := ss.fileRepo.GetFileByHash(hash)
+	if err != nil {
+		ss.repo.Delete(hash)
+		return
+	}
+
+	extract := extractorFor(file)
+	if extract == nil {
+		fc.Status = models.ContentUnsupported
+	} else if text, err := extractFile(file.StoragePath, extract); err != nil {
+		msg := err.Error()
+		fc.Status = models.ContentFailed
+		fc.Error = &msg
+	} else {
+		fc.Status = models.ContentIndexed
+		fc.Content = &text
+	}
+	if err := ss.repo.Save(fc); err != nil {
+		log.Printf("search index: failed to save %s: %v", hash, err)
+	}
+}
+
+// Purge drops indexed text for a blob that no longer exists. It is
+// registered as a BlobDeleter hook.
+func (ss *SearchService) Purge(r repository.Repos, hash string) error {
+	return r.Contents.Delete(hash)
+}
+
+// Search returns the user's files whose content matches query, best first.
+func (ss *SearchService) Search(userID uint, query string, limit, offset int) ([]SearchHit, int64, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, 0, ErrEmptyQuery
+	}
+	if limit <= 0 {
+		limit = defaultSearchCap
+	}
+	if limit > maxSearchCap {
+		limit = maxSearchCap
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	rows, total, err := ss.repo.Search(userID, query, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	hits := make([]SearchHit, 0, len(rows))
+	for _, r := range rows {
+		hits = append(hits, SearchHit{
+			ID:         r.UserFileID,
+			Filename:   r.FileName,
+			Folder:     r.Folder,
+			Size:       r.Size,
+			MimeType:   r.MimeType,
+			UploadDate: r.UploadedAt.Format(time.DateOnly),
+			Rank:       r.Rank,
+			Snippet:    highlightSnippet(r.Snippet),
+		})
+	}
+	return hits, total, nil
+}
+
+// highlightSnippet escapes document text and turns the headline markers
+// into <mark> tags.
+func highlightSnippet(s string) string {
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, repository.HighlightStart, "<mark>")
+	return strings.ReplaceAll(s, repository.HighlightStop, "</mark>")
+}
+
+type textExtractor func(src io.ReaderAt, size int64) (string, error)
+
+// extractorFor picks an extractor from the stored MIME type, falling back to
+// the extension for textual formats that sniff as text/plain.
+func extractorFor(file *models.File) textExtractor {
+	mt := mediaType(file.MimeType)
+	switch {
+	case mt == "application/pdf":
+		return extractPDF
+	case mt == "text/html":
+		return extractHTML
+	case mt == "text/plain", mt == "text/markdown", mt == "text/csv", mt == "application/json":
+		return extractPlain
+	}
+	switch strings.ToLower(filepath.Ext(file.Filename)) {
+	case ".md", ".markdown", ".csv", ".json", ".txt":
+		if strings.HasPrefix(mt, "text/") {
+			return extractPlain
+		}
+	}
+	return nil
+}
+
+func extractFile(path string, extract textExtractor) (text string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	// Parsers for untrusted documents can panic on malformed input
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("extract text: %v", r)
+		}
+	}()
+	text, err = extract(f, info.Size())
+	if err != nil {
+		return "", err
+	}
+	// Postgres text columns reject NUL bytes
+	text = strings.ReplaceAll(strings.ToValidUTF8(text, ""), "\x00", "")
+	return truncateText(text, maxIndexedText), nil
+}
+
+func extractPlain(src io.ReaderAt, size int64) (string, error) {
+	buf, err := io.ReadAll(io.NewSectionReader(src, 0, min(size, maxIndexedText)))
+	return string(buf), err
+}
+
+// extractHTML keeps visible text, skipping scripts and styles.
+func extractHTML(src io.ReaderAt, size int64) (string, error) {
+	z := xhtml.NewTokenizer(io.NewSectionReader(src, 0, size))
+	var b strings.Builder
+	skip := 0
+	for b.Len() < maxIndexedText {
+		switch z.Next() {
+		case xhtml.ErrorToken:
+			if z.Err() == io.EOF {
+				return b.String(), nil
+			}
+			return b.String(), z.Err()
+		case xhtml.StartTagToken:
+			if name, _ := z.TagName(); isHiddenElement(name) {
+				skip++
+			}
+		case xhtml.EndTagToken:
+			if name, _ := z.TagName(); isHiddenElement(name) && skip > 0 {
+				skip--
+			}
+		case xhtml.TextToken:
+			if skip == 0 {
+				b.Write(bytes.TrimSpace(z.Text()))
+				b.WriteByte(' ')
+			}
+		}
+	}
+	return b.String(), nil
+}
+
+func isHiddenElement(name []byte) bool {
+	switch string(name) {
+	case "script", "style", "noscript", "template":
+		return true
+	}
+	return false
+}
+
+func extractPDF(src io.ReaderAt, size int64) (string, error) {
+	r, err := pdf.NewReader(src, size)
+	if err != nil {
+		return "", err
+	}
+	plain, err := r.GetPlainText()
+	if err != nil {
+		return "", err
+	}
+	buf, err := io.ReadAll(io.LimitReader(plain, maxIndexedText))
+	return string(buf), err
+}
+
+// truncateText cuts s to at most n bytes without splitting a rune.
+func truncateText(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	s = s[:n]
+	return strings.ToValidUTF8(s, "")
+}
@@ -0,0 +1,217 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"backend/internal/models"
+	"backend/internal/repository"
+
+	"gorm.io/gorm"
+)
+
+func TestHighlightSnippet(t *testing.T) {
+	in := `<b>tax</b> & ` + repository.HighlightStart + "revenue" + repository.HighlightStop + " report"
+	want := `&lt;b&gt;tax&lt;/b&gt; &amp; <mark>revenue</mark> report`
+	if got := highlightSnippet(in); got != want {
+		t.Errorf("highlightSnippet = %q, want %q", got, want)
+	}
+}
+
+func TestExtractorFor(t *testing.T) {
+	for _, tt := range []struct {
+		mime, name string
+		want       string
+	}{
+		{"application/pdf", "a.pdf", "pdf"},
+		{"text/html; charset=utf-8", "a.html", "html"},
+		{"text/plain; charset=utf-8", "notes", "plain"},
+		{"application/json", "a.json", "plain"},
+		{"text/xml; charset=utf-8", "data.csv", "plain"}, // sniffed otherwise, named as CSV
+		{"application/octet-stream", "data.csv", ""},
+		{"image/png", "a.png", ""},
+	} {
+		got := ""
+		if f := extractorFor(&models.File{MimeType: tt.mime, Filename: tt.name}); f != nil {
+			got = map[uintptr]string{
+				reflect.ValueOf(extractPDF).Pointer():   "pdf",
+				reflect.ValueOf(extractHTML).Pointer():  "html",
+				reflect.ValueOf(extractPlain).Pointer(): "plain",
+			}[reflect.ValueOf(f).Pointer()]
+		}
+		if got != tt.want {
+			t.Errorf("extractorFor(%q, %q) = %q, want %q", tt.mime, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExtractHTMLKeepsVisibleText(t *testing.T) {
+	doc := strings.NewReader(`<html><head><title>Budget</title><style>p{}</style></head>
+		<body><p>Quarterly <b>revenue</b></p><script>var secret = 1</script>
+		<noscript>enable js</noscript><p>grew</p></body></html>`)
+	got, err := extractHTML(doc, doc.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(strings.Fields(got), " "); got != "Budget Quarterly revenue grew" {
+		t.Errorf("extracted %q", got)
+	}
+}
+
+func TestExtractFileCleansText(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "doc.txt")
+	content := "nul\x00byte \xff bad " + strings.Repeat("é", maxIndexedText)
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := extractFile(p, extractPlain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(got, "nulbyte  bad é") {
+		t.Errorf("text starts %q, want NUL and invalid bytes dropped", got[:16])
+	}
+	if len(got) > maxIndexedText || !strings.HasSuffix(got, "é") {
+		t.Errorf("text is %d bytes ending %q, want at most %d without a split rune", len(got), got[len(got)-2:], maxIndexedText)
+	}
+
+	if _, err := extractFile(p, func(src io.ReaderAt, size int64) (string, error) { panic("bad xref") }); err == nil {
+		t.Error("a panicking extractor was not turned into an error")
+	}
+}
+
+// indexText uploads content and indexes it in place of the background
+// workers.
+func indexText(t *testing.T, conn *gorm.DB, svc *FileService, ss *SearchService, userID uint, name, content string) *models.UserFile {
+	t.Helper()
+	uf, err := svc.ProcessFileUpload(userID, "", name, strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss.process(fileHash(t, conn, uf.FileID))
+	return uf
+}
+
+func hitIDs(hits []SearchHit) []uint {
+	ids := make([]uint, len(hits))
+	for i, h := range hits {
+		ids[i] = h.ID
+	}
+	return ids
+}
+
+func TestSearchRanksOwnFiles(t *testing.T) {
+	conn := openTestDB(t)
+	svc := newTestFileService(t, conn)
+	ss := NewSearchService(repository.NewSearchRepository(conn), repository.NewFileRepository(conn))
+	svc.OnNewBlob(ss.Enqueue)
+	users := createTestUsers(t, conn, 2)
+	alice, bob := users[0].ID, users[1].ID
+
+	dense := indexText(t, conn, svc, ss, alice, "dense.txt",
+		"Quarterly revenue report. Revenue grew, revenue targets met, revenue up.")
+	sparse := indexText(t, conn, svc, ss, alice, "sparse.txt",
+		"Minutes of the meeting. Someone asked about revenue once. Forecast pending.")
+	other := indexText(t, conn, svc, ss, alice, "other.txt", "Holiday plans and a packing list.")
+	// Bob stores the same content as Alice's best match under his own name
+	bobs, err := svc.ProcessFileUpload(bob, "", "mine.txt",
+		strings.NewReader("Quarterly revenue report. Revenue grew, revenue targets met, revenue up."))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hits, total, err := ss.Search(alice, "revenue", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || len(hits) != 2 || hits[0].ID != dense.ID || hits[1].ID != sparse.ID {
+		t.Fatalf("hits %v of %d, want [%d %d]", hitIDs(hits), total, dense.ID, sparse.ID)
+	}
+	if hits[0].Rank <= hits[1].Rank {
+		t.Errorf("ranks %v, %v: denser match not ranked higher", hits[0].Rank, hits[1].Rank)
+	}
+	if !strings.Contains(hits[0].Snippet, "<mark>") {
+		t.Errorf("snippet %q has no highlighted match", hits[0].Snippet)
+	}
+
+	for _, tt := range []struct {
+		query string
+		want  []uint
+	}{
+		{"revenues", []uint{dense.ID, sparse.ID}}, // stemmed
+		{`"quarterly revenue"`, []uint{dense.ID}},
+		{"revenue -forecast", []uint{dense.ID}},
+		{"holiday or packing", []uint{other.ID}},
+	} {
+		hits, _, err := ss.Search(alice, tt.query, 0, 0)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.query, err)
+		}
+		if got := hitIDs(hits); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%q: hits %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	// Identical content is indexed once and found through each user's file
+	hits, total, err = ss.Search(bob, "revenue", 0, 0)
+	if err != nil || total != 1 || len(hits) != 1 || hits[0].ID != bobs.ID || hits[0].Filename != "mine.txt" {
+		t.Errorf("bob's hits %+v of %d, %v; want only his own file", hits, total, err)
+	}
+
+	page, total, err := ss.Search(alice, "revenue", 1, 1)
+	if err != nil || total != 2 || len(page) != 1 || page[0].ID != sparse.ID {
+		t.Errorf("second page %v of %d, %v; want [%d] of 2", hitIDs(page), total, err, sparse.ID)
+	}
+	if _, _, err := ss.Search(alice, "   ", 0, 0); err != ErrEmptyQuery {
+		t.Errorf("blank query: err %v, want ErrEmptyQuery", err)
+	}
+}
+
+func TestSearchIndexOutcomes(t *testing.T) {
+	conn := openTestDB(t)
+	svc := newTestFileService(t, conn)
+	repo := repository.NewSearchRepository(conn)
+	ss := NewSearchService(repo, repository.NewFileRepository(conn))
+	svc.OnNewBlob(ss.Enqueue)
+	svc.deleter.OnBlobRemoved(ss.Purge)
+	user := createTestUsers(t, conn, 1)[0]
+
+	// Binary content is never queued
+	data, _ := randomContent(t, 1024)
+	bin, err := svc.ProcessFileUpload(user.ID, "", "data.bin", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Get(fileHash(t, conn, bin.FileID)); err == nil {
+		t.Error("binary content was queued for indexing")
+	}
+
+	// A malformed PDF fails with a reason instead of stalling the queue
+	pdf := indexText(t, conn, svc, ss, user.ID, "broken.pdf", "%PDF-1.4\n1 0 obj\n<< garbage")
+	fc, err := repo.Get(fileHash(t, conn, pdf.FileID))
+	if err != nil || fc.Status != models.ContentFailed || fc.Error == nil {
+		t.Errorf("malformed PDF indexed as %+v, %v; want failed with a reason", fc, err)
+	}
+
+	// Deleting the last reference drops the indexed text
+	doc := indexText(t, conn, svc, ss, user.ID, "doc.txt", "searchable words")
+	hash := fileHash(t, conn, doc.FileID)
+	if fc, err := repo.Get(hash); err != nil || fc.Status != models.ContentIndexed {
+		t.Fatalf("text indexed as %+v, %v", fc, err)
+	}
+	if err := svc.DeleteFile(doc.ID, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.deleter.Drain(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Get(hash); err == nil {
+		t.Error("indexed text kept after the blob was removed")
+	}
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"backend/internal/models"
+	"io"
+	"os"
+	"sync"
+)
+
+// spoolQuotaStep is how much a spool may grow between quota checks.
+const spoolQuotaStep = 16 << 20
+
+// Spool is an upload whose content arrives at arbitrary offsets, as SFTP
+// clients send it, kept in the staging area until it is complete. Commit
+// then stores it with PutFile.
+type Spool struct {
+	fs     *FileService
+	userID uint
+	path   string
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	checked int64 // size at the last quota check
+	done    bool
+}
+
+// NewSpool starts an upload to p, failing early if PutFile would reject
+// the path.
+func (fs *FileService) NewSpool(userID uint, p string) (*Spool, error) {
+	folder, name, err := splitPath(p)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, ErrPathExists
+	}
+	if _, ok, err := fs.folderTime(userID, folder); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrParentNotFound
+	}
+	if _, ok, err := fs.folderTime(userID, joinFolder(folder, name)); err != nil {
+		return nil, err
+	} else if ok {
+		return nil, ErrPathExists
+	}
+
+	f, err := fs.createStaging(fs.stagingDir(), "spool-*")
+	if err != nil {
+		return nil, err
+	}
+	return &Spool{fs: fs, userID: userID, path: joinFolder(folder, name), f: f}, nil
+}
+
+// WriteAt writes p at off. Writes past the size limit fail, and the quota
+// is checked as the content grows so a client over quota finds out before
+// sending everything.
+func (s *Spool) WriteAt(p []byte, off int64) (int, error) {
+	end := off + int64(len(p))
+	if max := s.fs.config.MaxFileSize; max > 0 && end > max {
+		return 0, ErrFileTooLarge
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return 0, os.ErrClosed
+	}
+	if end > s.size {
+		s.size = end
+	}
+	if s.size-s.checked >= spoolQuotaStep {
+		if err := s.fs.CheckStorageQuota(s.userID, s.size); err != nil {
+			return 0, err
+		}
+		s.checked = s.size
+	}
+	return s.f.WriteAt(p, off)
+}
+
+// Commit stores the content at the spool's path and discards the spool.
+func (s *Spool) Commit() (*models.UserFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return nil, os.ErrClosed
+	}
+	defer s.discard()
+
+	if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+		return nil, ErrUploadRead
+	}
+	return s.fs.PutFile(s.userID, s.path, s.f)
+}
+
+// Discard drops the content without storing it.
+func (s *Spool) Discard() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.discard()
+}
+
+func (s *Spool) discard() {
+	if s.done {
+		return
+	}
+	s.done = true
+	s.f.Close()
+	s.fs.removeStaging(s.f.Name())
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"bytes"
+	"crypto/rsa"
+	"errors"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"gorm.io/gorm"
+)
+
+const (
+	maxSSHKeyName    = 100
+	minRSAKeyBits    = 2048
+	maxPublicKeySize = 16 << 10
+)
+
+var (
+	ErrSSHKeyNotFound    = errors.New("ssh key not found")
+	ErrSSHKeyExists      = errors.New("ssh key is already registered")
+	ErrInvalidSSHKey     = errors.New("public key must be a single line in authorized_keys format")
+	ErrWeakSSHKey        = errors.New("DSA keys and RSA keys shorter than 2048 bits are not accepted")
+	ErrInvalidSSHKeyName = errors.New("ssh key name must be at most 100 characters")
+)
+
+// SSHKeyService manages the public keys users sign in to SFTP with.
+type SSHKeyService struct {
+	repo     *repository.SSHKeyRepository
+	userRepo *repository.UserRepository
+}
+
+func NewSSHKeyService(repo *repository.SSHKeyRepository, userRepo *repository.UserRepository) *SSHKeyService {
+	return &SSHKeyService{repo: repo, userRepo: userRepo}
+}
+
+// Add registers a public key, given as an authorized_keys line, for
+// userID. Without a name the key's comment is used.
+func (ks *SSHKeyService) Add(userID uint, name, publicKey string) (*models.SSHKey, error) {
+	if len(publicKey) > maxPublicKeySize {
+		return nil, ErrInvalidSSHKey
+	}
+	key, comment, options, rest, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(publicKey)))
+	if err != nil || len(options) > 0 || len(bytes.TrimSpace(rest)) > 0 {
+		return nil, ErrInvalidSSHKey
+	}
+	if err := checkKeyStrength(key); err != nil {
+		return nil, err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = truncateText(strings.TrimSpace(comment), maxSSHKeyName)
+	}
+	if len(name) > maxSSHKeyName || (name != "" && !validName(name)) {
+		return nil, ErrInvalidSSHKeyName
+	}
+
+	k := &models.SSHKey{
+		UserID:      userID,
+		Name:        name,
+		Fingerprint: ssh.FingerprintSHA256(key),
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+	}
+	if err := ks.repo.Create(k); err != nil {
+		if errors.Is(err, repository.ErrSSHKeyExists) {
+			return nil, ErrSSHKeyExists
+		}
+		return nil, err
+	}
+	return k, nil
+}
+
+func checkKeyStrength(key ssh.PublicKey) error {
+	switch key.Type() {
+	case ssh.KeyAlgoDSA:
+		return ErrWeakSSHKey
+	case ssh.KeyAlgoRSA:
+		ck, ok := key.(ssh.CryptoPublicKey)
+		if !ok {
+			return ErrInvalidSSHKey
+		}
+		rk, ok := ck.CryptoPublicKey().(*rsa.PublicKey)
+		if !ok || rk.N.BitLen() < minRSAKeyBits {
+			return ErrWeakSSHKey
+		}
+	case ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521, ssh.KeyAlgoED25519,
+		ssh.KeyAlgoSKECDSA256, ssh.KeyAlgoSKED25519:
+	default:
+		// Certificates and anything unusual
+		return ErrInvalidSSHKey
+	}
+	return nil
+}
+
+func (ks *SSHKeyService) List(userID uint) ([]models.SSHKey, error) {
+	return ks.repo.ListByUser(userID)
+}
+
+func (ks *SSHKeyService) Delete(userID, id uint) error {
+	err := ks.repo.Delete(id, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrSSHKeyNotFound
+	}
+	return err
+}
+
+// Lookup returns the registered key matching key and its owner, who must
+// be username. SSH clients offer keys before proving they hold them, so
+// finding a key does not mean it was used; Touch records that once the
+// signature has been checked.
+func (ks *SSHKeyService) Lookup(username string, key ssh.PublicKey) (*models.SSHKey, *models.User, error) {
+	k, err := ks.repo.GetByFingerprint(ssh.FingerprintSHA256(key))
+	if err != nil {
+		return nil, nil, ErrInvalidCreds
+	}
+	stored, _, _, _, err := ssh.ParseAuthorizedKey([]byte(k.PublicKey))
+	if err != nil || !bytes.Equal(stored.Marshal(), key.Marshal()) {
+		return nil, nil, ErrInvalidCreds
+	}
+	user, err := ks.userRepo.GetByID(k.UserID)
+	if err != nil || user == nil || user.Username != username || user.Disabled() {
+		return nil, nil, ErrInvalidCreds
+	}
+	return k, user, nil
+}
+
+// Recheck reports whether a sign-in with key keyID still holds: the key
+// has not been deleted and its user has not been disabled.
+func (ks *SSHKeyService) Recheck(keyID, userID uint) bool {
+	k, err := ks.repo.Get(keyID)
+	if err != nil || k.UserID != userID {
+		return false
+	}
+	user, err := ks.userRepo.GetByID(userID)
+	return err == nil && user != nil && !user.Disabled()
+}
+
+// Touch records that a key signed in.
+func (ks *SSHKeyService) Touch(id uint) {
+	ks.repo.Touch(id, time.Now())
+}
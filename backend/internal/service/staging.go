@@ -0,0 +1,250 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// sniffLen is the number of leading bytes http.DetectContentType looks at.
+const sniffLen = 512
+
+// stagedBlob is an upload that has been streamed to a staging file and
+// fingerprinted, but not yet promoted into the content-addressed store.
+type stagedBlob struct {
+	path     string
+	hash     string
+	size     int64
+	mimeType string
+}
+
+// stageUpload reads src exactly once, sniffing the MIME type from the first
+// bytes while hashing and writing everything to a staging file. Memory use is
+// bounded by the copy buffer regardless of upload size.
+func (fs *FileService) stageUpload(src io.Reader, filename string) (*stagedBlob, error) {
+	// Sniff from the head of the stream, then replay it in front of the rest
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, ErrUploadRead
+	}
+	head = head[:n]
+
+	mimeType, err := fs.checkMimeType(head, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	dst, err := fs.createStaging(fs.stagingDir(), "upload-*")
+	if err != nil {
+		return nil, err
+	}
+
+	body := io.MultiReader(bytes.NewReader(head), src)
+	if fs.config.MaxFileSize > 0 {
+		// Read one byte past the limit so oversize uploads are detectable
+		body = io.LimitReader(body, fs.config.MaxFileSize+1)
+	}
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(dst, hasher), body)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fs.removeStaging(dst.Name())
+		return nil, ErrUploadRead
+	}
+	if fs.config.MaxFileSize > 0 && size > fs.config.MaxFileSize {
+		fs.removeStaging(dst.Name())
+		return nil, ErrFileTooLarge
+	}
+
+	return &stagedBlob{
+		path:     dst.Name(),
+		hash:     hex.EncodeToString(hasher.Sum(nil)),
+		size:     size,
+		mimeType: mimeType,
+	}, nil
+}
+
+// promote moves a staged blob to its content-addressed location and returns
+// the final storage path.
+func (fs *FileService) promote(blob *stagedBlob) (string, error) {
+	storagePath := fs.blobPath(blob.hash)
+	if err := os.MkdirAll(filepath.Dir(storagePath), 0755); err != nil {
+		return "", errors.New("failed to create upload directory")
+	}
+	if err := os.Rename(blob.path, storagePath); err != nil {
+		return "", errors.New("failed to save file to disk")
+	}
+	fs.staging.forget(blob.path)
+	return storagePath, nil
+}
+
+// discard removes a staged blob that turned out to be a duplicate or whose
+// upload was rejected.
+func (fs *FileService) discard(blob *stagedBlob) {
+	if err := fs.removeStaging(blob.path); err != nil {
+		fmt.Printf("Warning: failed to remove staged upload %s: %v\n", blob.path, err)
+	}
+}
+
+// stagingFiles remembers the staging files of uploads in progress, so that
+// they can be removed if the server stops before the uploads finish.
+type stagingFiles struct {
+	mu    sync.Mutex
+	paths map[string]bool
+}
+
+func (s *stagingFiles) add(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.paths == nil {
+		s.paths = make(map[string]bool)
+	}
+	s.paths[path] = true
+}
+
+func (s *stagingFiles) forget(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.paths, path)
+}
+
+// take returns every tracked path and stops tracking them.
+func (s *stagingFiles) take() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	paths := make([]string, 0, len(s.paths))
+	for p := range s.paths {
+		paths = append(paths, p)
+	}
+	s.paths = nil
+	return paths
+}
+
+func (fs *FileService) stagingDir() string {
+	return filepath.Join(fs.config.UploadDir, ".staging")
+}
+
+// createStaging creates a staging file in dir, tracked until it is removed
+// with removeStaging or moved into place.
+func (fs *FileService) createStaging(dir, pattern string) (*os.File, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.New("failed to create staging directory")
+	}
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, errors.New("failed to create staging file")
+	}
+	fs.staging.add(f.Name())
+	return f, nil
+}
+
+// removeStaging deletes a staging file. One already gone is not an error.
+func (fs *FileService) removeStaging(path string) error {
+	fs.staging.forget(path)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// AbortUploads removes the staging files of every upload still in
+// progress and returns how many there were. The server calls it when it
+// stops without waiting any longer for transfers to finish, so that no
+// partial content is left behind; the uploads themselves then fail.
+func (fs *FileService) AbortUploads() int {
+	paths := fs.staging.take()
+	for _, p := range paths {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: failed to remove staging file %s: %v", p, err)
+		}
+	}
+	return len(paths)
+}
+
+// blobPath returns the on-disk location for content with the given hash,
+// fanned out by the first two hex digits to keep directories small.
+func (fs *FileService) blobPath(hash string) string {
+	return filepath.Join(fs.config.UploadDir, hash[:2], hash)
+}
+
+// checkMimeType validates the sniffed content type against the filename
+// extension and the configured allow-list.
+func (fs *FileService) checkMimeType(head []byte, filename string) (string, error) {
+	detectedMime := http.DetectContentType(head)
+
+	// Get expected MIME type from file extension
+	expectedMime := mime.TypeByExtension(filepath.Ext(filename))
+
+	// Validate if expected MIME type exists and matches
+	if expectedMime != "" && !mimeCompatible(detectedMime, expectedMime) {
+		return "", ErrMimeMismatch
+	}
+	// Sniffing can only tell that text is text; the extension says which kind
+	if expectedMime != "" && mediaType(detectedMime) == "text/plain" {
+		detectedMime = expectedMime
+	}
+
+	// Check against allowed types if configured
+	if len(fs.config.AllowedTypes) > 0 {
+		allowed := false
+		for _, allowedType := range fs.config.AllowedTypes {
+			if detectedMime == allowedType {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return "", ErrTypeNotAllowed
+		}
+	}
+
+	return detectedMime, nil
+}
+
+// mimeCompatible reports whether sniffed content matches the type implied by
+// the file extension. Textual formats such as JSON, CSV or Markdown sniff as
+// text/plain, so plain text is accepted for any textual extension.
+func mimeCompatible(detected, expected string) bool {
+	d, e := mediaType(detected), mediaType(expected)
+	if d == e {
+		return true
+	}
+	return d == "text/plain" && isTextualType(e)
+}
+
+func isTextualType(mediaType string) bool {
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript",
+		"application/x-yaml", "application/yaml", "application/toml", "application/sql":
+		return true
+	}
+	return false
+}
+
+// mediaType strips parameters such as charset from a MIME type.
+func mediaType(mimeType string) string {
+	if i := strings.IndexByte(mimeType, ';'); i >= 0 {
+		mimeType = mimeType[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(mimeType))
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAbortUploadsRemovesUnfinishedStaging(t *testing.T) {
+	fs := &FileService{config: FileConfig{UploadDir: t.TempDir()}}
+
+	// An upload that finishes normally leaves nothing to abort
+	blob, err := fs.stageUpload(strings.NewReader("finished"), "done.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.discard(blob)
+
+	// One still being written when the server gives up on it
+	f, err := fs.createStaging(fs.stagingDir(), "upload-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("partial")
+	defer f.Close()
+
+	if n := fs.AbortUploads(); n != 1 {
+		t.Errorf("aborted %d uploads, want 1", n)
+	}
+	if _, err := os.Stat(f.Name()); !os.IsNotExist(err) {
+		t.Errorf("staging file still there: %v", err)
+	}
+	entries, _ := os.ReadDir(fs.stagingDir())
+	if len(entries) != 0 {
+		t.Errorf("%d files left in staging", len(entries))
+	}
+	if n := fs.AbortUploads(); n != 0 {
+		t.Errorf("second abort removed %d uploads", n)
+	}
+}
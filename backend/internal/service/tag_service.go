@@ -0,0 +1,186 @@
+package service
+
+import (
+	"backend/internal/repository"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxTagLen           = 64
+	maxTagsPerFile      = 50
+	maxMetadataKeyLen   = 64
+	maxMetadataValueLen = 1024
+	maxMetadataPerFile  = 50
+	maxBulkEditFiles    = 1000
+)
+
+var (
+	ErrInvalidTag      = errors.New("invalid tag")
+	ErrInvalidMetadata = errors.New("invalid metadata key or value")
+	ErrTooManyTags     = errors.New("too many tags or metadata keys on a file")
+	ErrTooManyFiles    = errors.New("too many files selected")
+	ErrNoFilesSelected = errors.New("no files selected")
+)
+
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// TagService manages user-defined tags and key/value metadata on user files.
+type TagService struct {
+	tagRepo *repository.TagRepository
+	txm     *repository.TxManager
+}
+
+func NewTagService(tagRepo *repository.TagRepository, txm *repository.TxManager) *TagService {
+	return &TagService{tagRepo: tagRepo, txm: txm}
+}
+
+// TagEdit describes changes applied to every selected file. Removals are
+// applied before additions, so a tag can be renamed in one request.
+type TagEdit struct {
+	AddTags        []string          `json:"add_tags"`
+	RemoveTags     []string          `json:"remove_tags"`
+	SetMetadata    map[string]string `json:"set_metadata"`
+	RemoveMetadata []string          `json:"remove_metadata"`
+}
+
+// Edit applies edit to the given user files atomically. Every ID must
+// belong to userID, otherwise nothing is changed.
+func (ts *TagService) Edit(userID uint, ids []uint, edit TagEdit) error {
+	ids = dedupeIDs(ids)
+	if len(ids) == 0 {
+		return ErrNoFilesSelected
+	}
+	if len(ids) > maxBulkEditFiles {
+		return ErrTooManyFiles
+	}
+
+	addTags, err := normalizeTags(edit.AddTags)
+	if err != nil {
+		return err
+	}
+	removeTags, err := normalizeTags(edit.RemoveTags)
+	if err != nil {
+		return err
+	}
+	for k, v := range edit.SetMetadata {
+		if !validMetadataKey(k) || len(v) > maxMetadataValueLen || !utf8.ValidString(v) {
+			return ErrInvalidMetadata
+		}
+	}
+	for _, k := range edit.RemoveMetadata {
+		if !validMetadataKey(k) {
+			return ErrInvalidMetadata
+		}
+	}
+
+	return ts.txm.Do(func(r repository.Repos) error {
+		owned, err := r.Tags.CountOwnedUserFiles(userID, ids)
+		if err != nil {
+			return err
+		}
+		if owned != int64(len(ids)) {
+			return errors.New("file not found")
+		}
+
+		if err := r.Tags.RemoveTags(ids, removeTags); err != nil {
+			return err
+		}
+		if err := r.Tags.AddTags(ids, addTags); err != nil {
+			return err
+		}
+		if err := r.Tags.RemoveMetadata(ids, edit.RemoveMetadata); err != nil {
+			return err
+		}
+		if err := r.Tags.SetMetadata(ids, edit.SetMetadata); err != nil {
+			return err
+		}
+
+		// Enforce per-file limits on the result rather than the request
+		if n, err := r.Tags.MaxTagCount(ids); err != nil {
+			return err
+		} else if n > maxTagsPerFile {
+			return ErrTooManyTags
+		}
+		if n, err := r.Tags.MaxMetadataCount(ids); err != nil {
+			return err
+		} else if n > maxMetadataPerFile {
+			return ErrTooManyTags
+		}
+		return nil
+	})
+}
+
+// Get returns the tags and metadata of one of the user's files.
+func (ts *TagService) Get(userID, userfileID uint) ([]string, map[string]string, error) {
+	owned, err := ts.tagRepo.CountOwnedUserFiles(userID, []uint{userfileID})
+	if err != nil {
+		return nil, nil, err
+	}
+	if owned == 0 {
+		return nil, nil, errors.New("file not found")
+	}
+	tags, err := ts.tagRepo.GetTags(userfileID)
+	if err != nil {
+		return nil, nil, err
+	}
+	metadata, err := ts.tagRepo.GetMetadata(userfileID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tags, metadata, nil
+}
+
+// Suggest autocompletes tags the user has already used.
+func (ts *TagService) Suggest(userID uint, prefix string, limit int) ([]repository.TagCount, error) {
+	if limit <= 0 || limit > 50 {
+		limit = 10
+	}
+	return ts.tagRepo.SuggestTags(userID, strings.ToLower(strings.TrimSpace(prefix)), limit)
+}
+
+// normalizeTags lowercases and trims tags, dropping duplicates. Commas are
+// rejected because they separate tags in list filters.
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	out := make([]string, 0, len(tags))
+	for _, t := range tags {
+		t, err := normalizeTag(t)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func normalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" || len(tag) > maxTagLen || strings.ContainsAny(tag, ",") || !validName(tag) {
+		return "", ErrInvalidTag
+	}
+	return tag, nil
+}
+
+func validMetadataKey(key string) bool {
+	return len(key) <= maxMetadataKeyLen && metadataKeyPattern.MatchString(key)
+}
+
+func dedupeIDs(ids []uint) []uint {
+	seen := uniqueIDs(ids)
+	out := make([]uint, 0, len(seen))
+	for _, id := range ids {
+		if seen[id] {
+			out = append(out, id)
+			delete(seen, id)
+		}
+	}
+	return out
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"backend/internal/models"
+	"backend/internal/repository"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	got, err := normalizeTags([]string{" Work ", "urgent", "work", "Q3-report"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"q3-report", "urgent", "work"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, bad := range []string{"", "   ", "a,b", string(make([]byte, maxTagLen+1))} {
+		if _, err := normalizeTags([]string{bad}); !errors.Is(err, ErrInvalidTag) {
+			t.Errorf("%q: err = %v", bad, err)
+		}
+	}
+}
+
+// createUserFiles inserts n references to one stored file for userID.
+func createUserFiles(t *testing.T, conn *gorm.DB, userID uint, n int) []uint {
+	t.Helper()
+	f := &models.File{Filename: "f", Size: 1, Hash: fmt.Sprintf("%064d", userID), StoragePath: "x", MimeType: "text/plain", RefCount: int64(n)}
+	if err := conn.Create(f).Error; err != nil {
+		t.Fatal(err)
+	}
+	ufs := make([]models.UserFile, n)
+	for i := range ufs {
+		ufs[i] = models.UserFile{UserID: userID, FileID: f.ID, FileName: fmt.Sprintf("f%d", i), IsOwner: i == 0}
+	}
+	if err := conn.Omit(clause.Associations).CreateInBatches(&ufs, 500).Error; err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]uint, n)
+	for i, uf := range ufs {
+		ids[i] = uf.ID
+	}
+	return ids
+}
+
+func newTestTagService(conn *gorm.DB) *TagService {
+	return NewTagService(repository.NewTagRepository(conn), repository.NewTxManager(conn))
+}
+
+func TestTagEditLargestBulkEdit(t *testing.T) {
+	conn := openTestDB(t)
+	ts := newTestTagService(conn)
+	user := createTestUsers(t, conn, 1)[0]
+	ids := createUserFiles(t, conn, user.ID, maxBulkEditFiles)
+
+	// Every file gets every tag and key: far more rows than one INSERT
+	// can bind parameters for
+	edit := TagEdit{SetMetadata: map[string]string{}}
+	for i := 0; i < maxTagsPerFile; i++ {
+		edit.AddTags = append(edit.AddTags, fmt.Sprintf("tag-%02d", i))
+		edit.SetMetadata[fmt.Sprintf("key-%02d", i)] = "v"
+	}
+	if err := ts.Edit(user.ID, ids, edit); err != nil {
+		t.Fatal(err)
+	}
+	var tags, keys int64
+	conn.Model(&models.UserFileTag{}).Count(&tags)
+	conn.Model(&models.UserFileMetadata{}).Count(&keys)
+	if want := int64(maxBulkEditFiles * maxTagsPerFile); tags != want || keys != want {
+		t.Errorf("%d tags and %d keys, want %d of each", tags, keys, want)
+	}
+
+	if err := ts.Edit(user.ID, append(ids, ids[0]+uint(len(ids))), TagEdit{AddTags: []string{"x"}}); !errors.Is(err, ErrTooManyFiles) {
+		t.Errorf("editing %d files: err = %v", len(ids)+1, err)
+	}
+}
+
+func TestTagEdit(t *testing.T) {
+	conn := openTestDB(t)
+	ts := newTestTagService(conn)
+	users := createTestUsers(t, conn, 2)
+	ids := createUserFiles(t, conn, users[0].ID, 2)
+	other := createUserFiles(t, conn, users[1].ID, 1)
+
+	if err := ts.Edit(users[0].ID, ids, TagEdit{AddTags: []string{"draft"}, SetMetadata: map[string]string{"client": "acme"}}); err != nil {
+		t.Fatal(err)
+	}
+	// Removals apply first, so a tag can be renamed in one edit
+	if err := ts.Edit(users[0].ID, ids[:1], TagEdit{RemoveTags: []string{"draft"}, AddTags: []string{"final"}, SetMetadata: map[string]string{"client": "globex"}}); err != nil {
+		t.Fatal(err)
+	}
+	tags, meta, err := ts.Get(users[0].ID, ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, []string{"final"}) || meta["client"] != "globex" {
+		t.Errorf("first file: %v %v", tags, meta)
+	}
+	if tags, _, _ := ts.Get(users[0].ID, ids[1]); !reflect.DeepEqual(tags, []string{"draft"}) {
+		t.Errorf("second file: %v", tags)
+	}
+
+	// Someone else's file in the selection changes nothing
+	if err := ts.Edit(users[0].ID, append(ids, other...), TagEdit{AddTags: []string{"stolen"}}); err == nil {
+		t.Error("edited another user's file")
+	}
+	if tags, _, _ := ts.Get(users[0].ID, ids[1]); !reflect.DeepEqual(tags, []string{"draft"}) {
+		t.Errorf("failed edit left %v", tags)
+	}
+
+	// The per-file limit counts tags already there
+	var many []string
+	for i := 0; i < maxTagsPerFile; i++ {
+		many = append(many, fmt.Sprintf("t%d", i))
+	}
+	if err := ts.Edit(users[0].ID, ids[:1], TagEdit{AddTags: many}); !errors.Is(err, ErrTooManyTags) {
+		t.Errorf("%d tags on a file: err = %v", maxTagsPerFile+1, err)
+	}
+}
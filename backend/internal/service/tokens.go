@@ -0,0 +1,153 @@
+package service
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// PersonalTokenPrefix starts every personal token, so a token sent in
+	// place of a password can be told apart from one.
+	PersonalTokenPrefix = "vt_"
+
+	maxTokenNameLen  = 100
+	maxTokenLifetime = 10 * 365 * 24 * time.Hour
+)
+
+var (
+	ErrTokenNotFound    = errors.New("token not found")
+	ErrInvalidTokenName = errors.New("token name must be 1 to 100 characters")
+	ErrInvalidTokenTTL  = errors.New("token lifetime must be between 1 day and 10 years")
+)
+
+// TokenService issues and checks personal access tokens.
+type TokenService struct {
+	repo     *repository.TokenRepository
+	userRepo *repository.UserRepository
+}
+
+func NewTokenService(repo *repository.TokenRepository, userRepo *repository.UserRepository) *TokenService {
+	return &TokenService{repo: repo, userRepo: userRepo}
+}
+
+// Create issues a token for userID. The token itself is returned only here;
+// the vault keeps just its hash. A zero ttl never expires.
+func (ts *TokenService) Create(userID uint, name string, ttl time.Duration) (string, *models.PersonalToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxTokenNameLen || !validName(name) {
+		return "", nil, ErrInvalidTokenName
+	}
+	if ttl != 0 && (ttl < 24*time.Hour || ttl > maxTokenLifetime) {
+		return "", nil, ErrInvalidTokenTTL
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	raw := PersonalTokenPrefix + hex.EncodeToString(b)
+
+	t := &models.PersonalToken{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    raw[:len(PersonalTokenPrefix)+8],
+		TokenHash: hashToken(raw),
+	}
+	if ttl != 0 {
+		expires := time.Now().Add(ttl)
+		t.ExpiresAt = &expires
+	}
+	if err := ts.repo.Create(t); err != nil {
+		return "", nil, err
+	}
+	return raw, t, nil
+}
+
+func (ts *TokenService) List(userID uint) ([]models.PersonalToken, error) {
+	return ts.repo.ListByUser(userID)
+}
+
+func (ts *TokenService) Revoke(userID, id uint) error {
+	err := ts.repo.Delete(id, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrTokenNotFound
+	}
+	return err
+}
+
+// Grant is a sign-in Authenticate accepted. Callers that cache it, or keep
+// a session open on it, check it with Recheck before relying on it again.
+type Grant struct {
+	UserID   uint
+	Username string
+
+	password  string // the password hash the secret matched, or
+	tokenHash string // the hash of the personal token used
+}
+
+// Authenticate checks credentials from clients that send a username and a
+// secret, such as WebDAV's basic auth. The secret is either the account
+// password or one of the user's personal tokens.
+func (ts *TokenService) Authenticate(username, secret string) (*Grant, error) {
+	if !strings.HasPrefix(secret, PersonalTokenPrefix) {
+		user, err := verifyPassword(ts.userRepo, username, secret)
+		if err != nil {
+			return nil, err
+		}
+		return &Grant{UserID: user.ID, Username: user.Username, password: user.Password}, nil
+	}
+
+	t, err := ts.repo.GetByHash(hashToken(secret))
+	if err != nil {
+		return nil, ErrInvalidCreds
+	}
+	user, err := ts.userRepo.GetByID(t.UserID)
+	if err != nil || user == nil || user.Username != username || user.Disabled() {
+		return nil, ErrInvalidCreds
+	}
+	ts.repo.Touch(t.ID, time.Now())
+	return &Grant{UserID: user.ID, Username: user.Username, tokenHash: t.TokenHash}, nil
+}
+
+// Recheck reports whether g still holds: its user has not been disabled or
+// deleted, and has not changed their password or revoked the token since.
+// It costs a lookup or two, where checking a password again costs a bcrypt
+// comparison.
+func (ts *TokenService) Recheck(g *Grant) bool {
+	user, err := ts.userRepo.GetByID(g.UserID)
+	if err != nil || user == nil || user.Disabled() {
+		return false
+	}
+	if g.tokenHash != "" {
+		t, err := ts.repo.GetByHash(g.tokenHash)
+		return err == nil && t.UserID == user.ID
+	}
+	return user.Password == g.password
+}
+
+// Resolve returns the ID of the user a personal token belongs to, for
+// clients that send the token on its own as a bearer token.
+func (ts *TokenService) Resolve(secret string) (uint, error) {
+	if !strings.HasPrefix(secret, PersonalTokenPrefix) {
+		return 0, ErrInvalidCreds
+	}
+	t, err := ts.repo.GetByHash(hashToken(secret))
+	if err != nil {
+		return 0, ErrInvalidCreds
+	}
+	ts.repo.Touch(t.ID, time.Now())
+	return t.UserID, nil
+}
+
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
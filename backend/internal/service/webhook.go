@@ -0,0 +1,440 @@
+package service
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	webhookTimeout        = 10 * time.Second
+	webhookLease          = time.Minute // longer than webhookTimeout
+	webhookBaseBackoff    = 30 * time.Second
+	webhookMaxBackoff     = 12 * time.Hour
+	maxDeliveryAttempts   = 10
+	maxWebhookErrorLen    = 1024
+	minWebhookSecretLen   = 16
+	defaultDeliveryLimit  = 50
+	maxDeliveryLimit      = 200
+	webhookSignatureHdr   = "X-Webhook-Signature"
+	webhookTimestampHdr   = "X-Webhook-Timestamp"
+	webhookEventHdr       = "X-Webhook-Event"
+	webhookDeliveryHdr    = "X-Webhook-Delivery"
+	webhookUserAgentValue = "file-vault-webhooks/1"
+)
+
+var (
+	ErrInvalidWebhookURL    = errors.New("webhook URL must be an absolute http or https URL")
+	ErrUnknownEventType     = errors.New("unknown event type")
+	ErrWeakWebhookSecret    = errors.New("webhook secret must be at least 16 characters")
+	ErrAdminOnly            = errors.New("admin access required")
+	ErrWebhookNotFound      = errors.New("webhook not found")
+	ErrDeliveryNotFound     = errors.New("delivery not found")
+	errPrivateWebhookTarget = errors.New("webhook target resolves to a private address")
+)
+
+// WebhookEventTypes lists the events a webhook can subscribe to.
+var WebhookEventTypes = []string{
+	models.EventFileUploaded,
+	models.EventFileDeleted,
+	models.EventFileShared,
+	models.EventFileMadePublic,
+	models.EventFileMadePrivate,
+	models.EventFileRenamed,
+	models.EventFileSharedWithYou,
+	models.EventQuotaThreshold,
+}
+
+// WebhookInput registers a webhook. An empty Events list subscribes to
+// everything, and a secret is generated when none is given.
+type WebhookInput struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+	Global bool     `json:"global"`
+}
+
+// WebhookService manages webhook registrations and delivers queued events,
+// signing each payload with the webhook's secret.
+type WebhookService struct {
+	repo     *repository.WebhookRepository
+	userRepo *repository.UserRepository
+	interval time.Duration
+	wake     chan struct{}
+	// User webhooks may not reach internal services; global ones,
+	// registered by admins, may.
+	userClient   *http.Client
+	globalClient *http.Client
+}
+
+func NewWebhookService(repo *repository.WebhookRepository, userRepo *repository.UserRepository, interval time.Duration) *WebhookService {
+	return &WebhookService{
+		repo:         repo,
+		userRepo:     userRepo,
+		interval:     interval,
+		wake:         make(chan struct{}, 1),
+		userClient:   webhookClient(true),
+		globalClient: webhookClient(false),
+	}
+}
+
+// nonPublicPrefixes are the special-purpose ranges (RFC 6890 and later) a
+// user webhook may not reach. IPv6 forms that embed an IPv4 address (NAT64,
+// 6to4, Teredo) are refused whole rather than decoded; IPv4-mapped ones are
+// checked as the IPv4 address they map.
+var nonPublicPrefixes = func() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, p := range []string{
+		"0.0.0.0/8",       // "this network"
+		"10.0.0.0/8",      // private
+		"100.64.0.0/10",   // carrier-grade NAT
+		"127.0.0.0/8",     // loopback
+		"169.254.0.0/16",  // link-local, cloud metadata services
+		"172.16.0.0/12",   // private
+		"192.0.0.0/24",    // IETF protocol assignments
+		"192.0.2.0/24",    // documentation
+		"192.88.99.0/24",  // 6to4 relay anycast
+		"192.168.0.0/16",  // private
+		"198.18.0.0/15",   // benchmarking
+		"198.51.100.0/24", // documentation
+		"203.0.113.0/24",  // documentation
+		"224.0.0.0/4",     // multicast
+		"240.0.0.0/4",     // reserved, broadcast
+		"::/128",          // unspecified
+		"::1/128",         // loopback
+		"64:ff9b::/96",    // NAT64
+		"64:ff9b:1::/48",  // local-use NAT64
+		"100::/64",        // discard
+		"2001::/23",       // IETF protocol assignments, Teredo
+		"2001:db8::/32",   // documentation
+		"2002::/16",       // 6to4
+		"fc00::/7",        // unique local
+		"fe80::/10",       // link-local
+		"fec0::/10",       // site-local
+		"ff00::/8",        // multicast
+	} {
+		prefixes = append(prefixes, netip.MustParsePrefix(p))
+	}
+	return prefixes
+}()
+
+// publicAddr reports whether a webhook may be delivered to addr.
+func publicAddr(addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+	for _, p := range nonPublicPrefixes {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// webhookClient never follows redirects, which could otherwise bounce a
+// delivery to an address the dial check would refuse.
+func webhookClient(publicOnly bool) *http.Client {
+	dialer := &net.Dialer{Timeout: webhookTimeout}
+	if publicOnly {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil || !publicAddr(addr) {
+				return errPrivateWebhookTarget
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout:   webhookTimeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, Proxy: nil},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Register creates a webhook and returns it with its secret, which is not
+// shown again.
+func (ws *WebhookService) Register(userID uint, in WebhookInput) (*models.Webhook, string, error) {
+	u, err := url.Parse(strings.TrimSpace(in.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, "", ErrInvalidWebhookURL
+	}
+
+	events, err := normalizeEventTypes(in.Events)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if in.Global {
+		user, err := ws.userRepo.GetByID(userID)
+		if err != nil || !user.IsAdmin {
+			return nil, "", ErrAdminOnly
+		}
+	}
+
+	secret := in.Secret
+	if secret == "" {
+		b := make([]byte, 32)
+		rand.Read(b)
+		secret = hex.EncodeToString(b)
+	} else if len(secret) < minWebhookSecretLen {
+		return nil, "", ErrWeakWebhookSecret
+	}
+
+	hook := &models.Webhook{
+		UserID: userID,
+		Global: in.Global,
+		URL:    u.String(),
+		Secret: secret,
+		Events: strings.Join(events, ","),
+		Active: true,
+	}
+	if err := ws.repo.Create(hook); err != nil {
+		return nil, "", err
+	}
+	return hook, secret, nil
+}
+
+func normalizeEventTypes(events []string) ([]string, error) {
+	out := make([]string, 0, len(events))
+	seen := make(map[string]bool, len(events))
+	for _, e := range events {
+		e = strings.TrimSpace(e)
+		known := false
+		for _, t := range WebhookEventTypes {
+			known = known || t == e
+		}
+		if !known {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownEventType, e)
+		}
+		if !seen[e] {
+			seen[e] = true
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+func (ws *WebhookService) List(userID uint) ([]models.Webhook, error) {
+	return ws.repo.ListByUser(userID)
+}
+
+func (ws *WebhookService) Delete(userID, webhookID uint) error {
+	err := ws.repo.Delete(webhookID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrWebhookNotFound
+	}
+	return err
+}
+
+// Deliveries returns the delivery log of one of the user's webhooks, newest
+// first.
+func (ws *WebhookService) Deliveries(userID, webhookID, beforeID uint, limit int) ([]models.WebhookDelivery, error) {
+	if _, err := ws.repo.Get(webhookID, userID); err != nil {
+		return nil, ErrWebhookNotFound
+	}
+	if limit <= 0 {
+		limit = defaultDeliveryLimit
+	}
+	return ws.repo.ListDeliveries(webhookID, beforeID, min(limit, maxDeliveryLimit))
+}
+
+// Redeliver queues a fresh delivery of a past one's payload, leaving the
+// original in the log.
+func (ws *WebhookService) Redeliver(userID, webhookID, deliveryID uint) (*models.WebhookDelivery, error) {
+	if _, err := ws.repo.Get(webhookID, userID); err != nil {
+		return nil, ErrWebhookNotFound
+	}
+	orig, err := ws.repo.GetDelivery(webhookID, deliveryID)
+	if err != nil {
+		return nil, ErrDeliveryNotFound
+	}
+
+	deliveries := []models.WebhookDelivery{{
+		WebhookID:     orig.WebhookID,
+		EventID:       orig.EventID,
+		EventType:     orig.EventType,
+		Payload:       orig.Payload,
+		Status:        models.DeliveryPending,
+		NextAttemptAt: time.Now(),
+	}}
+	// Created through the slice, so the new ID is filled in
+	if err := ws.repo.CreateDeliveries(deliveries); err != nil {
+		return nil, err
+	}
+	ws.Wake()
+	return &deliveries[0], nil
+}
+
+// Fanout queues a delivery of ev to every subscribed webhook. It is an
+// EventDispatcher subscriber, so deliveries commit with the dispatch.
+func (ws *WebhookService) Fanout(r repository.Repos, ev models.OutboxEvent) error {
+	hooks, err := r.Webhooks.Subscribers(ev.Type, ev.UserID)
+	if err != nil || len(hooks) == 0 {
+		return err
+	}
+	payload, err := json.Marshal(envelope(ev))
+	if err != nil {
+		return err
+	}
+
+	deliveries := make([]models.WebhookDelivery, 0, len(hooks))
+	for _, h := range hooks {
+		deliveries = append(deliveries, models.WebhookDelivery{
+			WebhookID:     h.ID,
+			EventID:       ev.ID,
+			EventType:     ev.Type,
+			Payload:       string(payload),
+			Status:        models.DeliveryPending,
+			NextAttemptAt: time.Now(),
+		})
+	}
+	return r.Webhooks.CreateDeliveries(deliveries)
+}
+
+// Wake asks the worker to deliver now rather than at the next tick.
+func (ws *WebhookService) Wake() {
+	select {
+	case ws.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers due webhooks on every tick or wake-up until ctx is cancelled.
+func (ws *WebhookService) Run(ctx context.Context) {
+	ticker := time.NewTicker(ws.interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			d, err := ws.repo.ClaimDueDelivery(webhookLease)
+			if err != nil {
+				log.Printf("webhooks: %v", err)
+				break
+			}
+			if d == nil {
+				break
+			}
+			ws.deliver(ctx, d)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-ws.wake:
+		}
+	}
+}
+
+func (ws *WebhookService) deliver(ctx context.Context, d *models.WebhookDelivery) {
+	now := time.Now()
+	d.Attempts++
+	d.LastAttemptAt = &now
+
+	hook, err := ws.repo.GetByID(d.WebhookID)
+	switch {
+	case err != nil:
+		// Webhook deleted meanwhile; its deliveries cascade away
+		return
+	case !hook.Active:
+		err = errors.New("webhook disabled")
+		d.Attempts = maxDeliveryAttempts
+	default:
+		var status int
+		status, err = ws.send(ctx, hook, d)
+		if status != 0 {
+			d.LastStatusCode = &status
+		}
+	}
+
+	if err == nil {
+		d.Status = models.DeliverySucceeded
+		d.DeliveredAt = &now
+		d.LastError = nil
+	} else {
+		msg := truncateText(err.Error(), maxWebhookErrorLen)
+		d.LastError = &msg
+		if d.Attempts >= maxDeliveryAttempts {
+			d.Status = models.DeliveryFailed
+		} else {
+			backoff := webhookBaseBackoff << (d.Attempts - 1)
+			if backoff > webhookMaxBackoff || backoff <= 0 {
+				backoff = webhookMaxBackoff
+			}
+			d.NextAttemptAt = now.Add(backoff)
+		}
+	}
+	if err := ws.repo.SaveDelivery(d); err != nil {
+		log.Printf("webhooks: failed to save delivery %d: %v", d.ID, err)
+	}
+}
+
+// send POSTs the payload. The signature is an HMAC-SHA256 over
+// "<timestamp>.<body>", so receivers can reject replays of old payloads.
+func (ws *WebhookService) send(ctx context.Context, hook *models.Webhook, d *models.WebhookDelivery) (int, error) {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	body := []byte(d.Payload)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", webhookUserAgentValue)
+	req.Header.Set(webhookEventHdr, d.EventType)
+	req.Header.Set(webhookDeliveryHdr, strconv.FormatUint(uint64(d.ID), 10))
+	req.Header.Set(webhookTimestampHdr, ts)
+	req.Header.Set(webhookSignatureHdr, "sha256="+SignWebhook(hook.Secret, ts, body))
+
+	client := ws.userClient
+	if hook.Global {
+		client = ws.globalClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// SignWebhook computes the hex HMAC-SHA256 signature receivers should
+// compare against the X-Webhook-Signature header.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"backend/internal/models"
+	"backend/internal/repository"
+)
+
+func TestPublicAddr(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.216.34":          true,
+		"2606:2800:220:1::1":     true,
+		"::ffff:93.184.216.34":   true,
+		"0.0.0.0":                false,
+		"0.1.2.3":                false,
+		"10.1.2.3":               false,
+		"100.64.0.1":             false,
+		"100.127.255.254":        false,
+		"127.0.0.1":              false,
+		"169.254.169.254":        false,
+		"172.16.0.1":             false,
+		"192.168.1.1":            false,
+		"198.18.0.1":             false,
+		"224.0.0.1":              false,
+		"255.255.255.255":        false,
+		"::":                     false,
+		"::1":                    false,
+		"::ffff:127.0.0.1":       false,
+		"::ffff:10.0.0.1":        false,
+		"::ffff:169.254.169.254": false,
+		"64:ff9b::a9fe:a9fe":     false,
+		"64:ff9b:1::a00:1":       false,
+		"2001:0:4136:e378::1":    false,
+		"2002:a00:1::1":          false,
+		"fd00::1":                false,
+		"fe80::1%eth0":           false,
+		"ff02::1":                false,
+	} {
+		if got := publicAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("publicAddr(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestSignWebhook(t *testing.T) {
+	// A receiver computes HMAC-SHA256("<timestamp>.<body>") with the secret
+	got := SignWebhook("0123456789abcdef", "1700000000", []byte(`{"id":1}`))
+	if want := "4bcaced68dfea90a68df035b89cb7fb26692d899d32a1ccb1b0616cf48e4d1ed"; got != want {
+		t.Errorf("signature %s, want %s", got, want)
+	}
+}
+
+func TestUserWebhookCannotReachLoopback(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, nil)
+	if _, err := webhookClient(true).Do(req); err == nil || !strings.Contains(err.Error(), errPrivateWebhookTarget.Error()) {
+		t.Errorf("user client reached %s: %v", srv.URL, err)
+	}
+	resp, err := webhookClient(false).Do(req)
+	if err != nil {
+		t.Fatalf("global client: %v", err)
+	}
+	resp.Body.Close()
+}
+
+// webhookReceiver records the deliveries it gets, answering each with the
+// next status in statuses, then 200.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	statuses []int
+	got      []*http.Request
+	bodies   [][]byte
+}
+
+func (wr *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	wr.got = append(wr.got, r)
+	wr.bodies = append(wr.bodies, body)
+	if len(wr.statuses) > 0 {
+		w.WriteHeader(wr.statuses[0])
+		wr.statuses = wr.statuses[1:]
+	}
+}
+
+// deliverDue delivers every due webhook delivery, as one pass of Run does.
+func deliverDue(t *testing.T, ws *WebhookService) {
+	t.Helper()
+	for {
+		d, err := ws.repo.ClaimDueDelivery(webhookLease)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d == nil {
+			return
+		}
+		ws.deliver(context.Background(), d)
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	conn := openTestDB(t)
+	files := newTestFileService(t, conn)
+	admin := createTestUsers(t, conn, 1)[0]
+	conn.Model(&models.User{}).Where("id = ?", admin.ID).Update("is_admin", true)
+
+	ws := NewWebhookService(repository.NewWebhookRepository(conn), repository.NewUserRepository(conn), time.Hour)
+	dispatcher := NewEventDispatcher(repository.NewTxManager(conn), time.Hour)
+	dispatcher.Subscribe(ws.Fanout)
+
+	// Only a global webhook may be delivered to the loopback test server
+	receiver := &webhookReceiver{statuses: []int{http.StatusInternalServerError}}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+	hook, secret, err := ws.Register(admin.ID, WebhookInput{
+		URL: srv.URL, Events: []string{models.EventFileUploaded}, Global: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uf, err := files.ProcessFileUpload(admin.ID, "", "a.txt", strings.NewReader("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dispatcher.Drain(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first attempt fails and is retried later
+	deliverDue(t, ws)
+	deliveries, err := ws.Deliveries(admin.ID, hook.ID, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("%d deliveries, want 1", len(deliveries))
+	}
+	d := deliveries[0]
+	if d.Status != models.DeliveryPending || d.Attempts != 1 || d.LastStatusCode == nil || *d.LastStatusCode != 500 {
+		t.Fatalf("after a failed attempt: %+v", d)
+	}
+	if !d.NextAttemptAt.After(time.Now()) {
+		t.Errorf("retry due at %v, want a backoff", d.NextAttemptAt)
+	}
+	deliverDue(t, ws)
+	if len(receiver.got) != 1 {
+		t.Fatalf("retried before its backoff: %d requests", len(receiver.got))
+	}
+
+	conn.Model(&models.WebhookDelivery{}).Where("id = ?", d.ID).Update("next_attempt_at", time.Now())
+	deliverDue(t, ws)
+	if len(receiver.got) != 2 {
+		t.Fatalf("%d requests, want the retry", len(receiver.got))
+	}
+	got, _ := ws.repo.GetDelivery(hook.ID, d.ID)
+	if got.Status != models.DeliverySucceeded || got.Attempts != 2 || got.DeliveredAt == nil {
+		t.Errorf("after the retry: %+v", got)
+	}
+
+	// Each request is signed over its timestamp and body
+	req, body := receiver.got[1], receiver.bodies[1]
+	sig := "sha256=" + SignWebhook(secret, req.Header.Get("X-Webhook-Timestamp"), body)
+	if req.Header.Get("X-Webhook-Signature") != sig {
+		t.Errorf("signature %q, want %q", req.Header.Get("X-Webhook-Signature"), sig)
+	}
+	if req.Header.Get("X-Webhook-Event") != models.EventFileUploaded {
+		t.Errorf("event header %q", req.Header.Get("X-Webhook-Event"))
+	}
+	var env struct {
+		Type string `json:"type"`
+		Data struct {
+			UserFileID uint `json:"user_file_id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &env); err != nil {
+		t.Fatal(err)
+	}
+	if env.Type != models.EventFileUploaded || env.Data.UserFileID != uf.ID {
+		t.Errorf("payload %s", body)
+	}
+
+	// Redelivery sends the same payload as a new delivery
+	again, err := ws.Redeliver(admin.ID, hook.ID, d.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliverDue(t, ws)
+	if len(receiver.got) != 3 || string(receiver.bodies[2]) != string(body) {
+		t.Fatalf("redelivery sent %d requests", len(receiver.got))
+	}
+	if receiver.got[2].Header.Get("X-Webhook-Delivery") == receiver.got[1].Header.Get("X-Webhook-Delivery") {
+		t.Error("redelivery reused the delivery ID")
+	}
+	if got, _ := ws.repo.GetDelivery(hook.ID, again.ID); got.Status != models.DeliverySucceeded {
+		t.Errorf("redelivery: %+v", got)
+	}
+}
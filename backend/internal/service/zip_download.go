@@ -0,0 +1,154 @@
+package service
+
+import (
+	"archive/zip"
+	"backend/internal/repository"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+const defaultMaxZipDownloadSize = 2 << 30 // 2 GiB
+
+var (
+	ErrNothingToDownload = errors.New("no files selected")
+	ErrDownloadTooLarge  = errors.New("selection exceeds download size limit")
+)
+
+// ZipEntry is one file to be written into a streamed ZIP download.
+type ZipEntry struct {
+	Name        string // path inside the archive, unique within it
+	Size        int64
+	StoragePath string
+	UserFileID  uint
+	FileID      uint
+}
+
+// PrepareZipDownload resolves a selection of user files, either explicit
+// user_files IDs or everything under folder, into archive entries. Every ID
+// must belong to userID. Entry names are the user-visible file names, made
+// unique within the archive, and paths under folder are kept relative to it.
+func (fs *FileService) PrepareZipDownload(userID uint, ids []uint, folder string) ([]ZipEntry, error) {
+	var (
+		rows []repository.ArchiveRow
+		err  error
+	)
+	if len(ids) > 0 {
+		rows, err = fs.userFileRepo.GetArchiveRowsByIDs(userID, ids)
+		if err == nil && len(rows) != len(uniqueIDs(ids)) {
+			return nil, errors.New("file not found")
+		}
+	} else {
+		folder, err = normalizeFolder(folder)
+		if err != nil {
+			return nil, err
+		}
+		rows, err = fs.userFileRepo.GetArchiveRowsInFolder(userID, folder)
+	}
+	if err != nil {
+		return nil, errors.New("failed to load files")
+	}
+	if len(rows) == 0 {
+		return nil, ErrNothingToDownload
+	}
+
+	var total int64
+	seen := make(map[string]bool, len(rows))
+	entries := make([]ZipEntry, 0, len(rows))
+	downloaded := make([]uint, 0, len(rows))
+	for _, r := range rows {
+		total += r.Size
+		if total > fs.maxZipDownloadSize() {
+			return nil, ErrDownloadTooLarge
+		}
+
+		name := r.FileName
+		if len(ids) == 0 {
+			// Keep the layout below the requested folder
+			rel := strings.TrimPrefix(strings.TrimPrefix(r.Folder, folder), "/")
+			name = path.Join(rel, r.FileName)
+		}
+		entries = append(entries, ZipEntry{
+			Name:        uniqueEntryName(name, seen),
+			Size:        r.Size,
+			StoragePath: r.StoragePath,
+			UserFileID:  r.UserFileID,
+			FileID:      r.FileID,
+		})
+		downloaded = append(downloaded, r.UserFileID)
+	}
+
+	if err := fs.userFileRepo.IncrementDownloadTimesBatch(downloaded); err != nil {
+		fmt.Printf("Warning: failed to increment download count: %v\n", err)
+	}
+	return entries, nil
+}
+
+// WriteZip streams entries into a ZIP archive on w without buffering whole
+// files or touching temporary storage. It returns how many entries were
+// written in full before any error.
+func WriteZip(w io.Writer, entries []ZipEntry) (int, error) {
+	zw := zip.NewWriter(w)
+	for i, e := range entries {
+		if err := writeZipEntry(zw, e); err != nil {
+			return i, err
+		}
+	}
+	return len(entries), zw.Close()
+}
+
+func writeZipEntry(zw *zip.Writer, e ZipEntry) error {
+	src, err := os.Open(e.StoragePath)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", e.Name, err)
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	hdr.Name = e.Name
+	hdr.Method = zip.Deflate
+
+	dst, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// uniqueEntryName appends " (n)" before the extension until name is unused.
+func uniqueEntryName(name string, seen map[string]bool) string {
+	candidate := name
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for n := 1; seen[strings.ToLower(candidate)]; n++ {
+		candidate = fmt.Sprintf("%s (%d)%s", base, n, ext)
+	}
+	seen[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+func uniqueIDs(ids []uint) map[uint]bool {
+	set := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+func (fs *FileService) maxZipDownloadSize() int64 {
+	if fs.config.MaxZipDownloadSize > 0 {
+		return fs.config.MaxZipDownloadSize
+	}
+	return defaultMaxZipDownloadSize
+}
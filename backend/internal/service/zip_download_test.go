@@ -0,0 +1,93 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestZipDownloadOfFolder(t *testing.T) {
+	conn := openTestDB(t)
+	svc := newTestFileService(t, conn)
+	user := createTestUsers(t, conn, 1)[0]
+
+	files := []struct{ folder, name, content string }{
+		{"docs", "a.txt", "first a"},
+		{"docs", "a.txt", "second a"},
+		{"docs/sub", "b.txt", "b"},
+		{"other", "c.txt", "not selected"},
+	}
+	for _, f := range files {
+		if _, err := svc.ProcessFileUpload(user.ID, f.folder, f.name, strings.NewReader(f.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := svc.PrepareZipDownload(user.ID, nil, "/docs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if n, err := WriteZip(&buf, entries); err != nil || n != len(entries) {
+		t.Fatalf("wrote %d of %d entries: %v", n, len(entries), err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(rc)
+		rc.Close()
+		got[f.Name] = string(b)
+	}
+	var names []string
+	for name := range got {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// Paths stay relative to the folder, and the second a.txt is renamed
+	if strings.Join(names, ",") != "a (1).txt,a.txt,sub/b.txt" {
+		t.Fatalf("archive holds %v", names)
+	}
+	as := []string{got["a.txt"], got["a (1).txt"]}
+	sort.Strings(as)
+	if got["sub/b.txt"] != "b" || as[0] != "first a" || as[1] != "second a" {
+		t.Errorf("archive contents %v", got)
+	}
+}
+
+func TestZipDownloadSizeLimit(t *testing.T) {
+	conn := openTestDB(t)
+	svc := newTestFileService(t, conn)
+	user := createTestUsers(t, conn, 1)[0]
+	svc.config.MaxZipDownloadSize = 10
+
+	var ids []uint
+	for _, name := range []string{"a.txt", "b.txt"} {
+		uf, err := svc.ProcessFileUpload(user.ID, "", name, strings.NewReader("six b."))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, uf.ID)
+	}
+
+	if _, err := svc.PrepareZipDownload(user.ID, ids[:1], ""); err != nil {
+		t.Errorf("one file under the limit: %v", err)
+	}
+	if _, err := svc.PrepareZipDownload(user.ID, ids, ""); !errors.Is(err, ErrDownloadTooLarge) {
+		t.Errorf("two files over the limit: %v, want ErrDownloadTooLarge", err)
+	}
+	if _, err := svc.PrepareZipDownload(user.ID, nil, "empty"); !errors.Is(err, ErrNothingToDownload) {
+		t.Errorf("empty folder: %v, want ErrNothingToDownload", err)
+	}
+}
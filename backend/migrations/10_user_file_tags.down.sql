@@ -0,0 +1,3 @@
+DROP TABLE user_file_metadata;
+
+DROP TABLE user_file_tags;
@@ -0,0 +1,16 @@
+CREATE TABLE user_file_tags (
+    user_file_id INT NOT NULL REFERENCES user_files(id) ON DELETE CASCADE,
+    tag          TEXT NOT NULL,
+    PRIMARY KEY (user_file_id, tag)
+);
+
+CREATE INDEX idx_user_file_tags_tag ON user_file_tags(tag);
+
+CREATE TABLE user_file_metadata (
+    user_file_id INT NOT NULL REFERENCES user_files(id) ON DELETE CASCADE,
+    key          TEXT NOT NULL,
+    value        TEXT NOT NULL,
+    PRIMARY KEY (user_file_id, key)
+);
+
+CREATE INDEX idx_user_file_metadata_key_value ON user_file_metadata(key, value);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_user_files_user_file_name;
+DROP INDEX IF EXISTS idx_user_files_user_uploaded_at;
+
+ALTER TABLE user_files ALTER COLUMN download_times DROP NOT NULL;
+ALTER TABLE user_files ALTER COLUMN uploaded_at DROP NOT NULL;
@@ -0,0 +1,9 @@
+-- Keyset pagination compares (sort column, id) row values, which never match NULLs
+UPDATE user_files SET uploaded_at = NOW() WHERE uploaded_at IS NULL;
+UPDATE user_files SET download_times = 0 WHERE download_times IS NULL;
+
+ALTER TABLE user_files ALTER COLUMN uploaded_at SET NOT NULL;
+ALTER TABLE user_files ALTER COLUMN download_times SET NOT NULL;
+
+CREATE INDEX idx_user_files_user_uploaded_at ON user_files(user_id, uploaded_at, id);
+CREATE INDEX idx_user_files_user_file_name ON user_files(user_id, file_name, id);
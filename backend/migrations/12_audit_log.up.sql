@@ -0,0 +1,39 @@
+ALTER TABLE users ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE audit_log (
+    id           BIGSERIAL PRIMARY KEY,
+    created_at   TIMESTAMPTZ NOT NULL,
+    actor_id     INT,
+    actor_name   TEXT NOT NULL DEFAULT '',
+    action       TEXT NOT NULL,
+    user_file_id INT,
+    file_id      INT,
+    ip           TEXT NOT NULL DEFAULT '',
+    user_agent   TEXT NOT NULL DEFAULT '',
+    outcome      TEXT NOT NULL,
+    status       INT NOT NULL,
+    detail       TEXT NOT NULL DEFAULT '',
+    prev_hash    TEXT NOT NULL,
+    hash         TEXT NOT NULL
+);
+
+-- No foreign keys: entries must outlive the users and files they mention
+CREATE INDEX idx_audit_log_actor ON audit_log(actor_id, id);
+CREATE INDEX idx_audit_log_action ON audit_log(action, id);
+CREATE INDEX idx_audit_log_user_file ON audit_log(user_file_id, id);
+CREATE INDEX idx_audit_log_file ON audit_log(file_id, id);
+CREATE INDEX idx_audit_log_created_at ON audit_log(created_at);
+
+CREATE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_no_update_delete
+    BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+CREATE TRIGGER audit_log_no_truncate
+    BEFORE TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
@@ -0,0 +1,17 @@
+CREATE TABLE download_events (
+    id           BIGSERIAL PRIMARY KEY,
+    user_file_id INT NOT NULL REFERENCES user_files(id) ON DELETE CASCADE,
+    file_id      INT NOT NULL,
+    link_id      TEXT,
+    user_id      INT,
+    via          TEXT NOT NULL,
+    ip           TEXT NOT NULL DEFAULT '',
+    user_agent   TEXT NOT NULL DEFAULT '',
+    referrer     TEXT NOT NULL DEFAULT '',
+    bytes_sent   BIGINT NOT NULL,
+    completed    BOOLEAN NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_download_events_user_file ON download_events(user_file_id, created_at);
+CREATE INDEX idx_download_events_link ON download_events(link_id, created_at) WHERE link_id IS NOT NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS personal_tokens;
+DROP TABLE IF EXISTS user_folders;
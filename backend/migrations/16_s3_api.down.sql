@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS multipart_parts;
+DROP TABLE IF EXISTS multipart_uploads;
+DROP TABLE IF EXISTS access_keys;
@@ -0,0 +1,30 @@
+CREATE TABLE access_keys (
+    id            SERIAL PRIMARY KEY,
+    user_id       INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    access_key_id TEXT NOT NULL UNIQUE,
+    secret        TEXT NOT NULL,
+    name          TEXT NOT NULL DEFAULT '',
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at  TIMESTAMPTZ
+);
+
+CREATE INDEX idx_access_keys_user_id ON access_keys(user_id);
+
+CREATE TABLE multipart_uploads (
+    id         TEXT PRIMARY KEY,
+    user_id    INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    key        TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_multipart_uploads_user_id ON multipart_uploads(user_id);
+CREATE INDEX idx_multipart_uploads_created_at ON multipart_uploads(created_at);
+
+CREATE TABLE multipart_parts (
+    upload_id   TEXT NOT NULL REFERENCES multipart_uploads(id) ON DELETE CASCADE,
+    part_number INT NOT NULL,
+    size        BIGINT NOT NULL,
+    etag        TEXT NOT NULL,
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (upload_id, part_number)
+);
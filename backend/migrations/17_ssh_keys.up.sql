@@ -0,0 +1,11 @@
+CREATE TABLE ssh_keys (
+    id           SERIAL PRIMARY KEY,
+    user_id      INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name         TEXT NOT NULL DEFAULT '',
+    fingerprint  TEXT NOT NULL UNIQUE,
+    public_key   TEXT NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_ssh_keys_user_id ON ssh_keys(user_id);
@@ -0,0 +1,38 @@
+-- Per-user change counter. Bumping it locks the user's row, so changes get
+-- sequence numbers in commit order and a cursor never skips one.
+ALTER TABLE users ADD COLUMN change_seq BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE file_changes (
+    id           BIGSERIAL PRIMARY KEY,
+    user_id      INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    seq          BIGINT NOT NULL,
+    op           TEXT NOT NULL, -- created, modified or deleted
+    folder       TEXT NOT NULL,
+    file_name    TEXT NOT NULL,
+    user_file_id INT,
+    file_id      INT,
+    hash         TEXT NOT NULL DEFAULT '',
+    size         BIGINT NOT NULL DEFAULT 0,
+    changed_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE(user_id, seq)
+);
+
+CREATE INDEX idx_file_changes_path ON file_changes(user_id, folder, file_name, seq DESC);
+
+-- Start every existing user's feed with their current files, so reading it
+-- from the beginning rebuilds the whole tree
+INSERT INTO file_changes (user_id, seq, op, folder, file_name, user_file_id, file_id, hash, size, changed_at)
+SELECT user_id, ROW_NUMBER() OVER (PARTITION BY user_id ORDER BY folder, file_name),
+       'created', folder, file_name, user_file_id, file_id, hash, size, uploaded_at
+FROM (
+    SELECT DISTINCT ON (uf.user_id, uf.folder, uf.file_name)
+           uf.user_id, uf.folder, uf.file_name, uf.id AS user_file_id, f.id AS file_id,
+           f.hash, f.size, uf.uploaded_at
+    FROM user_files uf
+    JOIN files f ON f.id = uf.file_id
+    ORDER BY uf.user_id, uf.folder, uf.file_name, uf.uploaded_at DESC, uf.id DESC
+) AS newest;
+
+UPDATE users u SET change_seq = c.max_seq
+FROM (SELECT user_id, MAX(seq) AS max_seq FROM file_changes GROUP BY user_id) AS c
+WHERE c.user_id = u.id;
@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN disabled_at TIMESTAMPTZ;
+-- NULL uses the server-wide USER_STORAGE_QUOTA_MB
+ALTER TABLE users ADD COLUMN quota_bytes BIGINT;
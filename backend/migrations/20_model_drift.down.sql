@@ -0,0 +1,7 @@
+ALTER TABLE files ALTER COLUMN ref_count DROP NOT NULL;
+ALTER TABLE files ALTER COLUMN ref_count TYPE INT;
+
+ALTER TABLE user_files ALTER COLUMN visibility DROP NOT NULL;
+ALTER TABLE user_files ALTER COLUMN is_owner DROP NOT NULL;
+ALTER TABLE user_files ALTER COLUMN file_name DROP NOT NULL;
+ALTER TABLE user_files ALTER COLUMN file_name TYPE VARCHAR(255);
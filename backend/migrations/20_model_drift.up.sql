@@ -0,0 +1,16 @@
+-- Bring columns created before the models settled in line with them
+UPDATE user_files SET file_name = '' WHERE file_name IS NULL;
+UPDATE user_files SET is_owner = FALSE WHERE is_owner IS NULL;
+UPDATE user_files SET visibility = 'private' WHERE visibility IS NULL;
+
+ALTER TABLE user_files ALTER COLUMN file_name TYPE TEXT;
+ALTER TABLE user_files ALTER COLUMN file_name SET NOT NULL;
+ALTER TABLE user_files ALTER COLUMN is_owner SET NOT NULL;
+ALTER TABLE user_files ALTER COLUMN visibility SET NOT NULL;
+
+UPDATE files f SET ref_count = (
+    SELECT COUNT(*) FROM user_files uf WHERE uf.file_id = f.id
+) WHERE ref_count IS NULL;
+
+ALTER TABLE files ALTER COLUMN ref_count TYPE BIGINT;
+ALTER TABLE files ALTER COLUMN ref_count SET NOT NULL;
@@ -0,0 +1,3 @@
+-- Fails while any user still holds the same content at two paths
+DROP INDEX IF EXISTS idx_user_files_user_file;
+ALTER TABLE user_files ADD CONSTRAINT user_files_user_id_file_id_key UNIQUE(user_id, file_id);
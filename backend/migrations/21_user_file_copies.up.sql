@@ -0,0 +1,4 @@
+-- A user may keep the same content at several paths, as WebDAV, S3 and
+-- SFTP clients expect; uploads through the API still reject it
+ALTER TABLE user_files DROP CONSTRAINT IF EXISTS user_files_user_id_file_id_key;
+CREATE INDEX IF NOT EXISTS idx_user_files_user_file ON user_files(user_id, file_id);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_files_hash;
+DROP TABLE IF EXISTS blob_deletions;
+-- Fails while any user still holds the same content at two paths
+DROP INDEX IF EXISTS idx_user_files_user_file;
+ALTER TABLE user_files DROP CONSTRAINT IF EXISTS user_files_user_id_file_id_key;
+ALTER TABLE user_files ADD CONSTRAINT user_files_user_id_file_id_key UNIQUE(user_id, file_id);
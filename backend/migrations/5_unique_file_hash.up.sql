@@ -0,0 +1,64 @@
+-- A user may now reference one content at several paths: racing uploads
+-- by the same user left two rows of it, and both files stay
+ALTER TABLE user_files DROP CONSTRAINT IF EXISTS user_files_user_id_file_id_key;
+CREATE INDEX IF NOT EXISTS idx_user_files_user_file ON user_files(user_id, file_id);
+
+-- The queue the blob deletion worker drains; the dropped rows' blobs go
+-- into it below
+CREATE TABLE IF NOT EXISTS blob_deletions (
+    id              SERIAL PRIMARY KEY,
+    hash            TEXT NOT NULL,
+    storage_path    TEXT NOT NULL,
+    attempts        INT NOT NULL DEFAULT 0,
+    last_error      TEXT,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Collapse duplicate content rows created by racing uploads onto the oldest row
+CREATE TEMP TABLE file_dupes ON COMMIT DROP AS
+SELECT id, MIN(id) OVER (PARTITION BY hash) AS keep_id FROM files;
+
+CREATE TEMP TABLE affected_users ON COMMIT DROP AS
+SELECT DISTINCT uf.user_id FROM user_files uf
+JOIN file_dupes d ON d.id = uf.file_id AND d.id <> d.keep_id;
+
+UPDATE user_files uf SET file_id = d.keep_id
+FROM file_dupes d
+WHERE uf.file_id = d.id AND d.id <> d.keep_id;
+
+-- The content is stored once now, so only its oldest owner pays for it
+UPDATE user_files uf SET is_owner = FALSE
+FROM (
+    SELECT file_id, MIN(id) AS owner_id FROM user_files
+    WHERE is_owner AND file_id IN (SELECT keep_id FROM file_dupes WHERE id <> keep_id)
+    GROUP BY file_id
+) o
+WHERE uf.file_id = o.file_id AND uf.is_owner AND uf.id <> o.owner_id;
+
+-- The dropped rows' content lives on under the kept row's hash, so their
+-- blobs are queued without one and only the path is checked before deleting
+INSERT INTO blob_deletions (hash, storage_path)
+SELECT '', f.storage_path FROM files f
+JOIN file_dupes d ON f.id = d.id AND d.id <> d.keep_id;
+
+DELETE FROM files f USING file_dupes d
+WHERE f.id = d.id AND d.id <> d.keep_id;
+
+UPDATE files f SET ref_count = (
+    SELECT COUNT(*) FROM user_files uf WHERE uf.file_id = f.id
+);
+
+UPDATE users u SET actual_storage = s.actual, expected_storage = s.expected
+FROM (
+    SELECT a.user_id,
+           COALESCE(SUM(f.size) FILTER (WHERE uf.is_owner), 0) AS actual,
+           COALESCE(SUM(f.size), 0) AS expected
+    FROM affected_users a
+    LEFT JOIN user_files uf ON uf.user_id = a.user_id
+    LEFT JOIN files f ON f.id = uf.file_id
+    GROUP BY a.user_id
+) s
+WHERE u.id = s.user_id;
+
+CREATE UNIQUE INDEX idx_files_hash ON files(hash);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_blob_deletions_next_attempt_at;
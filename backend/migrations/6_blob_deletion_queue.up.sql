@@ -0,0 +1,13 @@
+-- 5_unique_file_hash creates the queue; databases migrated before it did
+-- get it here
+CREATE TABLE IF NOT EXISTS blob_deletions (
+    id              SERIAL PRIMARY KEY,
+    hash            TEXT NOT NULL,
+    storage_path    TEXT NOT NULL,
+    attempts        INT NOT NULL DEFAULT 0,
+    last_error      TEXT,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_blob_deletions_next_attempt_at ON blob_deletions(next_attempt_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_user_files_user_folder;
+
+ALTER TABLE user_files DROP COLUMN folder;
@@ -0,0 +1,3 @@
+ALTER TABLE user_files ADD COLUMN folder TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX idx_user_files_user_folder ON user_files(user_id, folder);
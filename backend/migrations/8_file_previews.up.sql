@@ -0,0 +1,12 @@
+CREATE TABLE file_previews (
+    hash       TEXT PRIMARY KEY,
+    status     TEXT NOT NULL DEFAULT 'pending',
+    kind       TEXT NOT NULL DEFAULT '',
+    sizes      TEXT NOT NULL DEFAULT '',
+    snippet    TEXT,
+    error      TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_file_previews_status ON file_previews(status);
@@ -0,0 +1,12 @@
+CREATE TABLE file_contents (
+    hash       TEXT PRIMARY KEY,
+    status     TEXT NOT NULL DEFAULT 'pending',
+    content    TEXT,
+    error      TEXT,
+    tsv        TSVECTOR GENERATED ALWAYS AS (to_tsvector('english', COALESCE(content, ''))) STORED,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_file_contents_status ON file_contents(status);
+CREATE INDEX idx_file_contents_tsv ON file_contents USING GIN(tsv);
@@ -0,0 +1,9 @@
+// Package migrations holds the numbered SQL migrations, embedded so that
+// every binary carries the schema it expects. Each change is a pair of
+// files, N_name.up.sql and N_name.down.sql.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,73 @@
+package vaultclient
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Operations in the change feed.
+const (
+	ChangeCreated  = "created"
+	ChangeModified = "modified"
+	ChangeDeleted  = "deleted"
+)
+
+// Change says that the file at a path appeared, changed or went away. For
+// created and modified paths it describes the file now there; only the
+// newest file at a path counts. A rename shows up as the old path deleted
+// and the new one created, with the same ID and hash.
+type Change struct {
+	Seq       int64     `json:"seq"`
+	Op        string    `json:"op"`
+	Folder    string    `json:"folder"`
+	Filename  string    `json:"filename"`
+	ID        uint      `json:"id,omitempty"`
+	FileID    uint      `json:"file_id,omitempty"`
+	Hash      string    `json:"hash,omitempty"` // hex SHA-256 of the content
+	Size      int64     `json:"size"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// Path returns the changed path, folder and name joined with "/".
+func (ch *Change) Path() string {
+	if ch.Folder == "" {
+		return ch.Filename
+	}
+	return ch.Folder + "/" + ch.Filename
+}
+
+// ChangePage is one page of the change feed. Pass Cursor to the next call
+// to continue after it.
+type ChangePage struct {
+	Changes []Change `json:"changes"`
+	Cursor  int64    `json:"cursor"`
+	HasMore bool     `json:"has_more"`
+}
+
+// Changes returns the changes after cursor, oldest first, at most limit of
+// them (0 for the server's default). Cursor 0 replays every file the user
+// holds, so a client starting from nothing can build its view of the tree.
+func (c *Client) Changes(ctx context.Context, cursor int64, limit int) (*ChangePage, error) {
+	q := url.Values{"cursor": {strconv.FormatInt(cursor, 10)}}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	var page ChangePage
+	if err := c.do(ctx, http.MethodGet, "/api/changes", q, nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// LatestCursor returns the current end of the change feed, for clients that
+// only want changes from now on.
+func (c *Client) LatestCursor(ctx context.Context) (int64, error) {
+	var page ChangePage
+	if err := c.do(ctx, http.MethodGet, "/api/changes", url.Values{"cursor": {"latest"}}, nil, &page); err != nil {
+		return 0, err
+	}
+	return page.Cursor, nil
+}
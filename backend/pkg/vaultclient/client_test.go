@@ -0,0 +1,396 @@
+package vaultclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeVault imitates the parts of the server's API the client uses.
+type fakeVault struct {
+	t *testing.T
+
+	mu        sync.Mutex
+	files     map[uint][]byte // by file ID
+	parts     map[int][]byte  // parts of the one resumable upload
+	partPuts  []int           // part numbers received, in order
+	failPart  int             // answer 500 to this part number once
+	lastQuery map[string]string
+	auth      []string // credentials seen, "cookie:..." or "bearer:..."
+}
+
+func newFakeVault(t *testing.T) (*fakeVault, *Client) {
+	t.Helper()
+	fv := &fakeVault{t: t, files: map[uint][]byte{}, parts: map[int][]byte{}}
+	srv := httptest.NewServer(fv.routes())
+	t.Cleanup(srv.Close)
+	c, err := New(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fv, c
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// authed records the request's credentials and rejects it without any.
+func (fv *fakeVault) authed(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var cred string
+		if b, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			cred = "bearer:" + b
+		} else if ck, err := r.Cookie(SessionCookie); err == nil {
+			cred = "cookie:" + ck.Value
+		}
+		if cred == "" {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing auth token"})
+			return
+		}
+		fv.mu.Lock()
+		fv.auth = append(fv.auth, cred)
+		fv.mu.Unlock()
+		h(w, r)
+	}
+}
+
+func (fv *fakeVault) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/login", func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Username, Password string }
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Password != "secret" {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid credentials"})
+			return
+		}
+		// Like the real server, scope the cookie to a host the test does
+		// not dial
+		http.SetCookie(w, &http.Cookie{Name: SessionCookie, Value: "jwt-for-" + req.Username, Domain: "localhost", Path: "/"})
+		writeJSON(w, http.StatusOK, map[string]any{"success": true, "user_id": 7})
+	})
+	mux.HandleFunc("GET /api/me", fv.authed(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"id": 7, "username": "alice", "is_admin": false})
+	}))
+	mux.HandleFunc("POST /api/upload", fv.authed(func(w http.ResponseWriter, r *http.Request) {
+		f, hdr, err := r.FormFile("file")
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "no file provided"})
+			return
+		}
+		data, _ := io.ReadAll(f)
+		if bytes.Equal(data, []byte("too much")) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "storage quota exceeded: 10 bytes left", "code": "quota_exceeded"})
+			return
+		}
+		fv.mu.Lock()
+		id := uint(len(fv.files) + 1)
+		fv.files[id] = data
+		fv.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]any{"id": id, "file_id": id, "filename": hdr.Filename, "folder": r.URL.Query().Get("folder")})
+	}))
+	mux.HandleFunc("GET /api/files", fv.authed(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		fv.mu.Lock()
+		fv.lastQuery = map[string]string{}
+		for k := range q {
+			fv.lastQuery[k] = q.Get(k)
+		}
+		fv.mu.Unlock()
+		if q.Get("minSize") == "-1" {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid filter", "code": "invalid_filter", "fields": map[string]string{"minSize": "must be a non-negative integer"}})
+			return
+		}
+		// Five files, served in pages of limit
+		start, _ := strconv.Atoi(q.Get("cursor"))
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		end := min(start+limit, 5)
+		var files []map[string]any
+		for i := start; i < end; i++ {
+			files = append(files, map[string]any{"id": i + 1, "file_id": i + 1, "filename": fmt.Sprintf("f%d.txt", i+1), "public": "no"})
+		}
+		page := map[string]any{"files": files, "has_more": end < 5}
+		if end < 5 {
+			page["next_cursor"] = strconv.Itoa(end)
+		}
+		writeJSON(w, http.StatusOK, page)
+	}))
+	mux.HandleFunc("GET /api/files/{id}/download", fv.authed(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(r.PathValue("id"))
+		fv.mu.Lock()
+		data, ok := fv.files[uint(id)]
+		fv.mu.Unlock()
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "File not found or access denied"})
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Write(data)
+	}))
+	mux.HandleFunc("PATCH /api/files/{id}/visibility", fv.authed(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(r.PathValue("id"))
+		if r.URL.Query().Get("make_public") != "true" {
+			writeJSON(w, http.StatusOK, map[string]any{"file_id": id, "visibility": "private", "public_link": ""})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"file_id": id, "visibility": "public", "public_link": "/download/tok123"})
+	}))
+	mux.HandleFunc("GET /api/storage-stats", fv.authed(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"total_storage": 100, "original_storage": 150, "savings": 50})
+	}))
+
+	mux.HandleFunc("POST /api/uploads", fv.authed(func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Folder, Filename string }
+		json.NewDecoder(r.Body).Decode(&req)
+		writeJSON(w, http.StatusCreated, map[string]any{"upload_id": "up1", "folder": req.Folder, "filename": req.Filename})
+	}))
+	mux.HandleFunc("GET /api/uploads/{id}", fv.authed(func(w http.ResponseWriter, r *http.Request) {
+		fv.mu.Lock()
+		defer fv.mu.Unlock()
+		parts := []Part{}
+		for n, data := range fv.parts {
+			parts = append(parts, Part{PartNumber: n, Size: int64(len(data)), ETag: md5Hex(data)})
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"upload_id": r.PathValue("id"), "parts": parts})
+	}))
+	mux.HandleFunc("PUT /api/uploads/{id}/parts/{n}", fv.authed(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.PathValue("n"))
+		data, _ := io.ReadAll(r.Body)
+		fv.mu.Lock()
+		defer fv.mu.Unlock()
+		fv.partPuts = append(fv.partPuts, n)
+		if n == fv.failPart {
+			fv.failPart = 0
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Upload failed"})
+			return
+		}
+		fv.parts[n] = data
+		writeJSON(w, http.StatusOK, map[string]any{"part_number": n, "etag": md5Hex(data)})
+	}))
+	mux.HandleFunc("POST /api/uploads/{id}/complete", fv.authed(func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Parts []Part }
+		json.NewDecoder(r.Body).Decode(&req)
+		fv.mu.Lock()
+		defer fv.mu.Unlock()
+		var all []byte
+		for _, p := range req.Parts {
+			data, ok := fv.parts[p.PartNumber]
+			if !ok || md5Hex(data) != p.ETag {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "part missing or its ETag does not match"})
+				return
+			}
+			all = append(all, data...)
+		}
+		id := uint(len(fv.files) + 1)
+		fv.files[id] = all
+		writeJSON(w, http.StatusOK, map[string]any{"id": id, "file_id": id, "filename": "big.bin"})
+	}))
+	return mux
+}
+
+func md5Hex(b []byte) string {
+	sum := md5.Sum(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestLoginKeepsSessionCookie(t *testing.T) {
+	fv, c := newFakeVault(t)
+	ctx := context.Background()
+
+	if _, err := c.Login(ctx, "alice", "wrong"); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("bad password: got %v, want ErrUnauthorized", err)
+	}
+	if _, err := c.Me(ctx); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("Me before login: got %v, want ErrUnauthorized", err)
+	}
+
+	id, err := c.Login(ctx, "alice", "secret")
+	if err != nil || id != 7 {
+		t.Fatalf("Login = %d, %v", id, err)
+	}
+	me, err := c.Me(ctx)
+	if err != nil || me.Username != "alice" {
+		t.Fatalf("Me = %+v, %v", me, err)
+	}
+	if got := fv.auth[len(fv.auth)-1]; got != "cookie:jwt-for-alice" {
+		t.Errorf("credentials sent = %q", got)
+	}
+	if c.Session() != "jwt-for-alice" {
+		t.Errorf("Session() = %q", c.Session())
+	}
+}
+
+func TestTokenSentAsBearer(t *testing.T) {
+	fv, c := newFakeVault(t)
+	c.SetToken("vt_abc")
+	if _, err := c.StorageStats(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := fv.auth[0]; got != "bearer:vt_abc" {
+		t.Errorf("credentials sent = %q", got)
+	}
+}
+
+func TestUploadReportsProgress(t *testing.T) {
+	fv, c := newFakeVault(t)
+	c.SetToken("vt_abc")
+	content := bytes.Repeat([]byte("x"), 100000)
+
+	var last, calls int64
+	ref, err := c.Upload(context.Background(), "a.txt", bytes.NewReader(content), &UploadOptions{
+		Folder:   "docs",
+		Size:     int64(len(content)),
+		Progress: func(done, total int64) { last, calls = done, calls+1 },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.Filename != "a.txt" || ref.Folder != "docs" {
+		t.Errorf("ref = %+v", ref)
+	}
+	if !bytes.Equal(fv.files[ref.FileID], content) {
+		t.Error("server received different content")
+	}
+	if last != int64(len(content)) || calls < 2 {
+		t.Errorf("progress ended at %d after %d calls", last, calls)
+	}
+}
+
+func TestErrorsMatchSentinels(t *testing.T) {
+	_, c := newFakeVault(t)
+	c.SetToken("vt_abc")
+	ctx := context.Background()
+
+	_, err := c.Upload(ctx, "a.txt", strings.NewReader("too much"), nil)
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("over quota: got %v", err)
+	}
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+		t.Errorf("over quota: not an *Error with status 403: %v", err)
+	}
+
+	_, err = c.Download(ctx, 99, io.Discard, nil)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing file: got %v", err)
+	}
+
+	neg := int64(-1)
+	_, err = c.ListFiles(ctx, &ListOptions{MinSize: &neg})
+	if !errors.Is(err, ErrInvalidFilter) || !errors.As(err, &apiErr) || apiErr.Fields["minSize"] == "" {
+		t.Errorf("bad filter: got %v", err)
+	}
+
+	for _, tc := range []struct {
+		status int
+		code   string
+		want   error
+	}{
+		{409, "already_uploaded", ErrAlreadyUploaded},
+		{409, "", ErrConflict},
+		{413, "file_too_large", ErrFileTooLarge},
+		{400, "type_not_allowed", ErrTypeNotAllowed},
+		{400, "invalid_path", ErrInvalidPath},
+		{400, "", ErrBadRequest},
+		{403, "", ErrForbidden},
+		{429, "", ErrRateLimited},
+		{500, "internal", ErrServer},
+	} {
+		if got := errorKind(tc.status, tc.code); got != tc.want {
+			t.Errorf("errorKind(%d, %q) = %v, want %v", tc.status, tc.code, got, tc.want)
+		}
+	}
+}
+
+func TestFilesFollowsCursors(t *testing.T) {
+	fv, c := newFakeVault(t)
+	c.SetToken("vt_abc")
+	public := false
+
+	var names []string
+	for f, err := range c.Files(context.Background(), &ListOptions{Limit: 2, Tags: []string{"a", "b"}, Public: &public}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, f.Filename)
+	}
+	if got := strings.Join(names, ","); got != "f1.txt,f2.txt,f3.txt,f4.txt,f5.txt" {
+		t.Errorf("files = %s", got)
+	}
+	if fv.lastQuery["tags"] != "a,b" || fv.lastQuery["isPublic"] != "false" || fv.lastQuery["cursor"] != "4" {
+		t.Errorf("last query = %v", fv.lastQuery)
+	}
+}
+
+func TestDownloadAndShare(t *testing.T) {
+	fv, c := newFakeVault(t)
+	c.SetToken("vt_abc")
+	ctx := context.Background()
+	fv.files[1] = []byte("hello")
+
+	var buf bytes.Buffer
+	var total int64
+	n, err := c.Download(ctx, 1, &buf, func(_, t int64) { total = t })
+	if err != nil || n != 5 || buf.String() != "hello" || total != 5 {
+		t.Fatalf("Download = %d, %v, %q, total %d", n, err, buf.String(), total)
+	}
+
+	s, err := c.SetPublic(ctx, 1, true)
+	if err != nil || s.Visibility != "public" || s.Token != "tok123" {
+		t.Fatalf("SetPublic = %+v, %v", s, err)
+	}
+	s, err = c.SetPublic(ctx, 1, false)
+	if err != nil || s.Visibility != "private" || s.Token != "" {
+		t.Fatalf("SetPublic(false) = %+v, %v", s, err)
+	}
+
+	stats, err := c.StorageStats(ctx)
+	if err != nil || stats.Savings != 50 {
+		t.Fatalf("StorageStats = %+v, %v", stats, err)
+	}
+}
+
+func TestUploadResumableSkipsStoredParts(t *testing.T) {
+	fv, c := newFakeVault(t)
+	c.SetToken("vt_abc")
+	ctx := context.Background()
+	content := bytes.Repeat([]byte("0123456789"), 50) // 500 bytes, 5 parts of 100
+	fv.failPart = 3
+
+	var id string
+	opts := &ResumableOptions{PartSize: 100, OnStart: func(s string) { id = s }}
+	_, err := c.UploadResumable(ctx, "big.bin", bytes.NewReader(content), int64(len(content)), opts)
+	if !errors.Is(err, ErrServer) || id != "up1" {
+		t.Fatalf("first attempt: err %v, id %q", err, id)
+	}
+
+	var last int64
+	opts.UploadID = id
+	opts.Progress = func(done, _ int64) { last = done }
+	ref, err := c.UploadResumable(ctx, "big.bin", bytes.NewReader(content), int64(len(content)), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fv.files[ref.FileID], content) {
+		t.Error("assembled content differs")
+	}
+	if got := fmt.Sprint(fv.partPuts); got != "[1 2 3 3 4 5]" {
+		t.Errorf("parts sent = %s, want only the failed part and later ones resent", got)
+	}
+	if last != int64(len(content)) {
+		t.Errorf("progress ended at %d", last)
+	}
+}
@@ -0,0 +1,107 @@
+package vaultclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Errors the server reports, matched against *Error with errors.Is. The
+// server identifies most failures by status code alone; where one status
+// covers several failures, the error code it sends tells them apart.
+var (
+	ErrUnauthorized    = errors.New("not signed in or credentials rejected")
+	ErrForbidden       = errors.New("access denied")
+	ErrNotFound        = errors.New("not found")
+	ErrBadRequest      = errors.New("bad request")
+	ErrInvalidFilter   = errors.New("invalid filter")
+	ErrInvalidPath     = errors.New("invalid file name or folder")
+	ErrTypeNotAllowed  = errors.New("file type not allowed")
+	ErrConflict        = errors.New("conflict")
+	ErrAlreadyUploaded = errors.New("file already uploaded")
+	ErrQuotaExceeded   = errors.New("storage quota exceeded")
+	ErrFileTooLarge    = errors.New("file too large")
+	ErrRateLimited     = errors.New("rate limit exceeded")
+	ErrServer          = errors.New("server error")
+)
+
+// Error is a failed API call.
+type Error struct {
+	StatusCode int
+	Code       string            // the server's error code, if it sent one
+	Message    string            // the server's message
+	Fields     map[string]string // per-parameter problems, for invalid filters
+
+	kind error
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("vaultclient: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("vaultclient: %s (%d)", e.Message, e.StatusCode)
+}
+
+// Unwrap returns the sentinel error for the failure, such as ErrNotFound.
+func (e *Error) Unwrap() error {
+	return e.kind
+}
+
+// Codes the server uses to tell failures with the same status apart.
+const (
+	codeQuotaExceeded   = "quota_exceeded"
+	codeAlreadyUploaded = "already_uploaded"
+	codeTypeNotAllowed  = "type_not_allowed"
+	codeInvalidPath     = "invalid_path"
+	codeInvalidFilter   = "invalid_filter"
+)
+
+func parseError(resp *http.Response) error {
+	e := &Error{StatusCode: resp.StatusCode}
+	var body struct {
+		Error   string            `json:"error"`
+		Code    string            `json:"code"`
+		Message string            `json:"message"` // sign-up reports errors this way
+		Fields  map[string]string `json:"fields"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body); err == nil {
+		e.Code, e.Message, e.Fields = body.Code, body.Error, body.Fields
+		if e.Message == "" {
+			e.Message = body.Message
+		}
+	}
+	e.kind = errorKind(e.StatusCode, e.Code)
+	return e
+}
+
+func errorKind(status int, code string) error {
+	switch {
+	case status == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case status == http.StatusForbidden && code == codeQuotaExceeded:
+		return ErrQuotaExceeded
+	case status == http.StatusForbidden:
+		return ErrForbidden
+	case status == http.StatusNotFound:
+		return ErrNotFound
+	case status == http.StatusConflict && code == codeAlreadyUploaded:
+		return ErrAlreadyUploaded
+	case status == http.StatusConflict:
+		return ErrConflict
+	case status == http.StatusRequestEntityTooLarge:
+		return ErrFileTooLarge
+	case status == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case status >= 500:
+		return ErrServer
+	case code == codeTypeNotAllowed:
+		return ErrTypeNotAllowed
+	case code == codeInvalidPath:
+		return ErrInvalidPath
+	case code == codeInvalidFilter:
+		return ErrInvalidFilter
+	}
+	return ErrBadRequest
+}
@@ -0,0 +1,321 @@
+package vaultclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// File is one entry of the file listing. ID identifies the user's copy and
+// is what Delete, Rename and SetPublic take; FileID identifies the stored
+// content and is what Download takes.
+type File struct {
+	ID            uint              `json:"id"`
+	FileID        uint              `json:"file_id"`
+	Filename      string            `json:"filename"`
+	Folder        string            `json:"folder"`
+	Size          int64             `json:"size"`
+	Uploader      string            `json:"uploader"`
+	UploadDate    string            `json:"upload_date"` // YYYY-MM-DD
+	Public        bool              `json:"public"`
+	PublicLink    string            `json:"public_link,omitempty"`
+	DownloadCount *int              `json:"downloads,omitempty"`
+	Tags          []string          `json:"tags"`
+	Metadata      map[string]string `json:"metadata"`
+	PreviewURL    string            `json:"preview_url,omitempty"`
+	ThumbnailURL  string            `json:"thumbnail_url,omitempty"`
+}
+
+func (f *File) UnmarshalJSON(b []byte) error {
+	type plain File
+	var v struct {
+		plain
+		Public string `json:"public"` // "yes" or "no"
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*f = File(v.plain)
+	f.Public = v.Public == "yes"
+	return nil
+}
+
+// Path returns the file's folder and name joined with "/".
+func (f *File) Path() string {
+	if f.Folder == "" {
+		return f.Filename
+	}
+	return f.Folder + "/" + f.Filename
+}
+
+// PublicToken returns the token of the file's public link, or "" if the
+// file is private.
+func (f *File) PublicToken() string {
+	if f.PublicLink == "" {
+		return ""
+	}
+	return f.PublicLink[strings.LastIndex(f.PublicLink, "/")+1:]
+}
+
+// ListOptions filters, orders and pages the file listing. Zero values
+// leave a filter out.
+type ListOptions struct {
+	Filename  string // substring of the name
+	MimeType  string // such as "image/png" or "image/*"
+	Uploader  string
+	MinSize   *int64
+	MaxSize   *int64
+	StartDate time.Time
+	EndDate   time.Time
+	Tags      []string // files carrying every tag
+	Metadata  []string // "key" or "key=value" predicates
+	Public    *bool
+
+	Sort   string // name, size, uploaded_at, downloads or mime_type
+	Order  string // asc or desc
+	Limit  int    // page size; 0 lists everything in one page
+	Cursor string // NextCursor of the previous page
+	Count  bool   // also report the total number of matches
+}
+
+func (o *ListOptions) query() url.Values {
+	q := url.Values{}
+	set := func(k, v string) {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	set("filename", o.Filename)
+	set("mimeType", o.MimeType)
+	set("uploader", o.Uploader)
+	if o.MinSize != nil {
+		q.Set("minSize", strconv.FormatInt(*o.MinSize, 10))
+	}
+	if o.MaxSize != nil {
+		q.Set("maxSize", strconv.FormatInt(*o.MaxSize, 10))
+	}
+	if !o.StartDate.IsZero() {
+		q.Set("startDate", o.StartDate.Format(time.RFC3339Nano))
+	}
+	if !o.EndDate.IsZero() {
+		q.Set("endDate", o.EndDate.Format(time.RFC3339Nano))
+	}
+	set("tags", strings.Join(o.Tags, ","))
+	set("metadata", strings.Join(o.Metadata, ","))
+	if o.Public != nil {
+		q.Set("isPublic", strconv.FormatBool(*o.Public))
+	}
+	set("sort", o.Sort)
+	set("order", o.Order)
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	set("cursor", o.Cursor)
+	if o.Count {
+		q.Set("count", "true")
+	}
+	return q
+}
+
+// FilePage is one page of the file listing.
+type FilePage struct {
+	Files      []File `json:"files"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+	Total      *int64 `json:"total,omitempty"`
+}
+
+// ListFiles returns one page of the user's files.
+func (c *Client) ListFiles(ctx context.Context, opts *ListOptions) (*FilePage, error) {
+	if opts == nil {
+		opts = &ListOptions{}
+	}
+	var page FilePage
+	if err := c.do(ctx, http.MethodGet, "/api/files", opts.query(), nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// defaultPageSize is the page size Files uses when opts sets none.
+const defaultPageSize = 200
+
+// Files iterates over every file matching opts, fetching pages as needed.
+// Iteration stops at the first error, which is yielded with a zero File.
+func (c *Client) Files(ctx context.Context, opts *ListOptions) iter.Seq2[File, error] {
+	return func(yield func(File, error) bool) {
+		o := ListOptions{}
+		if opts != nil {
+			o = *opts
+		}
+		if o.Limit <= 0 {
+			o.Limit = defaultPageSize
+		}
+		for {
+			page, err := c.ListFiles(ctx, &o)
+			if err != nil {
+				yield(File{}, err)
+				return
+			}
+			for _, f := range page.Files {
+				if !yield(f, nil) {
+					return
+				}
+			}
+			if !page.HasMore || page.NextCursor == "" {
+				return
+			}
+			o.Cursor, o.Count = page.NextCursor, false
+		}
+	}
+}
+
+// ProgressFunc reports a transfer's progress: done bytes out of total, or
+// total -1 when the size is not known.
+type ProgressFunc func(done, total int64)
+
+// progressReader counts bytes read through it.
+type progressReader struct {
+	r        io.Reader
+	done     int64
+	total    int64
+	progress ProgressFunc
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.done += int64(n)
+		p.progress(p.done, p.total)
+	}
+	return n, err
+}
+
+// progressWriter counts bytes written through it.
+type progressWriter struct {
+	w        io.Writer
+	done     int64
+	total    int64
+	progress ProgressFunc
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	if n > 0 {
+		p.done += int64(n)
+		p.progress(p.done, p.total)
+	}
+	return n, err
+}
+
+// Download writes the content of the file with the given FileID to w and
+// returns the number of bytes written. progress may be nil.
+func (c *Client) Download(ctx context.Context, fileID uint, w io.Writer, progress ProgressFunc) (int64, error) {
+	return c.download(ctx, fmt.Sprintf("/api/files/%d/download", fileID), w, progress)
+}
+
+// DownloadPublic writes the content of a publicly shared file to w. It needs
+// no credentials; token is Share.Token.
+func (c *Client) DownloadPublic(ctx context.Context, token string, w io.Writer, progress ProgressFunc) (int64, error) {
+	return c.download(ctx, "/api/public/"+url.PathEscape(token), w, progress)
+}
+
+// PublicURL returns the URL anyone can download a shared file from.
+func (c *Client) PublicURL(token string) string {
+	return c.endpoint("/api/public/"+url.PathEscape(token), nil)
+}
+
+func (c *Client) download(ctx context.Context, p string, w io.Writer, progress ProgressFunc) (int64, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, p, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Del("Accept")
+	resp, err := c.send(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if progress != nil {
+		w = &progressWriter{w: w, total: resp.ContentLength, progress: progress}
+	}
+	n, err := io.Copy(w, resp.Body)
+	if err == nil && resp.ContentLength >= 0 && n != resp.ContentLength {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// FileRef names a stored file, as returned by uploads and renames.
+type FileRef struct {
+	ID       uint   `json:"id"`
+	FileID   uint   `json:"file_id"`
+	Filename string `json:"filename"`
+	Folder   string `json:"folder"`
+}
+
+// Delete deletes one of the user's files.
+func (c *Client) Delete(ctx context.Context, id uint) error {
+	return c.do(ctx, http.MethodPost, fmt.Sprintf("/api/files/%d/delete", id), nil, nil, nil)
+}
+
+// Rename moves a file to a new name, folder or both; nil leaves that part
+// unchanged.
+func (c *Client) Rename(ctx context.Context, id uint, folder, filename *string) (*FileRef, error) {
+	in := struct {
+		Filename *string `json:"filename,omitempty"`
+		Folder   *string `json:"folder,omitempty"`
+	}{filename, folder}
+	var ref FileRef
+	if err := c.do(ctx, http.MethodPatch, fmt.Sprintf("/api/files/%d", id), nil, in, &ref); err != nil {
+		return nil, err
+	}
+	return &ref, nil
+}
+
+// Share is a file's visibility after SetPublic.
+type Share struct {
+	ID         uint   `json:"file_id"`
+	Visibility string `json:"visibility"` // "public" or "private"
+	Link       string `json:"public_link"`
+	Token      string `json:"-"` // for DownloadPublic
+}
+
+// SetPublic shares a file through a public link, or withdraws the link.
+// Sharing again issues a new link, so earlier links stop working.
+func (c *Client) SetPublic(ctx context.Context, id uint, public bool) (*Share, error) {
+	q := url.Values{"make_public": {strconv.FormatBool(public)}}
+	var s Share
+	if err := c.do(ctx, http.MethodPatch, fmt.Sprintf("/api/files/%d/visibility", id), q, nil, &s); err != nil {
+		return nil, err
+	}
+	if i := strings.LastIndex(s.Link, "/"); i >= 0 {
+		s.Token = s.Link[i+1:]
+	}
+	return &s, nil
+}
+
+// StorageStats reports how much the user stores and what deduplication
+// saves them, in bytes.
+type StorageStats struct {
+	TotalStorage    int64 `json:"total_storage"`    // bytes actually stored
+	OriginalStorage int64 `json:"original_storage"` // bytes before deduplication
+	Savings         int64 `json:"savings"`
+}
+
+// StorageStats returns the user's storage usage.
+func (c *Client) StorageStats(ctx context.Context) (*StorageStats, error) {
+	var s StorageStats
+	if err := c.do(ctx, http.MethodGet, "/api/storage-stats", nil, nil, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
@@ -0,0 +1,303 @@
+package vaultclient
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// UploadOptions configures Upload.
+type UploadOptions struct {
+	Folder   string       // destination folder, "" for the root
+	Size     int64        // content length if known, for progress; 0 means unknown
+	Progress ProgressFunc // optional
+}
+
+// Upload streams r to the server as a new file named filename. The content
+// is sent as it is read, without buffering it in memory. Uploading content
+// the user already stores fails with ErrAlreadyUploaded.
+func (c *Client) Upload(ctx context.Context, filename string, r io.Reader, opts *UploadOptions) (*FileRef, error) {
+	if opts == nil {
+		opts = &UploadOptions{}
+	}
+	if opts.Progress != nil {
+		total := opts.Size
+		if total <= 0 {
+			total = -1
+		}
+		r = &progressReader{r: r, total: total, progress: opts.Progress}
+	}
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		part, err := mw.CreateFormFile("file", filename)
+		if err == nil {
+			_, err = io.Copy(part, r)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	var q url.Values
+	if opts.Folder != "" {
+		q = url.Values{"folder": {opts.Folder}}
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, "/api/upload", q, pr)
+	if err != nil {
+		pr.Close()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	resp, err := c.send(req)
+	// Unblock the writer if the server answered before reading everything
+	pr.Close()
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var ref FileRef
+	if err := decode(resp, &ref); err != nil {
+		return nil, err
+	}
+	return &ref, nil
+}
+
+// UploadFile uploads the local file at path under its base name.
+func (c *Client) UploadFile(ctx context.Context, path string, opts *UploadOptions) (*FileRef, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	o := UploadOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Size == 0 {
+		if fi, err := f.Stat(); err == nil {
+			o.Size = fi.Size()
+		}
+	}
+	return c.Upload(ctx, filepath.Base(path), f, &o)
+}
+
+// Part is one stored part of a resumable upload. ETag is the hex MD5 of the
+// part's content.
+type Part struct {
+	PartNumber int    `json:"part_number"`
+	Size       int64  `json:"size,omitempty"`
+	ETag       string `json:"etag"`
+}
+
+// ResumableUpload is an upload in progress on the server. Parts lists what
+// the server has stored so far, when fetched with GetUpload.
+type ResumableUpload struct {
+	ID        string    `json:"upload_id"`
+	Folder    string    `json:"folder"`
+	Filename  string    `json:"filename"`
+	CreatedAt time.Time `json:"created_at"`
+	Parts     []Part    `json:"parts"`
+}
+
+// StartUpload begins a resumable upload of filename into folder. Completing
+// it replaces any file at that path. Unfinished uploads are discarded by the
+// server after a week.
+func (c *Client) StartUpload(ctx context.Context, folder, filename string) (*ResumableUpload, error) {
+	in := map[string]string{"folder": folder, "filename": filename}
+	var u ResumableUpload
+	if err := c.do(ctx, http.MethodPost, "/api/uploads", nil, in, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// GetUpload returns an upload with the parts the server has stored.
+func (c *Client) GetUpload(ctx context.Context, id string) (*ResumableUpload, error) {
+	var u ResumableUpload
+	if err := c.do(ctx, http.MethodGet, "/api/uploads/"+url.PathEscape(id), nil, nil, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// UploadPart stores size bytes from r as part n, counting from 1. Sending a
+// part number again replaces that part.
+func (c *Client) UploadPart(ctx context.Context, id string, n int, r io.Reader, size int64) (*Part, error) {
+	p := "/api/uploads/" + url.PathEscape(id) + "/parts/" + strconv.Itoa(n)
+	req, err := c.newRequest(ctx, http.MethodPut, p, nil, io.NopCloser(r))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	part := Part{Size: size}
+	if err := decode(resp, &part); err != nil {
+		return nil, err
+	}
+	return &part, nil
+}
+
+// CompleteUpload joins parts, in ascending part number order, into the file.
+func (c *Client) CompleteUpload(ctx context.Context, id string, parts []Part) (*FileRef, error) {
+	in := map[string][]Part{"parts": parts}
+	var ref FileRef
+	if err := c.do(ctx, http.MethodPost, "/api/uploads/"+url.PathEscape(id)+"/complete", nil, in, &ref); err != nil {
+		return nil, err
+	}
+	return &ref, nil
+}
+
+// AbortUpload ends an upload and drops its parts.
+func (c *Client) AbortUpload(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/api/uploads/"+url.PathEscape(id), nil, nil, nil)
+}
+
+const (
+	// DefaultPartSize is the part size UploadResumable uses unless told
+	// otherwise.
+	DefaultPartSize = 8 << 20
+	maxParts        = 10000
+)
+
+// ResumableOptions configures UploadResumable.
+type ResumableOptions struct {
+	Folder   string
+	PartSize int64        // bytes per part; DefaultPartSize if 0
+	Progress ProgressFunc // optional; parts already on the server count as done
+
+	// UploadID resumes an earlier upload instead of starting a new one.
+	// Parts the server already holds with matching content are skipped.
+	UploadID string
+	// OnStart is called with the upload's ID before any part is sent, so
+	// the caller can record it and resume after a crash.
+	OnStart func(uploadID string)
+}
+
+// UploadResumable uploads size bytes of src as filename in parts. If it
+// fails part-way, calling it again with the same UploadID sends only the
+// missing parts. src must return the same content on every attempt.
+func (c *Client) UploadResumable(ctx context.Context, filename string, src io.ReaderAt, size int64, opts *ResumableOptions) (*FileRef, error) {
+	o := ResumableOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	partSize := o.PartSize
+	if partSize <= 0 {
+		partSize = DefaultPartSize
+	}
+	if size > partSize*maxParts {
+		partSize = (size + maxParts - 1) / maxParts
+	}
+
+	stored := map[int]Part{}
+	id := o.UploadID
+	if id == "" {
+		u, err := c.StartUpload(ctx, o.Folder, filename)
+		if err != nil {
+			return nil, err
+		}
+		id = u.ID
+	} else {
+		u, err := c.GetUpload(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range u.Parts {
+			stored[p.PartNumber] = p
+		}
+	}
+	if o.OnStart != nil {
+		o.OnStart(id)
+	}
+
+	count := int((size + partSize - 1) / partSize)
+	if count == 0 {
+		count = 1 // an empty file is one empty part
+	}
+	parts := make([]Part, 0, count)
+	var done int64
+	for n := 1; n <= count; n++ {
+		off := int64(n-1) * partSize
+		length := min(partSize, size-off)
+
+		if p, ok := stored[n]; ok && p.Size == length {
+			sum, err := partMD5(io.NewSectionReader(src, off, length))
+			if err != nil {
+				return nil, err
+			}
+			if sum == p.ETag {
+				parts = append(parts, Part{PartNumber: n, ETag: p.ETag})
+				done += length
+				if o.Progress != nil {
+					o.Progress(done, size)
+				}
+				continue
+			}
+		}
+
+		var r io.Reader = io.NewSectionReader(src, off, length)
+		if o.Progress != nil {
+			base := done
+			r = &progressReader{r: r, progress: func(d, _ int64) { o.Progress(base+d, size) }}
+		}
+		p, err := c.UploadPart(ctx, id, n, r, length)
+		if err != nil {
+			return nil, fmt.Errorf("part %d of upload %s: %w", n, id, err)
+		}
+		parts = append(parts, Part{PartNumber: n, ETag: p.ETag})
+		done += length
+	}
+
+	ref, err := c.CompleteUpload(ctx, id, parts)
+	if err != nil {
+		return nil, fmt.Errorf("completing upload %s: %w", id, err)
+	}
+	return ref, nil
+}
+
+// UploadFileResumable uploads the local file at path under its base name
+// with UploadResumable.
+func (c *Client) UploadFileResumable(ctx context.Context, path string, opts *ResumableOptions) (*FileRef, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, errors.New("vaultclient: not a regular file: " + path)
+	}
+	return c.UploadResumable(ctx, filepath.Base(path), f, fi.Size(), opts)
+}
+
+func partMD5(r io.Reader) (string, error) {
+	h := md5.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -123,7 +123,7 @@ export async function filterFiles(filters: FileFilter): Promise<{ files: FileMet
   if (filters.uploader) query.append("uploader", filters.uploader);
   if (filters.isPublic !== undefined) query.append("isPublic", filters.isPublic ? "true" : "false");
 
-  const res = await fetch(`${API_BASE}/api/files/filter?${query.toString()}`, {
+  const res = await fetch(`${API_BASE}/api/files?${query.toString()}`, {
     credentials: "include",
     headers: { "Accept": "application/json" },
   });
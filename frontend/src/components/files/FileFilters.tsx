@@ -26,8 +26,8 @@ export const FileFilters: React.FC<Props> = ({ onChange }) => {
       const min = minSize ? parseInt(minSize) * 1024 : 0;
       const max = maxSize ? parseInt(maxSize) * 1024 : Infinity;
       filter.sizeRange = [min, max];
-      filter.minSize = min;
-      filter.maxSize = max;
+      if (minSize) filter.minSize = min;
+      if (maxSize) filter.maxSize = max;
     }
 
     if (fromDate || toDate) {
@@ -0,0 +1,40 @@
+// src/hooks/useVaultEvents.ts
+import { useEffect, useRef } from "react";
+import { API_BASE } from "../api/files";
+
+export type VaultEvent = {
+  id: number;
+  type: string;
+  created_at: string;
+  user_id: number;
+  data: Record<string, unknown>;
+};
+
+const EVENT_TYPES = [
+  "file.uploaded",
+  "file.deleted",
+  "file.renamed",
+  "file.shared",
+  "file.made_public",
+  "file.made_private",
+  "file.shared_with_you",
+  "quota.threshold_crossed",
+  "resync",
+];
+
+// Subscribes to the server's event stream. EventSource reconnects on its own
+// and resumes from the last event it saw.
+export const useVaultEvents = (onEvent: (event: VaultEvent | null) => void) => {
+  const handler = useRef(onEvent);
+  handler.current = onEvent;
+
+  useEffect(() => {
+    const source = new EventSource(`${API_BASE}/api/events`, { withCredentials: true });
+    const listener = (e: MessageEvent) => {
+      // "resync" carries no event: the client missed too much and should reload
+      handler.current(e.type === "resync" ? null : JSON.parse(e.data));
+    };
+    EVENT_TYPES.forEach((t) => source.addEventListener(t, listener));
+    return () => source.close();
+  }, []);
+};
@@ -8,6 +8,7 @@ import { FileFilters } from "../components/files/FileFilters";
 import { filterFiles } from "../api/files"; // backend API call
 import "./dashboard.css"; 
 import { useStorageStats } from "../hooks/userStorageStats";
+import { useVaultEvents } from "../hooks/useVaultEvents";
 
 const Dashboard: React.FC = () => {
   const { user, logout } = useAuth();
@@ -36,6 +37,14 @@ const Dashboard: React.FC = () => {
     await refreshStorage();
   };
 
+  // Changes from other tabs and devices arrive as server-sent events
+  useVaultEvents((event) => {
+    if (event?.type === "quota.threshold_crossed") {
+      alert(`You have used ${event.data.threshold_percent}% of your storage quota.`);
+    }
+    refreshData();
+  });
+
   // Fetch initially
   useEffect(() => {
     refreshData();